@@ -28,5 +28,19 @@ func main() {
 		}
 	}
 
+	if envToken, ok := os.LookupEnv("SERVERLEDGE_TOKEN"); ok {
+		cli.ServerConfig.Token = envToken
+	}
+	if envNamespace, ok := os.LookupEnv("SERVERLEDGE_NAMESPACE"); ok {
+		cli.ServerConfig.Namespace = envNamespace
+	}
+	if envTLS, ok := os.LookupEnv("SERVERLEDGE_TLS"); ok {
+		cli.ServerConfig.TLS = envTLS == "true" || envTLS == "1"
+	}
+	if envCA, ok := os.LookupEnv("SERVERLEDGE_CACERT"); ok {
+		cli.ServerConfig.CAFile = envCA
+		cli.ServerConfig.TLS = true
+	}
+
 	cli.Init()
 }
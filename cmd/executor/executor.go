@@ -9,6 +9,14 @@ import (
 )
 
 func main() {
+	if err := executor.StartWorker(); err != nil {
+		log.Fatalf("Could not start worker process: %v", err)
+	}
+
 	http.HandleFunc("/invoke", executor.InvokeHandler)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", executor.DEFAULT_EXECUTOR_PORT), nil))
+	http.HandleFunc("/invoke/stream", executor.StreamHandler)
+	http.HandleFunc("/cancel", executor.CancelHandler)
+	http.HandleFunc("/health", executor.HealthHandler)
+	http.HandleFunc("/ready", executor.ReadyHandler)
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", executor.GetExecutorPort()), nil))
 }
@@ -12,13 +12,14 @@ import (
 	"github.com/grussorusso/serverledge/internal/config"
 	"github.com/grussorusso/serverledge/internal/lb"
 	"github.com/grussorusso/serverledge/internal/registration"
+	"github.com/grussorusso/serverledge/internal/tlsutil"
 	"github.com/grussorusso/serverledge/utils"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 )
 
 func registerTerminationHandler(e *echo.Echo) {
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 
 	go func() {
@@ -44,10 +45,14 @@ func main() {
 	}
 	config.ReadConfiguration(configFileName)
 
+	if err := tlsutil.Init(); err != nil {
+		log.Fatalf("Invalid TLS configuration: %v\n", err)
+	}
+
 	// TODO: split Area in Region + Type (e.g., cloud/lb/edge)
 	region := config.GetString(config.REGISTRY_AREA, "ROME")
 	registry := &registration.Registry{Area: "lb/" + region}
-	hostport := fmt.Sprintf("http://%s:%d", utils.GetIpAddress().String(), config.GetInt(config.API_PORT, 1323))
+	hostport := fmt.Sprintf("%s://%s:%d", tlsutil.Scheme(), utils.GetIpAddress().String(), config.GetInt(config.API_PORT, 1323))
 	if _, err := registry.RegisterToEtcd(hostport); err != nil {
 		log.Printf("Could not register to Etcd: %v\n", err)
 	}
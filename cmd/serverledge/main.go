@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/grussorusso/serverledge/internal/node"
@@ -14,11 +15,16 @@ import (
 	"golang.org/x/net/context"
 
 	"github.com/grussorusso/serverledge/internal/api"
+	"github.com/grussorusso/serverledge/internal/asyncstore"
+	"github.com/grussorusso/serverledge/internal/auth"
 	"github.com/grussorusso/serverledge/internal/cache"
 	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/container"
+	"github.com/grussorusso/serverledge/internal/function"
 	"github.com/grussorusso/serverledge/internal/metrics"
 	"github.com/grussorusso/serverledge/internal/registration"
 	"github.com/grussorusso/serverledge/internal/scheduling"
+	"github.com/grussorusso/serverledge/internal/tlsutil"
 	"github.com/grussorusso/serverledge/utils"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -28,19 +34,24 @@ func startAPIServer(e *echo.Echo) {
 	e.Use(middleware.Recover())
 
 	// Routes
-	e.POST("/invoke/:fun", api.InvokeFunction)
-	e.POST("/prewarm", api.PrewarmFunction)
-	e.POST("/create", api.CreateFunction)
-	e.POST("/delete", api.DeleteFunction)
-	e.GET("/function", api.GetFunctions)
-	e.GET("/poll/:reqId", api.PollAsyncResult)
-	e.GET("/status", api.GetServerStatus)
+	api.RegisterRoutes(e)
 
 	// Start server
 	portNumber := config.GetInt(config.API_PORT, 1323)
 	e.HideBanner = true
 
-	if err := e.Start(fmt.Sprintf(":%d", portNumber)); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	tlsConfig, err := tlsutil.ServerConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if tlsConfig != nil {
+		e.TLSServer.Addr = fmt.Sprintf(":%d", portNumber)
+		e.TLSServer.TLSConfig = tlsConfig
+		err = e.StartServer(e.TLSServer)
+	} else {
+		err = e.Start(fmt.Sprintf(":%d", portNumber))
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		e.Logger.Fatal("shutting down the server")
 	}
 }
@@ -65,20 +76,32 @@ func cacheSetup() {
 	cache.GetCacheInstance()
 }
 
-func registerTerminationHandler(r *registration.Registry, e *echo.Echo) {
-	c := make(chan os.Signal)
-	signal.Notify(c, os.Interrupt)
+func registerTerminationHandler(e *echo.Echo) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
 	go func() {
 		select {
 		case sig := <-c:
-			fmt.Printf("Got %s signal. Terminating...\n", sig)
-			node.ShutdownAllContainers()
-
-			// deregister from etcd; server should be unreachable
-			err := r.Deregister()
-			if err != nil {
-				log.Fatal(err)
+			fmt.Printf("Got %s signal. Draining and terminating...\n", sig)
+
+			// a second signal forces termination
+			go func() {
+				<-c
+				fmt.Println("Forcing termination...")
+				node.ShutdownAllContainers()
+				os.Exit(1)
+			}()
+
+			// deregister, wait for pending requests and destroy containers
+			scheduling.Drain(scheduling.DefaultDrainTimeout())
+
+			// other nodes take over the async requests still pending
+			if err := registration.Reg.Close(); err != nil {
+				log.Printf("Could not close the registration: %v\n", err)
+			}
+			if store, err := asyncstore.Get(); err == nil {
+				_ = store.Close()
 			}
 
 			//stop container janitor
@@ -102,6 +125,10 @@ func main() {
 	}
 	config.ReadConfiguration(configFileName)
 
+	if err := tlsutil.Init(); err != nil {
+		log.Fatalf("Invalid TLS configuration: %v\n", err)
+	}
+
 	//setting up cache parameters
 	cacheSetup()
 
@@ -120,22 +147,41 @@ func main() {
 		log.Fatal(err)
 	}
 
-	url := fmt.Sprintf("http://%s:%d", utils.GetIpAddress().String(), config.GetInt(config.API_PORT, 1323))
+	url := fmt.Sprintf("%s://%s:%d", tlsutil.Scheme(), utils.GetIpAddress().String(), config.GetInt(config.API_PORT, 1323))
 	myKey, err := registry.RegisterToEtcd(url)
 	if err != nil {
 		log.Fatal(err)
 	}
 	node.NodeIdentifier = myKey
+	registration.Reg = registry
 
 	go metrics.Init()
 
 	e := echo.New()
 
 	// Register a signal handler to cleanup things on termination
-	registerTerminationHandler(registry, e)
+	registerTerminationHandler(e)
+
+	container.InitContainerFactory()
+	if err = container.InitRuntimeCatalog(); err != nil {
+		log.Printf("Could not load the runtime catalog (using built-in runtimes): %v\n", err)
+	}
+	if err = function.InitNamespaces(); err != nil {
+		log.Fatal(err)
+	}
+	if err = auth.InitKeyStore(); err != nil {
+		log.Fatal(err)
+	}
+	if err = asyncstore.Init(); err != nil {
+		log.Fatalf("Could not open the async result store: %v\n", err)
+	}
+	if config.GetBool(config.FACTORY_IMAGES_PREPULL, true) {
+		go node.PrePullImages()
+	}
 
 	schedulingPolicy := createSchedulingPolicy()
 	go scheduling.Run(schedulingPolicy)
+	go scheduling.RunAsyncRecovery()
 
 	if !isInCloud {
 		err = registration.InitEdgeMonitoring(registry)
@@ -0,0 +1,81 @@
+# Example of a persistent worker for the Serverledge executor.
+# Handler modules are imported upon the first invocation and reused by the
+# following ones.
+import base64
+import importlib
+import json
+import os
+import sys
+import traceback
+
+requests = os.fdopen(int(os.environ.get("WORKER_REQUEST_FD", "3")), "r")
+responses = os.fdopen(int(os.environ.get("WORKER_RESPONSE_FD", "4")), "w")
+
+handlers = {}
+
+
+def get_handler(handler_dir, handler):
+    key = (handler_dir, handler)
+    if key not in handlers:
+        if handler_dir not in sys.path:
+            sys.path.insert(0, handler_dir)
+        module_name, func_name = handler.rsplit(".", 1)
+        module = importlib.import_module(module_name)
+        handlers[key] = getattr(module, func_name)
+    return handlers[key]
+
+
+def http_response(result):
+    """Converts a dict returned by a handler into an HTTP response: the body
+    can be bytes, a string or any JSON-serializable object."""
+    body = result.get("body", b"")
+    if isinstance(body, str):
+        body = body.encode("utf-8")
+    elif not isinstance(body, (bytes, bytearray)):
+        body = json.dumps(body).encode("utf-8")
+
+    headers = {}
+    for name, value in (result.get("headers") or {}).items():
+        headers[name] = value if isinstance(value, list) else [str(value)]
+
+    return {
+        "StatusCode": int(result["statusCode"]),
+        "Headers": headers,
+        "Body": base64.b64encode(body).decode("ascii"),
+    }
+
+
+try:
+    context = json.loads(os.environ.get("CONTEXT", "{}"))
+except ValueError:
+    context = {}
+
+for line in requests:
+    request = json.loads(line)
+    response = {}
+    try:
+        h = get_handler(request["HandlerDir"], request["Handler"])
+        params = request.get("Params") or {}
+        # HTTP trigger: the raw request is passed in place of parameters
+        http_request = request.get("HTTPRequest")
+        if http_request is not None:
+            params = {
+                "method": http_request.get("Method"),
+                "path": http_request.get("Path"),
+                "query": http_request.get("Query"),
+                "headers": http_request.get("Headers") or {},
+                "body": base64.b64decode(http_request.get("Body") or ""),
+            }
+        result = h(params, context)
+        if http_request is not None and isinstance(result, dict) and "statusCode" in result:
+            response["HTTPResponse"] = http_response(result)
+        else:
+            response["Result"] = json.dumps(result)
+        response["Success"] = True
+    except Exception:
+        response["Success"] = False
+        response["Output"] = traceback.format_exc()
+    # the output of the handler must precede the result
+    sys.stdout.flush()
+    responses.write(json.dumps(response) + "\n")
+    responses.flush()
@@ -3,7 +3,10 @@ var http = require('http');
 
 http.createServer(async (request, response) => {
 
-	if (request.method !== 'POST') {
+	if (request.method === 'GET' && (request.url === '/health' || request.url === '/ready')) {
+		response.writeHead(200);
+		response.end();
+	} else if (request.method !== 'POST') {
 		response.writeHead(404);
 		response.end('Invalid request method');
 	} else {
@@ -19,21 +22,22 @@ http.createServer(async (request, response) => {
 		try {
 			const reqbody = JSON.parse(data);
 
-			var handler = reqbody["Handler"]	
-			var handler_dir = reqbody["HandlerDir"]
-			var params = reqbody["Params"]
-			var return_output = reqbody["ReturnOutput"]
+			// concurrent invocations must not share state
+			const handler = reqbody["Handler"]
+			const handler_dir = reqbody["HandlerDir"]
+			const params = reqbody["Params"]
+			const return_output = reqbody["ReturnOutput"]
 
-			var context = {}
+			let context = {}
 			if (process.env.CONTEXT !== "undefined") {
 				context = process.env.CONTEXT
 			}
 
 			let h = require(path.join(handler_dir, handler))
 
-			result = h(params, context)
+			const result = h(params, context)
 
-			resp = {}
+			const resp = {}
 			resp["Result"] = JSON.stringify(result);
 			resp["Success"] = true
 			if (return_output === true) {
@@ -46,7 +50,7 @@ http.createServer(async (request, response) => {
 			response.writeHead(200, { 'Content-Type': contentType });
 			response.end(JSON.stringify(resp), 'utf-8');
 		} catch (error) {
-			resp = {}
+			const resp = {}
 			resp["Success"] = false
 			resp["Output"] = "Output capture not supported for this runtime yet."
 			response.writeHead(500, { 'Content-Type': contentType });
@@ -1,10 +1,12 @@
 # Python 3 server example
-from http.server import BaseHTTPRequestHandler, HTTPServer
+from http.server import BaseHTTPRequestHandler, ThreadingHTTPServer
 import time
 import os
 import sys
 import importlib
 import json
+import base64
+import threading
 
 hostName = "0.0.0.0"
 serverPort = 8080
@@ -12,28 +14,61 @@ serverPort = 8080
 #executed_modules = {}
 added_dirs = {}
 
+# sys.path is shared by all the threads serving concurrent invocations
+path_lock = threading.Lock()
+
+# at most MAX_CONCURRENCY invocations are served at once (as set by the node)
+try:
+    max_concurrency = max(1, int(os.environ.get("MAX_CONCURRENCY", "1")))
+except ValueError:
+    max_concurrency = 1
+invocation_slots = threading.BoundedSemaphore(max_concurrency)
+
 from io import StringIO
 import sys
 
+class ThreadOutput:
+    """Replaces sys.stdout/sys.stderr, writing to the buffer of the current
+    thread (if it is capturing output) or to the original stream, so that
+    concurrent invocations capture their own output only."""
+    def __init__(self, stream):
+        self._stream = stream
+        self._local = threading.local()
+
+    def _target(self):
+        return getattr(self._local, "buffer", None) or self._stream
+
+    def write(self, data):
+        return self._target().write(data)
+
+    def flush(self):
+        return self._target().flush()
+
+    def __getattr__(self, name):
+        return getattr(self._stream, name)
+
+    def start_capture(self):
+        self._local.buffer = StringIO()
+
+    def stop_capture(self):
+        buffer = self._local.buffer
+        self._local.buffer = None
+        return buffer.getvalue()
+
+sys.stdout = ThreadOutput(sys.stdout)
+sys.stderr = ThreadOutput(sys.stderr)
+
 class CaptureOutput:
     def __enter__(self):
         self._stdout_output = ''
         self._stderr_output = ''
-
-        self._stdout = sys.stdout
-        sys.stdout = StringIO()
-
-        self._stderr = sys.stderr
-        sys.stderr = StringIO()
-
+        sys.stdout.start_capture()
+        sys.stderr.start_capture()
         return self
 
     def __exit__(self, *args):
-        self._stdout_output = sys.stdout.getvalue()
-        sys.stdout = self._stdout
-
-        self._stderr_output = sys.stderr.getvalue()
-        sys.stderr = self._stderr
+        self._stdout_output = sys.stdout.stop_capture()
+        self._stderr_output = sys.stderr.stop_capture()
 
     def get_stdout(self):
         return self._stdout_output
@@ -41,7 +76,33 @@ class CaptureOutput:
     def get_stderr(self):
         return self._stderr_output
 
+def http_response(result):
+    """Converts a dict returned by a handler into an HTTP response: the body
+    can be bytes, a string or any JSON-serializable object."""
+    body = result.get("body", b"")
+    if isinstance(body, str):
+        body = body.encode("utf-8")
+    elif not isinstance(body, (bytes, bytearray)):
+        body = json.dumps(body).encode("utf-8")
+
+    headers = {}
+    for name, value in (result.get("headers") or {}).items():
+        headers[name] = value if isinstance(value, list) else [str(value)]
+
+    return {
+        "StatusCode": int(result["statusCode"]),
+        "Headers": headers,
+        "Body": base64.b64encode(body).decode("ascii"),
+    }
+
 class Executor(BaseHTTPRequestHandler):
+    def do_GET(self):
+        if "health" in self.path or "ready" in self.path:
+            self.send_response(200)
+        else:
+            self.send_response(404)
+        self.end_headers()
+
     def do_POST(self):
         content_length = int(self.headers['Content-Length']) 
         post_data = self.rfile.read(content_length) 
@@ -52,6 +113,21 @@ class Executor(BaseHTTPRequestHandler):
             self.end_headers()
             return
 
+        # invocations exceeding the max concurrency are rejected
+        if not invocation_slots.acquire(blocking=False):
+            message = b"too many concurrent invocations"
+            self.send_response(429)
+            self.send_header("Content-type", "text/plain")
+            self.send_header("Content-Length", str(len(message)))
+            self.end_headers()
+            self.wfile.write(message)
+            return
+        try:
+            self.invoke(request)
+        finally:
+            invocation_slots.release()
+
+    def invoke(self, request):
         handler = request["Handler"] 
         handler_dir = request["HandlerDir"]
 
@@ -60,14 +136,26 @@ class Executor(BaseHTTPRequestHandler):
         except:
             params = {}
 
+        # HTTP trigger: the raw request is passed in place of parameters
+        http_request = request.get("HTTPRequest")
+        if http_request is not None:
+            params = {
+                "method": http_request.get("Method"),
+                "path": http_request.get("Path"),
+                "query": http_request.get("Query"),
+                "headers": http_request.get("Headers") or {},
+                "body": base64.b64decode(http_request.get("Body") or ""),
+            }
+
         if "context" in os.environ:
             context = json.loads(os.environ["CONTEXT"]) 
         else:
             context = {}
 
-        if not handler_dir in added_dirs:
-            sys.path.insert(1, handler_dir)
-            added_dirs[handler_dir] = True
+        with path_lock:
+            if not handler_dir in added_dirs:
+                sys.path.insert(1, handler_dir)
+                added_dirs[handler_dir] = True
 
         # Get module name
         module,func_name = os.path.splitext(handler)
@@ -84,14 +172,21 @@ class Executor(BaseHTTPRequestHandler):
                 loaded_mod = importlib.import_module(module)
 
             if not return_output:
+                t0 = time.time()
                 result = getattr(loaded_mod, func_name)(params, context)
+                response["Duration"] = time.time() - t0
                 response["Output"] = ""
             else:
                 with CaptureOutput() as capturer:
+                    t0 = time.time()
                     result = getattr(loaded_mod, func_name)(params, context)
+                    response["Duration"] = time.time() - t0
                 response["Output"] = str(capturer.get_stdout()) + "\n" + str(capturer.get_stderr())
 
-            response["Result"] = json.dumps(result)
+            if http_request is not None and isinstance(result, dict) and "statusCode" in result:
+                response["HTTPResponse"] = http_response(result)
+            else:
+                response["Result"] = json.dumps(result)
             response["Success"] = True
         except Exception as e:
             print(e, file=sys.stderr)
@@ -105,7 +200,7 @@ class Executor(BaseHTTPRequestHandler):
 
 
 if __name__ == "__main__":        
-    webServer = HTTPServer((hostName, serverPort), Executor)
+    webServer = ThreadingHTTPServer((hostName, serverPort), Executor)
     print("Server started http://%s:%s" % (hostName, serverPort))
 
     try:
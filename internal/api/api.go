@@ -8,30 +8,49 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/grussorusso/serverledge/internal/asyncstore"
 	"github.com/grussorusso/serverledge/internal/client"
 	"github.com/grussorusso/serverledge/internal/config"
 	"github.com/grussorusso/serverledge/internal/container"
+	"github.com/grussorusso/serverledge/internal/executor"
 	"github.com/grussorusso/serverledge/internal/function"
 	"github.com/grussorusso/serverledge/internal/node"
 	"github.com/grussorusso/serverledge/internal/registration"
+	"github.com/grussorusso/serverledge/internal/sse"
+	"github.com/grussorusso/serverledge/internal/tlsutil"
 	"github.com/grussorusso/serverledge/utils"
 
 	"github.com/grussorusso/serverledge/internal/scheduling"
 	"github.com/labstack/echo/v4"
 )
 
+// statusClientClosedRequest is returned (non-standard) when the client closes
+// the connection before the request is served.
+const statusClientClosedRequest = 499
+
+var FunctionExistsErr = errors.New("the function already exists")
+var FunctionNotFoundErr = errors.New("unknown function")
+var UnknownRuntimeErr = errors.New("unknown runtime")
+var InvalidHandlerErr = errors.New("invalid handler")
+var RuntimeInUseErr = errors.New("the runtime is used by some function")
+var ResultNotFoundErr = errors.New("no result is available")
+var InvalidFunctionNameErr = errors.New("invalid function name")
+var InvalidFunctionErr = errors.New("invalid function definition")
+
 var requestsPool = sync.Pool{
 	New: func() any {
 		return new(function.Request)
 	},
 }
 
-// GetFunctions handles a request to list the function available in the system.
+// GetFunctions handles a request to list the function available in the
+// namespace.
 func GetFunctions(c echo.Context) error {
-	list, err := function.GetAll()
+	list, err := function.GetAllInNamespace(namespaceOf(c))
 	if err != nil {
 		return c.String(http.StatusServiceUnavailable, "")
 	}
@@ -41,11 +60,14 @@ func GetFunctions(c echo.Context) error {
 // InvokeFunction handles a function invocation request.
 func InvokeFunction(c echo.Context) error {
 	funcName := c.Param("fun")
-	fun, ok := function.GetFunction(funcName)
+	fun, ok := lookupFunction(c, funcName)
 	if !ok {
 		log.Printf("Dropping request for unknown fun '%s'\n", funcName)
 		return c.String(http.StatusNotFound, "Function unknown")
 	}
+	if err := node.AdmitInvocation(fun); err != nil {
+		return c.String(http.StatusTooManyRequests, "Invocation rate quota exceeded")
+	}
 
 	var invocationRequest client.InvocationRequest
 	err := json.NewDecoder(c.Request().Body).Decode(&invocationRequest)
@@ -53,9 +75,53 @@ func InvokeFunction(c echo.Context) error {
 		log.Printf("Could not parse request: %v\n", err)
 		return fmt.Errorf("could not parse request: %v", err)
 	}
+	if err := validateAsyncOptions(&invocationRequest); err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
 
-	r := requestsPool.Get().(*function.Request)
-	defer requestsPool.Put(r)
+	r := newRequest(c, fun, &invocationRequest)
+	r.Stream = nil
+
+	if r.Async {
+		if err := scheduling.AcceptAsyncRequest(r); err != nil {
+			log.Printf("Could not accept async request: %v\n", err)
+			return c.String(http.StatusServiceUnavailable, "Could not accept the request")
+		}
+		go scheduling.SubmitAsyncRequest(r)
+		return c.JSON(http.StatusOK, function.AsyncResponse{ReqId: r.ReqId})
+	}
+
+	executionReport, err := scheduling.SubmitRequest(r)
+	if errors.Is(err, scheduling.CancelledErr) {
+		// the request might still be referenced by the scheduler, so it
+		// is not recycled
+		log.Printf("Request %s cancelled by the client\n", r.ReqId)
+		return c.NoContent(statusClientClosedRequest)
+	}
+	requestsPool.Put(r)
+
+	if errors.Is(err, node.OutOfResourcesErr) {
+		return c.String(http.StatusTooManyRequests, "")
+	} else if err != nil {
+		log.Printf("Invocation failed: %v\n", err)
+		return c.String(http.StatusInternalServerError, "")
+	} else {
+		return c.JSON(http.StatusOK, function.Response{Success: true, ExecutionReport: executionReport})
+	}
+}
+
+// newRequest prepares a function request. Synchronous requests are taken
+// from the pool, and must be put back when done.
+func newRequest(c echo.Context, fun *function.Function, invocationRequest *client.InvocationRequest) *function.Request {
+	var r *function.Request
+	if invocationRequest.Async {
+		// async requests outlive this handler and cannot be recycled
+		r = new(function.Request)
+		r.Ctx = context.Background()
+	} else {
+		r = requestsPool.Get().(*function.Request)
+		r.Ctx = c.Request().Context()
+	}
 	r.Fun = fun
 	r.Params = invocationRequest.Params
 	r.Arrival = time.Now()
@@ -64,53 +130,284 @@ func InvokeFunction(c echo.Context) error {
 	r.CanDoOffloading = invocationRequest.CanDoOffloading
 	r.Async = invocationRequest.Async
 	r.ReturnOutput = invocationRequest.ReturnOutput
-	r.ReqId = fmt.Sprintf("%s-%s%d", fun, node.NodeIdentifier[len(node.NodeIdentifier)-5:], r.Arrival.Nanosecond())
+	r.Retries = 0
+	r.HTTPRequest = invocationRequest.HTTPRequest
+	r.Callback = invocationRequest.Callback
+	r.ResultTTL = time.Duration(invocationRequest.ResultTTL) * time.Second
+	r.Attempts = 0
+	r.LastError = ""
+	if invocationRequest.ReqId != "" && isNode(c) {
+		// async request offloaded by another node
+		r.ReqId = invocationRequest.ReqId
+		r.Attempts = invocationRequest.Attempts
+	} else {
+		r.ReqId = fmt.Sprintf("%s-%s%d", fun.Name, node.NodeIdentifier[len(node.NodeIdentifier)-5:], r.Arrival.Nanosecond())
+	}
+	return r
+}
 
-	if r.Async {
-		go scheduling.SubmitAsyncRequest(r)
-		return c.JSON(http.StatusOK, function.AsyncResponse{ReqId: r.ReqId})
+// validateAsyncOptions checks the options of an invocation request which are
+// specific to asynchronous requests (callback and result TTL).
+func validateAsyncOptions(invocationRequest *client.InvocationRequest) error {
+	if invocationRequest.ResultTTL < 0 {
+		return errors.New("invalid ResultTTL: negative")
+	} else if invocationRequest.ResultTTL > 0 && !invocationRequest.Async {
+		return errors.New("ResultTTL is only supported for asynchronous requests")
+	}
+	if invocationRequest.Callback == nil {
+		return nil
+	}
+	if !invocationRequest.Async {
+		return errors.New("callbacks are only supported for asynchronous requests")
+	}
+	return invocationRequest.Callback.Validate()
+}
+
+// lookupFunction retrieves a function of the namespace of the request, given
+// its name.
+func lookupFunction(c echo.Context, name string) (*function.Function, bool) {
+	if strings.Contains(name, "/") {
+		return nil, false
+	}
+	return function.GetFunction(function.QualifiedName(namespaceOf(c), name))
+}
+
+// InvokeFunctionStream handles a synchronous invocation request, streaming
+// the function output and partial results as Server-Sent Events, followed by
+// the final response (or an error). Offloaded requests only stream the final
+// response.
+func InvokeFunctionStream(c echo.Context) error {
+	funcName := c.Param("fun")
+	fun, ok := lookupFunction(c, funcName)
+	if !ok {
+		log.Printf("Dropping request for unknown fun '%s'\n", funcName)
+		return c.String(http.StatusNotFound, "Function unknown")
+	}
+	if err := node.AdmitInvocation(fun); err != nil {
+		return c.String(http.StatusTooManyRequests, "Invocation rate quota exceeded")
 	}
 
+	var invocationRequest client.InvocationRequest
+	err := json.NewDecoder(c.Request().Body).Decode(&invocationRequest)
+	if err != nil && err != io.EOF {
+		log.Printf("Could not parse request: %v\n", err)
+		return fmt.Errorf("could not parse request: %v", err)
+	}
+	if invocationRequest.Async {
+		return c.String(http.StatusBadRequest, "Asynchronous requests cannot be streamed")
+	}
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, sse.ContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	// events are not written once the handler has returned (e.g., if the
+	// request has been cancelled while running)
+	var lock sync.Mutex
+	closed := false
+	defer func() {
+		lock.Lock()
+		closed = true
+		lock.Unlock()
+	}()
+	emit := func(event string, data string) {
+		lock.Lock()
+		defer lock.Unlock()
+		if closed {
+			return
+		}
+		if err := sse.WriteEvent(w, event, data); err != nil {
+			log.Printf("Could not stream event: %v\n", err)
+		}
+	}
+
+	r := newRequest(c, fun, &invocationRequest)
+	r.Stream = emit
+
+	executionReport, err := scheduling.SubmitRequest(r)
+	if errors.Is(err, scheduling.CancelledErr) {
+		log.Printf("Request %s cancelled by the client\n", r.ReqId)
+		return nil
+	}
+	r.Stream = nil
+	requestsPool.Put(r)
+
+	if errors.Is(err, node.OutOfResourcesErr) {
+		emit(executor.EVENT_ERROR, "Too many requests")
+	} else if err != nil {
+		log.Printf("Invocation failed: %v\n", err)
+		emit(executor.EVENT_ERROR, "Invocation failed")
+	} else {
+		response, _ := json.Marshal(function.Response{Success: true, ExecutionReport: executionReport})
+		emit(executor.EVENT_RESULT, string(response))
+	}
+	return nil
+}
+
+// maxHTTPBodyBytes is the max size of the body of HTTP trigger requests
+const maxHTTPBodyBytes = 32 * 1048576
+
+// credentialHeaders are used to access Serverledge, hence they are not passed
+// to functions through their HTTP trigger
+var credentialHeaders = []string{"Authorization", "X-API-Key", function.NAMESPACE_HEADER}
+
+// InvokeHTTP handles a request received by the HTTP trigger of a function:
+// the raw request is passed to the function, and the HTTP response returned
+// by the function is relayed verbatim to the client. If the function does not
+// return a HTTP response, its result is returned as a JSON body.
+func InvokeHTTP(c echo.Context) error {
+	funcName := c.Param("fun")
+	fun, ok := lookupFunction(c, funcName)
+	if !ok {
+		log.Printf("Dropping request for unknown fun '%s'\n", funcName)
+		return c.String(http.StatusNotFound, "Function unknown")
+	}
+	if err := node.AdmitInvocation(fun); err != nil {
+		return c.String(http.StatusTooManyRequests, "Invocation rate quota exceeded")
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Response(), c.Request().Body, maxHTTPBodyBytes))
+	if err != nil {
+		return c.String(http.StatusRequestEntityTooLarge, "")
+	}
+	headers := c.Request().Header.Clone()
+	for _, name := range credentialHeaders {
+		headers.Del(name)
+	}
+	httpRequest := &function.HTTPRequest{
+		Method:  c.Request().Method,
+		Path:    "/" + c.Param("*"),
+		Query:   c.QueryString(),
+		Headers: headers,
+		Body:    body,
+	}
+
+	r := newRequest(c, fun, &client.InvocationRequest{CanDoOffloading: true, HTTPRequest: httpRequest})
+	r.Stream = nil
+
 	executionReport, err := scheduling.SubmitRequest(r)
+	if errors.Is(err, scheduling.CancelledErr) {
+		log.Printf("Request %s cancelled by the client\n", r.ReqId)
+		return c.NoContent(statusClientClosedRequest)
+	}
+	r.HTTPRequest = nil
+	requestsPool.Put(r)
 
 	if errors.Is(err, node.OutOfResourcesErr) {
 		return c.String(http.StatusTooManyRequests, "")
 	} else if err != nil {
 		log.Printf("Invocation failed: %v\n", err)
 		return c.String(http.StatusInternalServerError, "")
-	} else {
-		return c.JSON(http.StatusOK, function.Response{Success: true, ExecutionReport: executionReport})
 	}
+
+	resp := executionReport.HTTPResponse
+	if resp == nil {
+		return c.Blob(http.StatusOK, echo.MIMEApplicationJSON, []byte(executionReport.Result))
+	}
+	for name, values := range resp.Headers {
+		if isHopByHopHeader(name) {
+			continue
+		}
+		for _, v := range values {
+			c.Response().Header().Add(name, v)
+		}
+	}
+	status := resp.StatusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+	c.Response().WriteHeader(status)
+	_, err = c.Response().Write(resp.Body)
+	return err
+}
+
+// isHopByHopHeader returns true for headers that must not be relayed.
+func isHopByHopHeader(name string) bool {
+	switch http.CanonicalHeaderKey(name) {
+	case "Connection", "Keep-Alive", "Proxy-Connection", "Transfer-Encoding", "Upgrade", "Trailer", "Te", "Content-Length":
+		return true
+	}
+	return false
 }
 
 // PollAsyncResult checks for the result of an asynchronous invocation.
 func PollAsyncResult(c echo.Context) error {
-	reqId := c.Param("reqId")
-	if len(reqId) < 0 {
+	wait, err := parseWait(c, maxPollWait())
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	payload, err := pollAsyncResult(c, c.Param("reqId"), wait)
+	var remote *asyncstore.RemoteResultErr
+	if errors.As(err, &remote) {
+		// large results are served by the node that produced them
+		return c.Redirect(http.StatusTemporaryRedirect, remote.NodeURL+c.Request().URL.RequestURI())
+	} else if errors.Is(err, ResultNotFoundErr) {
+		// the request may be still pending
+		if status, err := getAsyncStatus(namespaceOf(c), c.Param("reqId")); err == nil {
+			return c.JSON(http.StatusAccepted, status)
+		}
 		return c.String(http.StatusNotFound, "")
+	} else if err != nil {
+		log.Println(err)
+		return c.String(http.StatusInternalServerError, "Could not retrieve results")
 	}
+	return c.JSONBlob(http.StatusOK, payload)
+}
 
-	etcdClient, err := utils.GetEtcdClient()
+// GetCallbackStatus returns the delivery status of the callback of an
+// asynchronous invocation.
+func GetCallbackStatus(c echo.Context) error {
+	payload, err := getCallbackStatus(namespaceOf(c), c.Param("reqId"))
+	if errors.Is(err, ResultNotFoundErr) {
+		return c.String(http.StatusNotFound, "")
+	} else if err != nil {
+		log.Println(err)
+		return c.String(http.StatusInternalServerError, "Could not retrieve callback status")
+	}
+	return c.JSONBlob(http.StatusOK, payload)
+}
+
+// getAsyncResult retrieves the JSON-encoded result of an asynchronous
+// invocation in a namespace.
+func getAsyncResult(namespace string, reqId string) ([]byte, error) {
+	if len(reqId) == 0 {
+		return nil, ResultNotFoundErr
+	}
+	store, err := asyncstore.Get()
 	if err != nil {
-		log.Println("Could not connect to Etcd")
-		return c.String(http.StatusInternalServerError, "Failed to connect to the Global Registry")
+		return nil, err
+	}
+	result, err := store.Get(namespace, reqId)
+	if errors.Is(err, asyncstore.NotFoundErr) {
+		return nil, ResultNotFoundErr
 	}
+	return result, err
+}
 
-	ctx := context.Background()
+// getCallbackStatus retrieves the JSON-encoded delivery status of the
+// callback of an asynchronous invocation in a namespace.
+func getCallbackStatus(namespace string, reqId string) ([]byte, error) {
+	if len(reqId) == 0 {
+		return nil, ResultNotFoundErr
+	}
+	return getFromEtcd(function.CallbackStatusKey(namespace, reqId))
+}
 
-	key := fmt.Sprintf("async/%s", reqId)
-	res, err := etcdClient.Get(ctx, key)
+func getFromEtcd(key string) ([]byte, error) {
+	etcdClient, err := utils.GetEtcdClient()
 	if err != nil {
-		log.Println(err)
-		return c.String(http.StatusInternalServerError, "Could not retrieve results")
+		return nil, fmt.Errorf("failed to connect to the Global Registry: %v", err)
 	}
 
-	if len(res.Kvs) == 1 {
-		payload := res.Kvs[0].Value
-		return c.JSONBlob(http.StatusOK, payload)
-	} else {
-		return c.String(http.StatusNotFound, "")
+	res, err := etcdClient.Get(context.Background(), key)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Kvs) != 1 {
+		return nil, ResultNotFoundErr
 	}
+	return res.Kvs[0].Value, nil
 }
 
 // CreateFunction handles a function creation request.
@@ -122,29 +419,87 @@ func CreateFunction(c echo.Context) error {
 		return err
 	}
 
-	_, ok := function.GetFunction(f.Name) // TODO: we would need a system-wide lock here...
-	if ok {
-		log.Printf("Dropping request for already existing function '%s'\n", f.Name)
+	err = createFunction(namespaceOf(c), &f)
+	if errors.Is(err, FunctionExistsErr) {
 		return c.String(http.StatusConflict, "")
+	} else if errors.Is(err, UnknownRuntimeErr) {
+		return c.JSON(http.StatusNotFound, "Invalid runtime.")
+	} else if errors.Is(err, function.NamespaceNotFoundErr) {
+		return c.JSON(http.StatusNotFound, "Unknown namespace.")
+	} else if errors.Is(err, InvalidHandlerErr) || errors.Is(err, InvalidFunctionNameErr) || errors.Is(err, InvalidFunctionErr) {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	} else if errors.Is(err, node.QuotaExceededErr) {
+		return c.JSON(http.StatusForbidden, "Function quota exceeded.")
+	} else if err != nil {
+		return c.JSON(http.StatusServiceUnavailable, "")
+	}
+	response := struct{ Created string }{f.Name}
+	return c.JSON(http.StatusOK, response)
+}
+
+// createFunction validates and registers a new function in a namespace,
+// filling in default values.
+func createFunction(namespace string, f *function.Function) error {
+	if f.Name == "" || strings.Contains(f.Name, "/") {
+		return fmt.Errorf("%w: '%s'", InvalidFunctionNameErr, f.Name)
+	}
+	if f.AsyncResultTTL < 0 {
+		return fmt.Errorf("%w: negative AsyncResultTTL", InvalidFunctionErr)
+	}
+	if f.Retry != nil {
+		if err := f.Retry.Validate(); err != nil {
+			return fmt.Errorf("%w: %v", InvalidFunctionErr, err)
+		}
+	}
+	f.Namespace = ""
+	if namespace != function.DEFAULT_NAMESPACE {
+		f.Namespace = namespace
 	}
 
-	log.Printf("New request: creation of %s\n", f.Name)
+	_, ok := function.GetFunction(f.Id()) // TODO: we would need a system-wide lock here...
+	if ok {
+		log.Printf("Dropping request for already existing function '%s'\n", f.Id())
+		return FunctionExistsErr
+	}
+
+	ns, ok := function.GetNamespace(namespace)
+	if !ok {
+		return function.NamespaceNotFoundErr
+	}
+	if ns.Quota.MaxFunctions > 0 {
+		existing, err := function.GetAllInNamespace(namespace)
+		if err != nil {
+			return err
+		}
+		if len(existing) >= ns.Quota.MaxFunctions {
+			log.Printf("Dropping creation of '%s': function quota exceeded\n", f.Id())
+			return node.QuotaExceededErr
+		}
+	}
+
+	log.Printf("New request: creation of %s\n", f.Id())
 
-	// Check that the selected runtime exists
+	// Check that the selected runtime exists and supports the handler
 	if f.Runtime != container.CUSTOM_RUNTIME {
-		_, ok := container.RuntimeToInfo[f.Runtime]
+		runtime, ok := container.GetRuntimeInfo(f.Runtime)
 		if !ok {
-			return c.JSON(http.StatusNotFound, "Invalid runtime.")
+			return UnknownRuntimeErr
 		}
+		if err := runtime.ValidateHandler(f.Handler); err != nil {
+			return fmt.Errorf("%w: %v", InvalidHandlerErr, err)
+		}
+		if f.MemoryMB <= 0 {
+			f.MemoryMB = runtime.GetDefaultMemoryMB()
+		}
+	} else if f.MemoryMB <= 0 {
+		f.MemoryMB = container.DefaultMemoryMB
 	}
 
-	err = f.SaveToEtcd()
-	if err != nil {
+	if err := f.SaveToEtcd(); err != nil {
 		log.Printf("Failed creation: %v\n", err)
-		return c.JSON(http.StatusServiceUnavailable, "")
+		return err
 	}
-	response := struct{ Created string }{f.Name}
-	return c.JSON(http.StatusOK, response)
+	return nil
 }
 
 // DeleteFunction handles a function deletion request.
@@ -156,24 +511,39 @@ func DeleteFunction(c echo.Context) error {
 		return err
 	}
 
-	_, ok := function.GetFunction(f.Name) // TODO: we would need a system-wide lock here...
-	if !ok {
-		log.Printf("Dropping request for non existing function '%s'\n", f.Name)
+	err = deleteFunction(namespaceOf(c), f.Name)
+	if errors.Is(err, FunctionNotFoundErr) {
 		return c.String(http.StatusNotFound, "Unknown function")
+	} else if err != nil {
+		return c.String(http.StatusServiceUnavailable, "")
 	}
 
-	log.Printf("New request: deleting %s\n", f.Name)
-	err = f.Delete()
-	if err != nil {
+	response := struct{ Deleted string }{f.Name}
+	return c.JSON(http.StatusOK, response)
+}
+
+// deleteFunction removes a function of a namespace, destroying its local warm
+// containers.
+func deleteFunction(namespace string, name string) error {
+	if strings.Contains(name, "/") {
+		return FunctionNotFoundErr
+	}
+	f, ok := function.GetFunction(function.QualifiedName(namespace, name)) // TODO: we would need a system-wide lock here...
+	if !ok {
+		log.Printf("Dropping request for non existing function '%s'\n", name)
+		return FunctionNotFoundErr
+	}
+
+	log.Printf("New request: deleting %s\n", f.Id())
+	if err := f.Delete(); err != nil {
 		log.Printf("Failed deletion: %v\n", err)
-		return c.String(http.StatusServiceUnavailable, "")
+		return err
 	}
 
 	// Delete local warm containers
-	node.ShutdownWarmContainersFor(&f)
-
-	response := struct{ Deleted string }{f.Name}
-	return c.JSON(http.StatusOK, response)
+	node.ShutdownWarmContainersFor(f)
+	node.ForgetUsage(f.Id())
+	return nil
 }
 
 func DecodeServiceClass(serviceClass string) (p function.ServiceClass) {
@@ -190,19 +560,44 @@ func DecodeServiceClass(serviceClass string) (p function.ServiceClass) {
 
 // GetServerStatus simple api to check the current server status
 func GetServerStatus(c echo.Context) error {
+	return c.JSON(http.StatusOK, nodeStatusFor(c))
+}
+
+// nodeStatusFor returns the status of the node as visible to the client of
+// the request: clients bound to a namespace only see the usage of its
+// functions.
+func nodeStatusFor(c echo.Context) registration.StatusInformation {
+	status := getNodeStatus()
+	namespace := principalOf(c).Namespace
+	if namespace == "" {
+		return status
+	}
+	status.Images = nil
+	visible := make(map[string]node.ObservedUsage)
+	for id, usage := range status.ObservedUsage {
+		if ns, _ := function.SplitQualifiedName(id); ns == namespace {
+			visible[id] = usage
+		}
+	}
+	status.ObservedUsage = visible
+	return status
+}
+
+func getNodeStatus() registration.StatusInformation {
 	node.Resources.RLock()
 	defer node.Resources.RUnlock()
 	portNumber := config.GetInt("api.port", 1323)
-	url := fmt.Sprintf("http://%s:%d", utils.GetIpAddress().String(), portNumber)
-	response := registration.StatusInformation{
+	url := fmt.Sprintf("%s://%s:%d", tlsutil.Scheme(), utils.GetIpAddress().String(), portNumber)
+	return registration.StatusInformation{
 		Url:            url,
 		AvailableMemMB: node.Resources.AvailableMemMB,
 		AvailableCPUs:  node.Resources.AvailableCPUs,
 		DropCount:      node.Resources.DropCount,
+		CancelCount:    node.Resources.CancelCount,
 		Coordinates:    *registration.Reg.Client.GetCoordinate(),
+		Images:         container.GetImagesStatus(),
+		ObservedUsage:  node.GetAllObservedUsage(),
 	}
-
-	return c.JSON(http.StatusOK, response)
 }
 
 // PrewarmFunction handles a prewarming request.
@@ -214,7 +609,7 @@ func PrewarmFunction(c echo.Context) error {
 		return err
 	}
 
-	fun, ok := function.GetFunction(req.Function)
+	fun, ok := lookupFunction(c, req.Function)
 	if !ok {
 		log.Printf("Dropping request for unknown fun '%s'\n", req.Function)
 		return c.String(http.StatusNotFound, "Function unknown")
@@ -229,3 +624,43 @@ func PrewarmFunction(c echo.Context) error {
 	response := struct{ Prewarmed int64 }{count}
 	return c.JSON(http.StatusOK, response)
 }
+
+// DrainNode handles a request to put the node in maintenance mode.
+// The request returns once the node has been drained.
+func DrainNode(c echo.Context) error {
+	var req client.DrainRequest
+	err := json.NewDecoder(c.Request().Body).Decode(&req)
+	if err != nil && err != io.EOF {
+		log.Printf("Could not parse request: %v\n", err)
+		return err
+	}
+
+	completed, pending := drainNode(&req)
+	response := struct {
+		Drained bool
+		Pending int64
+	}{completed, pending}
+	return c.JSON(http.StatusOK, response)
+}
+
+// drainNode drains the node, returning whether draining completed and the
+// number of requests still in flight.
+func drainNode(req *client.DrainRequest) (bool, int64) {
+	timeout := scheduling.DefaultDrainTimeout()
+	if req.Timeout > 0 {
+		timeout = time.Duration(req.Timeout) * time.Second
+	}
+
+	completed := scheduling.Drain(timeout)
+	return completed, node.InFlightRequests()
+}
+
+// ResumeNode handles a request to bring a drained node back into service.
+func ResumeNode(c echo.Context) error {
+	if err := scheduling.Resume(); err != nil {
+		log.Printf("Failed resuming: %v\n", err)
+		return c.String(http.StatusServiceUnavailable, "")
+	}
+	response := struct{ Resumed bool }{true}
+	return c.JSON(http.StatusOK, response)
+}
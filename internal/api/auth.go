@@ -0,0 +1,149 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/grussorusso/serverledge/internal/auth"
+	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/grussorusso/serverledge/internal/tlsutil"
+	"github.com/labstack/echo/v4"
+)
+
+// principalContextKey is the echo context key of the authenticated client.
+const principalContextKey = "auth.principal"
+
+// namespaceContextKey is the echo context key of the namespace accessed by
+// the request.
+const namespaceContextKey = "namespace"
+
+// Authenticate is a middleware that identifies the client of every request
+// based on its API key, if authentication is enabled, and resolves the
+// namespace the request refers to.
+func Authenticate(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var principal auth.Principal
+		if auth.Enabled() && tlsutil.IsClusterPeer(c.Request()) {
+			// other nodes are identified by their certificates
+			principal = auth.Principal{Role: auth.SERVICE}
+			c.Set(principalContextKey, principal)
+		} else if auth.Enabled() {
+			var err error
+			principal, err = auth.Authenticate(auth.TokenFromRequest(c.Request()))
+			if err != nil {
+				if !errors.Is(err, auth.MissingCredentialsErr) {
+					log.Printf("Rejected request from %s: %v\n", c.RealIP(), err)
+				}
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+				return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+			}
+			c.Set(principalContextKey, principal)
+		}
+
+		namespace := c.Request().Header.Get(function.NAMESPACE_HEADER)
+		if namespace == "" {
+			namespace = principal.Namespace
+		}
+		if namespace == "" {
+			namespace = function.DEFAULT_NAMESPACE
+		} else if err := function.ValidateNamespaceName(namespace); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		if principal.Namespace != "" && namespace != principal.Namespace {
+			return echo.NewHTTPError(http.StatusForbidden, "access to namespace "+namespace+" not allowed")
+		}
+		c.Set(namespaceContextKey, namespace)
+		return next(c)
+	}
+}
+
+// authorize returns a middleware that only lets requests through if the
+// client has the given permission.
+func authorize(p auth.Permission) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !auth.Enabled() {
+				return next(c)
+			}
+			principal := principalOf(c)
+			if !principal.Can(p) {
+				return echo.NewHTTPError(http.StatusForbidden, "operation not allowed for role "+string(principal.Role))
+			}
+			return next(c)
+		}
+	}
+}
+
+// principalOf returns the client of the request (zero value if
+// authentication is disabled).
+func principalOf(c echo.Context) auth.Principal {
+	principal, _ := c.Get(principalContextKey).(auth.Principal)
+	return principal
+}
+
+// isNode returns true if the request has been sent by another node (or
+// authentication is disabled).
+func isNode(c echo.Context) bool {
+	return !auth.Enabled() || principalOf(c).Role == auth.SERVICE
+}
+
+// namespaceOf returns the namespace the request refers to.
+func namespaceOf(c echo.Context) string {
+	if namespace, ok := c.Get(namespaceContextKey).(string); ok {
+		return namespace
+	}
+	return function.DEFAULT_NAMESPACE
+}
+
+// KeyRequest asks for the creation of an API key.
+type KeyRequest struct {
+	Role        auth.Role
+	Namespace   string `json:",omitempty"` // restricts the key to a namespace
+	Description string
+}
+
+// KeyCreated is returned upon creation of an API key, which is never shown
+// again.
+type KeyCreated struct {
+	Token string
+	Key   auth.APIKey
+}
+
+func listKeysV2(c echo.Context) error {
+	return c.JSON(http.StatusOK, auth.GetAllKeys())
+}
+
+func createKeyV2(c echo.Context) error {
+	var req KeyRequest
+	if err := decodeV2(c, &req); err != nil {
+		return errorV2(c, http.StatusBadRequest, ERR_INVALID_REQUEST, err.Error())
+	}
+
+	key, token, err := auth.CreateKey(req.Role, req.Namespace, req.Description)
+	if errors.Is(err, auth.InvalidRoleErr) {
+		return errorV2(c, http.StatusBadRequest, ERR_INVALID_REQUEST, err.Error())
+	} else if errors.Is(err, function.NamespaceNotFoundErr) {
+		return errorV2(c, http.StatusNotFound, ERR_NAMESPACE_NOT_FOUND, err.Error())
+	} else if err != nil {
+		log.Printf("Failed API key creation: %v\n", err)
+		return errorV2(c, http.StatusServiceUnavailable, ERR_UNAVAILABLE, err.Error())
+	}
+	log.Printf("Created API key %s (%s)\n", key.Id, key.Role)
+
+	key.Hash = ""
+	c.Response().Header().Set(echo.HeaderLocation, V2_PREFIX+"/keys/"+key.Id)
+	return c.JSON(http.StatusCreated, KeyCreated{Token: token, Key: key})
+}
+
+func deleteKeyV2(c echo.Context) error {
+	id := c.Param("id")
+	err := auth.DeleteKey(id)
+	if errors.Is(err, auth.KeyNotFoundErr) {
+		return errorV2(c, http.StatusNotFound, ERR_KEY_NOT_FOUND, "unknown API key: "+id)
+	} else if err != nil {
+		return errorV2(c, http.StatusServiceUnavailable, ERR_UNAVAILABLE, err.Error())
+	}
+	log.Printf("Deleted API key %s\n", id)
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/grussorusso/serverledge/internal/client"
+	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/grussorusso/serverledge/internal/node"
+	"github.com/grussorusso/serverledge/internal/scheduling"
+	"github.com/labstack/echo/v4"
+)
+
+// GetDeadLetters lists the async requests of the namespace which have failed
+// after exhausting their attempts.
+func GetDeadLetters(c echo.Context) error {
+	letters, err := function.GetDeadLetters(namespaceOf(c))
+	if err != nil {
+		log.Println(err)
+		return c.String(http.StatusServiceUnavailable, "Could not retrieve dead letters")
+	}
+	return c.JSON(http.StatusOK, redactDeadLetters(letters))
+}
+
+// GetDeadLetter returns a failed async request, along with its parameters.
+func GetDeadLetter(c echo.Context) error {
+	letter, err := function.GetDeadLetter(namespaceOf(c), c.Param("reqId"))
+	if errors.Is(err, function.DeadLetterNotFoundErr) {
+		return c.String(http.StatusNotFound, "")
+	} else if err != nil {
+		log.Println(err)
+		return c.String(http.StatusServiceUnavailable, "Could not retrieve the dead letter")
+	}
+	return c.JSON(http.StatusOK, letter.Redacted())
+}
+
+// redactDeadLetters removes the callback secrets from dead letters returned
+// to clients.
+func redactDeadLetters(letters []function.DeadLetter) []function.DeadLetter {
+	redacted := make([]function.DeadLetter, 0, len(letters))
+	for _, letter := range letters {
+		redacted = append(redacted, letter.Redacted())
+	}
+	return redacted
+}
+
+// RedriveDeadLetter submits a failed async request again, as a new request.
+func RedriveDeadLetter(c echo.Context) error {
+	r, err := redriveDeadLetter(c, c.Param("reqId"))
+	if errors.Is(err, function.DeadLetterNotFoundErr) {
+		return c.String(http.StatusNotFound, "")
+	} else if errors.Is(err, FunctionNotFoundErr) {
+		return c.String(http.StatusNotFound, "Function unknown")
+	} else if errors.Is(err, node.QuotaExceededErr) {
+		return c.String(http.StatusTooManyRequests, "Invocation rate quota exceeded")
+	} else if err != nil {
+		return c.String(http.StatusServiceUnavailable, "Could not accept the request")
+	}
+	return c.JSON(http.StatusOK, function.AsyncResponse{ReqId: r.ReqId})
+}
+
+// redriveDeadLetter submits a failed async request again, with a new request
+// ID (and all its attempts). The dead letter is removed, unless the request
+// cannot be accepted.
+func redriveDeadLetter(c echo.Context, reqId string) (*function.Request, error) {
+	letter, err := function.GetDeadLetter(namespaceOf(c), reqId)
+	if err != nil {
+		return nil, err
+	}
+	fun, ok := lookupFunction(c, letter.Function)
+	if !ok {
+		return nil, FunctionNotFoundErr
+	}
+	if err := node.AdmitInvocation(fun); err != nil {
+		return nil, err
+	}
+
+	r := newRequest(c, fun, &client.InvocationRequest{
+		Params:          letter.Params,
+		QoSClass:        letter.QoSClass,
+		QoSMaxRespT:     letter.QoSMaxRespT,
+		CanDoOffloading: letter.CanDoOffloading,
+		Async:           true,
+		ReturnOutput:    letter.ReturnOutput,
+		Callback:        letter.Callback,
+		ResultTTL:       letter.ResultTTL,
+	})
+	r.Stream = nil
+
+	// the dead letter is removed first, so that it is re-driven only once
+	if err := letter.Delete(); err != nil {
+		return nil, err
+	}
+	if err := scheduling.AcceptAsyncRequest(r); err != nil {
+		log.Printf("Could not accept async request: %v\n", err)
+		if err := letter.SaveToEtcd(0); err != nil {
+			log.Printf("Could not restore dead letter %s: %v\n", reqId, err)
+		}
+		return nil, err
+	}
+	log.Printf("Re-driving failed request %s as %s\n", reqId, r.ReqId)
+	go scheduling.SubmitAsyncRequest(r)
+	return r, nil
+}
+
+func listDeadLettersV2(c echo.Context) error {
+	letters, err := function.GetDeadLetters(namespaceOf(c))
+	if err != nil {
+		return errorV2(c, http.StatusServiceUnavailable, ERR_UNAVAILABLE, err.Error())
+	}
+	return c.JSON(http.StatusOK, redactDeadLetters(letters))
+}
+
+func getDeadLetterV2(c echo.Context) error {
+	id := c.Param("id")
+	letter, err := function.GetDeadLetter(namespaceOf(c), id)
+	if errors.Is(err, function.DeadLetterNotFoundErr) {
+		return errorV2(c, http.StatusNotFound, ERR_DEAD_LETTER_NOT_FOUND, fmt.Sprintf("unknown dead letter: %s", id))
+	} else if err != nil {
+		return errorV2(c, http.StatusServiceUnavailable, ERR_UNAVAILABLE, err.Error())
+	}
+	return c.JSON(http.StatusOK, letter.Redacted())
+}
+
+func redriveDeadLetterV2(c echo.Context) error {
+	id := c.Param("id")
+	r, err := redriveDeadLetter(c, id)
+	if errors.Is(err, function.DeadLetterNotFoundErr) {
+		return errorV2(c, http.StatusNotFound, ERR_DEAD_LETTER_NOT_FOUND, fmt.Sprintf("unknown dead letter: %s", id))
+	} else if errors.Is(err, FunctionNotFoundErr) {
+		return errorV2(c, http.StatusNotFound, ERR_FUNCTION_NOT_FOUND, "the function of the request no longer exists")
+	} else if errors.Is(err, node.QuotaExceededErr) {
+		return errorV2(c, http.StatusTooManyRequests, ERR_QUOTA_EXCEEDED, "invocation rate quota exceeded")
+	} else if err != nil {
+		return errorV2(c, http.StatusServiceUnavailable, ERR_UNAVAILABLE, "could not accept the request")
+	}
+	location := V2_PREFIX + "/invocations/" + r.ReqId
+	c.Response().Header().Set(echo.HeaderLocation, location)
+	return c.JSON(http.StatusAccepted, AsyncInvocation{Id: r.ReqId, Location: location})
+}
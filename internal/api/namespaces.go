@@ -0,0 +1,86 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/grussorusso/serverledge/internal/node"
+	"github.com/labstack/echo/v4"
+)
+
+// NamespaceStatus describes a namespace, along with the number of its
+// functions and the resources they are using on this node.
+type NamespaceStatus struct {
+	function.Namespace
+	Functions int
+	Usage     node.NamespaceUsage
+}
+
+func listNamespacesV2(c echo.Context) error {
+	return c.JSON(http.StatusOK, function.GetAllNamespaces())
+}
+
+func getNamespaceV2(c echo.Context) error {
+	name := c.Param("name")
+	ns, ok := function.GetNamespace(name)
+	if !ok {
+		return errorV2(c, http.StatusNotFound, ERR_NAMESPACE_NOT_FOUND, fmt.Sprintf("unknown namespace: %s", name))
+	}
+	functions, err := function.GetAllInNamespace(name)
+	if err != nil {
+		return errorV2(c, http.StatusServiceUnavailable, ERR_UNAVAILABLE, err.Error())
+	}
+	return c.JSON(http.StatusOK, NamespaceStatus{Namespace: ns, Functions: len(functions), Usage: node.GetNamespaceUsage(name)})
+}
+
+func saveNamespaceV2(c echo.Context) error {
+	name := c.Param("name")
+	var ns function.Namespace
+	if err := decodeV2(c, &ns); err != nil {
+		return errorV2(c, http.StatusBadRequest, ERR_INVALID_REQUEST, err.Error())
+	}
+	if ns.Name == "" {
+		ns.Name = name
+	} else if ns.Name != name {
+		return errorV2(c, http.StatusBadRequest, ERR_INVALID_REQUEST, "the namespace name does not match the path")
+	}
+	if err := function.ValidateNamespaceName(ns.Name); err != nil {
+		return errorV2(c, http.StatusBadRequest, ERR_INVALID_REQUEST, err.Error())
+	}
+
+	log.Printf("New request: saving namespace %s\n", ns.Name)
+	if err := ns.SaveToEtcd(); err != nil {
+		log.Printf("Failed namespace creation: %v\n", err)
+		return errorV2(c, http.StatusServiceUnavailable, ERR_UNAVAILABLE, err.Error())
+	}
+	return c.JSON(http.StatusOK, ns)
+}
+
+func deleteNamespaceV2(c echo.Context) error {
+	name := c.Param("name")
+	if name == function.DEFAULT_NAMESPACE {
+		return errorV2(c, http.StatusBadRequest, ERR_INVALID_REQUEST, "the default namespace cannot be deleted")
+	}
+	if _, ok := function.GetNamespace(name); !ok {
+		return errorV2(c, http.StatusNotFound, ERR_NAMESPACE_NOT_FOUND, fmt.Sprintf("unknown namespace: %s", name))
+	}
+	functions, err := function.GetAllInNamespace(name)
+	if err != nil {
+		return errorV2(c, http.StatusServiceUnavailable, ERR_UNAVAILABLE, err.Error())
+	}
+	if len(functions) > 0 {
+		return errorV2(c, http.StatusConflict, ERR_NAMESPACE_NOT_EMPTY, fmt.Sprintf("namespace %s contains %d functions", name, len(functions)))
+	}
+
+	err = function.DeleteNamespace(name)
+	if errors.Is(err, function.NamespaceNotFoundErr) {
+		return errorV2(c, http.StatusNotFound, ERR_NAMESPACE_NOT_FOUND, fmt.Sprintf("unknown namespace: %s", name))
+	} else if err != nil {
+		return errorV2(c, http.StatusServiceUnavailable, ERR_UNAVAILABLE, err.Error())
+	}
+	log.Printf("Deleted namespace %s\n", name)
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,237 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const OPENAPI_VERSION = "3.0.3"
+const API_VERSION = "2.0.0"
+
+var pathParamRegex = regexp.MustCompile(`:([A-Za-z0-9_]+)`)
+
+var openAPIOnce sync.Once
+var openAPIDoc map[string]interface{}
+
+// openAPIDocument returns the OpenAPI description of the v2 API, which is
+// generated from the route table on first use.
+func openAPIDocument() map[string]interface{} {
+	openAPIOnce.Do(func() {
+		openAPIDoc = buildOpenAPIDocument(v2Routes)
+	})
+	return openAPIDoc
+}
+
+func buildOpenAPIDocument(routes []apiRoute) map[string]interface{} {
+	schemas := make(map[string]interface{})
+	paths := make(map[string]interface{})
+
+	schemas["Error"] = schemaFor(reflect.TypeOf(Error{}), schemas)
+	errorResponse := func(status int) map[string]interface{} {
+		return jsonContent(http.StatusText(status), map[string]interface{}{"$ref": "#/components/schemas/Error"})
+	}
+
+	for _, route := range routes {
+		path := V2_PREFIX + pathParamRegex.ReplaceAllString(route.Path, "{$1}")
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[path] = item
+		}
+
+		responses := make(map[string]interface{})
+		if route.Result != nil {
+			responses[strconv.Itoa(route.Status)] = jsonContent(http.StatusText(route.Status), schemaFor(reflect.TypeOf(route.Result), schemas))
+		} else {
+			responses[strconv.Itoa(route.Status)] = map[string]interface{}{"description": http.StatusText(route.Status)}
+		}
+		for _, status := range route.Errors {
+			responses[strconv.Itoa(status)] = errorResponse(status)
+		}
+		// returned only if authentication is enabled
+		responses[strconv.Itoa(http.StatusUnauthorized)] = errorResponse(http.StatusUnauthorized)
+		responses[strconv.Itoa(http.StatusForbidden)] = errorResponse(http.StatusForbidden)
+		responses["default"] = errorResponse(http.StatusInternalServerError)
+
+		op := map[string]interface{}{
+			"summary":     route.Summary,
+			"operationId": strings.ToLower(route.Method) + operationName(route.Path),
+			"responses":   responses,
+		}
+		var params []interface{}
+		for _, m := range pathParamRegex.FindAllStringSubmatch(route.Path, -1) {
+			params = append(params, map[string]interface{}{
+				"name":     m[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, name := range route.Query {
+			params = append(params, map[string]interface{}{
+				"name":   name,
+				"in":     "query",
+				"schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+		if route.Body != nil {
+			body := jsonContent("", schemaFor(reflect.TypeOf(route.Body), schemas))
+			delete(body, "description")
+			op["requestBody"] = body
+		}
+		item[strings.ToLower(route.Method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": OPENAPI_VERSION,
+		"info": map[string]interface{}{
+			"title":   "Serverledge API",
+			"version": API_VERSION,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer"},
+				"apiKeyAuth": map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+		},
+		"security": []interface{}{
+			map[string]interface{}{"bearerAuth": []string{}},
+			map[string]interface{}{"apiKeyAuth": []string{}},
+		},
+	}
+}
+
+func jsonContent(description string, schema interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": schema},
+		},
+	}
+}
+
+// operationName derives an operation name from a route path, e.g.,
+// "/functions/:name/invocations" -> "FunctionsByNameInvocations".
+func operationName(path string) string {
+	var name strings.Builder
+	for _, part := range strings.Split(path, "/") {
+		if part == "" {
+			continue
+		}
+		if strings.HasPrefix(part, ":") {
+			name.WriteString("By")
+			part = part[1:]
+		}
+		part = strings.Map(func(r rune) rune {
+			if r == '.' || r == '-' {
+				return -1
+			}
+			return r
+		}, part)
+		name.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return name.String()
+}
+
+// schemaFor returns the JSON schema of values of type t, as encoded by
+// encoding/json. Named struct types are added to the schemas components and
+// referenced.
+func schemaFor(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		name := schemaName(t)
+		if _, ok := schemas[name]; !ok {
+			schemas[name] = nil // placeholder for recursive types
+			schemas[name] = structSchema(t, schemas)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	default:
+		// interface{} and the like: any value
+		return map[string]interface{}{}
+	}
+}
+
+// schemaName returns the component name for a named type, qualified by its
+// package to avoid clashes (e.g., function.Response -> FunctionResponse).
+func schemaName(t reflect.Type) string {
+	pkg := t.PkgPath()
+	pkg = pkg[strings.LastIndex(pkg, "/")+1:]
+	if pkg == "api" || pkg == "" {
+		return t.Name()
+	}
+	return strings.ToUpper(pkg[:1]) + pkg[1:] + t.Name()
+}
+
+func structSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	properties := make(map[string]interface{})
+	addStructFields(t, properties, schemas)
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+func addStructFields(t reflect.Type, properties map[string]interface{}, schemas map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				// fields of embedded structs are promoted
+				addStructFields(ft, properties, schemas)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = schemaFor(field.Type, schemas)
+	}
+}
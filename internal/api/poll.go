@@ -0,0 +1,205 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/asyncstore"
+	"github.com/grussorusso/serverledge/internal/client"
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/grussorusso/serverledge/internal/sse"
+	"github.com/labstack/echo/v4"
+)
+
+// maxSubscribedRequests bounds the number of requests a client can subscribe
+// to with a single stream
+const maxSubscribedRequests = 100
+
+// keepAliveInterval is the interval between comments written to idle streams
+const keepAliveInterval = 15 * time.Second
+
+// parseWait parses the "wait" query parameter, either a duration (e.g.,
+// "30s") or a number of seconds, bounding it to max (if positive).
+func parseWait(c echo.Context, max time.Duration) (time.Duration, error) {
+	value := c.QueryParam("wait")
+	if value == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, convErr := strconv.ParseFloat(value, 64)
+		if convErr != nil {
+			return 0, fmt.Errorf("invalid wait: %s", value)
+		}
+		wait = time.Duration(seconds * float64(time.Second))
+	}
+	if wait < 0 {
+		return 0, fmt.Errorf("invalid wait: %s", value)
+	}
+	if max > 0 && wait > max {
+		wait = max
+	}
+	return wait, nil
+}
+
+// maxPollWait returns the max time a poll request may wait for a result.
+func maxPollWait() time.Duration {
+	return time.Duration(config.GetInt(config.POLL_MAX_WAIT, 60)) * time.Second
+}
+
+// pollAsyncResult retrieves the result of an asynchronous invocation,
+// waiting for it to be available for up to wait.
+func pollAsyncResult(c echo.Context, reqId string, wait time.Duration) ([]byte, error) {
+	if wait <= 0 {
+		return getAsyncResult(namespaceOf(c), reqId)
+	}
+	ctx, cancel := context.WithTimeout(c.Request().Context(), wait)
+	defer cancel()
+	return waitForAsyncResult(ctx, namespaceOf(c), reqId)
+}
+
+// waitForAsyncResult retrieves the result of an asynchronous invocation,
+// waiting for it to be published until ctx is done.
+func waitForAsyncResult(ctx context.Context, namespace string, reqId string) ([]byte, error) {
+	if len(reqId) == 0 {
+		return nil, ResultNotFoundErr
+	}
+	store, err := asyncstore.Get()
+	if err != nil {
+		return nil, err
+	}
+	result, err := store.Wait(ctx, namespace, reqId)
+	if errors.Is(err, asyncstore.NotFoundErr) {
+		return nil, ResultNotFoundErr
+	}
+	return result, err
+}
+
+// getAsyncStatus retrieves the status of an asynchronous invocation.
+func getAsyncStatus(namespace string, reqId string) (*function.AsyncRequestStatus, error) {
+	if len(reqId) == 0 {
+		return nil, ResultNotFoundErr
+	}
+	payload, err := getFromEtcd(function.AsyncRequestKey(namespace, reqId))
+	if err != nil {
+		return nil, err
+	}
+	var record function.AsyncRequestRecord
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return nil, err
+	}
+	return &record.AsyncRequestStatus, nil
+}
+
+// SubscribeAsyncResults streams the results of a set of asynchronous
+// invocations (given by the "reqId" query parameter, either repeated or
+// comma-separated) as Server-Sent Events, as soon as they are available.
+// The stream ends when all the results have been sent or, if the "wait"
+// query parameter is given, when it expires.
+func SubscribeAsyncResults(c echo.Context) error {
+	var reqIds []string
+	seen := make(map[string]bool)
+	for _, value := range c.QueryParams()["reqId"] {
+		for _, reqId := range strings.Split(value, ",") {
+			reqId = strings.TrimSpace(reqId)
+			if reqId != "" && !seen[reqId] {
+				seen[reqId] = true
+				reqIds = append(reqIds, reqId)
+			}
+		}
+	}
+	if len(reqIds) == 0 {
+		return c.String(http.StatusBadRequest, "No request ID given")
+	} else if len(reqIds) > maxSubscribedRequests {
+		return c.String(http.StatusBadRequest, fmt.Sprintf("Too many request IDs (max: %d)", maxSubscribedRequests))
+	}
+	wait, err := parseWait(c, 0)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if wait > 0 {
+		ctx, cancel = context.WithTimeout(c.Request().Context(), wait)
+	} else {
+		ctx, cancel = context.WithCancel(c.Request().Context())
+	}
+	defer cancel()
+
+	namespace := namespaceOf(c)
+	results := make(chan client.AsyncResultEvent)
+	errs := make(chan error, len(reqIds))
+	for _, reqId := range reqIds {
+		go func(reqId string) {
+			payload, err := waitForAsyncResult(ctx, namespace, reqId)
+			var remote *asyncstore.RemoteResultErr
+			if errors.As(err, &remote) {
+				// large results are not sent in the stream
+				location := remote.NodeURL + "/poll/" + url.PathEscape(reqId)
+				select {
+				case results <- client.AsyncResultEvent{ReqId: reqId, Location: location}:
+				case <-ctx.Done():
+				}
+				return
+			} else if err != nil {
+				if ctx.Err() == nil {
+					errs <- err
+				}
+				return
+			}
+			select {
+			case results <- client.AsyncResultEvent{ReqId: reqId, Result: payload}:
+			case <-ctx.Done():
+			}
+		}(reqId)
+	}
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, sse.ContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for len(seen) > 0 {
+		select {
+		case result := <-results:
+			delete(seen, result.ReqId)
+			data, _ := json.Marshal(result)
+			if err := sse.WriteEvent(w, client.EVENT_ASYNC_RESULT, string(data)); err != nil {
+				return nil
+			}
+		case err := <-errs:
+			log.Printf("Could not retrieve async results: %v\n", err)
+			_ = sse.WriteEvent(w, client.EVENT_ASYNC_ERROR, "Could not retrieve results")
+			return nil
+		case <-keepAlive.C:
+			if err := sse.WriteComment(w, "waiting"); err != nil {
+				return nil
+			}
+		case <-ctx.Done():
+			if c.Request().Context().Err() == nil {
+				pending := make([]string, 0, len(seen))
+				for reqId := range seen {
+					pending = append(pending, reqId)
+				}
+				sort.Strings(pending)
+				data, _ := json.Marshal(pending)
+				_ = sse.WriteEvent(w, client.EVENT_ASYNC_TIMEOUT, string(data))
+			}
+			return nil
+		}
+	}
+	return nil
+}
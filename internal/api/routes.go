@@ -0,0 +1,35 @@
+package api
+
+import (
+	"github.com/grussorusso/serverledge/internal/auth"
+	"github.com/labstack/echo/v4"
+)
+
+// RegisterRoutes registers the node API routes.
+func RegisterRoutes(e *echo.Echo) {
+	e.Use(Authenticate)
+
+	e.POST("/invoke/:fun", InvokeFunction, authorize(auth.INVOKE))
+	e.POST("/invoke/:fun/stream", InvokeFunctionStream, authorize(auth.INVOKE))
+	e.Any("/http/:fun", InvokeHTTP, authorize(auth.INVOKE))
+	e.Any("/http/:fun/*", InvokeHTTP, authorize(auth.INVOKE))
+	e.POST("/prewarm", PrewarmFunction, authorize(auth.MANAGE_FUNCTIONS))
+	e.POST("/create", CreateFunction, authorize(auth.MANAGE_FUNCTIONS))
+	e.POST("/delete", DeleteFunction, authorize(auth.MANAGE_FUNCTIONS))
+	e.GET("/function", GetFunctions, authorize(auth.READ))
+	e.GET("/runtime", GetRuntimes, authorize(auth.READ))
+	e.GET("/runtime/:name", GetRuntime, authorize(auth.READ))
+	e.POST("/runtime", SaveRuntime, authorize(auth.MANAGE_NODE))
+	e.DELETE("/runtime/:name", DeleteRuntime, authorize(auth.MANAGE_NODE))
+	e.GET("/poll/:reqId", PollAsyncResult, authorize(auth.READ))
+	e.GET("/subscribe", SubscribeAsyncResults, authorize(auth.READ))
+	e.GET("/callback/:reqId", GetCallbackStatus, authorize(auth.READ))
+	e.GET("/deadletter", GetDeadLetters, authorize(auth.READ))
+	e.GET("/deadletter/:reqId", GetDeadLetter, authorize(auth.READ))
+	e.POST("/deadletter/:reqId/redrive", RedriveDeadLetter, authorize(auth.INVOKE))
+	e.GET("/status", GetServerStatus, authorize(auth.READ))
+	e.POST("/drain", DrainNode, authorize(auth.MANAGE_NODE))
+	e.POST("/resume", ResumeNode, authorize(auth.MANAGE_NODE))
+
+	RegisterV2Routes(e)
+}
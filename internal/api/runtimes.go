@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/grussorusso/serverledge/internal/container"
+	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/labstack/echo/v4"
+)
+
+// GetRuntimes handles a request to list the runtimes in the catalog.
+func GetRuntimes(c echo.Context) error {
+	return c.JSON(http.StatusOK, container.GetAllRuntimes())
+}
+
+// GetRuntime handles a request to describe a runtime.
+func GetRuntime(c echo.Context) error {
+	runtime, ok := container.GetRuntimeInfo(c.Param("name"))
+	if !ok {
+		return c.String(http.StatusNotFound, "Unknown runtime")
+	}
+	return c.JSON(http.StatusOK, runtime)
+}
+
+// SaveRuntime handles a request to add a runtime to the catalog (or update an
+// existing one).
+func SaveRuntime(c echo.Context) error {
+	var runtime container.RuntimeInfo
+	if err := json.NewDecoder(c.Request().Body).Decode(&runtime); err != nil {
+		log.Printf("Could not parse request: %v\n", err)
+		return c.String(http.StatusBadRequest, "Could not parse request")
+	}
+	if err := runtime.Validate(); err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	log.Printf("New request: saving runtime %s\n", runtime.Name)
+	if err := runtime.SaveToEtcd(); err != nil {
+		log.Printf("Failed runtime creation: %v\n", err)
+		return c.String(http.StatusServiceUnavailable, "")
+	}
+
+	response := struct{ Saved string }{runtime.Name}
+	return c.JSON(http.StatusOK, response)
+}
+
+// DeleteRuntime handles a request to remove a runtime from the catalog.
+// Runtimes used by existing functions cannot be removed.
+func DeleteRuntime(c echo.Context) error {
+	name := c.Param("name")
+	err := deleteRuntime(name)
+	if errors.Is(err, UnknownRuntimeErr) {
+		return c.String(http.StatusNotFound, "Unknown runtime")
+	} else if errors.Is(err, RuntimeInUseErr) {
+		return c.String(http.StatusConflict, "Runtime used by "+strings.TrimPrefix(err.Error(), RuntimeInUseErr.Error()+": "))
+	} else if err != nil {
+		return c.String(http.StatusServiceUnavailable, "")
+	}
+
+	response := struct{ Deleted string }{name}
+	return c.JSON(http.StatusOK, response)
+}
+
+// deleteRuntime removes a runtime from the catalog, unless it is used by
+// some function.
+func deleteRuntime(name string) error {
+	if _, ok := container.GetRuntimeInfo(name); !ok {
+		return UnknownRuntimeErr
+	}
+
+	functions, err := function.GetAll()
+	if err != nil {
+		return err
+	}
+	for _, funcName := range functions {
+		if f, ok := function.GetFunction(funcName); ok && f.Runtime == name {
+			return fmt.Errorf("%w: function %s", RuntimeInUseErr, funcName)
+		}
+	}
+
+	log.Printf("New request: deleting runtime %s\n", name)
+	if err := container.DeleteRuntime(name); err != nil {
+		log.Printf("Failed runtime deletion: %v\n", err)
+		return err
+	}
+	return nil
+}
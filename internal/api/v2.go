@@ -0,0 +1,518 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/grussorusso/serverledge/internal/asyncstore"
+	"github.com/grussorusso/serverledge/internal/auth"
+	"github.com/grussorusso/serverledge/internal/client"
+	"github.com/grussorusso/serverledge/internal/container"
+	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/grussorusso/serverledge/internal/node"
+	"github.com/grussorusso/serverledge/internal/registration"
+	"github.com/grussorusso/serverledge/internal/scheduling"
+	"github.com/labstack/echo/v4"
+)
+
+const V2_PREFIX = "/v2"
+
+// Error codes returned by the v2 API.
+const (
+	ERR_INVALID_REQUEST       = "invalid_request"
+	ERR_UNAUTHORIZED          = "unauthorized"
+	ERR_FORBIDDEN             = "forbidden"
+	ERR_NOT_FOUND             = "not_found"
+	ERR_METHOD_NOT_ALLOWED    = "method_not_allowed"
+	ERR_FUNCTION_NOT_FOUND    = "function_not_found"
+	ERR_FUNCTION_EXISTS       = "function_exists"
+	ERR_RUNTIME_NOT_FOUND     = "runtime_not_found"
+	ERR_RUNTIME_IN_USE        = "runtime_in_use"
+	ERR_INVALID_RUNTIME       = "invalid_runtime"
+	ERR_INVALID_HANDLER       = "invalid_handler"
+	ERR_INVOCATION_NOT_FOUND  = "invocation_not_found"
+	ERR_DEAD_LETTER_NOT_FOUND = "dead_letter_not_found"
+	ERR_KEY_NOT_FOUND         = "key_not_found"
+	ERR_NAMESPACE_NOT_FOUND   = "namespace_not_found"
+	ERR_NAMESPACE_NOT_EMPTY   = "namespace_not_empty"
+	ERR_QUOTA_EXCEEDED        = "quota_exceeded"
+	ERR_TOO_MANY_REQUESTS     = "too_many_requests"
+	ERR_INVOCATION_FAILED     = "invocation_failed"
+	ERR_REQUEST_CANCELLED     = "request_cancelled"
+	ERR_UNAVAILABLE           = "unavailable"
+	ERR_INTERNAL              = "internal_error"
+)
+
+// Error is the body of every unsuccessful v2 API response.
+type Error struct {
+	Code    string // machine-readable error code
+	Message string // human-readable description
+}
+
+// AsyncInvocation is returned upon submission of an asynchronous invocation.
+type AsyncInvocation struct {
+	Id       string
+	Location string // path to poll for the result
+}
+
+// PrewarmRequest asks for the creation of function instances.
+type PrewarmRequest struct {
+	Instances      int64
+	ForceImagePull bool
+}
+
+// PrewarmResult reports the number of instances actually created.
+type PrewarmResult struct {
+	Prewarmed int64
+}
+
+// NodeInfo describes a node registered in the local area.
+type NodeInfo struct {
+	Id  string
+	Url string
+}
+
+// DrainResult reports the outcome of a drain request.
+type DrainResult struct {
+	Drained bool
+	Pending int64
+}
+
+// apiRoute describes an endpoint of the v2 API. The same description is used
+// to register the route and to generate the OpenAPI document.
+type apiRoute struct {
+	Method     string
+	Path       string // echo syntax, relative to V2_PREFIX
+	Summary    string
+	Handler    echo.HandlerFunc
+	Permission auth.Permission
+	Query      []string    // optional query parameters (strings)
+	Body       interface{} // zero value of the request body type (nil: no body)
+	Status     int         // status code of a successful response
+	Result     interface{} // zero value of the response body type (nil: no body)
+	Errors     []int       // status codes of possible error responses
+}
+
+var v2Routes []apiRoute
+
+func init() {
+	v2Routes = []apiRoute{
+		{Method: http.MethodGet, Path: "/functions", Summary: "List functions",
+			Handler: listFunctionsV2, Permission: auth.READ, Status: http.StatusOK, Result: []function.Function{},
+			Errors: []int{http.StatusServiceUnavailable}},
+		{Method: http.MethodPost, Path: "/functions", Summary: "Create a function",
+			Handler: createFunctionV2, Permission: auth.MANAGE_FUNCTIONS, Body: function.Function{}, Status: http.StatusCreated, Result: function.Function{},
+			Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusServiceUnavailable}},
+		{Method: http.MethodGet, Path: "/functions/:name", Summary: "Describe a function",
+			Handler: getFunctionV2, Permission: auth.READ, Status: http.StatusOK, Result: function.Function{},
+			Errors: []int{http.StatusNotFound}},
+		{Method: http.MethodDelete, Path: "/functions/:name", Summary: "Delete a function",
+			Handler: deleteFunctionV2, Permission: auth.MANAGE_FUNCTIONS, Status: http.StatusNoContent,
+			Errors: []int{http.StatusNotFound, http.StatusServiceUnavailable}},
+		{Method: http.MethodPost, Path: "/functions/:name/invocations", Summary: "Invoke a function (the response is 202 for asynchronous invocations)",
+			Handler: invokeFunctionV2, Permission: auth.INVOKE, Body: client.InvocationRequest{}, Status: http.StatusOK, Result: function.Response{},
+			Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusServiceUnavailable}},
+		{Method: http.MethodPost, Path: "/functions/:name/instances", Summary: "Prewarm function instances",
+			Handler: prewarmFunctionV2, Permission: auth.MANAGE_FUNCTIONS, Body: PrewarmRequest{}, Status: http.StatusOK, Result: PrewarmResult{},
+			Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusServiceUnavailable}},
+		{Method: http.MethodGet, Path: "/invocations/:id", Summary: "Get the result of an asynchronous invocation, waiting for it for up to 'wait' (e.g., 30s); the response is 202 with the invocation status if still pending",
+			Handler: getInvocationV2, Permission: auth.READ, Query: []string{"wait"}, Status: http.StatusOK, Result: function.Response{},
+			Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusServiceUnavailable}},
+		{Method: http.MethodGet, Path: "/invocations/:id/status", Summary: "Get the state of an asynchronous invocation",
+			Handler: getInvocationStatusV2, Permission: auth.READ, Status: http.StatusOK, Result: function.AsyncRequestStatus{},
+			Errors: []int{http.StatusNotFound, http.StatusServiceUnavailable}},
+		{Method: http.MethodGet, Path: "/invocations/:id/callback", Summary: "Get the delivery status of the callback of an asynchronous invocation",
+			Handler: getCallbackV2, Permission: auth.READ, Status: http.StatusOK, Result: function.CallbackStatus{},
+			Errors: []int{http.StatusNotFound, http.StatusServiceUnavailable}},
+		{Method: http.MethodGet, Path: "/dead-letters", Summary: "List the asynchronous invocations which failed after exhausting their attempts",
+			Handler: listDeadLettersV2, Permission: auth.READ, Status: http.StatusOK, Result: []function.DeadLetter{},
+			Errors: []int{http.StatusServiceUnavailable}},
+		{Method: http.MethodGet, Path: "/dead-letters/:id", Summary: "Describe a failed asynchronous invocation, along with its parameters",
+			Handler: getDeadLetterV2, Permission: auth.READ, Status: http.StatusOK, Result: function.DeadLetter{},
+			Errors: []int{http.StatusNotFound, http.StatusServiceUnavailable}},
+		{Method: http.MethodPost, Path: "/dead-letters/:id/redrive", Summary: "Submit a failed asynchronous invocation again, as a new invocation",
+			Handler: redriveDeadLetterV2, Permission: auth.INVOKE, Status: http.StatusAccepted, Result: AsyncInvocation{},
+			Errors: []int{http.StatusNotFound, http.StatusTooManyRequests, http.StatusServiceUnavailable}},
+		{Method: http.MethodGet, Path: "/runtimes", Summary: "List runtimes",
+			Handler: listRuntimesV2, Permission: auth.READ, Status: http.StatusOK, Result: []container.RuntimeInfo{}},
+		{Method: http.MethodGet, Path: "/runtimes/:name", Summary: "Describe a runtime",
+			Handler: getRuntimeV2, Permission: auth.READ, Status: http.StatusOK, Result: container.RuntimeInfo{},
+			Errors: []int{http.StatusNotFound}},
+		{Method: http.MethodPut, Path: "/runtimes/:name", Summary: "Create or update a runtime",
+			Handler: saveRuntimeV2, Permission: auth.MANAGE_NODE, Body: container.RuntimeInfo{}, Status: http.StatusOK, Result: container.RuntimeInfo{},
+			Errors: []int{http.StatusBadRequest, http.StatusServiceUnavailable}},
+		{Method: http.MethodDelete, Path: "/runtimes/:name", Summary: "Delete a runtime",
+			Handler: deleteRuntimeV2, Permission: auth.MANAGE_NODE, Status: http.StatusNoContent,
+			Errors: []int{http.StatusNotFound, http.StatusConflict, http.StatusServiceUnavailable}},
+		{Method: http.MethodGet, Path: "/nodes", Summary: "List the nodes in the local area",
+			Handler: listNodesV2, Permission: auth.READ, Status: http.StatusOK, Result: []NodeInfo{},
+			Errors: []int{http.StatusServiceUnavailable}},
+		{Method: http.MethodGet, Path: "/nodes/self", Summary: "Get the status of this node",
+			Handler: getNodeV2, Permission: auth.READ, Status: http.StatusOK, Result: registration.StatusInformation{}},
+		{Method: http.MethodPost, Path: "/nodes/self/drain", Summary: "Drain this node",
+			Handler: drainNodeV2, Permission: auth.MANAGE_NODE, Body: client.DrainRequest{}, Status: http.StatusOK, Result: DrainResult{},
+			Errors: []int{http.StatusBadRequest}},
+		{Method: http.MethodPost, Path: "/nodes/self/resume", Summary: "Bring this node back into service",
+			Handler: resumeNodeV2, Permission: auth.MANAGE_NODE, Status: http.StatusNoContent,
+			Errors: []int{http.StatusServiceUnavailable}},
+		{Method: http.MethodGet, Path: "/keys", Summary: "List API keys",
+			Handler: listKeysV2, Permission: auth.MANAGE_NODE, Status: http.StatusOK, Result: []auth.APIKey{}},
+		{Method: http.MethodPost, Path: "/keys", Summary: "Create an API key",
+			Handler: createKeyV2, Permission: auth.MANAGE_NODE, Body: KeyRequest{}, Status: http.StatusCreated, Result: KeyCreated{},
+			Errors: []int{http.StatusBadRequest, http.StatusServiceUnavailable}},
+		{Method: http.MethodDelete, Path: "/keys/:id", Summary: "Revoke an API key",
+			Handler: deleteKeyV2, Permission: auth.MANAGE_NODE, Status: http.StatusNoContent,
+			Errors: []int{http.StatusNotFound, http.StatusServiceUnavailable}},
+		{Method: http.MethodGet, Path: "/namespaces", Summary: "List namespaces",
+			Handler: listNamespacesV2, Permission: auth.MANAGE_NODE, Status: http.StatusOK, Result: []function.Namespace{}},
+		{Method: http.MethodGet, Path: "/namespaces/:name", Summary: "Describe a namespace and its resource usage on this node",
+			Handler: getNamespaceV2, Permission: auth.MANAGE_NODE, Status: http.StatusOK, Result: NamespaceStatus{},
+			Errors: []int{http.StatusNotFound, http.StatusServiceUnavailable}},
+		{Method: http.MethodPut, Path: "/namespaces/:name", Summary: "Create a namespace or update its quota",
+			Handler: saveNamespaceV2, Permission: auth.MANAGE_NODE, Body: function.Namespace{}, Status: http.StatusOK, Result: function.Namespace{},
+			Errors: []int{http.StatusBadRequest, http.StatusServiceUnavailable}},
+		{Method: http.MethodDelete, Path: "/namespaces/:name", Summary: "Delete an empty namespace",
+			Handler: deleteNamespaceV2, Permission: auth.MANAGE_NODE, Status: http.StatusNoContent,
+			Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusServiceUnavailable}},
+		{Method: http.MethodGet, Path: "/openapi.json", Summary: "Get the OpenAPI description of this API",
+			Handler: getOpenAPIV2, Permission: auth.READ, Status: http.StatusOK, Result: map[string]interface{}{}},
+	}
+}
+
+// RegisterV2Routes registers the routes of the v2 API.
+func RegisterV2Routes(e *echo.Echo) {
+	g := e.Group(V2_PREFIX)
+	for _, route := range v2Routes {
+		g.Add(route.Method, route.Path, route.Handler, authorize(route.Permission))
+	}
+
+	// errors raised by echo itself (e.g., unknown routes) are reported as
+	// v2 errors too
+	defaultHandler := e.HTTPErrorHandler
+	e.HTTPErrorHandler = func(err error, c echo.Context) {
+		path := c.Request().URL.Path
+		if path != V2_PREFIX && !strings.HasPrefix(path, V2_PREFIX+"/") {
+			defaultHandler(err, c)
+			return
+		}
+		if c.Response().Committed {
+			return
+		}
+		status, code := http.StatusInternalServerError, ERR_INTERNAL
+		var httpErr *echo.HTTPError
+		if errors.As(err, &httpErr) {
+			status = httpErr.Code
+			switch status {
+			case http.StatusNotFound:
+				code = ERR_NOT_FOUND
+			case http.StatusMethodNotAllowed:
+				code = ERR_METHOD_NOT_ALLOWED
+			case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+				code = ERR_INVALID_REQUEST
+			case http.StatusUnauthorized:
+				code = ERR_UNAUTHORIZED
+			case http.StatusForbidden:
+				code = ERR_FORBIDDEN
+			}
+			err = fmt.Errorf("%v", httpErr.Message)
+		}
+		_ = errorV2(c, status, code, err.Error())
+	}
+}
+
+func errorV2(c echo.Context, status int, code string, message string) error {
+	return c.JSON(status, Error{Code: code, Message: message})
+}
+
+// decodeV2 parses the JSON request body, if any.
+func decodeV2(c echo.Context, v interface{}) error {
+	err := json.NewDecoder(c.Request().Body).Decode(v)
+	if err != nil && err != io.EOF {
+		log.Printf("Could not parse request: %v\n", err)
+		return fmt.Errorf("could not parse request: %v", err)
+	}
+	return nil
+}
+
+// withoutCode returns a copy of the function, omitting its (possibly large)
+// code archive.
+func withoutCode(f *function.Function) function.Function {
+	res := *f
+	res.TarFunctionCode = ""
+	return res
+}
+
+func listFunctionsV2(c echo.Context) error {
+	names, err := function.GetAllInNamespace(namespaceOf(c))
+	if err != nil {
+		return errorV2(c, http.StatusServiceUnavailable, ERR_UNAVAILABLE, err.Error())
+	}
+	functions := make([]function.Function, 0, len(names))
+	for _, name := range names {
+		if f, ok := lookupFunction(c, name); ok {
+			functions = append(functions, withoutCode(f))
+		}
+	}
+	return c.JSON(http.StatusOK, functions)
+}
+
+func createFunctionV2(c echo.Context) error {
+	var f function.Function
+	if err := decodeV2(c, &f); err != nil {
+		return errorV2(c, http.StatusBadRequest, ERR_INVALID_REQUEST, err.Error())
+	}
+	if f.Name == "" {
+		return errorV2(c, http.StatusBadRequest, ERR_INVALID_REQUEST, "missing function name")
+	}
+
+	namespace := namespaceOf(c)
+	err := createFunction(namespace, &f)
+	if errors.Is(err, FunctionExistsErr) {
+		return errorV2(c, http.StatusConflict, ERR_FUNCTION_EXISTS, fmt.Sprintf("function %s already exists", f.Name))
+	} else if errors.Is(err, UnknownRuntimeErr) {
+		return errorV2(c, http.StatusBadRequest, ERR_INVALID_RUNTIME, fmt.Sprintf("unknown runtime: %s", f.Runtime))
+	} else if errors.Is(err, InvalidHandlerErr) {
+		return errorV2(c, http.StatusBadRequest, ERR_INVALID_HANDLER, err.Error())
+	} else if errors.Is(err, InvalidFunctionNameErr) || errors.Is(err, InvalidFunctionErr) {
+		return errorV2(c, http.StatusBadRequest, ERR_INVALID_REQUEST, err.Error())
+	} else if errors.Is(err, function.NamespaceNotFoundErr) {
+		return errorV2(c, http.StatusNotFound, ERR_NAMESPACE_NOT_FOUND, fmt.Sprintf("unknown namespace: %s", namespace))
+	} else if errors.Is(err, node.QuotaExceededErr) {
+		return errorV2(c, http.StatusForbidden, ERR_QUOTA_EXCEEDED, fmt.Sprintf("function quota of namespace %s exceeded", namespace))
+	} else if err != nil {
+		return errorV2(c, http.StatusServiceUnavailable, ERR_UNAVAILABLE, err.Error())
+	}
+
+	c.Response().Header().Set(echo.HeaderLocation, V2_PREFIX+"/functions/"+f.Name)
+	return c.JSON(http.StatusCreated, withoutCode(&f))
+}
+
+func getFunctionV2(c echo.Context) error {
+	name := c.Param("name")
+	f, ok := lookupFunction(c, name)
+	if !ok {
+		return errorV2(c, http.StatusNotFound, ERR_FUNCTION_NOT_FOUND, fmt.Sprintf("unknown function: %s", name))
+	}
+	return c.JSON(http.StatusOK, withoutCode(f))
+}
+
+func deleteFunctionV2(c echo.Context) error {
+	name := c.Param("name")
+	err := deleteFunction(namespaceOf(c), name)
+	if errors.Is(err, FunctionNotFoundErr) {
+		return errorV2(c, http.StatusNotFound, ERR_FUNCTION_NOT_FOUND, fmt.Sprintf("unknown function: %s", name))
+	} else if err != nil {
+		return errorV2(c, http.StatusServiceUnavailable, ERR_UNAVAILABLE, err.Error())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func invokeFunctionV2(c echo.Context) error {
+	name := c.Param("name")
+	fun, ok := lookupFunction(c, name)
+	if !ok {
+		log.Printf("Dropping request for unknown fun '%s'\n", name)
+		return errorV2(c, http.StatusNotFound, ERR_FUNCTION_NOT_FOUND, fmt.Sprintf("unknown function: %s", name))
+	}
+	if err := node.AdmitInvocation(fun); err != nil {
+		return errorV2(c, http.StatusTooManyRequests, ERR_QUOTA_EXCEEDED, fmt.Sprintf("invocation rate quota of namespace %s exceeded", fun.GetNamespace()))
+	}
+
+	var invocationRequest client.InvocationRequest
+	if err := decodeV2(c, &invocationRequest); err != nil {
+		return errorV2(c, http.StatusBadRequest, ERR_INVALID_REQUEST, err.Error())
+	}
+	if err := validateAsyncOptions(&invocationRequest); err != nil {
+		return errorV2(c, http.StatusBadRequest, ERR_INVALID_REQUEST, err.Error())
+	}
+
+	r := newRequest(c, fun, &invocationRequest)
+	r.Stream = nil
+
+	if r.Async {
+		if err := scheduling.AcceptAsyncRequest(r); err != nil {
+			log.Printf("Could not accept async request: %v\n", err)
+			return errorV2(c, http.StatusServiceUnavailable, ERR_UNAVAILABLE, "could not accept the request")
+		}
+		go scheduling.SubmitAsyncRequest(r)
+		location := V2_PREFIX + "/invocations/" + r.ReqId
+		c.Response().Header().Set(echo.HeaderLocation, location)
+		return c.JSON(http.StatusAccepted, AsyncInvocation{Id: r.ReqId, Location: location})
+	}
+
+	executionReport, err := scheduling.SubmitRequest(r)
+	if errors.Is(err, scheduling.CancelledErr) {
+		// the request might still be referenced by the scheduler, so it
+		// is not recycled
+		log.Printf("Request %s cancelled by the client\n", r.ReqId)
+		return errorV2(c, statusClientClosedRequest, ERR_REQUEST_CANCELLED, err.Error())
+	}
+	requestsPool.Put(r)
+
+	if errors.Is(err, node.OutOfResourcesErr) {
+		return errorV2(c, http.StatusTooManyRequests, ERR_TOO_MANY_REQUESTS, "not enough resources to serve the request")
+	} else if err != nil {
+		log.Printf("Invocation failed: %v\n", err)
+		return errorV2(c, http.StatusInternalServerError, ERR_INVOCATION_FAILED, err.Error())
+	}
+	return c.JSON(http.StatusOK, function.Response{Success: true, ExecutionReport: executionReport})
+}
+
+func prewarmFunctionV2(c echo.Context) error {
+	name := c.Param("name")
+	var req PrewarmRequest
+	if err := decodeV2(c, &req); err != nil {
+		return errorV2(c, http.StatusBadRequest, ERR_INVALID_REQUEST, err.Error())
+	}
+
+	fun, ok := lookupFunction(c, name)
+	if !ok {
+		return errorV2(c, http.StatusNotFound, ERR_FUNCTION_NOT_FOUND, fmt.Sprintf("unknown function: %s", name))
+	}
+
+	count, err := node.PrewarmInstances(fun, req.Instances, req.ForceImagePull)
+	if err != nil && !errors.Is(err, node.OutOfResourcesErr) {
+		log.Printf("Failed prewarming: %v\n", err)
+		return errorV2(c, http.StatusServiceUnavailable, ERR_UNAVAILABLE, err.Error())
+	}
+	return c.JSON(http.StatusOK, PrewarmResult{Prewarmed: count})
+}
+
+func getInvocationV2(c echo.Context) error {
+	id := c.Param("id")
+	wait, err := parseWait(c, maxPollWait())
+	if err != nil {
+		return errorV2(c, http.StatusBadRequest, ERR_INVALID_REQUEST, err.Error())
+	}
+	payload, err := pollAsyncResult(c, id, wait)
+	var remote *asyncstore.RemoteResultErr
+	if errors.As(err, &remote) {
+		// large results are served by the node that produced them
+		return c.Redirect(http.StatusTemporaryRedirect, remote.NodeURL+c.Request().URL.RequestURI())
+	} else if errors.Is(err, ResultNotFoundErr) {
+		// the request may be still pending
+		if status, err := getAsyncStatus(namespaceOf(c), id); err == nil {
+			return c.JSON(http.StatusAccepted, status)
+		}
+		return errorV2(c, http.StatusNotFound, ERR_INVOCATION_NOT_FOUND, fmt.Sprintf("no result available for invocation %s", id))
+	} else if err != nil {
+		log.Println(err)
+		return errorV2(c, http.StatusServiceUnavailable, ERR_UNAVAILABLE, "could not retrieve results")
+	}
+	return c.JSONBlob(http.StatusOK, payload)
+}
+
+func getInvocationStatusV2(c echo.Context) error {
+	id := c.Param("id")
+	status, err := getAsyncStatus(namespaceOf(c), id)
+	if errors.Is(err, ResultNotFoundErr) {
+		return errorV2(c, http.StatusNotFound, ERR_INVOCATION_NOT_FOUND, fmt.Sprintf("unknown invocation: %s", id))
+	} else if err != nil {
+		log.Println(err)
+		return errorV2(c, http.StatusServiceUnavailable, ERR_UNAVAILABLE, "could not retrieve the invocation status")
+	}
+	return c.JSON(http.StatusOK, status)
+}
+
+func getCallbackV2(c echo.Context) error {
+	id := c.Param("id")
+	payload, err := getCallbackStatus(namespaceOf(c), id)
+	if errors.Is(err, ResultNotFoundErr) {
+		return errorV2(c, http.StatusNotFound, ERR_INVOCATION_NOT_FOUND, fmt.Sprintf("no callback status available for invocation %s", id))
+	} else if err != nil {
+		log.Println(err)
+		return errorV2(c, http.StatusServiceUnavailable, ERR_UNAVAILABLE, "could not retrieve callback status")
+	}
+	return c.JSONBlob(http.StatusOK, payload)
+}
+
+func listRuntimesV2(c echo.Context) error {
+	return c.JSON(http.StatusOK, container.GetAllRuntimes())
+}
+
+func getRuntimeV2(c echo.Context) error {
+	name := c.Param("name")
+	runtime, ok := container.GetRuntimeInfo(name)
+	if !ok {
+		return errorV2(c, http.StatusNotFound, ERR_RUNTIME_NOT_FOUND, fmt.Sprintf("unknown runtime: %s", name))
+	}
+	return c.JSON(http.StatusOK, runtime)
+}
+
+func saveRuntimeV2(c echo.Context) error {
+	name := c.Param("name")
+	var runtime container.RuntimeInfo
+	if err := decodeV2(c, &runtime); err != nil {
+		return errorV2(c, http.StatusBadRequest, ERR_INVALID_REQUEST, err.Error())
+	}
+	if runtime.Name == "" {
+		runtime.Name = name
+	} else if runtime.Name != name {
+		return errorV2(c, http.StatusBadRequest, ERR_INVALID_REQUEST, "the runtime name does not match the path")
+	}
+	if err := runtime.Validate(); err != nil {
+		return errorV2(c, http.StatusBadRequest, ERR_INVALID_RUNTIME, err.Error())
+	}
+
+	log.Printf("New request: saving runtime %s\n", runtime.Name)
+	if err := runtime.SaveToEtcd(); err != nil {
+		log.Printf("Failed runtime creation: %v\n", err)
+		return errorV2(c, http.StatusServiceUnavailable, ERR_UNAVAILABLE, err.Error())
+	}
+	return c.JSON(http.StatusOK, runtime)
+}
+
+func deleteRuntimeV2(c echo.Context) error {
+	name := c.Param("name")
+	err := deleteRuntime(name)
+	if errors.Is(err, UnknownRuntimeErr) {
+		return errorV2(c, http.StatusNotFound, ERR_RUNTIME_NOT_FOUND, fmt.Sprintf("unknown runtime: %s", name))
+	} else if errors.Is(err, RuntimeInUseErr) {
+		return errorV2(c, http.StatusConflict, ERR_RUNTIME_IN_USE, err.Error())
+	} else if err != nil {
+		return errorV2(c, http.StatusServiceUnavailable, ERR_UNAVAILABLE, err.Error())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func listNodesV2(c echo.Context) error {
+	servers, err := registration.Reg.GetAll(false)
+	if err != nil {
+		return errorV2(c, http.StatusServiceUnavailable, ERR_UNAVAILABLE, err.Error())
+	}
+	nodes := make([]NodeInfo, 0, len(servers))
+	for key, url := range servers {
+		nodes = append(nodes, NodeInfo{Id: key[strings.LastIndex(key, "/")+1:], Url: url})
+	}
+	return c.JSON(http.StatusOK, nodes)
+}
+
+func getNodeV2(c echo.Context) error {
+	return c.JSON(http.StatusOK, nodeStatusFor(c))
+}
+
+func drainNodeV2(c echo.Context) error {
+	var req client.DrainRequest
+	if err := decodeV2(c, &req); err != nil {
+		return errorV2(c, http.StatusBadRequest, ERR_INVALID_REQUEST, err.Error())
+	}
+	drained, pending := drainNode(&req)
+	return c.JSON(http.StatusOK, DrainResult{Drained: drained, Pending: pending})
+}
+
+func resumeNodeV2(c echo.Context) error {
+	if err := scheduling.Resume(); err != nil {
+		log.Printf("Failed resuming: %v\n", err)
+		return errorV2(c, http.StatusServiceUnavailable, ERR_UNAVAILABLE, err.Error())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func getOpenAPIV2(c echo.Context) error {
+	return c.JSON(http.StatusOK, openAPIDocument())
+}
@@ -0,0 +1,120 @@
+package asyncstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/spf13/viper"
+)
+
+func openTestStore(t *testing.T) *localStore {
+	t.Helper()
+	s, err := openLocalStore(filepath.Join(t.TempDir(), "async.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func TestLocalStore(t *testing.T) {
+	s := openTestStore(t)
+
+	if _, err := s.Get("", "missing"); !errors.Is(err, NotFoundErr) {
+		t.Errorf("unexpected error for a missing result: %v", err)
+	}
+	if err := s.Put("ns", "req", []byte(`{"Success":true}`), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if result, err := s.Get("ns", "req"); err != nil || string(result) != `{"Success":true}` {
+		t.Errorf("unexpected result: %s %v", result, err)
+	}
+	// results are scoped by namespace
+	if _, err := s.Get("", "req"); !errors.Is(err, NotFoundErr) {
+		t.Errorf("result visible in another namespace: %v", err)
+	}
+
+	// expired results are neither returned nor kept
+	if err := s.Put("", "expiring", []byte("{}"), time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := s.Get("", "expiring"); !errors.Is(err, NotFoundErr) {
+		t.Errorf("expired result returned: %v", err)
+	}
+	if err := s.removeExpired(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get("ns", "req"); err != nil {
+		t.Errorf("valid result removed: %v", err)
+	}
+}
+
+func TestLocalStoreWait(t *testing.T) {
+	s := openTestStore(t)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = s.Put("", "req", []byte("{}"), time.Minute)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if result, err := s.Wait(ctx, "", "req"); err != nil || string(result) != "{}" {
+		t.Errorf("unexpected result: %s %v", result, err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := s.Wait(ctx, "", "never"); !errors.Is(err, NotFoundErr) {
+		t.Errorf("unexpected error after waiting: %v", err)
+	}
+	if len(s.waiters) != 0 {
+		t.Errorf("waiters not removed: %v", s.waiters)
+	}
+}
+
+func TestSpilling(t *testing.T) {
+	dir := t.TempDir()
+	viper.Set(config.ASYNC_SPILL_DIR, dir)
+	viper.Set(config.ASYNC_RESULT_MAX_SIZE, 16)
+	defer viper.Set(config.ASYNC_SPILL_DIR, nil)
+	defer viper.Set(config.ASYNC_RESULT_MAX_SIZE, nil)
+
+	backend := openTestStore(t)
+	s := &spillingStore{AsyncResultStore: backend}
+
+	large := bytes.Repeat([]byte("x"), 100)
+	if err := s.Put("", "large", large, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("", "small", []byte("{}"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	// only a reference to the large result is kept in the backend
+	if value, _ := backend.Get("", "large"); !bytes.HasPrefix(value, []byte(spilledPrefix)) {
+		t.Errorf("large result not spilled: %s", value)
+	}
+	if result, err := s.Get("", "large"); err != nil || !bytes.Equal(result, large) {
+		t.Errorf("unexpected spilled result: %s %v", result, err)
+	}
+	if result, err := s.Get("", "small"); err != nil || string(result) != "{}" {
+		t.Errorf("unexpected result: %s %v", result, err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.json")); len(files) != 1 {
+		t.Errorf("unexpected spilled files: %v", files)
+	}
+
+	// results spilled by other nodes are served by them
+	if err := backend.Put("", "remote", []byte(spilledPrefix+"http://10.0.0.1:1323"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	var remote *RemoteResultErr
+	if _, err := s.Get("", "remote"); !errors.As(err, &remote) || remote.NodeURL != "http://10.0.0.1:1323" {
+		t.Errorf("unexpected error for a remote result: %v", err)
+	}
+}
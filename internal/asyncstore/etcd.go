@@ -0,0 +1,87 @@
+package asyncstore
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/grussorusso/serverledge/utils"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// etcdStore keeps the results in the Global Registry, attached to leases.
+type etcdStore struct{}
+
+func (s *etcdStore) Put(namespace string, reqId string, result []byte, ttl time.Duration) error {
+	etcdClient, err := utils.GetEtcdClient()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the Global Registry: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	lease, err := etcdClient.Grant(ctx, int64(math.Max(1, math.Ceil(ttl.Seconds()))))
+	if err != nil {
+		return err
+	}
+	_, err = etcdClient.Put(ctx, function.AsyncResultKey(namespace, reqId), string(result), clientv3.WithLease(lease.ID))
+	return err
+}
+
+func (s *etcdStore) Get(namespace string, reqId string) ([]byte, error) {
+	etcdClient, err := utils.GetEtcdClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the Global Registry: %v", err)
+	}
+	res, err := etcdClient.Get(context.Background(), function.AsyncResultKey(namespace, reqId))
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Kvs) != 1 {
+		return nil, NotFoundErr
+	}
+	return res.Kvs[0].Value, nil
+}
+
+func (s *etcdStore) Wait(ctx context.Context, namespace string, reqId string) ([]byte, error) {
+	etcdClient, err := utils.GetEtcdClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the Global Registry: %v", err)
+	}
+
+	key := function.AsyncResultKey(namespace, reqId)
+	res, err := etcdClient.Get(ctx, key)
+	if ctx.Err() != nil {
+		return nil, NotFoundErr
+	} else if err != nil {
+		return nil, err
+	}
+	if len(res.Kvs) == 1 {
+		return res.Kvs[0].Value, nil
+	}
+
+	// the watch starts right after the revision read above, so that results
+	// published in the meantime are not missed
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	watchChan := etcdClient.Watch(watchCtx, key, clientv3.WithRev(res.Header.Revision+1), clientv3.WithFilterDelete())
+	for watchResp := range watchChan {
+		if err := watchResp.Err(); err != nil {
+			return nil, err
+		}
+		for _, event := range watchResp.Events {
+			return event.Kv.Value, nil
+		}
+	}
+	return nil, NotFoundErr
+}
+
+func (s *etcdStore) Shared() bool {
+	return true
+}
+
+func (s *etcdStore) Close() error {
+	return nil
+}
@@ -0,0 +1,177 @@
+package asyncstore
+
+import (
+	"context"
+	"encoding/binary"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/function"
+	bolt "go.etcd.io/bbolt"
+)
+
+var resultsBucket = []byte("results")
+
+// expirationInterval is the interval between removals of expired results
+const expirationInterval = time.Minute
+
+// localStore keeps the results in an embedded key-value store, for nodes
+// which cannot (or should not) write to the Global Registry. Results are only
+// visible to the node itself.
+type localStore struct {
+	db      *bolt.DB
+	stop    chan struct{}
+	lock    sync.Mutex
+	waiters map[string][]chan []byte
+}
+
+func defaultLocalStorePath() string {
+	return filepath.Join(os.TempDir(), "serverledge-async.db")
+}
+
+func openLocalStore(path string) (*localStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(resultsBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	s := &localStore{db: db, stop: make(chan struct{}), waiters: make(map[string][]chan []byte)}
+	go s.expireResults()
+	return s, nil
+}
+
+// Values are stored along with their expiration time (Unix nanoseconds).
+func encodeValue(result []byte, expiration time.Time) []byte {
+	value := make([]byte, 8+len(result))
+	binary.BigEndian.PutUint64(value, uint64(expiration.UnixNano()))
+	copy(value[8:], result)
+	return value
+}
+
+func decodeValue(value []byte) ([]byte, time.Time) {
+	expiration := time.Unix(0, int64(binary.BigEndian.Uint64(value)))
+	return value[8:], expiration
+}
+
+func (s *localStore) Put(namespace string, reqId string, result []byte, ttl time.Duration) error {
+	key := function.AsyncResultKey(namespace, reqId)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(resultsBucket).Put([]byte(key), encodeValue(result, time.Now().Add(ttl)))
+	})
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	for _, waiter := range s.waiters[key] {
+		waiter <- result
+	}
+	delete(s.waiters, key)
+	s.lock.Unlock()
+	return nil
+}
+
+func (s *localStore) Get(namespace string, reqId string) ([]byte, error) {
+	var result []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(resultsBucket).Get([]byte(function.AsyncResultKey(namespace, reqId)))
+		if value == nil {
+			return NotFoundErr
+		}
+		stored, expiration := decodeValue(value)
+		if time.Now().After(expiration) {
+			return NotFoundErr
+		}
+		// values are only valid within the transaction
+		result = append([]byte(nil), stored...)
+		return nil
+	})
+	return result, err
+}
+
+func (s *localStore) Wait(ctx context.Context, namespace string, reqId string) ([]byte, error) {
+	// the waiter is registered first, so that results stored in the
+	// meantime are not missed
+	key := function.AsyncResultKey(namespace, reqId)
+	waiter := make(chan []byte, 1)
+	s.lock.Lock()
+	s.waiters[key] = append(s.waiters[key], waiter)
+	s.lock.Unlock()
+	defer s.removeWaiter(key, waiter)
+
+	if result, err := s.Get(namespace, reqId); err != NotFoundErr {
+		return result, err
+	}
+	select {
+	case result := <-waiter:
+		return result, nil
+	case <-ctx.Done():
+		return nil, NotFoundErr
+	}
+}
+
+func (s *localStore) removeWaiter(key string, waiter chan []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	waiters := s.waiters[key]
+	for i, w := range waiters {
+		if w == waiter {
+			s.waiters[key] = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(s.waiters[key]) == 0 {
+		delete(s.waiters, key)
+	}
+}
+
+// expireResults periodically removes the expired results.
+func (s *localStore) expireResults() {
+	ticker := time.NewTicker(expirationInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.removeExpired(); err != nil {
+				log.Printf("Could not remove expired async results: %v\n", err)
+			}
+		}
+	}
+}
+
+func (s *localStore) removeExpired() error {
+	now := time.Now()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(resultsBucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if _, expiration := decodeValue(v); now.After(expiration) {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (s *localStore) Shared() bool {
+	return false
+}
+
+func (s *localStore) Close() error {
+	close(s.stop)
+	return s.db.Close()
+}
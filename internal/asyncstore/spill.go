@@ -0,0 +1,119 @@
+package asyncstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/grussorusso/serverledge/internal/registration"
+)
+
+// spilledPrefix marks the values referring to results spilled to the disk of
+// a node (whose URL follows), as results are JSON objects
+const spilledPrefix = "spilled:"
+
+// spillingStore writes the results exceeding the configured size to the
+// local disk, keeping a reference to them in the underlying store. Spilled
+// results are served by the node that produced them.
+type spillingStore struct {
+	AsyncResultStore
+}
+
+func newSpillingStore(backend AsyncResultStore) *spillingStore {
+	s := &spillingStore{AsyncResultStore: backend}
+	go s.expireSpilledResults()
+	return s
+}
+
+func spillDir() string {
+	return config.GetString(config.ASYNC_SPILL_DIR, filepath.Join(os.TempDir(), "serverledge-results"))
+}
+
+func spillFile(namespace string, reqId string) string {
+	hash := sha256.Sum256([]byte(function.AsyncResultKey(namespace, reqId)))
+	return filepath.Join(spillDir(), hex.EncodeToString(hash[:])+".json")
+}
+
+// nodeURL returns the URL other nodes can retrieve spilled results from.
+func nodeURL() string {
+	if registration.Reg == nil {
+		return ""
+	}
+	return registration.Reg.URL()
+}
+
+func (s *spillingStore) Put(namespace string, reqId string, result []byte, ttl time.Duration) error {
+	maxSize := config.GetInt(config.ASYNC_RESULT_MAX_SIZE, 1024*1024)
+	if maxSize <= 0 || len(result) <= maxSize {
+		return s.AsyncResultStore.Put(namespace, reqId, result, ttl)
+	}
+
+	if err := os.MkdirAll(spillDir(), 0700); err != nil {
+		return err
+	}
+	file := spillFile(namespace, reqId)
+	if err := os.WriteFile(file, result, 0600); err != nil {
+		return err
+	}
+	// the modification time is set to the expiration time
+	expiration := time.Now().Add(ttl)
+	if err := os.Chtimes(file, expiration, expiration); err != nil {
+		return err
+	}
+	return s.AsyncResultStore.Put(namespace, reqId, []byte(spilledPrefix+nodeURL()), ttl)
+}
+
+func (s *spillingStore) Get(namespace string, reqId string) ([]byte, error) {
+	value, err := s.AsyncResultStore.Get(namespace, reqId)
+	return s.resolve(namespace, reqId, value, err)
+}
+
+func (s *spillingStore) Wait(ctx context.Context, namespace string, reqId string) ([]byte, error) {
+	value, err := s.AsyncResultStore.Wait(ctx, namespace, reqId)
+	return s.resolve(namespace, reqId, value, err)
+}
+
+// resolve reads the spilled result a value refers to, if any.
+func (s *spillingStore) resolve(namespace string, reqId string, value []byte, err error) ([]byte, error) {
+	if err != nil || !bytes.HasPrefix(value, []byte(spilledPrefix)) {
+		return value, err
+	}
+	url := string(value[len(spilledPrefix):])
+	if url != nodeURL() {
+		return nil, &RemoteResultErr{NodeURL: url}
+	}
+	result, err := os.ReadFile(spillFile(namespace, reqId))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, NotFoundErr
+	}
+	return result, err
+}
+
+// expireSpilledResults periodically removes the expired spilled results.
+func (s *spillingStore) expireSpilledResults() {
+	ticker := time.NewTicker(expirationInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		files, err := filepath.Glob(filepath.Join(spillDir(), "*.json"))
+		if err != nil {
+			continue
+		}
+		now := time.Now()
+		for _, file := range files {
+			info, err := os.Stat(file)
+			if err == nil && info.ModTime().Before(now) {
+				if err := os.Remove(file); err != nil {
+					log.Printf("Could not remove expired spilled result: %v\n", err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Package asyncstore keeps the results of asynchronous invocations, either in
+// the Global Registry (etcd) or in an embedded key-value store local to the
+// node. Results larger than a configurable size are spilled to the local
+// disk.
+package asyncstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/config"
+)
+
+var NotFoundErr = errors.New("result not found")
+
+// Available backends.
+const (
+	ETCD  = "etcd"
+	LOCAL = "local"
+)
+
+// AsyncResultStore keeps the results of asynchronous invocations.
+type AsyncResultStore interface {
+	// Put stores the result of a request, which expires after ttl.
+	Put(namespace string, reqId string, result []byte, ttl time.Duration) error
+	// Get retrieves the result of a request (NotFoundErr if not available).
+	Get(namespace string, reqId string) ([]byte, error)
+	// Wait retrieves the result of a request, waiting for it to be stored
+	// until ctx is done (then, NotFoundErr is returned).
+	Wait(ctx context.Context, namespace string, reqId string) ([]byte, error)
+	// Shared returns true if the results are visible to all the nodes.
+	Shared() bool
+	Close() error
+}
+
+// RemoteResultErr is returned when a result has been spilled to the disk of
+// another node, which serves it.
+type RemoteResultErr struct {
+	NodeURL string
+}
+
+func (e *RemoteResultErr) Error() string {
+	return fmt.Sprintf("result available at %s", e.NodeURL)
+}
+
+var store AsyncResultStore
+var storeErr error
+var storeOnce sync.Once
+
+// Get returns the configured store, opening it the first time.
+func Get() (AsyncResultStore, error) {
+	storeOnce.Do(func() {
+		var backend AsyncResultStore
+		switch kind := config.GetString(config.ASYNC_STORE, ETCD); kind {
+		case ETCD:
+			backend = &etcdStore{}
+		case LOCAL:
+			backend, storeErr = openLocalStore(config.GetString(config.ASYNC_STORE_PATH, defaultLocalStorePath()))
+		default:
+			storeErr = fmt.Errorf("unknown async result store: %s", kind)
+		}
+		if storeErr == nil {
+			log.Printf("Async results kept in the %s store\n", config.GetString(config.ASYNC_STORE, ETCD))
+			store = newSpillingStore(backend)
+		}
+	})
+	return store, storeErr
+}
+
+// Init opens the configured store.
+func Init() error {
+	_, err := Get()
+	return err
+}
+
+// DefaultTTL returns the configured time the results are kept for.
+func DefaultTTL() time.Duration {
+	return time.Duration(config.GetInt(config.ASYNC_RESULT_TTL, 1800)) * time.Second
+}
@@ -0,0 +1,146 @@
+// Package auth implements the authentication of API clients via API keys,
+// and the authorization of their requests based on roles.
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/function"
+)
+
+type Role string
+
+const (
+	ADMIN     Role = "admin"     // full access, including node and key management
+	DEVELOPER Role = "developer" // manages and invokes functions
+	INVOKER   Role = "invoker"   // invokes functions
+	SERVICE   Role = "service"   // other nodes (e.g., offloaded requests)
+)
+
+type Permission int
+
+const (
+	READ             Permission = iota // list functions and runtimes, read status and results
+	INVOKE                             // invoke functions
+	MANAGE_FUNCTIONS                   // create, delete and prewarm functions
+	MANAGE_NODE                        // manage runtimes, API keys, drain the node
+)
+
+var rolePermissions = map[Role][]Permission{
+	ADMIN:     {READ, INVOKE, MANAGE_FUNCTIONS, MANAGE_NODE},
+	DEVELOPER: {READ, INVOKE, MANAGE_FUNCTIONS},
+	INVOKER:   {READ, INVOKE},
+	SERVICE:   {READ, INVOKE},
+}
+
+// Principal describes an authenticated client.
+type Principal struct {
+	Role      Role
+	Namespace string // the only namespace the client can access (empty: any)
+}
+
+// Can returns true if the client has the permission. Clients bound to a
+// namespace cannot manage the node, which is shared by all the namespaces.
+func (p Principal) Can(perm Permission) bool {
+	if p.Namespace != "" && perm == MANAGE_NODE {
+		return false
+	}
+	return p.Role.Can(perm)
+}
+
+var MissingCredentialsErr = errors.New("missing credentials")
+var InvalidCredentialsErr = errors.New("invalid credentials")
+var InvalidRoleErr = errors.New("invalid role")
+
+// Valid returns true if r is a role that can be assigned to API keys.
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok && r != SERVICE
+}
+
+// Can returns true if the role grants the permission.
+func (r Role) Can(p Permission) bool {
+	for _, granted := range rolePermissions[r] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Enabled returns true if API requests must be authenticated.
+func Enabled() bool {
+	return config.GetBool(config.AUTH_ENABLED, false)
+}
+
+// ServiceToken returns the credential used by nodes to authenticate to each
+// other (empty if not configured).
+func ServiceToken() string {
+	return config.GetString(config.AUTH_SERVICE_TOKEN, "")
+}
+
+// Authenticate identifies the client owning the token.
+func Authenticate(token string) (Principal, error) {
+	if token == "" {
+		return Principal{}, MissingCredentialsErr
+	}
+	if service := ServiceToken(); service != "" && subtle.ConstantTimeCompare([]byte(token), []byte(service)) == 1 {
+		return Principal{Role: SERVICE}, nil
+	}
+
+	id, secret, ok := strings.Cut(token, ".")
+	if !ok {
+		return Principal{}, InvalidCredentialsErr
+	}
+	key, ok := getKey(id)
+	if !ok || subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(key.Hash)) != 1 {
+		return Principal{}, InvalidCredentialsErr
+	}
+	return Principal{Role: key.Role, Namespace: key.Namespace}, nil
+}
+
+// TokenFromRequest extracts the credential from either the Authorization
+// (bearer token) or the X-API-Key header.
+func TokenFromRequest(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// Transport adds a bearer token (and, optionally, the namespace to access)
+// to the requests it sends.
+type Transport struct {
+	Base      http.RoundTripper // http.DefaultTransport if nil
+	Token     string
+	Namespace string
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	addToken := t.Token != "" && req.Header.Get("Authorization") == ""
+	addNamespace := t.Namespace != "" && req.Header.Get(function.NAMESPACE_HEADER) == ""
+	if !addToken && !addNamespace {
+		return base.RoundTrip(req)
+	}
+	// requests must not be modified by round trippers
+	req = req.Clone(req.Context())
+	if addToken {
+		req.Header.Set("Authorization", "Bearer "+t.Token)
+	}
+	if addNamespace {
+		req.Header.Set(function.NAMESPACE_HEADER, t.Namespace)
+	}
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,276 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/grussorusso/serverledge/utils"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// APIKey describes an API key. Only a hash of the secret part of the key is
+// stored: keys are shown in full only once, upon creation. The full key
+// (token) has the form <Id>.<secret>.
+type APIKey struct {
+	Id          string
+	Role        Role
+	Namespace   string `json:",omitempty"` // the only namespace the key gives access to (empty: any)
+	Description string `json:",omitempty"`
+	Created     time.Time
+	Hash        string `json:",omitempty"` // SHA-256 of the secret (never returned by the API)
+}
+
+const keysEtcdPrefix = "/auth/keys/"
+const keysInitEtcdKey = "/auth/initialized"
+
+var KeyNotFoundErr = errors.New("unknown API key")
+
+// keys is the local copy of the API keys, kept in sync with etcd
+var keys = make(map[string]APIKey)
+var keysLock sync.RWMutex
+
+func getKeyEtcdKey(id string) string {
+	return keysEtcdPrefix + id
+}
+
+func getKey(id string) (APIKey, bool) {
+	keysLock.RLock()
+	defer keysLock.RUnlock()
+	key, ok := keys[id]
+	return key, ok
+}
+
+func setKey(key APIKey) {
+	keysLock.Lock()
+	defer keysLock.Unlock()
+	keys[key.Id] = key
+}
+
+func removeKey(id string) {
+	keysLock.Lock()
+	defer keysLock.Unlock()
+	delete(keys, id)
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// newKey generates a new API key, returning the corresponding token.
+func newKey(role Role, namespace string, description string) (APIKey, string, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	key := APIKey{Id: id, Role: role, Namespace: namespace, Description: description, Created: time.Now(), Hash: hashSecret(secret)}
+	return key, id + "." + secret, nil
+}
+
+// CreateKey generates and stores a new API key with the given role, possibly
+// restricted to a namespace. The returned token must be handed to the
+// client, as it cannot be recovered.
+func CreateKey(role Role, namespace string, description string) (APIKey, string, error) {
+	if !role.Valid() {
+		return APIKey{}, "", fmt.Errorf("%w: %s", InvalidRoleErr, role)
+	}
+	if namespace != "" {
+		if _, ok := function.GetNamespace(namespace); !ok {
+			return APIKey{}, "", fmt.Errorf("%w: %s", function.NamespaceNotFoundErr, namespace)
+		}
+	}
+	key, token, err := newKey(role, namespace, description)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+
+	cli, err := utils.GetEtcdClient()
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	payload, err := json.Marshal(key)
+	if err != nil {
+		return APIKey{}, "", fmt.Errorf("Could not marshal API key: %v", err)
+	}
+	if _, err = cli.Put(ctx, getKeyEtcdKey(key.Id), string(payload)); err != nil {
+		return APIKey{}, "", fmt.Errorf("Failed Put: %v", err)
+	}
+
+	// update the local copy without waiting for the watcher
+	setKey(key)
+	return key, token, nil
+}
+
+// DeleteKey revokes an API key.
+func DeleteKey(id string) error {
+	cli, err := utils.GetEtcdClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	dresp, err := cli.Delete(ctx, getKeyEtcdKey(id))
+	if err != nil {
+		return fmt.Errorf("Failed Delete: %v", err)
+	}
+	removeKey(id)
+	if dresp.Deleted != 1 {
+		return KeyNotFoundErr
+	}
+	return nil
+}
+
+// GetAllKeys returns the API keys (without their hashes), sorted by creation
+// time.
+func GetAllKeys() []APIKey {
+	keysLock.RLock()
+	defer keysLock.RUnlock()
+	list := make([]APIKey, 0, len(keys))
+	for _, key := range keys {
+		key.Hash = ""
+		list = append(list, key)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return list
+}
+
+// InitKeyStore loads the API keys from etcd and keeps them in sync in the
+// background. The first time a node with authentication enabled connects to
+// etcd, an admin key is generated and logged, so that further keys can be
+// created through the API.
+func InitKeyStore() error {
+	cli, err := utils.GetEtcdClient()
+	if err != nil {
+		return err
+	}
+
+	if Enabled() {
+		if err := seedAdminKey(cli); err != nil {
+			return err
+		}
+	}
+
+	rev, err := loadKeys(cli)
+	if err != nil {
+		return err
+	}
+
+	go watchKeys(cli, rev)
+	return nil
+}
+
+func seedAdminKey(cli *clientv3.Client) error {
+	key, token, err := newKey(ADMIN, "", "initial admin key")
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	resp, err := cli.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(keysInitEtcdKey), "=", 0)).
+		Then(clientv3.OpPut(keysInitEtcdKey, "true"), clientv3.OpPut(getKeyEtcdKey(key.Id), string(payload))).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if resp.Succeeded {
+		log.Printf("Generated initial admin API key (it will not be shown again): %s\n", token)
+	}
+	return nil
+}
+
+// loadKeys replaces the local keys with the content of etcd and returns the
+// etcd revision it corresponds to.
+func loadKeys(cli *clientv3.Client) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	resp, err := cli.Get(ctx, keysEtcdPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return 0, fmt.Errorf("Could not read from etcd: %v", err)
+	}
+
+	loaded := make(map[string]APIKey)
+	for _, kv := range resp.Kvs {
+		var key APIKey
+		if err := json.Unmarshal(kv.Value, &key); err != nil {
+			log.Printf("Ignoring malformed API key %s: %v\n", kv.Key, err)
+			continue
+		}
+		loaded[key.Id] = key
+	}
+
+	keysLock.Lock()
+	keys = loaded
+	keysLock.Unlock()
+
+	return resp.Header.Revision, nil
+}
+
+func watchKeys(cli *clientv3.Client, rev int64) {
+	for {
+		watchChan := cli.Watch(context.Background(), keysEtcdPrefix, clientv3.WithPrefix(),
+			clientv3.WithRev(rev+1))
+		for wresp := range watchChan {
+			if wresp.Err() != nil {
+				log.Printf("API keys watch failed: %v\n", wresp.Err())
+				break
+			}
+			for _, ev := range wresp.Events {
+				id := string(ev.Kv.Key)[len(keysEtcdPrefix):]
+				if ev.Type == clientv3.EventTypeDelete {
+					removeKey(id)
+					continue
+				}
+				var key APIKey
+				if err := json.Unmarshal(ev.Kv.Value, &key); err != nil {
+					log.Printf("Ignoring malformed API key %s: %v\n", id, err)
+					continue
+				}
+				setKey(key)
+			}
+			rev = wresp.Header.Revision
+		}
+
+		if cli.Ctx().Err() != nil {
+			return // the client has been closed
+		}
+		// the watch has been interrupted (e.g., history compacted): reload
+		// all the keys and start again
+		time.Sleep(1 * time.Second)
+		if newRev, err := loadKeys(cli); err == nil {
+			rev = newRev
+		}
+	}
+}
@@ -1,18 +1,26 @@
 package cli
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	neturl "net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/grussorusso/serverledge/internal/api"
+	"github.com/grussorusso/serverledge/internal/auth"
 	"github.com/grussorusso/serverledge/internal/client"
 	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/container"
+	"github.com/grussorusso/serverledge/internal/executor"
 	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/grussorusso/serverledge/internal/sse"
+	"github.com/grussorusso/serverledge/internal/tlsutil"
 	"github.com/grussorusso/serverledge/utils"
 	"github.com/spf13/cobra"
 )
@@ -23,6 +31,27 @@ var rootCmd = &cobra.Command{
 	Use:   "serverledge-cli",
 	Short: "CLI utility for Serverledge",
 	Long:  `CLI utility to interact with a Serverledge FaaS platform.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		var base http.RoundTripper
+		if ServerConfig.TLS {
+			loader, err := tlsutil.NewLoader(ServerConfig.CertFile, ServerConfig.KeyFile, ServerConfig.CAFile)
+			if err != nil {
+				fmt.Printf("Invalid TLS configuration: %v\n", err)
+				os.Exit(1)
+			}
+			tr := http.DefaultTransport.(*http.Transport).Clone()
+			tr.TLSClientConfig = loader.ClientConfig()
+			base = tr
+		}
+		// authenticate every request, if an API key is given, and
+		// select the namespace
+		if ServerConfig.Token != "" || ServerConfig.Namespace != "" {
+			base = &auth.Transport{Base: base, Token: ServerConfig.Token, Namespace: ServerConfig.Namespace}
+		}
+		if base != nil {
+			http.DefaultClient.Transport = base
+		}
+	},
 }
 
 var invokeCmd = &cobra.Command{
@@ -61,20 +90,145 @@ var statusCmd = &cobra.Command{
 	Run:   getStatus,
 }
 
+var drainCmd = &cobra.Command{
+	Use:   "drain",
+	Short: "Drains the node (maintenance mode)",
+	Run:   drainNode,
+}
+
+var resumeCmd = &cobra.Command{
+	Use:   "resume",
+	Short: "Brings a drained node back into service",
+	Run:   resumeNode,
+}
+
+var runtimeCmd = &cobra.Command{
+	Use:   "runtime",
+	Short: "Manages the runtime catalog",
+}
+
+var runtimeListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists available runtimes",
+	Run:   listRuntimes,
+}
+
+var runtimeAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Adds a runtime to the catalog (or updates an existing one)",
+	Run:   addRuntime,
+}
+
+var runtimeDeleteCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "Removes a runtime from the catalog",
+	Run:   deleteRuntime,
+}
+
+var keyCmd = &cobra.Command{
+	Use:   "key",
+	Short: "Manages API keys",
+}
+
+var keyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists API keys",
+	Run:   listKeys,
+}
+
+var keyCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Creates an API key",
+	Run:   createKey,
+}
+
+var keyDeleteCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "Revokes an API key",
+	Run:   deleteKey,
+}
+
+var namespaceCmd = &cobra.Command{
+	Use:   "namespace",
+	Short: "Manages namespaces",
+}
+
+var namespaceListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists namespaces",
+	Run:   listNamespaces,
+}
+
+var namespaceSetCmd = &cobra.Command{
+	Use:   "set",
+	Short: "Creates a namespace (or updates its quota)",
+	Run:   setNamespace,
+}
+
+var namespaceDeleteCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "Deletes an empty namespace",
+	Run:   deleteNamespace,
+}
+
+var deadLetterCmd = &cobra.Command{
+	Use:   "deadletter",
+	Short: "Manages async requests which failed after exhausting their attempts",
+}
+
+var deadLetterListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists failed async requests",
+	Run:   listDeadLetters,
+}
+
+var deadLetterShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Shows a failed async request, along with its parameters",
+	Run:   showDeadLetter,
+}
+
+var deadLetterRedriveCmd = &cobra.Command{
+	Use:   "redrive",
+	Short: "Submits a failed async request again, as a new request",
+	Run:   redriveDeadLetter,
+}
+
 var funcName, runtime, handler, customImage, src, qosClass string
-var requestId string
+var requestIds []string
 var memory int64
+var maxConcurrency int
+var resultTTL int64
+var retryPolicy function.RetryPolicy
+var deadLetterId string
 var cpuDemand, qosMaxRespT float64
 var params []string
 var paramsFile string
 var asyncInvocation bool
 var verbose bool
 var returnOutput bool
+var followOutput bool
+var callbackURL, callbackSecret string
+var pollCallback bool
+var pollWait bool
+var pollTimeout time.Duration
+var drainTimeout int64
+var runtimeName, runtimeImage, handlerFormat string
+var invocationCmd []string
+var keyId, keyRole, keyDescription, keyNamespace string
+var namespaceName string
+var quota function.Quota
 
 func Init() {
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
 	rootCmd.PersistentFlags().StringVarP(&ServerConfig.Host, "host", "H", ServerConfig.Host, "remote Serverledge host")
 	rootCmd.PersistentFlags().IntVarP(&ServerConfig.Port, "port", "P", ServerConfig.Port, "remote Serverledge port")
+	rootCmd.PersistentFlags().StringVarP(&ServerConfig.Token, "token", "T", ServerConfig.Token, "API key")
+	rootCmd.PersistentFlags().BoolVarP(&ServerConfig.TLS, "tls", "", ServerConfig.TLS, "connect to the node over HTTPS")
+	rootCmd.PersistentFlags().StringVarP(&ServerConfig.CAFile, "cacert", "", ServerConfig.CAFile, "CA certificate used to verify the node (default: system roots)")
+	rootCmd.PersistentFlags().StringVarP(&ServerConfig.CertFile, "cert", "", ServerConfig.CertFile, "client certificate (if required by the node)")
+	rootCmd.PersistentFlags().StringVarP(&ServerConfig.KeyFile, "key", "", ServerConfig.KeyFile, "client private key")
+	rootCmd.PersistentFlags().StringVarP(&ServerConfig.Namespace, "namespace", "N", ServerConfig.Namespace, "namespace of the functions (default: the namespace of the API key, if any, or 'default')")
 
 	rootCmd.AddCommand(invokeCmd)
 	invokeCmd.Flags().StringVarP(&funcName, "function", "f", "", "name of the function")
@@ -84,15 +238,25 @@ func Init() {
 	invokeCmd.Flags().StringVarP(&paramsFile, "params_file", "j", "", "File containing parameters (JSON)")
 	invokeCmd.Flags().BoolVarP(&asyncInvocation, "async", "a", false, "Asynchronous invocation")
 	invokeCmd.Flags().BoolVarP(&returnOutput, "ret_output", "o", false, "Capture function output (if supported by used runtime)")
+	invokeCmd.Flags().BoolVarP(&followOutput, "follow", "F", false, "Print function output while the function is running")
+	invokeCmd.Flags().StringVarP(&callbackURL, "callback", "", "", "URL the response is POSTed to (async invocations only)")
+	invokeCmd.Flags().StringVarP(&callbackSecret, "callback_secret", "", "", "Secret used to sign callback deliveries (optional)")
+	invokeCmd.Flags().Int64VarP(&resultTTL, "result_ttl", "", 0, "Seconds the result is kept (async invocations only; 0: function default)")
 
 	rootCmd.AddCommand(createCmd)
 	createCmd.Flags().StringVarP(&funcName, "function", "f", "", "name of the function")
 	createCmd.Flags().StringVarP(&runtime, "runtime", "", "python38", "runtime for the function")
 	createCmd.Flags().StringVarP(&handler, "handler", "", "", "function handler (runtime specific)")
-	createCmd.Flags().Int64VarP(&memory, "memory", "", 128, "memory (in MB) for the function")
+	createCmd.Flags().Int64VarP(&memory, "memory", "", 0, "memory (in MB) for the function (0: runtime default)")
 	createCmd.Flags().Float64VarP(&cpuDemand, "cpu", "", 0.0, "estimated CPU demand for the function (1.0 = 1 core)")
 	createCmd.Flags().StringVarP(&src, "src", "", "", "source for the function (single file, directory or TAR archive) (not necessary for runtime==custom)")
 	createCmd.Flags().StringVarP(&customImage, "custom_image", "", "", "custom container image (only if runtime == 'custom')")
+	createCmd.Flags().IntVarP(&maxConcurrency, "max_concurrency", "", 1, "max concurrent invocations served by each function instance")
+	createCmd.Flags().Int64VarP(&resultTTL, "result_ttl", "", 0, "seconds the results of async invocations are kept (0: node default)")
+	createCmd.Flags().IntVarP(&retryPolicy.MaxAttempts, "retry_attempts", "", 0, "max attempts of failed async invocations (0: no retries)")
+	createCmd.Flags().Float64VarP(&retryPolicy.Backoff, "retry_backoff", "", 0, "seconds before the first retry, doubled upon each further retry (0: default)")
+	createCmd.Flags().Float64VarP(&retryPolicy.MaxBackoff, "retry_max_backoff", "", 0, "max seconds between attempts (0: default)")
+	createCmd.Flags().StringSliceVarP(&retryPolicy.RetryOn, "retry_on", "", nil, "error classes that are retried: resources, offload, container, function (default: all)")
 
 	rootCmd.AddCommand(deleteCmd)
 	deleteCmd.Flags().StringVarP(&funcName, "function", "f", "", "name of the function")
@@ -101,8 +265,54 @@ func Init() {
 
 	rootCmd.AddCommand(statusCmd)
 
+	rootCmd.AddCommand(drainCmd)
+	drainCmd.Flags().Int64VarP(&drainTimeout, "timeout", "t", 0, "max time (in seconds) to wait for pending requests (0: node default)")
+
+	rootCmd.AddCommand(resumeCmd)
+
+	rootCmd.AddCommand(runtimeCmd)
+	runtimeCmd.AddCommand(runtimeListCmd)
+	runtimeCmd.AddCommand(runtimeAddCmd)
+	runtimeAddCmd.Flags().StringVarP(&runtimeName, "name", "n", "", "name of the runtime")
+	runtimeAddCmd.Flags().StringVarP(&runtimeImage, "image", "i", "", "container image of the runtime")
+	runtimeAddCmd.Flags().StringSliceVarP(&invocationCmd, "cmd", "", nil, "command used by the executor to run functions (comma-separated)")
+	runtimeAddCmd.Flags().Int64VarP(&memory, "memory", "", 0, "default memory (in MB) for functions")
+	runtimeAddCmd.Flags().StringVarP(&handlerFormat, "handler_format", "", "", "regular expression that function handlers must match")
+	runtimeCmd.AddCommand(runtimeDeleteCmd)
+	runtimeDeleteCmd.Flags().StringVarP(&runtimeName, "name", "n", "", "name of the runtime")
+
+	rootCmd.AddCommand(keyCmd)
+	keyCmd.AddCommand(keyListCmd)
+	keyCmd.AddCommand(keyCreateCmd)
+	keyCreateCmd.Flags().StringVarP(&keyRole, "role", "r", "", "role of the key (admin, developer, invoker)")
+	keyCreateCmd.Flags().StringVarP(&keyDescription, "description", "d", "", "description of the key (optional)")
+	keyCreateCmd.Flags().StringVarP(&keyNamespace, "key_namespace", "", "", "only namespace the key gives access to (optional)")
+	keyCmd.AddCommand(keyDeleteCmd)
+	keyDeleteCmd.Flags().StringVarP(&keyId, "id", "", "", "ID of the key")
+
+	rootCmd.AddCommand(namespaceCmd)
+	namespaceCmd.AddCommand(namespaceListCmd)
+	namespaceCmd.AddCommand(namespaceSetCmd)
+	namespaceSetCmd.Flags().StringVarP(&namespaceName, "name", "n", "", "name of the namespace")
+	namespaceSetCmd.Flags().IntVarP(&quota.MaxFunctions, "max_functions", "", 0, "max number of functions (0: unlimited)")
+	namespaceSetCmd.Flags().IntVarP(&quota.MaxContainers, "max_containers", "", 0, "max concurrent containers on each node (0: unlimited)")
+	namespaceSetCmd.Flags().Int64VarP(&quota.MaxMemoryMB, "max_memory", "", 0, "max total memory (in MB) of the containers on each node (0: unlimited)")
+	namespaceSetCmd.Flags().Float64VarP(&quota.MaxInvocationRate, "max_rate", "", 0, "max invocations per second on each node (0: unlimited)")
+	namespaceCmd.AddCommand(namespaceDeleteCmd)
+	namespaceDeleteCmd.Flags().StringVarP(&namespaceName, "name", "n", "", "name of the namespace")
+
+	rootCmd.AddCommand(deadLetterCmd)
+	deadLetterCmd.AddCommand(deadLetterListCmd)
+	deadLetterCmd.AddCommand(deadLetterShowCmd)
+	deadLetterShowCmd.Flags().StringVarP(&deadLetterId, "request", "", "", "ID of the failed async request")
+	deadLetterCmd.AddCommand(deadLetterRedriveCmd)
+	deadLetterRedriveCmd.Flags().StringVarP(&deadLetterId, "request", "", "", "ID of the failed async request")
+
 	rootCmd.AddCommand(pollCmd)
-	pollCmd.Flags().StringVarP(&requestId, "request", "", "", "ID of the async request")
+	pollCmd.Flags().StringSliceVarP(&requestIds, "request", "", nil, "ID of the async request (more than one can be given with --wait)")
+	pollCmd.Flags().BoolVarP(&pollWait, "wait", "w", false, "Wait for the results to be available, printing them as they arrive")
+	pollCmd.Flags().DurationVarP(&pollTimeout, "wait_timeout", "", 0, "Max time to wait for the results, e.g., 30s (0: no limit)")
+	pollCmd.Flags().BoolVarP(&pollCallback, "callback", "", false, "Show the delivery status of the callback instead of the result")
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
@@ -168,13 +378,36 @@ func invoke(cmd *cobra.Command, args []string) {
 		CanDoOffloading: true,
 		ReturnOutput:    returnOutput,
 		Async:           asyncInvocation}
+	if callbackURL != "" {
+		if !asyncInvocation {
+			fmt.Println("Callbacks are only supported for asynchronous invocations")
+			os.Exit(1)
+		}
+		request.Callback = &function.Callback{URL: callbackURL, Secret: callbackSecret}
+	}
+	if resultTTL != 0 {
+		if !asyncInvocation {
+			fmt.Println("The result TTL is only supported for asynchronous invocations")
+			os.Exit(1)
+		}
+		request.ResultTTL = resultTTL
+	}
 	invocationBody, err := json.Marshal(request)
 	if err != nil {
 		showHelpAndExit(cmd)
 	}
 
+	if followOutput {
+		if asyncInvocation {
+			fmt.Println("Asynchronous invocations cannot be followed")
+			os.Exit(1)
+		}
+		followInvocation(invocationBody)
+		return
+	}
+
 	// Send invocation request
-	url := fmt.Sprintf("http://%s:%d/invoke/%s", ServerConfig.Host, ServerConfig.Port, funcName)
+	url := fmt.Sprintf("%s://%s:%d/invoke/%s", ServerConfig.Scheme(), ServerConfig.Host, ServerConfig.Port, funcName)
 	resp, err := utils.PostJson(url, invocationBody)
 	if err != nil {
 		fmt.Printf("Invocation failed: %v\n", err)
@@ -183,6 +416,36 @@ func invoke(cmd *cobra.Command, args []string) {
 	utils.PrintJsonResponse(resp.Body)
 }
 
+// followInvocation invokes a function, printing its output as soon as it is
+// streamed by the server, followed by the response.
+func followInvocation(invocationBody []byte) {
+	url := fmt.Sprintf("%s://%s:%d/invoke/%s/stream", ServerConfig.Scheme(), ServerConfig.Host, ServerConfig.Port, funcName)
+	resp, err := utils.PostJson(url, invocationBody)
+	if err != nil {
+		fmt.Printf("Invocation failed: %v\n", err)
+		os.Exit(2)
+	}
+	defer resp.Body.Close()
+
+	err = sse.ReadEvents(resp.Body, func(event string, data string) error {
+		switch event {
+		case executor.EVENT_OUTPUT:
+			fmt.Print(data)
+		case executor.EVENT_PARTIAL:
+			fmt.Printf("[partial result] %s\n", data)
+		case executor.EVENT_RESULT:
+			utils.PrintJsonResponse(io.NopCloser(strings.NewReader(data)))
+		case executor.EVENT_ERROR:
+			return fmt.Errorf("%s", data)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Invocation failed: %v\n", err)
+		os.Exit(2)
+	}
+}
+
 func create(cmd *cobra.Command, args []string) {
 	if funcName == "" || runtime == "" {
 		showHelpAndExit(cmd)
@@ -210,13 +473,18 @@ func create(cmd *cobra.Command, args []string) {
 		CPUDemand:       cpuDemand,
 		TarFunctionCode: encoded,
 		CustomImage:     customImage,
+		MaxConcurrency:  maxConcurrency,
+		AsyncResultTTL:  resultTTL,
+	}
+	if retryPolicy.MaxAttempts > 0 {
+		request.Retry = &retryPolicy
 	}
 	requestBody, err := json.Marshal(request)
 	if err != nil {
 		showHelpAndExit(cmd)
 	}
 
-	url := fmt.Sprintf("http://%s:%d/create", ServerConfig.Host, ServerConfig.Port)
+	url := fmt.Sprintf("%s://%s:%d/create", ServerConfig.Scheme(), ServerConfig.Host, ServerConfig.Port)
 	resp, err := utils.PostJson(url, requestBody)
 	if err != nil {
 		// TODO: check returned error code
@@ -269,7 +537,7 @@ func deleteFunction(cmd *cobra.Command, args []string) {
 		os.Exit(2)
 	}
 
-	url := fmt.Sprintf("http://%s:%d/delete", ServerConfig.Host, ServerConfig.Port)
+	url := fmt.Sprintf("%s://%s:%d/delete", ServerConfig.Scheme(), ServerConfig.Host, ServerConfig.Port)
 	resp, err := utils.PostJson(url, requestBody)
 	if err != nil {
 		fmt.Printf("Deletion request failed: %v\n", err)
@@ -279,7 +547,7 @@ func deleteFunction(cmd *cobra.Command, args []string) {
 }
 
 func listFunctions(cmd *cobra.Command, args []string) {
-	url := fmt.Sprintf("http://%s:%d/function", ServerConfig.Host, ServerConfig.Port)
+	url := fmt.Sprintf("%s://%s:%d/function", ServerConfig.Scheme(), ServerConfig.Host, ServerConfig.Port)
 	resp, err := http.Get(url)
 	if err != nil {
 		fmt.Printf("List request failed: %v\n", err)
@@ -289,7 +557,7 @@ func listFunctions(cmd *cobra.Command, args []string) {
 }
 
 func getStatus(cmd *cobra.Command, args []string) {
-	url := fmt.Sprintf("http://%s:%d/status", ServerConfig.Host, ServerConfig.Port)
+	url := fmt.Sprintf("%s://%s:%d/status", ServerConfig.Scheme(), ServerConfig.Host, ServerConfig.Port)
 	resp, err := http.Get(url)
 	if err != nil {
 		fmt.Printf("Invocation failed: %v", err)
@@ -299,15 +567,313 @@ func getStatus(cmd *cobra.Command, args []string) {
 }
 
 func poll(cmd *cobra.Command, args []string) {
-	if len(requestId) < 1 {
+	if len(requestIds) < 1 || (len(requestIds) > 1 && !pollWait) {
 		showHelpAndExit(cmd)
 	}
+	if pollWait {
+		if pollCallback {
+			fmt.Println("The delivery status of callbacks cannot be waited for")
+			os.Exit(1)
+		}
+		waitForResults()
+		return
+	}
+
+	url := fmt.Sprintf("%s://%s:%d/poll/%s", ServerConfig.Scheme(), ServerConfig.Host, ServerConfig.Port, requestIds[0])
+	if pollCallback {
+		url = fmt.Sprintf("%s://%s:%d/callback/%s", ServerConfig.Scheme(), ServerConfig.Host, ServerConfig.Port, requestIds[0])
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		fmt.Printf("Polling request failed: %v\n", err)
+		os.Exit(2)
+	}
+	utils.PrintJsonResponse(resp.Body)
+}
 
-	url := fmt.Sprintf("http://%s:%d/poll/%s", ServerConfig.Host, ServerConfig.Port, requestId)
+// waitForResults subscribes to the results of the requests, printing them as
+// soon as they are available.
+func waitForResults() {
+	query := neturl.Values{}
+	query.Set("reqId", strings.Join(requestIds, ","))
+	if pollTimeout > 0 {
+		query.Set("wait", pollTimeout.String())
+	}
+	url := fmt.Sprintf("%s://%s:%d/subscribe?%s", ServerConfig.Scheme(), ServerConfig.Host, ServerConfig.Port, query.Encode())
 	resp, err := http.Get(url)
 	if err != nil {
 		fmt.Printf("Polling request failed: %v\n", err)
 		os.Exit(2)
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		fmt.Printf("Polling request failed: %s %s\n", resp.Status, body)
+		os.Exit(2)
+	}
+
+	err = sse.ReadEvents(resp.Body, func(event string, data string) error {
+		switch event {
+		case client.EVENT_ASYNC_RESULT:
+			var result client.AsyncResultEvent
+			if err := json.Unmarshal([]byte(data), &result); err != nil {
+				return err
+			}
+			if len(requestIds) > 1 {
+				fmt.Printf("%s:\n", result.ReqId)
+			}
+			if result.Location != "" {
+				fmt.Printf("Result available at: %s\n", result.Location)
+				return nil
+			}
+			utils.PrintJsonResponse(io.NopCloser(bytes.NewReader(result.Result)))
+		case client.EVENT_ASYNC_TIMEOUT:
+			return fmt.Errorf("timed out waiting for %s", data)
+		case client.EVENT_ASYNC_ERROR:
+			return fmt.Errorf("%s", data)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Polling request failed: %v\n", err)
+		os.Exit(2)
+	}
+}
+
+func drainNode(cmd *cobra.Command, args []string) {
+	requestBody, err := json.Marshal(client.DrainRequest{Timeout: drainTimeout})
+	if err != nil {
+		showHelpAndExit(cmd)
+	}
+
+	url := fmt.Sprintf("%s://%s:%d/drain", ServerConfig.Scheme(), ServerConfig.Host, ServerConfig.Port)
+	resp, err := utils.PostJson(url, requestBody)
+	if err != nil {
+		fmt.Printf("Drain request failed: %v\n", err)
+		os.Exit(2)
+	}
+	utils.PrintJsonResponse(resp.Body)
+}
+
+func resumeNode(cmd *cobra.Command, args []string) {
+	url := fmt.Sprintf("%s://%s:%d/resume", ServerConfig.Scheme(), ServerConfig.Host, ServerConfig.Port)
+	resp, err := utils.PostJson(url, []byte{})
+	if err != nil {
+		fmt.Printf("Resume request failed: %v\n", err)
+		os.Exit(2)
+	}
+	utils.PrintJsonResponse(resp.Body)
+}
+
+func listRuntimes(cmd *cobra.Command, args []string) {
+	url := fmt.Sprintf("%s://%s:%d/runtime", ServerConfig.Scheme(), ServerConfig.Host, ServerConfig.Port)
+	resp, err := http.Get(url)
+	if err != nil {
+		fmt.Printf("List request failed: %v\n", err)
+		os.Exit(2)
+	}
+	utils.PrintJsonResponse(resp.Body)
+}
+
+func addRuntime(cmd *cobra.Command, args []string) {
+	if runtimeName == "" || runtimeImage == "" {
+		showHelpAndExit(cmd)
+	}
+
+	request := container.RuntimeInfo{
+		Name:            runtimeName,
+		Image:           runtimeImage,
+		InvocationCmd:   invocationCmd,
+		DefaultMemoryMB: memory,
+		HandlerFormat:   handlerFormat,
+	}
+	requestBody, err := json.Marshal(request)
+	if err != nil {
+		showHelpAndExit(cmd)
+	}
+
+	url := fmt.Sprintf("%s://%s:%d/runtime", ServerConfig.Scheme(), ServerConfig.Host, ServerConfig.Port)
+	resp, err := utils.PostJson(url, requestBody)
+	if err != nil {
+		fmt.Printf("Runtime request failed: %v\n", err)
+		os.Exit(2)
+	}
+	utils.PrintJsonResponse(resp.Body)
+}
+
+func deleteRuntime(cmd *cobra.Command, args []string) {
+	if runtimeName == "" {
+		showHelpAndExit(cmd)
+	}
+
+	url := fmt.Sprintf("%s://%s:%d/runtime/%s", ServerConfig.Scheme(), ServerConfig.Host, ServerConfig.Port, runtimeName)
+	resp, err := utils.Delete(url)
+	if err != nil {
+		fmt.Printf("Deletion request failed: %v\n", err)
+		os.Exit(2)
+	}
+	utils.PrintJsonResponse(resp.Body)
+}
+
+func listKeys(cmd *cobra.Command, args []string) {
+	url := fmt.Sprintf("%s://%s:%d/v2/keys", ServerConfig.Scheme(), ServerConfig.Host, ServerConfig.Port)
+	resp, err := http.Get(url)
+	if err != nil {
+		fmt.Printf("List request failed: %v\n", err)
+		os.Exit(2)
+	}
+	utils.PrintJsonResponse(resp.Body)
+}
+
+func createKey(cmd *cobra.Command, args []string) {
+	if keyRole == "" {
+		showHelpAndExit(cmd)
+	}
+
+	requestBody, err := json.Marshal(api.KeyRequest{Role: auth.Role(keyRole), Namespace: keyNamespace, Description: keyDescription})
+	if err != nil {
+		showHelpAndExit(cmd)
+	}
+
+	url := fmt.Sprintf("%s://%s:%d/v2/keys", ServerConfig.Scheme(), ServerConfig.Host, ServerConfig.Port)
+	resp, err := http.Post(url, "application/json", bytes.NewReader(requestBody))
+	if err != nil {
+		fmt.Printf("Key request failed: %v\n", err)
+		os.Exit(2)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		fmt.Printf("Key request failed: %v\n", resp.Status)
+		utils.PrintJsonResponse(resp.Body)
+		os.Exit(2)
+	}
+	utils.PrintJsonResponse(resp.Body)
+}
+
+func deleteKey(cmd *cobra.Command, args []string) {
+	if keyId == "" {
+		showHelpAndExit(cmd)
+	}
+
+	url := fmt.Sprintf("%s://%s:%d/v2/keys/%s", ServerConfig.Scheme(), ServerConfig.Host, ServerConfig.Port, keyId)
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	if err != nil {
+		showHelpAndExit(cmd)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Printf("Deletion request failed: %v\n", err)
+		os.Exit(2)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		fmt.Printf("Deletion request failed: %v\n", resp.Status)
+		utils.PrintJsonResponse(resp.Body)
+		os.Exit(2)
+	}
+	fmt.Printf("Deleted key %s\n", keyId)
+}
+
+func listNamespaces(cmd *cobra.Command, args []string) {
+	url := fmt.Sprintf("%s://%s:%d/v2/namespaces", ServerConfig.Scheme(), ServerConfig.Host, ServerConfig.Port)
+	resp, err := http.Get(url)
+	if err != nil {
+		fmt.Printf("List request failed: %v\n", err)
+		os.Exit(2)
+	}
+	utils.PrintJsonResponse(resp.Body)
+}
+
+func setNamespace(cmd *cobra.Command, args []string) {
+	if namespaceName == "" {
+		showHelpAndExit(cmd)
+	}
+
+	requestBody, err := json.Marshal(function.Namespace{Name: namespaceName, Quota: quota})
+	if err != nil {
+		showHelpAndExit(cmd)
+	}
+
+	url := fmt.Sprintf("%s://%s:%d/v2/namespaces/%s", ServerConfig.Scheme(), ServerConfig.Host, ServerConfig.Port, namespaceName)
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(requestBody))
+	if err != nil {
+		showHelpAndExit(cmd)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Printf("Namespace request failed: %v\n", err)
+		os.Exit(2)
+	}
+	if resp.StatusCode != http.StatusOK {
+		fmt.Printf("Namespace request failed: %v\n", resp.Status)
+		utils.PrintJsonResponse(resp.Body)
+		os.Exit(2)
+	}
+	utils.PrintJsonResponse(resp.Body)
+}
+
+func deleteNamespace(cmd *cobra.Command, args []string) {
+	if namespaceName == "" {
+		showHelpAndExit(cmd)
+	}
+
+	url := fmt.Sprintf("%s://%s:%d/v2/namespaces/%s", ServerConfig.Scheme(), ServerConfig.Host, ServerConfig.Port, namespaceName)
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	if err != nil {
+		showHelpAndExit(cmd)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Printf("Deletion request failed: %v\n", err)
+		os.Exit(2)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		fmt.Printf("Deletion request failed: %v\n", resp.Status)
+		utils.PrintJsonResponse(resp.Body)
+		os.Exit(2)
+	}
+	fmt.Printf("Deleted namespace %s\n", namespaceName)
+}
+
+func listDeadLetters(cmd *cobra.Command, args []string) {
+	url := fmt.Sprintf("%s://%s:%d/deadletter", ServerConfig.Scheme(), ServerConfig.Host, ServerConfig.Port)
+	resp, err := http.Get(url)
+	if err != nil {
+		fmt.Printf("List request failed: %v\n", err)
+		os.Exit(2)
+	}
+	utils.PrintJsonResponse(resp.Body)
+}
+
+func showDeadLetter(cmd *cobra.Command, args []string) {
+	if deadLetterId == "" {
+		showHelpAndExit(cmd)
+	}
+
+	url := fmt.Sprintf("%s://%s:%d/deadletter/%s", ServerConfig.Scheme(), ServerConfig.Host, ServerConfig.Port, deadLetterId)
+	resp, err := http.Get(url)
+	if err != nil {
+		fmt.Printf("Dead letter request failed: %v\n", err)
+		os.Exit(2)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		fmt.Printf("Unknown dead letter: %s\n", deadLetterId)
+		os.Exit(2)
+	}
+	utils.PrintJsonResponse(resp.Body)
+}
+
+func redriveDeadLetter(cmd *cobra.Command, args []string) {
+	if deadLetterId == "" {
+		showHelpAndExit(cmd)
+	}
+
+	url := fmt.Sprintf("%s://%s:%d/deadletter/%s/redrive", ServerConfig.Scheme(), ServerConfig.Host, ServerConfig.Port, deadLetterId)
+	resp, err := utils.PostJson(url, []byte{})
+	if err != nil {
+		fmt.Printf("Redrive request failed: %v\n", err)
+		os.Exit(2)
+	}
 	utils.PrintJsonResponse(resp.Body)
 }
@@ -1,5 +1,11 @@
 package client
 
+import (
+	"encoding/json"
+
+	"github.com/grussorusso/serverledge/internal/function"
+)
+
 type InvocationRequest struct {
 	Params          map[string]interface{}
 	QoSClass        int64
@@ -7,6 +13,11 @@ type InvocationRequest struct {
 	CanDoOffloading bool
 	Async           bool
 	ReturnOutput    bool
+	HTTPRequest     *function.HTTPRequest `json:",omitempty"` // set for requests received by the HTTP trigger
+	Callback        *function.Callback    `json:",omitempty"` // async requests only
+	ReqId           string                `json:",omitempty"` // set by nodes offloading async requests
+	ResultTTL       int64                 `json:",omitempty"` // seconds the result of async requests is kept (0: function default)
+	Attempts        int                   `json:",omitempty"` // failed attempts of async requests offloaded by nodes
 }
 
 type PrewarmingRequest struct {
@@ -14,3 +25,21 @@ type PrewarmingRequest struct {
 	Instances      int64
 	ForceImagePull bool
 }
+
+type DrainRequest struct {
+	Timeout int64 // seconds (0: use configured default)
+}
+
+// Events of the stream returned by /subscribe.
+const (
+	EVENT_ASYNC_RESULT  = "result"  // AsyncResultEvent
+	EVENT_ASYNC_TIMEOUT = "timeout" // JSON list of the IDs of the requests still pending
+	EVENT_ASYNC_ERROR   = "error"   // results cannot be retrieved
+)
+
+// AsyncResultEvent carries the result of an async request to subscribers.
+type AsyncResultEvent struct {
+	ReqId    string
+	Result   json.RawMessage `json:",omitempty"` // as returned by /poll
+	Location string          `json:",omitempty"` // URL of the result, if too large to be sent in place of Result
+}
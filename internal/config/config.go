@@ -3,6 +3,7 @@ package config
 import (
 	"log"
 	"path/filepath"
+	"strings"
 
 	"github.com/spf13/viper"
 )
@@ -42,6 +43,23 @@ func GetString(key string, defaultValue string) string {
 	}
 }
 
+// GetStringSlice returns the configured list for a given key, which may be
+// given as a comma-separated string.
+func GetStringSlice(key string) []string {
+	if !viper.IsSet(key) {
+		return nil
+	}
+	var values []string
+	for _, value := range viper.GetStringSlice(key) {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
 func GetBool(key string, defaultValue bool) bool {
 	if viper.IsSet(key) {
 		return viper.GetBool(key)
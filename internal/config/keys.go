@@ -13,6 +13,50 @@ const CLOUD_URL = "cloud.server.url"
 // even if they are locally available (true/false).
 const FACTORY_REFRESH_IMAGES = "factory.images.refresh"
 
+// Pulls the images of registered functions at startup (true/false).
+const FACTORY_IMAGES_PREPULL = "factory.images.prepull"
+
+// Max disk space (in MB) used by images before unused ones are removed
+// (0 = no limit)
+const FACTORY_IMAGES_BUDGET = "factory.images.budget"
+
+// Interval (in seconds) between image garbage collections
+const FACTORY_IMAGES_GC_INTERVAL = "factory.images.gc.interval"
+
+// Pulls runtime images as soon as runtimes are added to the catalog (true/false).
+const RUNTIME_PREPULL = "runtime.prepull"
+
+// Container factory to use
+// Possible values: "docker" (default), "containerd", "process"
+const FACTORY_TYPE = "factory.type"
+
+// containerd socket address (if factory.type = "containerd")
+const FACTORY_CONTAINERD_ADDRESS = "factory.containerd.address"
+
+// containerd namespace for Serverledge containers
+const FACTORY_CONTAINERD_NAMESPACE = "factory.containerd.namespace"
+
+// directories containing CNI network configuration and plugin binaries
+const FACTORY_CNI_CONF_DIR = "factory.cni.confdir"
+const FACTORY_CNI_BIN_DIR = "factory.cni.bindir"
+
+// path of the executor binary run by the process factory (default: next to
+// the node executable)
+const FACTORY_PROCESS_EXECUTOR = "factory.process.executor"
+
+// directory where the process factory creates sandboxes
+const FACTORY_PROCESS_DIR = "factory.process.dir"
+
+// whether the process factory enforces resource limits via cgroup v2 (true/false)
+const FACTORY_PROCESS_CGROUPS = "factory.process.cgroups"
+
+// Stable identifier of the node, used to label its containers
+// (default: <hostname>-<api port>)
+const NODE_ID = "node.id"
+
+// Whether containers left behind by a previous run are reclaimed at startup
+const CONTAINER_RECONCILE = "container.reconcile"
+
 // Amount of memory available for the container pool (in MB)
 const POOL_MEMORY_MB = "container.pool.memory"
 
@@ -64,3 +108,96 @@ const SCHEDULING_POLICY = "scheduler.policy"
 
 // Capacity of the queue (possibly) used by the scheduler
 const SCHEDULER_QUEUE_CAPACITY = "scheduler.queue.capacity"
+
+// Max number of times a request is scheduled again after its container failed
+const SCHEDULER_BROKEN_RETRIES = "scheduler.retries.broken"
+
+// Interval (in seconds) between health checks of warm containers (0 = disabled)
+const CONTAINER_HEALTH_INTERVAL = "container.health.interval"
+
+// Max time (in seconds) to wait for pending requests when draining the node
+const DRAIN_TIMEOUT = "drain.timeout"
+
+// Sample the resources used by containers upon each invocation (if supported by the factory)
+const CONTAINER_STATS = "container.stats"
+
+// Requires API requests to be authenticated via API keys (true/false)
+const AUTH_ENABLED = "auth.enabled"
+
+// Credential used by nodes to authenticate to each other (e.g., when
+// offloading requests); must be the same on all the nodes
+const AUTH_SERVICE_TOKEN = "auth.service.token"
+
+// TLS certificate and private key of the node (PEM files). If set, the API
+// is served over HTTPS, and the certificate is presented to other nodes and
+// to etcd (mutual TLS)
+const TLS_CERT = "tls.cert"
+const TLS_KEY = "tls.key"
+
+// CA certificate of the cluster (PEM file), used to verify other nodes and
+// etcd
+const TLS_CA = "tls.ca"
+
+// Organizational unit of the certificates of nodes: only API clients
+// presenting a certificate signed by the cluster CA with this unit in the
+// subject are authenticated as nodes
+const TLS_NODE_OU = "tls.node.ou"
+
+// Requires API clients to present a certificate signed by the cluster CA (true/false)
+const TLS_CLIENT_REQUIRE = "tls.client.require"
+
+// Interval (in seconds) between checks for updated certificate files
+const TLS_RELOAD_INTERVAL = "tls.reload.interval"
+
+// Connects to etcd over TLS, using the certificates of the node (true/false)
+const ETCD_TLS = "etcd.tls"
+
+// Max number of attempts to deliver the callback of an async request
+const CALLBACK_MAX_ATTEMPTS = "callback.attempts"
+
+// Delay (in seconds) before retrying a failed callback delivery, doubled
+// upon each further attempt
+const CALLBACK_BACKOFF = "callback.backoff"
+
+// Timeout (in seconds) of each callback delivery attempt
+const CALLBACK_TIMEOUT = "callback.timeout"
+
+// Hosts, IP addresses and CIDR networks callbacks may be delivered to, even
+// if internal (e.g., private or loopback addresses)
+const CALLBACK_ALLOW = "callback.allow"
+
+// Hosts, IP addresses and CIDR networks callbacks may not be delivered to
+const CALLBACK_DENY = "callback.deny"
+
+// Max time (in seconds) a poll request may wait for the result of an async
+// request
+const POLL_MAX_WAIT = "poll.wait.max"
+
+// Interval (in seconds) between checks for async requests whose owner node
+// has failed
+const ASYNC_RECOVERY_INTERVAL = "async.recovery.interval"
+
+// Max age (in seconds) of orphaned async requests that are submitted again;
+// older ones expire
+const ASYNC_EXPIRATION = "async.expiration"
+
+// Store of the results of async requests: "etcd" (default), shared by all the
+// nodes, or "local", an embedded key-value store
+const ASYNC_STORE = "async.store"
+
+// File of the local store of async results
+const ASYNC_STORE_PATH = "async.store.path"
+
+// Default time (in seconds) the results of async requests are kept
+const ASYNC_RESULT_TTL = "async.result.ttl"
+
+// Max size (in bytes) of the async results kept in the store; larger ones are
+// spilled to the local disk (0 = no limit)
+const ASYNC_RESULT_MAX_SIZE = "async.result.max_size"
+
+// Directory where large async results are spilled
+const ASYNC_SPILL_DIR = "async.spill.dir"
+
+// Time (in seconds) failed async requests are kept in the dead-letter store
+// (0 = forever)
+const ASYNC_DEADLETTER_TTL = "async.deadletter.ttl"
@@ -1,6 +1,20 @@
 package config
 
 type RemoteServerConf struct {
-	Host string
-	Port int
+	Host      string
+	Port      int
+	Token     string // API key (optional)
+	Namespace string // namespace of the functions (optional)
+	TLS       bool   // whether the API is served over HTTPS
+	CAFile    string // CA certificate used to verify the server (optional)
+	CertFile  string // client certificate (optional)
+	KeyFile   string // client private key (optional)
+}
+
+// Scheme returns the URL scheme of the remote API.
+func (c *RemoteServerConf) Scheme() string {
+	if c.TLS {
+		return "https"
+	}
+	return "http"
 }
@@ -2,36 +2,57 @@ package container
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/grussorusso/serverledge/internal/executor"
+	"github.com/grussorusso/serverledge/internal/sse"
 )
 
-// NewContainer creates and starts a new container.
+// InvocationFailedErr is returned when the Executor reports that it could not
+// serve an invocation (e.g., the handler could not be run), although the
+// container is working.
+var InvocationFailedErr = errors.New("the executor could not serve the invocation")
+
+// NewContainer creates and starts a new container, pulling the image if
+// needed.
 func NewContainer(image, codeTar string, opts *ContainerOptions) (ContainerID, error) {
+	acquireImage(image)
+	if err := DownloadImage(image, false); err != nil {
+		// error ignored, as we might still have a stale copy of the image
+		log.Printf("Could not download image %s: %v\n", image, err)
+	}
+
 	contID, err := cf.Create(image, opts)
 	if err != nil {
+		releaseImage(image)
 		log.Printf("Failed container creation\n")
 		return "", err
 	}
+	trackContainerImage(contID, image)
 
 	if len(codeTar) > 0 {
 		decodedCode, _ := base64.StdEncoding.DecodeString(codeTar)
 		err = cf.CopyToContainer(contID, bytes.NewReader(decodedCode), "/app/")
 		if err != nil {
 			log.Printf("Failed code copy\n")
+			_ = Destroy(contID)
 			return "", err
 		}
 	}
 
 	err = cf.Start(contID)
 	if err != nil {
+		_ = Destroy(contID)
 		return "", err
 	}
 
@@ -40,18 +61,48 @@ func NewContainer(image, codeTar string, opts *ContainerOptions) (ContainerID, e
 
 // Execute interacts with the Executor running in the container to invoke the
 // function through a HTTP request.
-func Execute(contID ContainerID, req *executor.InvocationRequest) (*executor.InvocationResult, time.Duration, error) {
+// If ctx is cancelled before completion, the Executor is asked to abort the
+// invocation.
+// As the Executor of a new container may need some time to start, we first
+// wait for it to be ready, unless the container is warm. The time spent
+// waiting is returned.
+// If stream is not nil, the function output and partial results are passed
+// to it as soon as they are produced (if supported by the Executor).
+func Execute(ctx context.Context, contID ContainerID, req *executor.InvocationRequest, isWarm bool, stream func(event string, data string)) (*executor.InvocationResult, time.Duration, error) {
 	ipAddr, err := cf.GetIPAddress(contID)
 	if err != nil {
 		return nil, 0, fmt.Errorf("Failed to retrieve IP address for container: %v", err)
 	}
 
-	postBody, _ := json.Marshal(req)
-	postBodyB := bytes.NewBuffer(postBody)
-	resp, waitDuration, err := sendPostRequestWithRetries(fmt.Sprintf("http://%s:%d/invoke", ipAddr,
-		executor.DEFAULT_EXECUTOR_PORT), postBodyB)
-	if err != nil || resp == nil {
-		return nil, waitDuration, fmt.Errorf("Request to executor failed: %v", err)
+	var readinessTime time.Duration
+	if !isWarm {
+		readinessTime, err = waitForReadiness(ctx, ipAddr)
+		if ctx.Err() != nil {
+			return nil, readinessTime, ctx.Err()
+		} else if err != nil {
+			return nil, readinessTime, err
+		}
+	}
+
+	path := "/invoke"
+	if stream != nil {
+		path = "/invoke/stream"
+	}
+	resp, err := postInvocation(ctx, executorURL(ipAddr, path), req)
+	if err == nil && stream != nil && resp.StatusCode == http.StatusNotFound {
+		// streaming not supported by the Executor
+		_ = resp.Body.Close()
+		resp, err = postInvocation(ctx, executorURL(ipAddr, "/invoke"), req)
+	}
+	if ctx.Err() != nil {
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		cancelExecution(ipAddr, req.Id)
+		return nil, readinessTime, ctx.Err()
+	}
+	if err != nil {
+		return nil, readinessTime, fmt.Errorf("Request to executor failed: %v", err)
 	}
 	defer func(Body io.ReadCloser) {
 		err := Body.Close()
@@ -60,59 +111,196 @@ func Execute(contID ContainerID, req *executor.InvocationRequest) (*executor.Inv
 		}
 	}(resp.Body)
 
-	d := json.NewDecoder(resp.Body)
-	response := &executor.InvocationResult{}
-	err = d.Decode(response)
+	var response *executor.InvocationResult
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		// the Executor answers with the reason of the failure
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, readinessTime, fmt.Errorf("%w: %s", InvocationFailedErr, strings.TrimSpace(string(msg)))
+	} else if strings.HasPrefix(resp.Header.Get("Content-Type"), sse.ContentType) {
+		response, err = readEventStream(resp.Body, stream)
+		if ctx.Err() != nil {
+			cancelExecution(ipAddr, req.Id)
+			return nil, readinessTime, ctx.Err()
+		}
+	} else {
+		response = &executor.InvocationResult{}
+		err = json.NewDecoder(resp.Body).Decode(response)
+	}
+	if errors.Is(err, InvocationFailedErr) {
+		return nil, readinessTime, err
+	} else if err != nil {
+		return nil, readinessTime, fmt.Errorf("Parsing executor response failed: %v", err)
+	}
+
+	return response, readinessTime, nil
+}
+
+func postInvocation(ctx context.Context, url string, req *executor.InvocationRequest) (*http.Response, error) {
+	postBody, _ := json.Marshal(req)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(postBody))
 	if err != nil {
-		return nil, waitDuration, fmt.Errorf("Parsing executor response failed: %v", err)
+		return nil, err
 	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	return http.DefaultClient.Do(httpReq)
+}
 
-	return response, waitDuration, nil
+// readEventStream forwards the events streamed by the Executor, returning
+// the final result.
+func readEventStream(body io.Reader, stream func(event string, data string)) (*executor.InvocationResult, error) {
+	var response *executor.InvocationResult
+	err := sse.ReadEvents(body, func(event string, data string) error {
+		switch event {
+		case executor.EVENT_RESULT:
+			response = &executor.InvocationResult{}
+			return json.Unmarshal([]byte(data), response)
+		case executor.EVENT_ERROR:
+			return fmt.Errorf("%w: %s", InvocationFailedErr, data)
+		default:
+			if stream != nil {
+				stream(event, data)
+			}
+		}
+		return nil
+	})
+	if err == nil && response == nil {
+		// the stream has been interrupted
+		err = fmt.Errorf("no result received")
+	}
+	return response, err
+}
+
+// WaitForReadiness waits until the Executor running in the container is
+// ready to serve invocations.
+func WaitForReadiness(contID ContainerID) error {
+	ipAddr, err := cf.GetIPAddress(contID)
+	if err != nil {
+		return fmt.Errorf("Failed to retrieve IP address for container: %v", err)
+	}
+	_, err = waitForReadiness(context.Background(), ipAddr)
+	return err
+}
+
+// executorURL returns the URL of an Executor endpoint. The address returned
+// by the factory may include the port, or the default one is used.
+func executorURL(address string, path string) string {
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return fmt.Sprintf("http://%s%s", address, path)
+	}
+	return fmt.Sprintf("http://%s:%d%s", address, executor.DEFAULT_EXECUTOR_PORT, path)
+}
+
+// cancelClient is used to cancel invocations: Executors which do not answer
+// quickly are not waited for
+var cancelClient = &http.Client{Timeout: 2 * time.Second}
+
+// cancelExecution asks the Executor to abort a running invocation.
+func cancelExecution(ipAddr string, invocationId string) {
+	if invocationId == "" {
+		return
+	}
+
+	body, _ := json.Marshal(&executor.CancellationRequest{Id: invocationId})
+	resp, err := cancelClient.Post(executorURL(ipAddr, "/cancel"), "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Could not cancel invocation %s: %v\n", invocationId, err)
+		return
+	}
+	_ = resp.Body.Close()
 }
 
 func GetMemoryMB(id ContainerID) (int64, error) {
 	return cf.GetMemoryMB(id)
 }
 
+// healthClient is used to probe Executors, which must answer quickly
+var healthClient = &http.Client{Timeout: 2 * time.Second}
+
+// CheckHealth probes the Executor running in the container. Executors that
+// do not implement the health endpoint are considered healthy, as long as
+// they answer.
+func CheckHealth(contID ContainerID) error {
+	ipAddr, err := cf.GetIPAddress(contID)
+	if err != nil {
+		return fmt.Errorf("Failed to retrieve IP address for container: %v", err)
+	}
+
+	resp, err := healthClient.Get(executorURL(ipAddr, "/health"))
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("Executor health check failed: %s", resp.Status)
+	}
+	return nil
+}
+
+// ListContainers returns the existing containers having all the given labels.
+func ListContainers(labels map[string]string) ([]ContainerInfo, error) {
+	lister, ok := cf.(ContainerLister)
+	if !ok {
+		return nil, fmt.Errorf("the container factory does not support listing containers")
+	}
+	return lister.ListContainers(labels)
+}
+
+// AdoptContainer starts tracking a container that has not been created
+// through NewContainer (e.g., a container reclaimed after a restart).
+func AdoptContainer(id ContainerID, image string) {
+	acquireImage(image)
+	trackContainerImage(id, image)
+}
+
 func Destroy(id ContainerID) error {
+	untrackContainerImage(id)
 	return cf.Destroy(id)
 }
 
-func sendPostRequestWithRetries(url string, body *bytes.Buffer) (*http.Response, time.Duration, error) {
-	const TIMEOUT_MILLIS = 30000
-	const MAX_BACKOFF_MILLIS = 500
-	var backoffMillis = 25
-	var totalWaitMillis = 0
-	var attempts = 1
+// Max time to wait for a new Executor to be ready
+const READINESS_TIMEOUT = 30 * time.Second
 
-	var err error
+// waitForReadiness polls the readiness endpoint of the Executor. Executors
+// that do not implement the endpoint are considered ready as soon as they
+// answer.
+func waitForReadiness(ctx context.Context, ipAddr string) (time.Duration, error) {
+	const MAX_BACKOFF = 100 * time.Millisecond
+	backoff := 5 * time.Millisecond
+	url := executorURL(ipAddr, "/ready")
+	t0 := time.Now()
 
-	for totalWaitMillis < TIMEOUT_MILLIS {
-		resp, err := http.Post(url, "application/json", body)
+	var err error
+	for time.Since(t0) < READINESS_TIMEOUT {
+		var req *http.Request
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return 0, err
+		}
+		var resp *http.Response
+		resp, err = healthClient.Do(req)
 		if err == nil {
-			return resp, time.Duration(totalWaitMillis * int(time.Millisecond)), err
-		} else if attempts > 3 {
-			// It is common to have a failure after a cold start, so
-			// we avoid logging failures on the first attempt(s)
-			log.Printf("Warning: Retrying POST to executor (attempts: %d): %v\n", attempts, err)
+			_ = resp.Body.Close()
+			if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNotFound {
+				return time.Since(t0), nil
+			}
+			err = fmt.Errorf("Executor not ready: %s", resp.Status)
+		}
+		if ctx.Err() != nil {
+			return time.Since(t0), ctx.Err()
 		}
 
-		time.Sleep(time.Duration(backoffMillis * int(time.Millisecond)))
-		totalWaitMillis += backoffMillis
-		attempts += 1
-
-		if backoffMillis < MAX_BACKOFF_MILLIS {
-			backoffMillis = minInt(backoffMillis*2, MAX_BACKOFF_MILLIS)
+		time.Sleep(backoff)
+		if backoff < MAX_BACKOFF {
+			backoff = minDuration(backoff*2, MAX_BACKOFF)
 		}
 	}
 
-	return nil, time.Duration(totalWaitMillis * int(time.Millisecond)), err
+	return time.Since(t0), fmt.Errorf("Executor not ready after %v: %v", READINESS_TIMEOUT, err)
 }
 
-func minInt(a, b int) int {
+func minDuration(a, b time.Duration) time.Duration {
 	if a <= b {
 		return a
-	} else {
-		return b
 	}
+	return b
 }
@@ -0,0 +1,383 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"syscall"
+
+	v1stats "github.com/containerd/cgroups/stats/v1"
+	v2stats "github.com/containerd/cgroups/v2/stats"
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/oci"
+	refdocker "github.com/containerd/containerd/reference/docker"
+	gocni "github.com/containerd/go-cni"
+	"github.com/containerd/typeurl"
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/utils"
+	"github.com/lithammer/shortuuid"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// containerdIPLabel is the container label storing its IP address
+const containerdIPLabel = "serverledge.ip"
+
+// ContainerdFactory creates containers through containerd, using CNI for
+// networking.
+type ContainerdFactory struct {
+	client *containerd.Client
+	cni    gocni.CNI
+	ctx    context.Context
+
+	ipLock      sync.RWMutex
+	ipAddresses map[ContainerID]string
+}
+
+func InitContainerdFactory() *ContainerdFactory {
+	address := config.GetString(config.FACTORY_CONTAINERD_ADDRESS, "/run/containerd/containerd.sock")
+	namespace := config.GetString(config.FACTORY_CONTAINERD_NAMESPACE, "serverledge")
+
+	cli, err := containerd.New(address, containerd.WithDefaultNamespace(namespace))
+	if err != nil {
+		panic(err)
+	}
+
+	cni, err := gocni.New(
+		gocni.WithPluginConfDir(config.GetString(config.FACTORY_CNI_CONF_DIR, "/etc/cni/net.d")),
+		gocni.WithPluginDir([]string{config.GetString(config.FACTORY_CNI_BIN_DIR, "/opt/cni/bin")}))
+	if err != nil {
+		panic(err)
+	}
+	if err := cni.Load(gocni.WithLoNetwork, gocni.WithDefaultConf); err != nil {
+		panic(fmt.Errorf("could not load CNI configuration: %v", err))
+	}
+
+	ctx := namespaces.WithNamespace(context.Background(), namespace)
+	containerdFact := &ContainerdFactory{
+		client:      cli,
+		cni:         cni,
+		ctx:         ctx,
+		ipAddresses: make(map[ContainerID]string),
+	}
+	cf = containerdFact
+	return containerdFact
+}
+
+// normalizeImageRef turns a Docker-style image name (e.g.,
+// "grussorusso/serverledge-python310") into a fully qualified reference.
+func normalizeImageRef(image string) (string, error) {
+	ref, err := refdocker.ParseDockerRef(image)
+	if err != nil {
+		return "", fmt.Errorf("Invalid image name '%s': %v", image, err)
+	}
+	return ref.String(), nil
+}
+
+func (cf *ContainerdFactory) Create(image string, opts *ContainerOptions) (ContainerID, error) {
+	ref, err := normalizeImageRef(image)
+	if err != nil {
+		return "", err
+	}
+	img, err := cf.client.GetImage(cf.ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("Image '%s' not available: %v", image, err)
+	}
+
+	specOpts := []oci.SpecOpts{
+		oci.WithImageConfig(img),
+		oci.WithMemoryLimit(uint64(opts.MemoryMB * 1048576)), // convert to bytes
+	}
+	if len(opts.Cmd) > 0 {
+		specOpts = append(specOpts, oci.WithProcessArgs(opts.Cmd...))
+	}
+	if len(opts.Env) > 0 {
+		specOpts = append(specOpts, oci.WithEnv(opts.Env))
+	}
+	if opts.CPUQuota > 0.0 {
+		specOpts = append(specOpts, withCPUQuota(opts.CPUQuota))
+	}
+
+	id := "serverledge-" + shortuuid.New()
+	_, err = cf.client.NewContainer(cf.ctx, id,
+		containerd.WithContainerLabels(opts.Labels),
+		containerd.WithImage(img),
+		containerd.WithNewSnapshot(id+"-snapshot", img),
+		containerd.WithNewSpec(specOpts...))
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("Created container %s\n", id)
+	return id, nil
+}
+
+// withCPUQuota caps the CPU usage of the container (1.0 = 1 core).
+func withCPUQuota(cpuQuota float64) oci.SpecOpts {
+	return func(_ context.Context, _ oci.Client, _ *containers.Container, s *oci.Spec) error {
+		if s.Linux == nil {
+			return nil
+		}
+		if s.Linux.Resources == nil {
+			s.Linux.Resources = &specs.LinuxResources{}
+		}
+		if s.Linux.Resources.CPU == nil {
+			s.Linux.Resources.CPU = &specs.LinuxCPU{}
+		}
+		period := uint64(50000) // 50ms
+		quota := int64(50000.0 * cpuQuota)
+		s.Linux.Resources.CPU.Period = &period
+		s.Linux.Resources.CPU.Quota = &quota
+		return nil
+	}
+}
+
+// CopyToContainer extracts the content (a TAR archive) into the container
+// root filesystem. The container must not have been started yet. Symbolic
+// links in the image are resolved within the root filesystem.
+func (cf *ContainerdFactory) CopyToContainer(contID ContainerID, content io.Reader, destPath string) error {
+	cont, err := cf.client.LoadContainer(cf.ctx, contID)
+	if err != nil {
+		return err
+	}
+	info, err := cont.Info(cf.ctx)
+	if err != nil {
+		return err
+	}
+
+	mounts, err := cf.client.SnapshotService(info.Snapshotter).Mounts(cf.ctx, info.SnapshotKey)
+	if err != nil {
+		return fmt.Errorf("Could not retrieve mounts for %s: %v", contID, err)
+	}
+
+	return mount.WithTempMount(cf.ctx, mounts, func(root string) error {
+		return utils.UntarInRoot(content, root, destPath)
+	})
+}
+
+func (cf *ContainerdFactory) Start(contID ContainerID) error {
+	cont, err := cf.client.LoadContainer(cf.ctx, contID)
+	if err != nil {
+		return err
+	}
+
+	task, err := cont.NewTask(cf.ctx, cio.NullIO)
+	if err != nil {
+		return err
+	}
+	if err := task.Start(cf.ctx); err != nil {
+		_, _ = task.Delete(cf.ctx, containerd.WithProcessKill)
+		return err
+	}
+
+	result, err := cf.cni.Setup(cf.ctx, contID, netnsPath(task.Pid()))
+	if err != nil {
+		_, _ = task.Delete(cf.ctx, containerd.WithProcessKill)
+		return fmt.Errorf("CNI setup failed for %s: %v", contID, err)
+	}
+
+	for name, iface := range result.Interfaces {
+		if name == "lo" {
+			continue
+		}
+		for _, ipConf := range iface.IPConfigs {
+			if ipv4 := ipConf.IP.To4(); ipv4 != nil {
+				cf.ipLock.Lock()
+				cf.ipAddresses[contID] = ipv4.String()
+				cf.ipLock.Unlock()
+				// the address is saved as a label as well, so that it can
+				// be recovered if the node is restarted
+				_, err = cont.SetLabels(cf.ctx, map[string]string{containerdIPLabel: ipv4.String()})
+				return err
+			}
+		}
+	}
+
+	return fmt.Errorf("no IPv4 address assigned to %s", contID)
+}
+
+func (cf *ContainerdFactory) Destroy(contID ContainerID) error {
+	cont, err := cf.client.LoadContainer(cf.ctx, contID)
+	if err != nil {
+		return err
+	}
+
+	if task, err := cont.Task(cf.ctx, nil); err == nil {
+		if err := cf.cni.Remove(cf.ctx, contID, netnsPath(task.Pid())); err != nil {
+			log.Printf("CNI teardown failed for %s: %v\n", contID, err)
+		}
+
+		exitCh, err := task.Wait(cf.ctx)
+		if err == nil && task.Kill(cf.ctx, syscall.SIGKILL) == nil {
+			<-exitCh
+		}
+		if _, err := task.Delete(cf.ctx, containerd.WithProcessKill); err != nil {
+			log.Printf("Could not delete task for %s: %v\n", contID, err)
+		}
+	}
+
+	cf.ipLock.Lock()
+	delete(cf.ipAddresses, contID)
+	cf.ipLock.Unlock()
+
+	return cont.Delete(cf.ctx, containerd.WithSnapshotCleanup)
+}
+
+// ListContainers returns the containers having all the given labels. The IP
+// addresses of running containers are recovered as well.
+func (cf *ContainerdFactory) ListContainers(labels map[string]string) ([]ContainerInfo, error) {
+	filters := make([]string, 0, len(labels))
+	for k, v := range labels {
+		filters = append(filters, fmt.Sprintf("labels.%q==%s", k, v))
+	}
+	list, err := cf.client.Containers(cf.ctx, strings.Join(filters, ","))
+	if err != nil {
+		return nil, err
+	}
+
+	containers := make([]ContainerInfo, 0, len(list))
+	for _, cont := range list {
+		contLabels, err := cont.Labels(cf.ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		running := false
+		if task, err := cont.Task(cf.ctx, nil); err == nil {
+			if status, err := task.Status(cf.ctx); err == nil && status.Status == containerd.Running {
+				running = true
+			}
+		}
+		if ip, ok := contLabels[containerdIPLabel]; ok && running {
+			cf.ipLock.Lock()
+			cf.ipAddresses[cont.ID()] = ip
+			cf.ipLock.Unlock()
+		} else {
+			running = false // not reachable
+		}
+
+		containers = append(containers, ContainerInfo{ID: cont.ID(), Labels: contLabels, Running: running})
+	}
+	return containers, nil
+}
+
+func (cf *ContainerdFactory) HasImage(image string) bool {
+	ref, err := normalizeImageRef(image)
+	if err != nil {
+		return false
+	}
+	_, err = cf.client.GetImage(cf.ctx, ref)
+	return err == nil
+}
+
+func (cf *ContainerdFactory) PullImage(image string) error {
+	ref, err := normalizeImageRef(image)
+	if err != nil {
+		return err
+	}
+
+	if _, err := cf.client.Pull(cf.ctx, ref, containerd.WithPullUnpack); err != nil {
+		return fmt.Errorf("Could not pull image '%s': %v", image, err)
+	}
+
+	log.Printf("Pulled image: %s\n", image)
+	return nil
+}
+
+func (cf *ContainerdFactory) ImageSize(image string) (int64, error) {
+	ref, err := normalizeImageRef(image)
+	if err != nil {
+		return 0, err
+	}
+	img, err := cf.client.GetImage(cf.ctx, ref)
+	if err != nil {
+		return 0, err
+	}
+	return img.Size(cf.ctx)
+}
+
+func (cf *ContainerdFactory) RemoveImage(image string) error {
+	ref, err := normalizeImageRef(image)
+	if err != nil {
+		return err
+	}
+	return cf.client.ImageService().Delete(cf.ctx, ref, images.SynchronousDelete())
+}
+
+func (cf *ContainerdFactory) GetIPAddress(contID ContainerID) (string, error) {
+	cf.ipLock.RLock()
+	defer cf.ipLock.RUnlock()
+
+	ip, ok := cf.ipAddresses[contID]
+	if !ok {
+		return "", fmt.Errorf("no IP address known for %s", contID)
+	}
+	return ip, nil
+}
+
+func (cf *ContainerdFactory) GetStats(contID ContainerID) (*ContainerStats, error) {
+	cont, err := cf.client.LoadContainer(cf.ctx, contID)
+	if err != nil {
+		return nil, err
+	}
+	task, err := cont.Task(cf.ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	metric, err := task.Metrics(cf.ctx)
+	if err != nil {
+		return nil, err
+	}
+	data, err := typeurl.UnmarshalAny(metric.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &ContainerStats{}
+	switch m := data.(type) {
+	case *v1stats.Metrics: // cgroup v1
+		if m.CPU != nil && m.CPU.Usage != nil {
+			stats.CPUSeconds = float64(m.CPU.Usage.Total) / 1e9
+		}
+		if m.Memory != nil && m.Memory.Usage != nil {
+			stats.MemoryBytes = int64(m.Memory.Usage.Usage)
+		}
+	case *v2stats.Metrics: // cgroup v2
+		if m.CPU != nil {
+			stats.CPUSeconds = float64(m.CPU.UsageUsec) / 1e6
+		}
+		if m.Memory != nil {
+			stats.MemoryBytes = int64(m.Memory.Usage)
+		}
+	default:
+		return nil, fmt.Errorf("unexpected metrics type: %s", metric.Data.GetTypeUrl())
+	}
+	return stats, nil
+}
+
+func (cf *ContainerdFactory) GetMemoryMB(contID ContainerID) (int64, error) {
+	cont, err := cf.client.LoadContainer(cf.ctx, contID)
+	if err != nil {
+		return -1, err
+	}
+	spec, err := cont.Spec(cf.ctx)
+	if err != nil {
+		return -1, err
+	}
+	if spec.Linux == nil || spec.Linux.Resources == nil || spec.Linux.Resources.Memory == nil ||
+		spec.Linux.Resources.Memory.Limit == nil {
+		return -1, fmt.Errorf("no memory limit set for %s", contID)
+	}
+	return *spec.Linux.Resources.Memory.Limit / 1048576, nil
+}
+
+func netnsPath(pid uint32) string {
+	return fmt.Sprintf("/proc/%d/ns/net", pid)
+}
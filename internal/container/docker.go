@@ -2,15 +2,16 @@ package container
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
-	"os/exec"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
-	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/docker/docker/pkg/jsonmessage"
 	//	"github.com/docker/docker/pkg/stdcopy"
 )
 
@@ -32,11 +33,6 @@ func InitDockerContainerFactory() *DockerFactory {
 }
 
 func (cf *DockerFactory) Create(image string, opts *ContainerOptions) (ContainerID, error) {
-	if !cf.HasImage(image) {
-		_ = cf.PullImage(image)
-		// error ignored, as we might still have a stale copy of the image
-	}
-
 	contResources := container.Resources{Memory: opts.MemoryMB * 1048576} // convert to bytes
 	if opts.CPUQuota > 0.0 {
 		contResources.CPUPeriod = 50000 // 50ms
@@ -44,10 +40,11 @@ func (cf *DockerFactory) Create(image string, opts *ContainerOptions) (Container
 	}
 
 	resp, err := cf.cli.ContainerCreate(cf.ctx, &container.Config{
-		Image: image,
-		Cmd:   opts.Cmd,
-		Env:   opts.Env,
-		Tty:   false,
+		Image:  image,
+		Cmd:    opts.Cmd,
+		Env:    opts.Env,
+		Tty:    false,
+		Labels: opts.Labels,
 	}, &container.HostConfig{Resources: contResources}, nil, nil, "")
 
 	id := resp.ID
@@ -77,23 +74,19 @@ func (cf *DockerFactory) Destroy(contID ContainerID) error {
 }
 
 func (cf *DockerFactory) HasImage(image string) bool {
-	// TODO: we should try using cf.cli.ImageList(...)
-	cmd := fmt.Sprintf("docker images %s | grep -vF REPOSITORY", image)
-	_, err := exec.Command("bash", "-c", cmd).Output()
-	if err != nil {
-		return false
-	}
-
-	// We have the image, but we may need to refresh it
-	if config.GetBool(config.FACTORY_REFRESH_IMAGES, false) {
-		if refreshed, ok := refreshedImages[image]; !ok || !refreshed {
-			return false
-		}
-	}
-	return true
+	list, err := cf.cli.ImageList(cf.ctx, types.ImageListOptions{
+		Filters: filters.NewArgs(filters.Arg("reference", image)),
+	})
+	return err == nil && len(list) > 0
 }
 
 func (cf *DockerFactory) PullImage(image string) error {
+	return cf.PullImageWithProgress(image, nil)
+}
+
+// PullImageWithProgress pulls an image, periodically reporting the number of
+// downloaded bytes over the total size of the layers.
+func (cf *DockerFactory) PullImageWithProgress(image string, progress func(current, total int64)) error {
 	pullResp, err := cf.cli.ImagePull(cf.ctx, image, types.ImagePullOptions{})
 	if err != nil {
 		return fmt.Errorf("Could not pull image '%s': %v", image, err)
@@ -105,13 +98,69 @@ func (cf *DockerFactory) PullImage(image string) error {
 			log.Printf("Could not close the docker image pull response\n")
 		}
 	}(pullResp)
-	// This seems to be necessary to wait for the image to be pulled:
-	_, _ = io.Copy(io.Discard, pullResp)
+
+	// The pull is completed when the response has been entirely read
+	layersCurrent := make(map[string]int64)
+	layersTotal := make(map[string]int64)
+	decoder := json.NewDecoder(pullResp)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := decoder.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("Could not pull image '%s': %v", image, err)
+		}
+		if msg.Error != nil {
+			return fmt.Errorf("Could not pull image '%s': %v", image, msg.Error)
+		}
+		if progress == nil || msg.Progress == nil || msg.Status != "Downloading" {
+			continue
+		}
+
+		layersCurrent[msg.ID] = msg.Progress.Current
+		layersTotal[msg.ID] = msg.Progress.Total
+		var current, total int64
+		for id := range layersTotal {
+			current += layersCurrent[id]
+			total += layersTotal[id]
+		}
+		progress(current, total)
+	}
+
 	log.Printf("Pulled image: %s\n", image)
-	refreshedImages[image] = true
 	return nil
 }
 
+func (cf *DockerFactory) ImageSize(image string) (int64, error) {
+	inspect, _, err := cf.cli.ImageInspectWithRaw(cf.ctx, image)
+	if err != nil {
+		return 0, err
+	}
+	return inspect.Size, nil
+}
+
+func (cf *DockerFactory) RemoveImage(image string) error {
+	_, err := cf.cli.ImageRemove(cf.ctx, image, types.ImageRemoveOptions{PruneChildren: true})
+	return err
+}
+
+func (cf *DockerFactory) ListContainers(labels map[string]string) ([]ContainerInfo, error) {
+	args := filters.NewArgs()
+	for k, v := range labels {
+		args.Add("label", k+"="+v)
+	}
+	list, err := cf.cli.ContainerList(cf.ctx, types.ContainerListOptions{All: true, Filters: args})
+	if err != nil {
+		return nil, err
+	}
+
+	containers := make([]ContainerInfo, 0, len(list))
+	for _, c := range list {
+		containers = append(containers, ContainerInfo{ID: c.ID, Labels: c.Labels, Running: c.State == "running"})
+	}
+	return containers, nil
+}
+
 func (cf *DockerFactory) GetIPAddress(contID ContainerID) (string, error) {
 	contJson, err := cf.cli.ContainerInspect(cf.ctx, contID)
 	if err != nil {
@@ -120,6 +169,23 @@ func (cf *DockerFactory) GetIPAddress(contID ContainerID) (string, error) {
 	return contJson.NetworkSettings.IPAddress, nil
 }
 
+func (cf *DockerFactory) GetStats(contID ContainerID) (*ContainerStats, error) {
+	resp, err := cf.cli.ContainerStatsOneShot(cf.ctx, contID)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var stats types.StatsJSON
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, err
+	}
+	return &ContainerStats{
+		CPUSeconds:  float64(stats.CPUStats.CPUUsage.TotalUsage) / 1e9,
+		MemoryBytes: int64(stats.MemoryStats.Usage),
+	}, nil
+}
+
 func (cf *DockerFactory) GetMemoryMB(contID ContainerID) (int64, error) {
 	contJson, err := cf.cli.ContainerInspect(cf.ctx, contID)
 	if err != nil {
@@ -2,6 +2,9 @@ package container
 
 import (
 	"io"
+	"log"
+
+	"github.com/grussorusso/serverledge/internal/config"
 )
 
 // A Factory to create and manage container.
@@ -22,6 +25,22 @@ type ContainerOptions struct {
 	Env      []string
 	MemoryMB int64
 	CPUQuota float64
+	Labels   map[string]string
+}
+
+// ContainerInfo describes an existing container.
+type ContainerInfo struct {
+	ID      ContainerID
+	Labels  map[string]string
+	Running bool
+}
+
+// ContainerLister is implemented by factories that can list the existing
+// containers, e.g., to reclaim containers left behind by a previous run of
+// the node.
+type ContainerLister interface {
+	// ListContainers returns the containers having all the given labels.
+	ListContainers(labels map[string]string) ([]ContainerInfo, error)
 }
 
 type ContainerID = string
@@ -29,9 +48,20 @@ type ContainerID = string
 // cf is the container factory for the node
 var cf Factory
 
-func DownloadImage(image string, forceRefresh bool) error {
-	if forceRefresh || !cf.HasImage(image) {
-		return cf.PullImage(image)
+// InitContainerFactory initializes the container factory selected in the
+// configuration.
+func InitContainerFactory() Factory {
+	factoryType := config.GetString(config.FACTORY_TYPE, "docker")
+	log.Printf("Configured container factory: %s\n", factoryType)
+	var factory Factory
+	if factoryType == "containerd" {
+		factory = InitContainerdFactory()
+	} else if factoryType == "process" {
+		factory = InitProcessFactory()
+	} else {
+		factory = InitDockerContainerFactory()
 	}
-	return nil
+
+	go runImageCollector()
+	return factory
 }
@@ -0,0 +1,344 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/executor"
+	"github.com/grussorusso/serverledge/internal/sse"
+	"github.com/lithammer/shortuuid"
+)
+
+// FakeFactory is an in-memory Factory for testing purposes. Each fake
+// container is backed by an HTTP server on the loopback interface that
+// emulates the Executor. Latencies and failures can be scripted.
+type FakeFactory struct {
+	CreateLatency time.Duration
+	StartLatency  time.Duration
+	ExecLatency   time.Duration
+	PullLatency   time.Duration
+	RemoveLatency time.Duration
+
+	// ImageSizeBytes is the size reported for every image
+	ImageSizeBytes int64
+
+	// ExecCPUSeconds is the CPU time consumed by each invocation, and
+	// MemoryBytes the memory usage reported for every container
+	ExecCPUSeconds float64
+	MemoryBytes    int64
+
+	// CreateErr and StartErr (if not nil) are returned by the corresponding
+	// operations
+	CreateErr error
+	StartErr  error
+
+	// ExecErr (if not nil) is reported by the emulated Executor as the
+	// reason why invocations cannot be served
+	ExecErr error
+
+	// StreamOutput is the output emitted by streaming invocations
+	StreamOutput []string
+
+	// Handler (if not nil) computes the result of each invocation; by
+	// default, invocation parameters are returned as result
+	Handler func(*executor.InvocationRequest) *executor.InvocationResult
+
+	lock       sync.Mutex
+	containers map[ContainerID]*fakeContainer
+	images     map[string]bool
+	pulls      int
+}
+
+type fakeContainer struct {
+	opts     ContainerOptions
+	code     []byte
+	listener net.Listener
+	server   *http.Server
+	cpu      float64 // consumed CPU time
+}
+
+func InitFakeFactory() *FakeFactory {
+	fakeFact := &FakeFactory{
+		containers: make(map[ContainerID]*fakeContainer),
+		images:     make(map[string]bool),
+	}
+	cf = fakeFact
+	return fakeFact
+}
+
+// Count returns the number of existing fake containers.
+func (ff *FakeFactory) Count() int {
+	ff.lock.Lock()
+	defer ff.lock.Unlock()
+	return len(ff.containers)
+}
+
+func (ff *FakeFactory) getContainer(contID ContainerID) (*fakeContainer, error) {
+	ff.lock.Lock()
+	defer ff.lock.Unlock()
+
+	c, ok := ff.containers[contID]
+	if !ok {
+		return nil, fmt.Errorf("unknown container: %s", contID)
+	}
+	return c, nil
+}
+
+func (ff *FakeFactory) Create(image string, opts *ContainerOptions) (ContainerID, error) {
+	time.Sleep(ff.CreateLatency)
+	if ff.CreateErr != nil {
+		return "", ff.CreateErr
+	}
+
+	id := "fake-" + shortuuid.New()
+	ff.lock.Lock()
+	ff.containers[id] = &fakeContainer{opts: *opts}
+	ff.lock.Unlock()
+	return id, nil
+}
+
+func (ff *FakeFactory) CopyToContainer(contID ContainerID, content io.Reader, _ string) error {
+	c, err := ff.getContainer(contID)
+	if err != nil {
+		return err
+	}
+	c.code, err = io.ReadAll(content)
+	return err
+}
+
+func (ff *FakeFactory) Start(contID ContainerID) error {
+	time.Sleep(ff.StartLatency)
+	if ff.StartErr != nil {
+		return ff.StartErr
+	}
+
+	c, err := ff.getContainer(contID)
+	if err != nil {
+		return err
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/invoke", func(w http.ResponseWriter, r *http.Request) {
+		ff.invokeHandler(c, w, r)
+	})
+	mux.HandleFunc("/invoke/stream", func(w http.ResponseWriter, r *http.Request) {
+		ff.streamHandler(c, w, r)
+	})
+	mux.HandleFunc("/cancel", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	c.listener = l
+	c.server = &http.Server{Handler: mux}
+	go func() {
+		_ = c.server.Serve(l)
+	}()
+
+	return nil
+}
+
+func (ff *FakeFactory) invokeHandler(c *fakeContainer, w http.ResponseWriter, r *http.Request) {
+	req := &executor.InvocationRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if ff.ExecErr != nil {
+		http.Error(w, ff.ExecErr.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result := ff.execute(c, req, r)
+	if result == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+func (ff *FakeFactory) streamHandler(c *fakeContainer, w http.ResponseWriter, r *http.Request) {
+	req := &executor.InvocationRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", sse.ContentType)
+	for _, out := range ff.StreamOutput {
+		_ = sse.WriteEvent(w, executor.EVENT_OUTPUT, out)
+	}
+	if ff.ExecErr != nil {
+		_ = sse.WriteEvent(w, executor.EVENT_ERROR, ff.ExecErr.Error())
+		return
+	}
+	result := ff.execute(c, req, r)
+	if result == nil {
+		return
+	}
+	body, _ := json.Marshal(result)
+	_ = sse.WriteEvent(w, executor.EVENT_RESULT, string(body))
+}
+
+// execute emulates an invocation, returning nil if it is cancelled.
+func (ff *FakeFactory) execute(c *fakeContainer, req *executor.InvocationRequest, r *http.Request) *executor.InvocationResult {
+
+	select {
+	case <-time.After(ff.ExecLatency):
+	case <-r.Context().Done():
+		return nil
+	}
+
+	ff.lock.Lock()
+	c.cpu += ff.ExecCPUSeconds
+	ff.lock.Unlock()
+
+	var result *executor.InvocationResult
+	if ff.Handler != nil {
+		result = ff.Handler(req)
+	} else {
+		params, _ := json.Marshal(req.Params)
+		result = &executor.InvocationResult{Success: true, Result: string(params)}
+	}
+	return result
+}
+
+// Crash stops the emulated Executor of a container, which is not destroyed.
+func (ff *FakeFactory) Crash(contID ContainerID) error {
+	c, err := ff.getContainer(contID)
+	if err != nil {
+		return err
+	}
+	if c.server != nil {
+		return c.server.Close()
+	}
+	return nil
+}
+
+// HasContainer returns true if the container exists.
+func (ff *FakeFactory) HasContainer(contID ContainerID) bool {
+	_, err := ff.getContainer(contID)
+	return err == nil
+}
+
+// IDs returns the IDs of the existing fake containers.
+func (ff *FakeFactory) IDs() []ContainerID {
+	ff.lock.Lock()
+	defer ff.lock.Unlock()
+	ids := make([]ContainerID, 0, len(ff.containers))
+	for id := range ff.containers {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (ff *FakeFactory) Destroy(contID ContainerID) error {
+	c, err := ff.getContainer(contID)
+	if err != nil {
+		return err
+	}
+	if c.server != nil {
+		_ = c.server.Close()
+	}
+
+	ff.lock.Lock()
+	delete(ff.containers, contID)
+	ff.lock.Unlock()
+	return nil
+}
+
+func (ff *FakeFactory) HasImage(image string) bool {
+	ff.lock.Lock()
+	defer ff.lock.Unlock()
+	return ff.images[image]
+}
+
+func (ff *FakeFactory) PullImage(image string) error {
+	time.Sleep(ff.PullLatency)
+	ff.lock.Lock()
+	defer ff.lock.Unlock()
+	ff.images[image] = true
+	ff.pulls++
+	return nil
+}
+
+// Pulls returns the number of pulled images.
+func (ff *FakeFactory) Pulls() int {
+	ff.lock.Lock()
+	defer ff.lock.Unlock()
+	return ff.pulls
+}
+
+func (ff *FakeFactory) ImageSize(image string) (int64, error) {
+	if !ff.HasImage(image) {
+		return 0, fmt.Errorf("no such image: %s", image)
+	}
+	return ff.ImageSizeBytes, nil
+}
+
+func (ff *FakeFactory) RemoveImage(image string) error {
+	time.Sleep(ff.RemoveLatency)
+	ff.lock.Lock()
+	defer ff.lock.Unlock()
+	delete(ff.images, image)
+	return nil
+}
+
+func (ff *FakeFactory) ListContainers(labels map[string]string) ([]ContainerInfo, error) {
+	ff.lock.Lock()
+	defer ff.lock.Unlock()
+
+	containers := make([]ContainerInfo, 0)
+	for id, c := range ff.containers {
+		matches := true
+		for k, v := range labels {
+			matches = matches && c.opts.Labels[k] == v
+		}
+		if matches {
+			containers = append(containers, ContainerInfo{ID: id, Labels: c.opts.Labels, Running: c.listener != nil})
+		}
+	}
+	return containers, nil
+}
+
+func (ff *FakeFactory) GetIPAddress(contID ContainerID) (string, error) {
+	c, err := ff.getContainer(contID)
+	if err != nil {
+		return "", err
+	}
+	if c.listener == nil {
+		return "", fmt.Errorf("container %s not started", contID)
+	}
+	return c.listener.Addr().String(), nil
+}
+
+func (ff *FakeFactory) GetStats(contID ContainerID) (*ContainerStats, error) {
+	c, err := ff.getContainer(contID)
+	if err != nil {
+		return nil, err
+	}
+	ff.lock.Lock()
+	defer ff.lock.Unlock()
+	return &ContainerStats{CPUSeconds: c.cpu, MemoryBytes: ff.MemoryBytes}, nil
+}
+
+func (ff *FakeFactory) GetMemoryMB(contID ContainerID) (int64, error) {
+	c, err := ff.getContainer(contID)
+	if err != nil {
+		return -1, err
+	}
+	return c.opts.MemoryMB, nil
+}
@@ -0,0 +1,344 @@
+package container
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/config"
+)
+
+// ImageStore is implemented by factories that can inspect and remove the
+// images stored on the host. Unused images are garbage collected only if the
+// factory is an ImageStore.
+type ImageStore interface {
+	ImageSize(image string) (int64, error)
+	RemoveImage(image string) error
+}
+
+// ProgressPuller is implemented by factories that can report the progress
+// of image pulls.
+type ProgressPuller interface {
+	PullImageWithProgress(image string, progress func(current, total int64)) error
+}
+
+const (
+	IMAGE_PULLING   = "pulling"
+	IMAGE_AVAILABLE = "available"
+	IMAGE_FAILED    = "failed"
+	IMAGE_REMOVING  = "removing"
+)
+
+// ImageStatus describes an image used by the node.
+type ImageStatus struct {
+	Image     string
+	State     string
+	Progress  float64 `json:",omitempty"` // fraction of downloaded bytes (while pulling)
+	LastError string  `json:",omitempty"`
+	LastUsed  time.Time
+	InUse     int // containers using the image
+}
+
+// ImageStats contains counters about image management.
+type ImageStats struct {
+	Pulls         int64
+	FailedPulls   int64
+	PullsInFlight int64
+	Removed       int64
+}
+
+type pullOperation struct {
+	done chan struct{}
+	err  error
+}
+
+// imageManager keeps track of the images used by the node, deduplicating
+// concurrent pulls of the same image.
+type imageManager struct {
+	sync.Mutex
+	images     map[string]*ImageStatus
+	pulls      map[string]*pullOperation // in-flight pulls
+	removals   map[string]chan struct{}  // in-flight removals, closed upon completion
+	refreshed  map[string]bool           // images pulled at least once
+	containers map[ContainerID]string    // image of each container
+	stats      ImageStats
+}
+
+var imgManager = &imageManager{
+	images:     make(map[string]*ImageStatus),
+	pulls:      make(map[string]*pullOperation),
+	removals:   make(map[string]chan struct{}),
+	refreshed:  make(map[string]bool),
+	containers: make(map[ContainerID]string),
+}
+
+// getStatus returns the status of an image, creating it if needed.
+// The function is NOT thread-safe.
+func (m *imageManager) getStatus(image string) *ImageStatus {
+	status, ok := m.images[image]
+	if !ok {
+		status = &ImageStatus{Image: image, State: IMAGE_AVAILABLE}
+		m.images[image] = status
+	}
+	return status
+}
+
+// waitForRemoval waits until the image is not being removed anymore. The
+// lock must be held by the caller, and it is held again upon return.
+func (m *imageManager) waitForRemoval(image string) {
+	for {
+		done, ok := m.removals[image]
+		if !ok {
+			return
+		}
+		m.Unlock()
+		<-done
+		m.Lock()
+	}
+}
+
+// DownloadImage makes sure that the image is available on the host, pulling
+// it if needed. Concurrent requests for the same image share a single pull.
+func DownloadImage(image string, forceRefresh bool) error {
+	imgManager.Lock()
+	imgManager.waitForRemoval(image)
+	imgManager.getStatus(image).LastUsed = time.Now()
+	if op, ok := imgManager.pulls[image]; ok {
+		imgManager.Unlock()
+		<-op.done
+		return op.err
+	}
+	mustRefresh := config.GetBool(config.FACTORY_REFRESH_IMAGES, false) && !imgManager.refreshed[image]
+	imgManager.Unlock()
+
+	if !forceRefresh && !mustRefresh && cf.HasImage(image) {
+		return nil
+	}
+
+	imgManager.Lock()
+	imgManager.waitForRemoval(image)
+	if op, ok := imgManager.pulls[image]; ok {
+		// someone else started pulling meanwhile
+		imgManager.Unlock()
+		<-op.done
+		return op.err
+	}
+	op := &pullOperation{done: make(chan struct{})}
+	imgManager.pulls[image] = op
+	status := imgManager.getStatus(image)
+	status.State = IMAGE_PULLING
+	status.Progress = 0.0
+	imgManager.stats.Pulls++
+	imgManager.stats.PullsInFlight++
+	imgManager.Unlock()
+
+	op.err = pullImage(image)
+
+	imgManager.Lock()
+	delete(imgManager.pulls, image)
+	imgManager.stats.PullsInFlight--
+	if op.err != nil {
+		imgManager.stats.FailedPulls++
+		status.LastError = op.err.Error()
+		if cf.HasImage(image) {
+			// we still have a (possibly stale) copy of the image
+			status.State = IMAGE_AVAILABLE
+		} else {
+			status.State = IMAGE_FAILED
+		}
+	} else {
+		imgManager.refreshed[image] = true
+		status.State = IMAGE_AVAILABLE
+		status.LastError = ""
+	}
+	status.Progress = 0.0
+	imgManager.Unlock()
+	close(op.done)
+
+	return op.err
+}
+
+func pullImage(image string) error {
+	log.Printf("Pulling image: %s\n", image)
+	puller, ok := cf.(ProgressPuller)
+	if !ok {
+		return cf.PullImage(image)
+	}
+
+	return puller.PullImageWithProgress(image, func(current, total int64) {
+		if total <= 0 {
+			return
+		}
+		imgManager.Lock()
+		imgManager.getStatus(image).Progress = float64(current) / float64(total)
+		imgManager.Unlock()
+	})
+}
+
+// GetImagesStatus returns the status of the images used by the node.
+func GetImagesStatus() []ImageStatus {
+	imgManager.Lock()
+	defer imgManager.Unlock()
+
+	list := make([]ImageStatus, 0, len(imgManager.images))
+	for _, status := range imgManager.images {
+		list = append(list, *status)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Image < list[j].Image })
+	return list
+}
+
+// GetImageStats returns counters about image management.
+func GetImageStats() ImageStats {
+	imgManager.Lock()
+	defer imgManager.Unlock()
+	return imgManager.stats
+}
+
+// acquireImage marks the image as in use, so that it is not garbage
+// collected. If the image is being removed, it waits for the removal to
+// complete (the image is then pulled again by DownloadImage).
+func acquireImage(image string) {
+	imgManager.Lock()
+	defer imgManager.Unlock()
+	imgManager.waitForRemoval(image)
+	status := imgManager.getStatus(image)
+	status.InUse++
+	status.LastUsed = time.Now()
+}
+
+func releaseImage(image string) {
+	imgManager.Lock()
+	defer imgManager.Unlock()
+	imgManager.getStatus(image).InUse--
+}
+
+// trackContainerImage records the image of a new container, which must have
+// been acquired through acquireImage.
+func trackContainerImage(contID ContainerID, image string) {
+	imgManager.Lock()
+	defer imgManager.Unlock()
+	imgManager.containers[contID] = image
+}
+
+// untrackContainerImage releases the image of a destroyed container.
+func untrackContainerImage(contID ContainerID) {
+	imgManager.Lock()
+	defer imgManager.Unlock()
+	image, ok := imgManager.containers[contID]
+	if !ok {
+		return
+	}
+	delete(imgManager.containers, contID)
+	imgManager.getStatus(image).InUse--
+}
+
+// CollectUnusedImages removes the least recently used images that are not
+// used by any container, until the disk space used by the images is within
+// budgetMB. Only images used by the node are considered. It returns the
+// removed images.
+func CollectUnusedImages(budgetMB int64) ([]string, error) {
+	store, ok := cf.(ImageStore)
+	if !ok {
+		return nil, fmt.Errorf("the container factory does not support image removal")
+	}
+
+	imgManager.Lock()
+	candidates := make([]ImageStatus, 0, len(imgManager.images))
+	for _, status := range imgManager.images {
+		if status.State != IMAGE_PULLING && status.State != IMAGE_REMOVING {
+			candidates = append(candidates, *status)
+		}
+	}
+	imgManager.Unlock()
+
+	var usedBytes int64 = 0
+	sizes := make(map[string]int64)
+	for _, status := range candidates {
+		size, err := store.ImageSize(status.Image)
+		if err != nil {
+			continue // not on the host
+		}
+		sizes[status.Image] = size
+		usedBytes += size
+	}
+
+	budgetBytes := budgetMB * 1048576
+	if usedBytes <= budgetBytes {
+		return nil, nil
+	}
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].LastUsed.Before(candidates[j].LastUsed) })
+
+	removed := make([]string, 0)
+	for _, status := range candidates {
+		if usedBytes <= budgetBytes {
+			break
+		}
+		size, onHost := sizes[status.Image]
+		if !onHost {
+			continue
+		}
+
+		imgManager.Lock()
+		current := imgManager.getStatus(status.Image)
+		if current.InUse > 0 || current.State == IMAGE_PULLING || current.State == IMAGE_REMOVING {
+			imgManager.Unlock()
+			continue
+		}
+		// containers cannot be created from the image while it is being
+		// removed (see acquireImage)
+		previousState := current.State
+		current.State = IMAGE_REMOVING
+		done := make(chan struct{})
+		imgManager.removals[status.Image] = done
+		imgManager.Unlock()
+
+		err := store.RemoveImage(status.Image)
+
+		imgManager.Lock()
+		delete(imgManager.removals, status.Image)
+		if err == nil {
+			delete(imgManager.images, status.Image)
+			delete(imgManager.refreshed, status.Image)
+			imgManager.stats.Removed++
+		} else {
+			current.State = previousState
+		}
+		imgManager.Unlock()
+		close(done)
+
+		if err != nil {
+			log.Printf("Could not remove image %s: %v\n", status.Image, err)
+			continue
+		}
+		log.Printf("Removed unused image: %s\n", status.Image)
+		usedBytes -= size
+		removed = append(removed, status.Image)
+	}
+
+	return removed, nil
+}
+
+// runImageCollector periodically garbage collects unused images, if a disk
+// budget has been configured.
+func runImageCollector() {
+	budgetMB := int64(config.GetInt(config.FACTORY_IMAGES_BUDGET, 0))
+	if budgetMB <= 0 {
+		return
+	}
+	if _, ok := cf.(ImageStore); !ok {
+		log.Printf("Image garbage collection not supported by the container factory\n")
+		return
+	}
+
+	interval := time.Duration(config.GetInt(config.FACTORY_IMAGES_GC_INTERVAL, 300)) * time.Second
+	for {
+		time.Sleep(interval)
+		if _, err := CollectUnusedImages(budgetMB); err != nil {
+			log.Printf("Image garbage collection failed: %v\n", err)
+		}
+	}
+}
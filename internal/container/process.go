@@ -0,0 +1,292 @@
+package container
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/utils"
+	"github.com/lithammer/shortuuid"
+)
+
+// ProcessFactory runs function instances as child processes of the node,
+// without any container engine. Each instance runs the Serverledge executor
+// in a dedicated sandbox directory and listens on a dedicated loopback port.
+// If cgroup v2 is available, CPU and memory limits are enforced.
+//
+// As no container image is involved, the invocation commands of the
+// function runtime must be available on the host. For custom runtimes, the
+// image name is interpreted as the command to execute upon invocation.
+type ProcessFactory struct {
+	executorPath string
+	baseDir      string
+	cgroupRoot   string // empty if cgroups are not used
+
+	lock      sync.Mutex
+	sandboxes map[ContainerID]*processSandbox
+}
+
+type processSandbox struct {
+	dir       string
+	port      int
+	image     string
+	opts      ContainerOptions
+	cmd       *exec.Cmd
+	exited    chan struct{}
+	cgroupDir string
+}
+
+const cgroupV2Root = "/sys/fs/cgroup"
+
+func InitProcessFactory() *ProcessFactory {
+	executorPath := config.GetString(config.FACTORY_PROCESS_EXECUTOR, "")
+	if executorPath == "" {
+		// look for the executor next to the node executable
+		self, err := os.Executable()
+		if err != nil {
+			panic(err)
+		}
+		executorPath = filepath.Join(filepath.Dir(self), "executor")
+	}
+	if _, err := os.Stat(executorPath); err != nil {
+		panic(fmt.Errorf("executor not found: %v", err))
+	}
+
+	baseDir := config.GetString(config.FACTORY_PROCESS_DIR, filepath.Join(os.TempDir(), "serverledge-sandboxes"))
+	if err := os.MkdirAll(baseDir, 0755); err != nil {
+		panic(err)
+	}
+
+	processFact := &ProcessFactory{
+		executorPath: executorPath,
+		baseDir:      baseDir,
+		sandboxes:    make(map[ContainerID]*processSandbox),
+	}
+	if config.GetBool(config.FACTORY_PROCESS_CGROUPS, true) {
+		processFact.cgroupRoot = initCgroupRoot()
+	}
+
+	cf = processFact
+	return processFact
+}
+
+// initCgroupRoot creates the cgroup (v2) under which sandboxes are placed,
+// enabling the cpu and memory controllers. It returns an empty string if
+// cgroups cannot be used.
+func initCgroupRoot() string {
+	if _, err := os.Stat(filepath.Join(cgroupV2Root, "cgroup.controllers")); err != nil {
+		log.Println("cgroup v2 not available: resource limits will not be enforced")
+		return ""
+	}
+
+	root := filepath.Join(cgroupV2Root, "serverledge")
+	if err := os.MkdirAll(root, 0755); err != nil {
+		log.Printf("Could not create cgroup: %v\n", err)
+		return ""
+	}
+	// controllers must be enabled for the children of both the parent and
+	// our cgroup
+	_ = os.WriteFile(filepath.Join(cgroupV2Root, "cgroup.subtree_control"), []byte("+cpu +memory"), 0644)
+	err := os.WriteFile(filepath.Join(root, "cgroup.subtree_control"), []byte("+cpu +memory"), 0644)
+	if err != nil {
+		log.Printf("Could not enable cgroup controllers: %v\n", err)
+		return ""
+	}
+
+	return root
+}
+
+func (pf *ProcessFactory) getSandbox(contID ContainerID) (*processSandbox, error) {
+	pf.lock.Lock()
+	defer pf.lock.Unlock()
+
+	sb, ok := pf.sandboxes[contID]
+	if !ok {
+		return nil, fmt.Errorf("unknown sandbox: %s", contID)
+	}
+	return sb, nil
+}
+
+func (pf *ProcessFactory) Create(image string, opts *ContainerOptions) (ContainerID, error) {
+	id := "sandbox-" + shortuuid.New()
+	dir := filepath.Join(pf.baseDir, id)
+	if err := os.MkdirAll(filepath.Join(dir, "app"), 0755); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "tmp"), 0755); err != nil {
+		return "", err
+	}
+
+	port, err := allocatePort()
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return "", err
+	}
+
+	pf.lock.Lock()
+	pf.sandboxes[id] = &processSandbox{dir: dir, port: port, image: image, opts: *opts}
+	pf.lock.Unlock()
+
+	return id, nil
+}
+
+// allocatePort finds a free TCP port on the loopback interface.
+func allocatePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, fmt.Errorf("Could not allocate port: %v", err)
+	}
+	defer func(l net.Listener) {
+		_ = l.Close()
+	}(l)
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// CopyToContainer extracts the content (a TAR archive) into the sandbox
+// directory. The destination path is relative to the sandbox.
+func (pf *ProcessFactory) CopyToContainer(contID ContainerID, content io.Reader, destPath string) error {
+	sb, err := pf.getSandbox(contID)
+	if err != nil {
+		return err
+	}
+	return utils.Untar(content, filepath.Join(sb.dir, destPath))
+}
+
+func (pf *ProcessFactory) Start(contID ContainerID) error {
+	sb, err := pf.getSandbox(contID)
+	if err != nil {
+		return err
+	}
+
+	logFile, err := os.Create(filepath.Join(sb.dir, "executor.log"))
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(pf.executorPath)
+	cmd.Dir = sb.dir
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Env = append(os.Environ(), sb.opts.Env...)
+	cmd.Env = append(cmd.Env,
+		"EXECUTOR_PORT="+strconv.Itoa(sb.port),
+		"APP_DIR="+filepath.Join(sb.dir, "app"),
+		"TMPDIR="+filepath.Join(sb.dir, "tmp"))
+	if len(sb.opts.Cmd) == 0 && sb.image != "" {
+		cmd.Env = append(cmd.Env, "CUSTOM_CMD="+sb.image)
+	}
+
+	if err := cmd.Start(); err != nil {
+		_ = logFile.Close()
+		return err
+	}
+
+	sb.cmd = cmd
+	sb.exited = make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		_ = logFile.Close()
+		close(sb.exited)
+	}()
+
+	if pf.cgroupRoot != "" {
+		cgroupDir, err := pf.setupCgroup(contID, cmd.Process.Pid, &sb.opts)
+		if err != nil {
+			log.Printf("Could not apply resource limits to %s: %v\n", contID, err)
+		}
+		sb.cgroupDir = cgroupDir
+	}
+
+	return nil
+}
+
+// setupCgroup creates a cgroup for the sandbox, applying the resource limits
+// and moving the executor process into it.
+func (pf *ProcessFactory) setupCgroup(contID ContainerID, pid int, opts *ContainerOptions) (string, error) {
+	dir := filepath.Join(pf.cgroupRoot, contID)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return "", err
+	}
+
+	if opts.MemoryMB > 0 {
+		limit := strconv.FormatInt(opts.MemoryMB*1048576, 10) // convert to bytes
+		if err := os.WriteFile(filepath.Join(dir, "memory.max"), []byte(limit), 0644); err != nil {
+			return dir, err
+		}
+	}
+	if opts.CPUQuota > 0.0 {
+		cpuMax := fmt.Sprintf("%d 50000", int64(50000.0*opts.CPUQuota)) // 50ms period
+		if err := os.WriteFile(filepath.Join(dir, "cpu.max"), []byte(cpuMax), 0644); err != nil {
+			return dir, err
+		}
+	}
+
+	return dir, os.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644)
+}
+
+func (pf *ProcessFactory) Destroy(contID ContainerID) error {
+	sb, err := pf.getSandbox(contID)
+	if err != nil {
+		return err
+	}
+
+	if sb.cmd != nil {
+		// kill the whole process group (executor and handlers)
+		_ = syscall.Kill(-sb.cmd.Process.Pid, syscall.SIGKILL)
+		select {
+		case <-sb.exited:
+		case <-time.After(5 * time.Second):
+			log.Printf("Sandbox %s did not terminate\n", contID)
+		}
+	}
+
+	if sb.cgroupDir != "" {
+		if err := os.Remove(sb.cgroupDir); err != nil {
+			log.Printf("Could not remove cgroup of %s: %v\n", contID, err)
+		}
+	}
+
+	pf.lock.Lock()
+	delete(pf.sandboxes, contID)
+	pf.lock.Unlock()
+
+	return os.RemoveAll(sb.dir)
+}
+
+// HasImage always returns true, as images are not used by this factory.
+func (pf *ProcessFactory) HasImage(string) bool {
+	return true
+}
+
+// PullImage does nothing, as images are not used by this factory.
+func (pf *ProcessFactory) PullImage(string) error {
+	return nil
+}
+
+// GetIPAddress returns the loopback address and port where the sandbox
+// executor listens.
+func (pf *ProcessFactory) GetIPAddress(contID ContainerID) (string, error) {
+	sb, err := pf.getSandbox(contID)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(sb.port)), nil
+}
+
+func (pf *ProcessFactory) GetMemoryMB(contID ContainerID) (int64, error) {
+	sb, err := pf.getSandbox(contID)
+	if err != nil {
+		return -1, err
+	}
+	return sb.opts.MemoryMB, nil
+}
@@ -1,17 +1,314 @@
 package container
 
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/utils"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"golang.org/x/net/context"
+)
+
 // RuntimeInfo contains information about a supported function runtime env.
 type RuntimeInfo struct {
-	Image         string
-	InvocationCmd []string
+	Name            string
+	Image           string
+	InvocationCmd   []string
+	DefaultMemoryMB int64  // memory assigned to functions that do not specify it
+	HandlerFormat   string // regular expression that function handlers must match (optional)
 }
 
 const CUSTOM_RUNTIME = "custom"
 
-var refreshedImages = map[string]bool{}
+// DefaultMemoryMB is assigned to functions when neither the function nor its
+// runtime specify the amount of memory.
+const DefaultMemoryMB = 128
+
+// builtinRuntimes are added to the catalog the first time a node connects
+// to etcd. Afterwards, they can be updated or removed as any other runtime.
+var builtinRuntimes = []RuntimeInfo{
+	{"python310", "grussorusso/serverledge-python310", []string{"python", "/entrypoint.py"}, 128,
+		`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)+$`},
+	{"nodejs17", "grussorusso/serverledge-nodejs17", []string{"node", "/entrypoint.js"}, 128,
+		`^[A-Za-z0-9_./-]+\.js$`},
+	{"nodejs17ng", "grussorusso/serverledge-nodejs17ng", []string{}, 128,
+		`^[A-Za-z0-9_./-]+\.js$`},
+}
+
+const runtimesEtcdPrefix = "/runtime/"
+const runtimesInitEtcdKey = "/runtime-catalog/initialized"
+
+// runtimes is the local copy of the runtime catalog, kept in sync with etcd
+var runtimes = make(map[string]RuntimeInfo)
+var runtimesLock sync.RWMutex
+
+func init() {
+	for _, rt := range builtinRuntimes {
+		runtimes[rt.Name] = rt
+	}
+}
+
+func getRuntimeEtcdKey(name string) string {
+	return runtimesEtcdPrefix + name
+}
+
+// GetRuntimeInfo retrieves a runtime from the catalog.
+func GetRuntimeInfo(name string) (RuntimeInfo, bool) {
+	runtimesLock.RLock()
+	defer runtimesLock.RUnlock()
+	rt, ok := runtimes[name]
+	return rt, ok
+}
+
+// GetAllRuntimes returns the runtimes in the catalog, sorted by name.
+func GetAllRuntimes() []RuntimeInfo {
+	runtimesLock.RLock()
+	defer runtimesLock.RUnlock()
+	list := make([]RuntimeInfo, 0, len(runtimes))
+	for _, rt := range runtimes {
+		list = append(list, rt)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Validate checks that the runtime description is well-formed.
+func (rt *RuntimeInfo) Validate() error {
+	if rt.Name == "" || rt.Name == CUSTOM_RUNTIME {
+		return fmt.Errorf("invalid runtime name: '%s'", rt.Name)
+	}
+	if rt.Image == "" {
+		return fmt.Errorf("missing image for runtime %s", rt.Name)
+	}
+	if rt.DefaultMemoryMB < 0 {
+		return fmt.Errorf("invalid default memory: %d", rt.DefaultMemoryMB)
+	}
+	if _, err := regexp.Compile(rt.HandlerFormat); err != nil {
+		return fmt.Errorf("invalid handler format: %v", err)
+	}
+	return nil
+}
+
+// ValidateHandler checks whether handler is supported by the runtime.
+func (rt *RuntimeInfo) ValidateHandler(handler string) error {
+	if rt.HandlerFormat == "" {
+		return nil
+	}
+	matched, err := regexp.MatchString(rt.HandlerFormat, handler)
+	if err != nil || !matched {
+		return fmt.Errorf("handler '%s' is not supported by runtime %s (expected format: %s)",
+			handler, rt.Name, rt.HandlerFormat)
+	}
+	return nil
+}
+
+// GetDefaultMemoryMB returns the memory assigned to functions using the runtime
+// that do not specify it.
+func (rt *RuntimeInfo) GetDefaultMemoryMB() int64 {
+	if rt.DefaultMemoryMB > 0 {
+		return rt.DefaultMemoryMB
+	}
+	return DefaultMemoryMB
+}
+
+// SaveToEtcd adds (or updates) the runtime in the catalog.
+func (rt *RuntimeInfo) SaveToEtcd() error {
+	cli, err := utils.GetEtcdClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	payload, err := json.Marshal(*rt)
+	if err != nil {
+		return fmt.Errorf("Could not marshal runtime: %v", err)
+	}
+	_, err = cli.Put(ctx, getRuntimeEtcdKey(rt.Name), string(payload))
+	if err != nil {
+		return fmt.Errorf("Failed Put: %v", err)
+	}
+
+	// update the local copy without waiting for the watcher
+	updateRuntime(*rt)
+	return nil
+}
+
+// DeleteRuntime removes a runtime from the catalog.
+func DeleteRuntime(name string) error {
+	cli, err := utils.GetEtcdClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	dresp, err := cli.Delete(ctx, getRuntimeEtcdKey(name))
+	if err != nil || dresp.Deleted != 1 {
+		return fmt.Errorf("Failed Delete: %v", err)
+	}
+
+	removeRuntime(name)
+	return nil
+}
+
+// setRuntime updates the local catalog, returning true if the runtime image
+// has changed.
+func setRuntime(rt RuntimeInfo) bool {
+	runtimesLock.Lock()
+	defer runtimesLock.Unlock()
+	old, found := runtimes[rt.Name]
+	runtimes[rt.Name] = rt
+	return !found || old.Image != rt.Image
+}
+
+// updateRuntime updates the local catalog, pulling the runtime image if
+// needed.
+func updateRuntime(rt RuntimeInfo) {
+	if setRuntime(rt) && config.GetBool(config.RUNTIME_PREPULL, false) {
+		go prePullImage(rt)
+	}
+}
+
+func removeRuntime(name string) {
+	runtimesLock.Lock()
+	defer runtimesLock.Unlock()
+	delete(runtimes, name)
+}
+
+func prePullImage(rt RuntimeInfo) {
+	if cf == nil {
+		return
+	}
+	log.Printf("Pre-pulling image for runtime %s: %s\n", rt.Name, rt.Image)
+	if err := DownloadImage(rt.Image, false); err != nil {
+		log.Printf("Could not pull image %s: %v\n", rt.Image, err)
+	}
+}
+
+// InitRuntimeCatalog loads the runtime catalog from etcd (adding the
+// built-in runtimes to the catalog, if it has never been initialized) and
+// keeps it in sync in the background. If etcd is unavailable, the node
+// keeps using the built-in runtimes.
+func InitRuntimeCatalog() error {
+	cli, err := utils.GetEtcdClient()
+	if err != nil {
+		return err
+	}
+
+	if err := seedBuiltinRuntimes(cli); err != nil {
+		return err
+	}
+
+	rev, err := loadRuntimes(cli)
+	if err != nil {
+		return err
+	}
+
+	if config.GetBool(config.RUNTIME_PREPULL, false) {
+		for _, rt := range GetAllRuntimes() {
+			go prePullImage(rt)
+		}
+	}
+
+	go watchRuntimes(cli, rev)
+	return nil
+}
+
+func seedBuiltinRuntimes(cli *clientv3.Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	ops := []clientv3.Op{clientv3.OpPut(runtimesInitEtcdKey, "true")}
+	for _, rt := range builtinRuntimes {
+		payload, err := json.Marshal(rt)
+		if err != nil {
+			return err
+		}
+		ops = append(ops, clientv3.OpPut(getRuntimeEtcdKey(rt.Name), string(payload)))
+	}
+
+	_, err := cli.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(runtimesInitEtcdKey), "=", 0)).
+		Then(ops...).
+		Commit()
+	return err
+}
+
+// loadRuntimes replaces the local catalog with the content of etcd and
+// returns the etcd revision it corresponds to.
+func loadRuntimes(cli *clientv3.Client) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	resp, err := cli.Get(ctx, runtimesEtcdPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return 0, fmt.Errorf("Could not read from etcd: %v", err)
+	}
+
+	loaded := make(map[string]bool)
+	for _, kv := range resp.Kvs {
+		var rt RuntimeInfo
+		if err := json.Unmarshal(kv.Value, &rt); err != nil {
+			log.Printf("Ignoring malformed runtime %s: %v\n", kv.Key, err)
+			continue
+		}
+		setRuntime(rt)
+		loaded[rt.Name] = true
+	}
+
+	runtimesLock.Lock()
+	for name := range runtimes {
+		if !loaded[name] {
+			delete(runtimes, name)
+		}
+	}
+	runtimesLock.Unlock()
+
+	return resp.Header.Revision, nil
+}
+
+func watchRuntimes(cli *clientv3.Client, rev int64) {
+	for {
+		watchChan := cli.Watch(context.Background(), runtimesEtcdPrefix, clientv3.WithPrefix(),
+			clientv3.WithRev(rev+1))
+		for wresp := range watchChan {
+			if wresp.Err() != nil {
+				log.Printf("Runtime catalog watch failed: %v\n", wresp.Err())
+				break
+			}
+			for _, ev := range wresp.Events {
+				name := string(ev.Kv.Key)[len(runtimesEtcdPrefix):]
+				if ev.Type == clientv3.EventTypeDelete {
+					log.Printf("Runtime removed: %s\n", name)
+					removeRuntime(name)
+					continue
+				}
+				var rt RuntimeInfo
+				if err := json.Unmarshal(ev.Kv.Value, &rt); err != nil {
+					log.Printf("Ignoring malformed runtime %s: %v\n", name, err)
+					continue
+				}
+				log.Printf("Runtime updated: %s\n", name)
+				updateRuntime(rt)
+			}
+			rev = wresp.Header.Revision
+		}
 
-var RuntimeToInfo = map[string]RuntimeInfo{
-	"python310":  {"grussorusso/serverledge-python310", []string{"python", "/entrypoint.py"}},
-	"nodejs17":   {"grussorusso/serverledge-nodejs17", []string{"node", "/entrypoint.js"}},
-	"nodejs17ng": {"grussorusso/serverledge-nodejs17ng", []string{}},
+		if cli.Ctx().Err() != nil {
+			return // the client has been closed
+		}
+		// the watch has been interrupted (e.g., history compacted): reload
+		// the whole catalog and start again
+		time.Sleep(1 * time.Second)
+		if newRev, err := loadRuntimes(cli); err == nil {
+			rev = newRev
+		}
+	}
 }
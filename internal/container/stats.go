@@ -0,0 +1,24 @@
+package container
+
+import "fmt"
+
+// StatsProvider is implemented by factories that can report the resources
+// used by containers.
+type StatsProvider interface {
+	GetStats(contID ContainerID) (*ContainerStats, error)
+}
+
+// ContainerStats reports the resource usage of a container.
+type ContainerStats struct {
+	CPUSeconds  float64 // cumulative CPU time
+	MemoryBytes int64   // current memory usage
+}
+
+// GetStats samples the resource usage of a container.
+func GetStats(contID ContainerID) (*ContainerStats, error) {
+	provider, ok := cf.(StatsProvider)
+	if !ok {
+		return nil, fmt.Errorf("the container factory does not support resource usage sampling")
+	}
+	return provider.GetStats(contID)
+}
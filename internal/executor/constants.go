@@ -1,3 +1,39 @@
 package executor
 
+import (
+	"os"
+	"strconv"
+)
+
 const DEFAULT_EXECUTOR_PORT = 8080
+
+// Events streamed by the executor while serving a streaming invocation
+const (
+	EVENT_OUTPUT  = "output"  // chunk of handler output
+	EVENT_PARTIAL = "partial" // partial result written by the handler
+	EVENT_RESULT  = "result"  // final InvocationResult
+	EVENT_ERROR   = "error"   // the invocation could not be served
+)
+
+// File descriptor where handlers may write partial results (one per line)
+// during streaming invocations
+const PARTIAL_RESULT_FD = 3
+
+// GetExecutorPort returns the port where the executor listens, possibly
+// overridden by the EXECUTOR_PORT environment variable.
+func GetExecutorPort() int {
+	if port, err := strconv.Atoi(os.Getenv("EXECUTOR_PORT")); err == nil {
+		return port
+	}
+	return DEFAULT_EXECUTOR_PORT
+}
+
+// GetMaxConcurrency returns the number of invocations the executor may serve
+// at once, as set by the node in the MAX_CONCURRENCY environment variable
+// (default: 1).
+func GetMaxConcurrency() int {
+	if n, err := strconv.Atoi(os.Getenv("MAX_CONCURRENCY")); err == nil && n > 0 {
+		return n
+	}
+	return 1
+}
@@ -0,0 +1,67 @@
+package executor
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Files used to exchange raw HTTP requests and responses with the handler
+// process, placed in the working directory of the invocation.
+const httpRequestFileName = "http_request.json"
+const requestBodyFileName = "request_body"
+const httpResponseFileName = "http_response.json"
+const responseBodyFileName = "response_body"
+
+// prepareHTTPRequest writes the HTTP request to be passed to the handler,
+// returning the environment variables pointing to it:
+// HTTP_REQUEST_FILE (method, path, query and headers, JSON-encoded) and
+// REQUEST_BODY_FILE (raw body). The handler may write the raw response body to
+// RESPONSE_BODY_FILE, and the status code and headers to HTTP_RESPONSE_FILE.
+func prepareHTTPRequest(workDir string, req *HTTPRequest) ([]string, error) {
+	requestFile := filepath.Join(workDir, httpRequestFileName)
+	bodyFile := filepath.Join(workDir, requestBodyFileName)
+
+	metadata := *req
+	metadata.Body = nil
+	metadataB, _ := json.Marshal(&metadata)
+	if err := os.WriteFile(requestFile, metadataB, 0644); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(bodyFile, req.Body, 0644); err != nil {
+		return nil, err
+	}
+
+	return []string{
+		"HTTP_REQUEST_FILE=" + requestFile,
+		"REQUEST_BODY_FILE=" + bodyFile,
+		"HTTP_RESPONSE_FILE=" + filepath.Join(workDir, httpResponseFileName),
+		"RESPONSE_BODY_FILE=" + filepath.Join(workDir, responseBodyFileName),
+	}, nil
+}
+
+// readHTTPResponse reads the HTTP response written by the handler, if any.
+func readHTTPResponse(workDir string) (*HTTPResponse, error) {
+	metadataB, metaErr := os.ReadFile(filepath.Join(workDir, httpResponseFileName))
+	body, bodyErr := os.ReadFile(filepath.Join(workDir, responseBodyFileName))
+	if errors.Is(metaErr, fs.ErrNotExist) && errors.Is(bodyErr, fs.ErrNotExist) {
+		return nil, nil
+	}
+
+	resp := &HTTPResponse{}
+	if metaErr == nil {
+		if err := json.Unmarshal(metadataB, resp); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(metaErr, fs.ErrNotExist) {
+		return nil, metaErr
+	}
+	if bodyErr == nil {
+		resp.Body = body
+	} else if !errors.Is(bodyErr, fs.ErrNotExist) {
+		return nil, bodyErr
+	}
+	return resp, nil
+}
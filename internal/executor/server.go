@@ -1,17 +1,48 @@
 package executor
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/sse"
 )
 
-const resultFile = "/tmp/_executor_result.json"
-const paramsFile = "/tmp/_executor.params"
+// Each invocation gets its own working directory for parameters and result,
+// created in the temporary directory, which can be changed through the
+// TMPDIR environment variable (e.g., for process-based sandboxes).
+const resultFileName = "result.json"
+const paramsFileName = "params.json"
+
+// runningInvocation is an invocation being served, which can be cancelled.
+type runningInvocation struct {
+	cmd       *exec.Cmd // process serving the invocation (nil if not started yet)
+	cancelled bool
+}
+
+// runningInvocations tracks invocations by ID from the time they are
+// received, so that they can be aborted upon cancellation, even before their
+// handler process is started.
+var runningInvocations = make(map[string]*runningInvocation)
+var runningLock sync.Mutex
+
+// activeInvocations counts the invocations being served, which may not
+// exceed GetMaxConcurrency.
+var activeInvocations atomic.Int64
+
+var InvocationCancelledErr = errors.New("invocation cancelled")
 
 func readExecutionResult(resultFile string) string {
 	content, err := os.ReadFile(resultFile)
@@ -23,35 +54,144 @@ func readExecutionResult(resultFile string) string {
 	return string(content)
 }
 
-func InvokeHandler(w http.ResponseWriter, r *http.Request) {
-	// Parse request
+// invocationError is returned when an invocation request cannot be served.
+type invocationError struct {
+	status int
+	msg    string
+}
+
+func parseInvocationRequest(w http.ResponseWriter, r *http.Request) (*InvocationRequest, bool) {
 	reqDecoder := json.NewDecoder(r.Body)
 	req := &InvocationRequest{}
 	err := reqDecoder.Decode(req)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	// the handler directory may be relocated (e.g., for process-based
+	// sandboxes)
+	if appDir, ok := os.LookupEnv("APP_DIR"); ok {
+		req.HandlerDir = appDir
+	}
+	return req, true
+}
+
+func InvokeHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := parseInvocationRequest(w, r)
+	if !ok {
+		return
+	}
+
+	release, ok := acquireInvocationSlot(w)
+	if !ok {
+		return
+	}
+	defer release()
+
+	resp, invErr := invoke(req, nil)
+	if invErr != nil {
+		http.Error(w, invErr.msg, invErr.status)
+		return
+	}
+	writeResult(w, resp)
+}
+
+// StreamHandler serves an invocation streaming its output (and partial
+// results) as Server-Sent Events, followed by the final result.
+func StreamHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := parseInvocationRequest(w, r)
+	if !ok {
+		return
+	}
+
+	release, ok := acquireInvocationSlot(w)
+	if !ok {
+		return
+	}
+	defer release()
+
+	w.Header().Set("Content-Type", sse.ContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	var lock sync.Mutex
+	emit := func(event string, data string) {
+		lock.Lock()
+		defer lock.Unlock()
+		if err := sse.WriteEvent(w, event, data); err != nil {
+			log.Printf("Error while streaming event: %v\n", err)
+		}
+	}
+
+	resp, invErr := invoke(req, emit)
+	if invErr != nil {
+		emit(EVENT_ERROR, invErr.msg)
 		return
 	}
+	respBody, _ := json.Marshal(resp)
+	emit(EVENT_RESULT, string(respBody))
+}
+
+// acquireInvocationSlot reserves a slot for a new invocation, returning the
+// function which releases it. If GetMaxConcurrency invocations are being
+// served already, the request is rejected with 429.
+func acquireInvocationSlot(w http.ResponseWriter) (func(), bool) {
+	if activeInvocations.Add(1) > int64(GetMaxConcurrency()) {
+		activeInvocations.Add(-1)
+		http.Error(w, "too many concurrent invocations", http.StatusTooManyRequests)
+		return nil, false
+	}
+	return func() { activeInvocations.Add(-1) }, true
+}
+
+// invoke serves an invocation request. If emit is not nil, the output and
+// partial results of the handler are streamed through it.
+func invoke(req *InvocationRequest, emit func(event string, data string)) (*InvocationResult, *invocationError) {
+	defer trackInvocation(req.Id)()
+
+	if persistentWorkers != nil {
+		return invokeWorker(req, emit)
+	}
+
+	// per-invocation working directory, removed upon completion
+	workDir, err := os.MkdirTemp("", "invocation-")
+	if err != nil {
+		log.Printf("Could not create working directory: %v\n", err)
+		return nil, &invocationError{http.StatusInternalServerError, err.Error()}
+	}
+	defer func() {
+		if err := os.RemoveAll(workDir); err != nil {
+			log.Printf("Could not remove %s: %v\n", workDir, err)
+		}
+	}()
+	resultFile := filepath.Join(workDir, resultFileName)
 
-	// Set environment variables
-	err = os.Setenv("RESULT_FILE", resultFile)
-	err = errors.Join(err, os.Setenv("HANDLER", req.Handler))
-	err = errors.Join(err, os.Setenv("HANDLER_DIR", req.HandlerDir))
-	params := req.Params
-	if params == nil {
-		err = errors.Join(err, os.Setenv("PARAMS_FILE", ""))
+	// the handler process is configured through its own environment
+	env := append(os.Environ(),
+		"RESULT_FILE="+resultFile,
+		"HANDLER="+req.Handler,
+		"HANDLER_DIR="+req.HandlerDir)
+	if req.Params == nil {
+		env = append(env, "PARAMS_FILE=")
 	} else {
+		paramsFile := filepath.Join(workDir, paramsFileName)
 		paramsB, _ := json.Marshal(req.Params)
 		fileError := os.WriteFile(paramsFile, paramsB, 0644)
 		if fileError != nil {
 			log.Printf("Could not write parameters to %s\n", paramsFile)
-			http.Error(w, fileError.Error(), http.StatusInternalServerError)
-			return
+			return nil, &invocationError{http.StatusInternalServerError, fileError.Error()}
 		}
-		err = errors.Join(err, os.Setenv("PARAMS_FILE", paramsFile))
+		env = append(env, "PARAMS_FILE="+paramsFile)
 	}
-	if err != nil {
-		log.Printf("Error while setting environment variables: %s\n", err)
+
+	if req.HTTPRequest != nil {
+		httpEnv, err := prepareHTTPRequest(workDir, req.HTTPRequest)
+		if err != nil {
+			log.Printf("Could not write HTTP request: %v\n", err)
+			return nil, &invocationError{http.StatusInternalServerError, err.Error()}
+		}
+		env = append(env, httpEnv...)
 	}
 
 	// Exec handler process
@@ -62,38 +202,241 @@ func InvokeHandler(w http.ResponseWriter, r *http.Request) {
 		customCmd, ok := os.LookupEnv("CUSTOM_CMD")
 		if !ok {
 			log.Printf("Invalid request!\n")
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+			return nil, &invocationError{http.StatusBadRequest, "no command to execute"}
 		}
 
 		cmd = strings.Split(customCmd, " ")
 	}
 
 	var resp *InvocationResult
+	out := &outputWriter{capture: req.ReturnOutput}
 	execCmd := exec.Command(cmd[0], cmd[1:]...)
-	out, err := execCmd.CombinedOutput()
+	execCmd.Stdout = out
+	execCmd.Stderr = out
+	execCmd.Env = env
+
+	// partial results are written by the handler to a pipe
+	var partialDone chan struct{}
+	var partialWriter *os.File
+	if emit != nil {
+		out.emit = emit
+		var partialReader *os.File
+		partialReader, partialWriter, err = os.Pipe()
+		if err != nil {
+			return nil, &invocationError{http.StatusInternalServerError, err.Error()}
+		}
+		execCmd.ExtraFiles = []*os.File{partialWriter}
+		execCmd.Env = append(execCmd.Env, fmt.Sprintf("PARTIAL_RESULT_FD=%d", PARTIAL_RESULT_FD))
+
+		partialDone = make(chan struct{})
+		go func() {
+			defer close(partialDone)
+			defer partialReader.Close()
+			scanner := bufio.NewScanner(partialReader)
+			for scanner.Scan() {
+				emit(EVENT_PARTIAL, scanner.Text())
+			}
+		}()
+	}
+
+	t0 := time.Now()
+	err = runCommand(req.Id, execCmd)
+	duration := time.Since(t0).Seconds()
+	if partialWriter != nil {
+		_ = partialWriter.Close()
+		<-partialDone
+	}
+	usage := processUsage(execCmd.ProcessState)
 	if err != nil {
 		log.Printf("cmd.Run() failed with %s\n", err)
-		if req.ReturnOutput {
-			resp = &InvocationResult{Success: false, Output: string(out)}
-		} else {
-			resp = &InvocationResult{Success: false, Output: ""}
-		}
+		resp = &InvocationResult{Success: false, Output: out.String(), Usage: usage}
 	} else {
 		result := readExecutionResult(resultFile)
-
-		if req.ReturnOutput {
-			resp = &InvocationResult{true, result, string(out)}
-		} else {
-			resp = &InvocationResult{true, result, ""}
+		resp = &InvocationResult{Success: true, Result: result, Output: out.String(), Duration: duration, Usage: usage}
+		if req.HTTPRequest != nil {
+			resp.HTTPResponse, err = readHTTPResponse(workDir)
+			if err != nil {
+				log.Printf("Could not read HTTP response: %v\n", err)
+				resp.Success = false
+			}
 		}
 	}
 
+	return resp, nil
+}
+
+// outputWriter collects the output of the handler (if captured), possibly
+// streaming it.
+type outputWriter struct {
+	capture bool
+	buf     bytes.Buffer
+	emit    func(event string, data string)
+}
+
+func (o *outputWriter) Write(p []byte) (int, error) {
+	if o.capture {
+		o.buf.Write(p)
+	}
+	if o.emit != nil {
+		o.emit(EVENT_OUTPUT, string(p))
+	}
+	return len(p), nil
+}
+
+func (o *outputWriter) String() string {
+	return o.buf.String()
+}
+
+// invokeWorker serves the invocation through a persistent worker process,
+// which is passed the whole request (e.g., including the HTTP request of HTTP
+// trigger invocations). Failures of the worker (e.g., crashes) are reported
+// as errors of the executor, rather than of the function.
+func invokeWorker(req *InvocationRequest, emit func(event string, data string)) (*InvocationResult, *invocationError) {
+	resp, err := persistentWorkers.invoke(req, emit)
+	if err != nil {
+		log.Printf("Worker invocation failed: %v\n", err)
+		return nil, &invocationError{http.StatusInternalServerError, err.Error()}
+	}
+
+	if !req.ReturnOutput {
+		resp.Output = ""
+	}
+	return resp, nil
+}
+
+func writeResult(w http.ResponseWriter, resp *InvocationResult) {
 	w.Header().Set("Content-Type", "application/json")
 	respBody, _ := json.Marshal(resp)
-	_, err = w.Write(respBody)
+	_, err := w.Write(respBody)
 	if err != nil {
 		log.Printf("Error while writing response to HTTP %s\n", err)
 		return
 	}
 }
+
+// processUsage returns the resources used by a terminated handler process.
+func processUsage(state *os.ProcessState) *ResourceUsage {
+	if state == nil {
+		return nil
+	}
+
+	usage := &ResourceUsage{
+		UserTime:   state.UserTime().Seconds(),
+		SystemTime: state.SystemTime().Seconds(),
+		ExitCode:   state.ExitCode(),
+	}
+	if rusage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		usage.MaxRSSKB = rusage.Maxrss // KB on Linux
+	}
+	return usage
+}
+
+// trackInvocation registers an invocation, returning the function to call
+// upon its completion.
+func trackInvocation(id string) func() {
+	if id == "" {
+		return func() {}
+	}
+
+	runningLock.Lock()
+	runningInvocations[id] = &runningInvocation{}
+	runningLock.Unlock()
+
+	return func() {
+		runningLock.Lock()
+		delete(runningInvocations, id)
+		runningLock.Unlock()
+	}
+}
+
+// attachProcess associates the process serving an invocation with it, so
+// that it is killed upon cancellation. InvocationCancelledErr is returned if
+// the invocation has been cancelled already. The lock must be held by the
+// caller.
+func attachProcess(id string, cmd *exec.Cmd) error {
+	inv, ok := runningInvocations[id]
+	if !ok {
+		return nil
+	}
+	if inv.cancelled {
+		return InvocationCancelledErr
+	}
+	inv.cmd = cmd
+	return nil
+}
+
+// detachProcess dissociates an invocation from the process serving it, which
+// is not killed upon cancellation anymore.
+func detachProcess(id string) {
+	runningLock.Lock()
+	defer runningLock.Unlock()
+	if inv, ok := runningInvocations[id]; ok {
+		inv.cmd = nil
+	}
+}
+
+// runCommand runs the handler process, unless the invocation has been
+// cancelled before.
+func runCommand(id string, execCmd *exec.Cmd) error {
+	runningLock.Lock()
+	err := attachProcess(id, execCmd)
+	if err == nil {
+		err = execCmd.Start()
+	}
+	runningLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return execCmd.Wait()
+}
+
+// CancelHandler aborts an invocation, killing its handler process. If the
+// handler is not running yet, it will not be started.
+func CancelHandler(w http.ResponseWriter, r *http.Request) {
+	req := &CancellationRequest{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the process is killed while holding the lock, as it may be detached
+	// from the invocation (e.g., a worker) meanwhile
+	runningLock.Lock()
+	inv, ok := runningInvocations[req.Id]
+	if ok {
+		inv.cancelled = true
+		if inv.cmd != nil {
+			err = inv.cmd.Process.Kill()
+		}
+	}
+	runningLock.Unlock()
+	if !ok {
+		http.Error(w, "unknown invocation", http.StatusNotFound)
+		return
+	}
+	if err != nil && !errors.Is(err, os.ErrProcessDone) {
+		log.Printf("Could not kill handler for %s: %v\n", req.Id, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Cancelled invocation %s\n", req.Id)
+	w.WriteHeader(http.StatusOK)
+}
+
+// ReadyHandler reports that the executor is ready to serve invocations.
+// In persistent mode, the worker processes must be running.
+func ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	if persistentWorkers != nil && !persistentWorkers.isReady() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// HealthHandler reports that the executor is up and running.
+func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
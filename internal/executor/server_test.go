@@ -0,0 +1,181 @@
+package executor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/sse"
+)
+
+func invokeTestHandler(t *testing.T, req *InvocationRequest) *InvocationResult {
+	body, _ := json.Marshal(req)
+	rec := httptest.NewRecorder()
+	InvokeHandler(rec, httptest.NewRequest(http.MethodPost, "/invoke", bytes.NewReader(body)))
+
+	result := &InvocationResult{}
+	if err := json.NewDecoder(rec.Body).Decode(result); err != nil {
+		t.Errorf("invalid response: %v", err)
+	}
+	return result
+}
+
+func TestConcurrentInvocations(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	t.Setenv("MAX_CONCURRENCY", "8")
+	echoCmd := []string{"sh", "-c", `sleep 0.1; cat "$PARAMS_FILE" > "$RESULT_FILE"`}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			params := map[string]interface{}{"i": float64(i)}
+			result := invokeTestHandler(t, &InvocationRequest{Id: fmt.Sprint(i), Command: echoCmd, Params: params})
+			if !result.Success || result.Result != fmt.Sprintf(`{"i":%d}`, i) {
+				t.Errorf("unexpected result for invocation %d: %+v", i, result)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// invocations exceeding the max concurrency are rejected
+	t.Setenv("MAX_CONCURRENCY", "1")
+	done := make(chan *InvocationResult)
+	go func() {
+		done <- invokeTestHandler(t, &InvocationRequest{Command: []string{"sleep", "0.5"}})
+	}()
+	for i := 0; i < 100 && activeInvocations.Load() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, path := range []string{"/invoke", "/invoke/stream"} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader([]byte(`{"Command":["true"]}`)))
+		if path == "/invoke" {
+			InvokeHandler(rec, req)
+		} else {
+			StreamHandler(rec, req)
+		}
+		if rec.Code != http.StatusTooManyRequests {
+			t.Errorf("unexpected status of an invocation exceeding the max concurrency (%s): %d", path, rec.Code)
+		}
+	}
+	if result := <-done; !result.Success {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	// no result is returned if the handler does not write it
+	result := invokeTestHandler(t, &InvocationRequest{Command: []string{"true"}})
+	if !result.Success || result.Result != "" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	// the resource usage of the handler process is reported
+	result = invokeTestHandler(t, &InvocationRequest{Command: []string{"sh", "-c", "exit 3"}})
+	if result.Success || result.Usage == nil || result.Usage.ExitCode != 3 || result.Usage.MaxRSSKB <= 0 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+}
+
+func TestStreamingInvocation(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	cmd := []string{"sh", "-c", `echo hello; echo '{"step":1}' >&$PARTIAL_RESULT_FD; echo world; echo done > "$RESULT_FILE"`}
+	body, _ := json.Marshal(&InvocationRequest{Command: cmd})
+	rec := httptest.NewRecorder()
+	StreamHandler(rec, httptest.NewRequest(http.MethodPost, "/invoke/stream", bytes.NewReader(body)))
+
+	var output, partial string
+	result := &InvocationResult{}
+	err := sse.ReadEvents(rec.Body, func(event string, data string) error {
+		switch event {
+		case EVENT_OUTPUT:
+			output += data
+		case EVENT_PARTIAL:
+			partial += data
+		case EVENT_RESULT:
+			return json.Unmarshal([]byte(data), result)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "hello\nworld\n" || partial != `{"step":1}` {
+		t.Errorf("unexpected events: output %q, partial %q", output, partial)
+	}
+	if !result.Success || result.Result != "done\n" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+}
+
+func TestHTTPInvocation(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	cmd := []string{"sh", "-c", `cat "$REQUEST_BODY_FILE" > "$RESPONSE_BODY_FILE"; echo '{"StatusCode": 202}' > "$HTTP_RESPONSE_FILE"`}
+	httpReq := &HTTPRequest{Method: http.MethodPut, Path: "/", Body: []byte{0x00, 0xff}}
+	result := invokeTestHandler(t, &InvocationRequest{Command: cmd, HTTPRequest: httpReq})
+	if !result.Success || result.HTTPResponse == nil {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if result.HTTPResponse.StatusCode != http.StatusAccepted || !bytes.Equal(result.HTTPResponse.Body, httpReq.Body) {
+		t.Errorf("unexpected HTTP response: %+v", result.HTTPResponse)
+	}
+}
+
+func cancelTestInvocation(id string) int {
+	body, _ := json.Marshal(&CancellationRequest{Id: id})
+	rec := httptest.NewRecorder()
+	CancelHandler(rec, httptest.NewRequest(http.MethodPost, "/cancel", bytes.NewReader(body)))
+	return rec.Code
+}
+
+func TestCancellation(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	if code := cancelTestInvocation("unknown"); code != http.StatusNotFound {
+		t.Errorf("unexpected status for an unknown invocation: %d", code)
+	}
+
+	// running handlers are killed
+	done := make(chan *InvocationResult)
+	go func() {
+		done <- invokeTestHandler(t, &InvocationRequest{Id: "running", Command: []string{"sleep", "10"}})
+	}()
+	for i := 0; i < 100; i++ {
+		runningLock.Lock()
+		inv, ok := runningInvocations["running"]
+		started := ok && inv.cmd != nil
+		runningLock.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if code := cancelTestInvocation("running"); code != http.StatusOK {
+		t.Errorf("unexpected status: %d", code)
+	}
+	select {
+	case result := <-done:
+		if result.Success {
+			t.Errorf("cancelled invocation succeeded: %+v", result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler not killed")
+	}
+
+	// handlers of invocations cancelled before being started are not run
+	untrack := trackInvocation("pending")
+	defer untrack()
+	if code := cancelTestInvocation("pending"); code != http.StatusOK {
+		t.Errorf("unexpected status: %d", code)
+	}
+	if err := runCommand("pending", exec.Command("true")); !errors.Is(err, InvocationCancelledErr) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
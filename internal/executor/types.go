@@ -1,15 +1,49 @@
 package executor
 
 type InvocationRequest struct {
+	Id           string
 	Command      []string
 	Params       map[string]interface{}
 	Handler      string
 	HandlerDir   string
 	ReturnOutput bool
+	HTTPRequest  *HTTPRequest `json:",omitempty"` // set for HTTP trigger invocations
 }
 
 type InvocationResult struct {
-	Success bool
-	Result  string
-	Output  string
+	Success      bool
+	Result       string
+	Output       string
+	Duration     float64        // handler execution time in seconds (optional)
+	Usage        *ResourceUsage `json:",omitempty"` // (optional)
+	HTTPResponse *HTTPResponse  `json:",omitempty"` // HTTP trigger invocations only (optional)
+}
+
+// HTTPRequest is the raw HTTP request that triggered the invocation.
+type HTTPRequest struct {
+	Method  string
+	Path    string
+	Query   string
+	Headers map[string][]string
+	Body    []byte
+}
+
+// HTTPResponse is the raw HTTP response returned by the handler.
+type HTTPResponse struct {
+	StatusCode int
+	Headers    map[string][]string
+	Body       []byte
+}
+
+// ResourceUsage reports the resources used by the handler process.
+type ResourceUsage struct {
+	UserTime   float64 // seconds
+	SystemTime float64 // seconds
+	MaxRSSKB   int64
+	ExitCode   int
+}
+
+// CancellationRequest asks the executor to abort a running invocation.
+type CancellationRequest struct {
+	Id string
 }
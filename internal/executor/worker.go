@@ -0,0 +1,413 @@
+package executor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// In persistent mode, the executor starts long-lived worker processes once,
+// instead of spawning a new process for each invocation. Invocation requests
+// are written to a worker as JSON-encoded lines on file descriptor 3, and
+// the worker answers with a JSON-encoded InvocationResult line on file
+// descriptor 4, possibly preceded by partial results (see workerMessage).
+// The standard output and error of the worker are forwarded to those of the
+// executor (and the output is captured or streamed on request).
+// A worker serves one invocation at a time: as many workers as the
+// invocations the executor may serve at once are started.
+const WORKER_REQUEST_FD = 3
+const WORKER_RESPONSE_FD = 4
+
+const minRestartDelay = 100 * time.Millisecond
+const maxRestartDelay = 10 * time.Second
+
+// workers living less than this are considered to be crash-looping
+const minWorkerLifetime = time.Second
+
+// outputBarrier is written by the executor to the output pipe of a worker
+// after receiving a result, so that all the output written by the worker
+// before the result can be told apart
+const outputBarrier = "\x00serverledge:end-of-output\x00"
+
+// time waited for the output of an invocation to be forwarded
+const outputFlushTimeout = time.Second
+
+var WorkerNotRunningErr = errors.New("worker process not running")
+
+// workerMessage is a line written by a worker on the response descriptor:
+// either a partial result (streamed only if requested), or the result of the
+// invocation.
+type workerMessage struct {
+	InvocationResult
+	Partial *string `json:",omitempty"`
+}
+
+type worker struct {
+	cmdline []string
+
+	// lock serializes invocations and protects the fields below
+	lock      sync.Mutex
+	cmd       *exec.Cmd
+	requests  io.WriteCloser
+	respPipe  io.Closer
+	responses *bufio.Reader
+	outPipe   io.WriteCloser // write end of the output pipe of the worker
+	output    *workerOutput
+	startedAt time.Time
+	delay     time.Duration
+	stopped   bool
+}
+
+// workerPool is a set of workers, each serving an invocation at a time.
+type workerPool struct {
+	workers []*worker
+	idle    chan *worker
+}
+
+// persistentWorkers is not nil if the executor runs in persistent mode
+var persistentWorkers *workerPool
+
+// StartWorker enables the persistent mode if the WORKER_CMD environment
+// variable is set, starting a worker process for each invocation that may be
+// served at once (see GetMaxConcurrency).
+func StartWorker() error {
+	workerCmd, ok := os.LookupEnv("WORKER_CMD")
+	if !ok || strings.TrimSpace(workerCmd) == "" {
+		return nil
+	}
+
+	size := GetMaxConcurrency()
+	pool := &workerPool{idle: make(chan *worker, size)}
+	for i := 0; i < size; i++ {
+		w := &worker{cmdline: strings.Fields(workerCmd), delay: minRestartDelay}
+		w.lock.Lock()
+		err := w.start()
+		w.lock.Unlock()
+		if err != nil {
+			pool.stop()
+			return err
+		}
+		pool.workers = append(pool.workers, w)
+		pool.idle <- w
+	}
+	persistentWorkers = pool
+	return nil
+}
+
+// StopWorker terminates the worker processes (if any), which are not
+// restarted.
+func StopWorker() {
+	if persistentWorkers != nil {
+		persistentWorkers.stop()
+	}
+}
+
+func (p *workerPool) stop() {
+	for _, w := range p.workers {
+		w.stop()
+	}
+}
+
+// isReady returns true if all the worker processes are running.
+func (p *workerPool) isReady() bool {
+	for _, w := range p.workers {
+		if !w.isReady() {
+			return false
+		}
+	}
+	return true
+}
+
+// invoke serves the invocation through an idle worker, waiting for one to
+// be available. If emit is not nil, the output and partial results of the
+// worker are streamed through it.
+func (p *workerPool) invoke(req *InvocationRequest, emit func(event string, data string)) (*InvocationResult, error) {
+	w := <-p.idle
+	defer func() { p.idle <- w }()
+	return w.invoke(req, emit)
+}
+
+// stop terminates the worker process, which is not restarted.
+func (w *worker) stop() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.stopped = true
+	if w.cmd != nil {
+		w.discard(w.cmd)
+	}
+}
+
+// isReady returns true if the worker process is running.
+func (w *worker) isReady() bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.cmd != nil
+}
+
+// start spawns the worker process. The lock must be held by the caller.
+func (w *worker) start() error {
+	var pipes []*os.File
+	closeAll := func() {
+		for _, f := range pipes {
+			_ = f.Close()
+		}
+	}
+	for i := 0; i < 3; i++ {
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			closeAll()
+			return err
+		}
+		pipes = append(pipes, reader, writer)
+	}
+	reqReader, reqWriter := pipes[0], pipes[1]
+	respReader, respWriter := pipes[2], pipes[3]
+	outReader, outWriter := pipes[4], pipes[5]
+
+	cmd := exec.Command(w.cmdline[0], w.cmdline[1:]...)
+	cmd.Stdout = outWriter
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{reqReader, respWriter}
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("WORKER_REQUEST_FD=%d", WORKER_REQUEST_FD),
+		fmt.Sprintf("WORKER_RESPONSE_FD=%d", WORKER_RESPONSE_FD))
+	err := cmd.Start()
+
+	// the worker has its own copies of these (the write end of the output
+	// pipe is kept, to write barriers)
+	_ = reqReader.Close()
+	_ = respWriter.Close()
+	if err != nil {
+		_ = reqWriter.Close()
+		_ = respReader.Close()
+		_ = outReader.Close()
+		_ = outWriter.Close()
+		return err
+	}
+
+	log.Printf("Started worker process %d\n", cmd.Process.Pid)
+	w.cmd = cmd
+	w.requests = reqWriter
+	w.respPipe = respReader
+	w.responses = bufio.NewReader(respReader)
+	w.outPipe = outWriter
+	w.output = &workerOutput{}
+	w.startedAt = time.Now()
+	go w.output.forward(outReader)
+	go w.monitor(cmd)
+	return nil
+}
+
+// discard kills the worker process, which is not used anymore. The lock must
+// be held by the caller.
+func (w *worker) discard(cmd *exec.Cmd) {
+	_ = cmd.Process.Kill()
+	if w.cmd == cmd {
+		w.cmd = nil
+		_ = w.requests.Close()
+		_ = w.respPipe.Close()
+		_ = w.outPipe.Close()
+	}
+}
+
+// monitor waits for the termination of the worker process and restarts it,
+// delaying restarts of crash-looping workers.
+func (w *worker) monitor(cmd *exec.Cmd) {
+	err := cmd.Wait()
+	log.Printf("Worker process %d exited: %v\n", cmd.Process.Pid, err)
+
+	w.lock.Lock()
+	w.discard(cmd)
+	if time.Since(w.startedAt) < minWorkerLifetime {
+		w.delay = minDuration(2*w.delay, maxRestartDelay)
+	} else {
+		w.delay = minRestartDelay
+	}
+	delay := w.delay
+	w.lock.Unlock()
+
+	time.Sleep(delay)
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.stopped || w.cmd != nil {
+		return
+	}
+	if err := w.start(); err != nil {
+		log.Printf("Could not restart worker process: %v\n", err)
+	}
+}
+
+// invoke sends an invocation request to the worker and waits for the
+// result, which reports the time taken by the worker to serve it. A worker
+// that does not answer properly is killed (and restarted), as well as the
+// worker of a cancelled invocation.
+func (w *worker) invoke(req *InvocationRequest, emit func(event string, data string)) (*InvocationResult, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.cmd == nil {
+		if w.stopped {
+			return nil, WorkerNotRunningErr
+		}
+		// not restarted yet
+		if err := w.start(); err != nil {
+			return nil, fmt.Errorf("%w: %v", WorkerNotRunningErr, err)
+		}
+	}
+	cmd := w.cmd
+
+	runningLock.Lock()
+	err := attachProcess(req.Id, cmd)
+	runningLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	// the worker may serve other invocations afterwards
+	defer detachProcess(req.Id)
+
+	capturing := req.ReturnOutput || emit != nil
+	if capturing {
+		w.output.startCapture(req.ReturnOutput, emit)
+	}
+
+	t0 := time.Now()
+	line, _ := json.Marshal(req)
+	line = append(line, '\n')
+	if _, err := w.requests.Write(line); err != nil {
+		w.discard(cmd)
+		return nil, fmt.Errorf("could not send request to worker: %v", err)
+	}
+
+	var msg workerMessage
+	for {
+		respLine, err := w.responses.ReadBytes('\n')
+		if err != nil {
+			w.discard(cmd)
+			return nil, fmt.Errorf("could not read response from worker: %v", err)
+		}
+		msg = workerMessage{}
+		if err := json.Unmarshal(respLine, &msg); err != nil {
+			w.discard(cmd)
+			return nil, fmt.Errorf("invalid response from worker: %v", err)
+		}
+		if msg.Partial == nil {
+			break
+		}
+		if emit != nil {
+			emit(EVENT_PARTIAL, *msg.Partial)
+		}
+	}
+
+	result := &msg.InvocationResult
+	result.Duration = time.Since(t0).Seconds()
+	if capturing {
+		result.Output = w.output.stopCapture(w.outPipe) + result.Output
+	}
+	return result, nil
+}
+
+// workerOutput forwards the standard output of a worker process, capturing
+// or streaming it on behalf of the invocation being served (if requested).
+type workerOutput struct {
+	sync.Mutex
+	buf     *bytes.Buffer                   // nil if not capturing
+	emit    func(event string, data string) // nil if not streaming
+	flushed chan struct{}                   // signalled upon reading a barrier
+}
+
+// forward copies the output of the worker to the standard output, until the
+// pipe is closed.
+func (o *workerOutput) forward(pipe io.ReadCloser) {
+	defer pipe.Close()
+	reader := bufio.NewReader(pipe)
+	for {
+		line, err := reader.ReadBytes('\n')
+		// the barrier follows the last line written by the worker, which
+		// may not be terminated
+		barrier := bytes.HasSuffix(line, []byte(outputBarrier+"\n"))
+		if barrier {
+			line = line[:len(line)-len(outputBarrier)-1]
+		}
+		if len(line) > 0 {
+			_, _ = os.Stdout.Write(line)
+			o.Lock()
+			if o.buf != nil {
+				o.buf.Write(line)
+			}
+			if o.emit != nil {
+				o.emit(EVENT_OUTPUT, string(line))
+			}
+			o.Unlock()
+		}
+		if barrier {
+			o.Lock()
+			if o.flushed != nil {
+				select {
+				case o.flushed <- struct{}{}:
+				default:
+				}
+			}
+			o.Unlock()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// startCapture starts collecting the output (if capture is true) and/or
+// streaming it through emit (if not nil).
+func (o *workerOutput) startCapture(capture bool, emit func(event string, data string)) {
+	o.Lock()
+	defer o.Unlock()
+	o.buf = nil
+	if capture {
+		o.buf = &bytes.Buffer{}
+	}
+	o.emit = emit
+	o.flushed = make(chan struct{}, 1)
+}
+
+// stopCapture returns the output captured so far (if any), after waiting for
+// the output written by the worker before its result to be forwarded.
+func (o *workerOutput) stopCapture(pipe io.Writer) string {
+	o.Lock()
+	flushed := o.flushed
+	o.Unlock()
+
+	if _, err := pipe.Write([]byte(outputBarrier + "\n")); err == nil {
+		select {
+		case <-flushed:
+		case <-time.After(outputFlushTimeout):
+			log.Printf("Output of the worker not flushed in time\n")
+		}
+	}
+
+	o.Lock()
+	defer o.Unlock()
+	var output string
+	if o.buf != nil {
+		output = o.buf.String()
+	}
+	o.buf = nil
+	o.emit = nil
+	o.flushed = nil
+	return output
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a <= b {
+		return a
+	}
+	return b
+}
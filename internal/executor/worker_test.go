@@ -0,0 +1,275 @@
+package executor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/sse"
+)
+
+// TestHelperWorker is not a real test: it implements the worker protocol
+// when the test binary is spawned as a worker process.
+func TestHelperWorker(t *testing.T) {
+	if os.Getenv("GO_TEST_WORKER") != "1" {
+		return
+	}
+
+	requests := bufio.NewReader(os.NewFile(WORKER_REQUEST_FD, "requests"))
+	responses := os.NewFile(WORKER_RESPONSE_FD, "responses")
+	served := 0
+	for {
+		line, err := requests.ReadBytes('\n')
+		if err != nil {
+			os.Exit(0)
+		}
+		req := &InvocationRequest{}
+		_ = json.Unmarshal(line, req)
+		if req.Params["crash"] == true {
+			os.Exit(1)
+		}
+		if d, ok := req.Params["sleep"].(float64); ok {
+			time.Sleep(time.Duration(d * float64(time.Second)))
+		}
+		if text, ok := req.Params["print"].(string); ok {
+			fmt.Print(text)
+		}
+		if partial, ok := req.Params["partial"].(string); ok {
+			out, _ := json.Marshal(&workerMessage{Partial: &partial})
+			_, _ = responses.Write(append(out, '\n'))
+		}
+
+		served++
+		result := fmt.Sprintf(`{"Pid": %d, "Served": %d}`, os.Getpid(), served)
+		resp := &InvocationResult{Success: true, Result: result}
+		if req.HTTPRequest != nil {
+			resp.HTTPResponse = &HTTPResponse{StatusCode: http.StatusAccepted, Body: req.HTTPRequest.Body}
+		}
+		out, _ := json.Marshal(resp)
+		_, _ = responses.Write(append(out, '\n'))
+	}
+}
+
+type workerResult struct {
+	Pid    int
+	Served int
+}
+
+func invokeTestWorker(t *testing.T, w *worker, params map[string]interface{}) (*workerResult, error) {
+	resp, err := w.invoke(&InvocationRequest{Params: params}, nil)
+	if err != nil {
+		return nil, err
+	}
+	res := &workerResult{}
+	if err := json.Unmarshal([]byte(resp.Result), res); err != nil {
+		t.Fatalf("invalid result: %s", resp.Result)
+	}
+	return res, nil
+}
+
+func TestPersistentWorker(t *testing.T) {
+	t.Setenv("GO_TEST_WORKER", "1")
+	t.Setenv("WORKER_CMD", os.Args[0]+" -test.run=^TestHelperWorker$")
+	if err := StartWorker(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		StopWorker()
+		persistentWorkers = nil
+	}()
+	if len(persistentWorkers.workers) != 1 {
+		t.Fatalf("unexpected number of workers: %d", len(persistentWorkers.workers))
+	}
+	w := persistentWorkers.workers[0]
+
+	// warm invocations reuse the same process
+	first, err := invokeTestWorker(t, w, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := invokeTestWorker(t, w, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Pid != second.Pid || second.Served != 2 {
+		t.Fatalf("worker not reused: %+v, %+v", first, second)
+	}
+
+	// a crashed worker is restarted
+	if _, err := invokeTestWorker(t, w, map[string]interface{}{"crash": true}); err == nil {
+		t.Fatal("expected failure upon worker crash")
+	}
+	third, err := invokeTestWorker(t, w, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.Pid == first.Pid || third.Served != 1 {
+		t.Fatalf("worker not restarted: %+v", third)
+	}
+
+	StopWorker()
+	if _, err := w.invoke(&InvocationRequest{}, nil); !errors.Is(err, WorkerNotRunningErr) {
+		t.Fatalf("expected stopped worker, got: %v", err)
+	}
+}
+
+func TestWorkerPool(t *testing.T) {
+	t.Setenv("GO_TEST_WORKER", "1")
+	t.Setenv("WORKER_CMD", os.Args[0]+" -test.run=^TestHelperWorker$")
+	t.Setenv("MAX_CONCURRENCY", "2")
+	if err := StartWorker(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		StopWorker()
+		persistentWorkers = nil
+	}()
+
+	// invocations are served concurrently by different workers
+	var wg sync.WaitGroup
+	pids := make(chan int, 2)
+	t0 := time.Now()
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, invErr := invokeWorker(&InvocationRequest{Params: map[string]interface{}{"sleep": 0.5}}, nil)
+			res := &workerResult{}
+			if invErr != nil {
+				t.Errorf("invocation failed: %s", invErr.msg)
+			} else if err := json.Unmarshal([]byte(resp.Result), res); err != nil {
+				t.Errorf("invalid result: %+v", resp)
+			}
+			pids <- res.Pid
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(t0); elapsed > 900*time.Millisecond {
+		t.Errorf("invocations serialized: %v", elapsed)
+	}
+	if first, second := <-pids, <-pids; first == second {
+		t.Errorf("invocations served by the same worker: %d", first)
+	}
+
+	// the output of the worker is captured upon request
+	resp := invokeTestHandler(t, &InvocationRequest{ReturnOutput: true, Params: map[string]interface{}{"print": "hello\nworld"}})
+	if !resp.Success || resp.Output != "hello\nworld" {
+		t.Errorf("unexpected output: %q", resp.Output)
+	}
+	resp = invokeTestHandler(t, &InvocationRequest{Params: map[string]interface{}{"print": "hidden\n"}})
+	if !resp.Success || resp.Output != "" {
+		t.Errorf("unexpected output: %q", resp.Output)
+	}
+
+	// the duration does not include the time spent waiting for a worker
+	busy := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, _ = invokeWorker(&InvocationRequest{Params: map[string]interface{}{"sleep": 0.5}}, nil)
+			busy <- struct{}{}
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	resp = invokeTestHandler(t, &InvocationRequest{})
+	if !resp.Success || resp.Duration > 0.3 {
+		t.Errorf("unexpected duration: %+v", resp)
+	}
+	<-busy
+	<-busy
+
+	// cancellations only abort the invocation they refer to
+	cancelled := make(chan *invocationError)
+	go func() {
+		_, invErr := invoke(&InvocationRequest{Id: "cancelled", Params: map[string]interface{}{"sleep": 10.0}}, nil)
+		cancelled <- invErr
+	}()
+	other := make(chan *InvocationResult)
+	go func() {
+		resp, _ := invoke(&InvocationRequest{Id: "other", Params: map[string]interface{}{"sleep": 0.5}}, nil)
+		other <- resp
+	}()
+	for cancelTestInvocation("cancelled") != http.StatusOK {
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case invErr := <-cancelled:
+		if invErr == nil {
+			t.Errorf("cancelled invocation succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("invocation not cancelled")
+	}
+	if resp := <-other; !resp.Success {
+		t.Errorf("invocation aborted by the cancellation of another one")
+	}
+}
+
+func TestPersistentWorkerRequests(t *testing.T) {
+	t.Setenv("GO_TEST_WORKER", "1")
+	t.Setenv("WORKER_CMD", os.Args[0]+" -test.run=^TestHelperWorker$")
+	if err := StartWorker(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		StopWorker()
+		persistentWorkers = nil
+	}()
+
+	// the output and partial results of workers are streamed
+	params := map[string]interface{}{"print": "hello\n", "partial": `{"step":1}`}
+	body, _ := json.Marshal(&InvocationRequest{Params: params})
+	rec := httptest.NewRecorder()
+	StreamHandler(rec, httptest.NewRequest(http.MethodPost, "/invoke/stream", bytes.NewReader(body)))
+	var output, partial string
+	result := &InvocationResult{}
+	err := sse.ReadEvents(rec.Body, func(event string, data string) error {
+		switch event {
+		case EVENT_OUTPUT:
+			output += data
+		case EVENT_PARTIAL:
+			partial += data
+		case EVENT_RESULT:
+			return json.Unmarshal([]byte(data), result)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "hello\n" || partial != `{"step":1}` || !result.Success || result.Output != "" {
+		t.Errorf("unexpected events: output %q, partial %q, result %+v", output, partial, result)
+	}
+
+	// workers are passed the requests of the HTTP trigger
+	httpReq := &HTTPRequest{Method: http.MethodPut, Path: "/", Body: []byte{0x00, 0xff}}
+	result = invokeTestHandler(t, &InvocationRequest{HTTPRequest: httpReq})
+	if !result.Success || result.HTTPResponse == nil || !bytes.Equal(result.HTTPResponse.Body, httpReq.Body) {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	// crashes are reported as errors of the executor, not of the function
+	body, _ = json.Marshal(&InvocationRequest{Params: map[string]interface{}{"crash": true}})
+	rec = httptest.NewRecorder()
+	InvokeHandler(rec, httptest.NewRequest(http.MethodPost, "/invoke", bytes.NewReader(body)))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("unexpected status upon worker crash: %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	StreamHandler(rec, httptest.NewRequest(http.MethodPost, "/invoke/stream", bytes.NewReader(body)))
+	var failed bool
+	_ = sse.ReadEvents(rec.Body, func(event string, data string) error {
+		failed = failed || event == EVENT_ERROR
+		return nil
+	})
+	if !failed {
+		t.Errorf("worker crash not reported while streaming")
+	}
+}
@@ -0,0 +1,66 @@
+package function
+
+import (
+	"fmt"
+	"time"
+)
+
+// States of asynchronous requests.
+const (
+	ASYNC_QUEUED    = "queued"    // accepted, waiting to be scheduled
+	ASYNC_RUNNING   = "running"   // being executed by the owner node
+	ASYNC_OFFLOADED = "offloaded" // handed over to another node
+	ASYNC_RETRYING  = "retrying"  // failed, waiting to be attempted again
+	ASYNC_SUCCEEDED = "succeeded"
+	ASYNC_FAILED    = "failed"  // could not be served (after exhausting its attempts)
+	ASYNC_EXPIRED   = "expired" // not completed in time (e.g., orphaned for too long)
+)
+
+// AsyncRequestStatus reports the state of an asynchronous request.
+type AsyncRequestStatus struct {
+	ReqId       string
+	Function    string
+	State       string
+	Owner       string `json:",omitempty"` // registry key of the node in charge of the request
+	OffloadedTo string `json:",omitempty"` // URL of the remote node, if offloaded
+	Accepted    time.Time
+	Updated     time.Time
+	Takeovers   int    `json:",omitempty"` // number of times the request has been picked up by another node
+	Attempts    int    `json:",omitempty"` // number of failed attempts
+	LastError   string `json:",omitempty"` // error of the last failed attempt
+}
+
+// IsTerminal returns true if the request has completed (in any way).
+func (s *AsyncRequestStatus) IsTerminal() bool {
+	return s.State == ASYNC_SUCCEEDED || s.State == ASYNC_FAILED || s.State == ASYNC_EXPIRED
+}
+
+// AsyncRequestRecord is the durable record of an asynchronous request, which
+// is persisted in etcd as soon as the request is accepted. Along with its
+// status, it carries all the information needed to submit the request again
+// if the owner node fails.
+type AsyncRequestRecord struct {
+	AsyncRequestStatus
+	Namespace       string
+	OwnerLease      int64  // registry lease of the owner (0: none)
+	Area            string // area of the owner
+	Params          map[string]interface{}
+	QoSClass        int64
+	QoSMaxRespT     float64
+	CanDoOffloading bool
+	ReturnOutput    bool
+	Callback        *Callback `json:",omitempty"`
+	ResultTTL       int64     `json:",omitempty"` // seconds
+}
+
+// AsyncRequestPrefix is the prefix of the etcd keys of async request records.
+const AsyncRequestPrefix = "asyncreq/"
+
+// AsyncRequestKey returns the etcd key of the record of an asynchronous
+// invocation.
+func AsyncRequestKey(namespace string, reqId string) string {
+	if namespace == "" || namespace == DEFAULT_NAMESPACE {
+		return fmt.Sprintf("%s%s", AsyncRequestPrefix, reqId)
+	}
+	return fmt.Sprintf("%s%s/%s", AsyncRequestPrefix, namespace, reqId)
+}
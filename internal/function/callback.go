@@ -0,0 +1,173 @@
+package function
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/config"
+)
+
+var CallbackTargetDeniedErr = errors.New("callback target not allowed")
+
+// Callback asks for the response of an asynchronous invocation to be POSTed
+// to a URL upon completion.
+type Callback struct {
+	URL    string
+	Secret string `json:",omitempty"` // key used to sign deliveries (optional)
+}
+
+// Headers of callback deliveries.
+const (
+	CALLBACK_REQUEST_ID_HEADER = "X-Serverledge-Request-Id"
+	CALLBACK_TIMESTAMP_HEADER  = "X-Serverledge-Timestamp" // Unix time of the delivery attempt
+	CALLBACK_SIGNATURE_HEADER  = "X-Serverledge-Signature" // only if a secret is given
+)
+
+// States of callback deliveries.
+const (
+	CALLBACK_PENDING   = "pending"
+	CALLBACK_DELIVERED = "delivered"
+	CALLBACK_FAILED    = "failed"
+)
+
+// CallbackStatus reports the state of the delivery of a callback.
+type CallbackStatus struct {
+	URL         string
+	State       string
+	Attempts    int
+	LastAttempt time.Time `json:",omitempty"`
+	StatusCode  int       `json:",omitempty"` // returned by the last attempt
+	LastError   string    `json:",omitempty"`
+}
+
+// Validate checks that the callback URL is an absolute HTTP(S) URL, whose
+// host may be reached by callbacks (see CheckCallbackTarget).
+func (cb *Callback) Validate() error {
+	u, err := url.Parse(cb.URL)
+	if err != nil {
+		return fmt.Errorf("invalid callback URL: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid callback URL: '%s' (absolute http or https URL expected)", cb.URL)
+	}
+	if err := CheckCallbackTarget(u.Hostname(), nil); err != nil {
+		return fmt.Errorf("invalid callback URL: '%s' (%v)", cb.URL, err)
+	}
+	return nil
+}
+
+// CheckCallbackTarget checks whether callbacks may be delivered to a host,
+// reached at the given address (nil if not resolved yet). Hosts and
+// addresses in the deny list are rejected, and so are internal addresses
+// (e.g., loopback, private and link-local ones), unless they are in the
+// allow list.
+func CheckCallbackTarget(host string, ip net.IP) error {
+	if ip == nil {
+		ip = net.ParseIP(host)
+	}
+	if matchesCallbackTarget(config.GetStringSlice(config.CALLBACK_DENY), host, ip) {
+		return fmt.Errorf("%w: %s", CallbackTargetDeniedErr, host)
+	}
+	if matchesCallbackTarget(config.GetStringSlice(config.CALLBACK_ALLOW), host, ip) {
+		return nil
+	}
+	if ip != nil && isInternalAddress(ip) {
+		return fmt.Errorf("%w: %s (internal address)", CallbackTargetDeniedErr, ip)
+	}
+	return nil
+}
+
+// internalNetworks may not be reached by callbacks, unless allowed.
+var internalNetworks = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "this" network
+	netip.MustParsePrefix("10.0.0.0/8"),     // private
+	netip.MustParsePrefix("100.64.0.0/10"),  // carrier-grade NAT
+	netip.MustParsePrefix("127.0.0.0/8"),    // loopback
+	netip.MustParsePrefix("169.254.0.0/16"), // link-local
+	netip.MustParsePrefix("172.16.0.0/12"),  // private
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("192.168.0.0/16"), // private
+	netip.MustParsePrefix("198.18.0.0/15"),  // benchmarking
+	netip.MustParsePrefix("224.0.0.0/4"),    // multicast
+	netip.MustParsePrefix("240.0.0.0/4"),    // reserved, broadcast
+	netip.MustParsePrefix("::/128"),         // unspecified
+	netip.MustParsePrefix("::1/128"),        // loopback
+	netip.MustParsePrefix("fc00::/7"),       // unique local
+	netip.MustParsePrefix("fe80::/10"),      // link-local
+	netip.MustParsePrefix("ff00::/8"),       // multicast
+}
+
+// IPv6 networks embedding IPv4 addresses.
+var (
+	nat64Network = netip.MustParsePrefix("64:ff9b::/96") // address in the last 4 bytes
+	sixToFour    = netip.MustParsePrefix("2002::/16")    // address in bytes 2-5
+)
+
+// isInternalAddress returns true if the address belongs to an internal
+// network, including IPv6 addresses which map or embed internal IPv4 ones.
+func isInternalAddress(ip net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+	if b := addr.As16(); nat64Network.Contains(addr) {
+		addr = netip.AddrFrom4([4]byte{b[12], b[13], b[14], b[15]})
+	} else if sixToFour.Contains(addr) {
+		addr = netip.AddrFrom4([4]byte{b[2], b[3], b[4], b[5]})
+	}
+	for _, network := range internalNetworks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesCallbackTarget returns true if any entry (a host name, an IP
+// address or a CIDR network) matches the host or its address.
+func matchesCallbackTarget(entries []string, host string, ip net.IP) bool {
+	for _, entry := range entries {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+		} else if addr := net.ParseIP(entry); addr != nil {
+			if ip != nil && addr.Equal(ip) {
+				return true
+			}
+		} else if strings.EqualFold(entry, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// SignCallback computes the signature of a callback delivery, i.e., the
+// HMAC-SHA256 of "<timestamp>.<body>" keyed by the secret, in the form
+// "sha256=<hex digest>". Receivers should compute it again and compare it
+// with the signature header, and reject old timestamps.
+func SignCallback(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// CallbackStatusKey returns the etcd key of the delivery status of the
+// callback of an asynchronous invocation.
+func CallbackStatusKey(namespace string, reqId string) string {
+	if namespace == "" || namespace == DEFAULT_NAMESPACE {
+		return fmt.Sprintf("callback/%s", reqId)
+	}
+	return fmt.Sprintf("callback/%s/%s", namespace, reqId)
+}
@@ -0,0 +1,83 @@
+package function
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/spf13/viper"
+)
+
+func TestCheckCallbackTarget(t *testing.T) {
+	viper.Set(config.CALLBACK_ALLOW, "192.168.10.0/24,hooks.internal")
+	viper.Set(config.CALLBACK_DENY, "evil.example.com,203.0.113.7")
+	defer func() {
+		viper.Set(config.CALLBACK_ALLOW, "")
+		viper.Set(config.CALLBACK_DENY, "")
+	}()
+
+	tests := []struct {
+		host    string
+		ip      string // resolved address (if any)
+		allowed bool
+	}{
+		{"example.com", "", true},
+		{"8.8.8.8", "", true},
+		{"2001:4860:4860::8888", "", true},
+		{"example.com", "93.184.216.34", true},
+		// internal IPv4 networks
+		{"0.1.2.3", "", false},
+		{"10.1.2.3", "", false},
+		{"100.64.0.1", "", false},
+		{"100.127.255.254", "", false},
+		{"127.0.0.1", "", false},
+		{"169.254.169.254", "", false},
+		{"172.16.0.1", "", false},
+		{"192.168.1.1", "", false},
+		{"224.0.0.1", "", false},
+		{"255.255.255.255", "", false},
+		{"100.128.0.1", "", true},
+		// internal IPv6 networks
+		{"::", "", false},
+		{"::1", "", false},
+		{"fd00::1", "", false},
+		{"fe80::1", "", false},
+		{"ff02::1", "", false},
+		// IPv4-mapped addresses
+		{"::ffff:127.0.0.1", "", false},
+		{"::ffff:10.0.0.1", "", false},
+		{"::ffff:100.64.0.1", "", false},
+		{"::ffff:8.8.8.8", "", true},
+		// NAT64
+		{"64:ff9b::7f00:1", "", false},
+		{"64:ff9b::a9fe:a9fe", "", false},
+		{"64:ff9b::808:808", "", true},
+		// 6to4
+		{"2002:7f00:1::1", "", false},
+		{"2002:c0a8:101::1", "", false},
+		{"2002:808:808::1", "", true},
+		// addresses resolved when connecting
+		{"example.com", "127.0.0.1", false},
+		{"example.com", "::ffff:169.254.169.254", false},
+		// allow and deny lists
+		{"192.168.10.5", "", true},
+		{"::ffff:192.168.10.5", "", true},
+		{"hooks.internal", "10.0.0.1", true},
+		{"evil.example.com", "", false},
+		{"203.0.113.7", "", false},
+		{"::ffff:203.0.113.7", "", false},
+	}
+	for _, test := range tests {
+		var ip net.IP
+		if test.ip != "" {
+			ip = net.ParseIP(test.ip)
+		}
+		err := CheckCallbackTarget(test.host, ip)
+		if test.allowed && err != nil {
+			t.Errorf("%s (%s) rejected: %v", test.host, test.ip, err)
+		} else if !test.allowed && !errors.Is(err, CallbackTargetDeniedErr) {
+			t.Errorf("%s (%s) allowed", test.host, test.ip)
+		}
+	}
+}
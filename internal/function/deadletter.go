@@ -0,0 +1,151 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grussorusso/serverledge/utils"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+var DeadLetterNotFoundErr = errors.New("unknown dead letter")
+
+// DeadLetter is an asynchronous request which has failed after exhausting
+// its attempts. It is kept along with its parameters, so that it can be
+// inspected and submitted again (re-driven).
+type DeadLetter struct {
+	AsyncRequestRecord
+	ErrorClass string // class of the error of the last attempt
+	Failed     time.Time
+}
+
+// Redacted returns a copy of the dead letter without the callback secret,
+// which is only used to sign deliveries (e.g., once re-driven) and must not
+// be disclosed.
+func (l DeadLetter) Redacted() DeadLetter {
+	if l.Callback != nil {
+		l.Callback = &Callback{URL: l.Callback.URL}
+	}
+	return l
+}
+
+// DeadLetterPrefix is the prefix of the etcd keys of dead letters.
+const DeadLetterPrefix = "deadletter/"
+
+// DeadLetterKey returns the etcd key of the dead letter of an asynchronous
+// invocation.
+func DeadLetterKey(namespace string, reqId string) string {
+	if namespace == "" || namespace == DEFAULT_NAMESPACE {
+		return fmt.Sprintf("%s%s", DeadLetterPrefix, reqId)
+	}
+	return fmt.Sprintf("%s%s/%s", DeadLetterPrefix, namespace, reqId)
+}
+
+// SaveToEtcd stores the dead letter, which expires after ttl (if positive).
+func (l *DeadLetter) SaveToEtcd(ttl time.Duration) error {
+	cli, err := utils.GetEtcdClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	payload, err := json.Marshal(*l)
+	if err != nil {
+		return fmt.Errorf("Could not marshal dead letter: %v", err)
+	}
+	lease := clientv3.NoLease
+	if ttl > 0 {
+		resp, err := cli.Grant(ctx, int64(math.Max(1, math.Ceil(ttl.Seconds()))))
+		if err != nil {
+			return err
+		}
+		lease = resp.ID
+	}
+	_, err = cli.Put(ctx, DeadLetterKey(l.Namespace, l.ReqId), string(payload), clientv3.WithLease(lease))
+	if err != nil {
+		return fmt.Errorf("Failed Put: %v", err)
+	}
+	return nil
+}
+
+// Delete removes the dead letter. DeadLetterNotFoundErr is returned if it
+// does not exist (anymore), e.g., if it has been re-driven meanwhile.
+func (l *DeadLetter) Delete() error {
+	cli, err := utils.GetEtcdClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dresp, err := cli.Delete(ctx, DeadLetterKey(l.Namespace, l.ReqId))
+	if err != nil {
+		return fmt.Errorf("Failed Delete: %v", err)
+	}
+	if dresp.Deleted != 1 {
+		return DeadLetterNotFoundErr
+	}
+	return nil
+}
+
+// GetDeadLetter retrieves the dead letter of a request in a namespace.
+func GetDeadLetter(namespace string, reqId string) (*DeadLetter, error) {
+	if reqId == "" || strings.Contains(reqId, "/") {
+		return nil, DeadLetterNotFoundErr
+	}
+	cli, err := utils.GetEtcdClient()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := cli.Get(ctx, DeadLetterKey(namespace, reqId))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) != 1 {
+		return nil, DeadLetterNotFoundErr
+	}
+	var letter DeadLetter
+	if err := json.Unmarshal(resp.Kvs[0].Value, &letter); err != nil {
+		return nil, err
+	}
+	return &letter, nil
+}
+
+// GetDeadLetters returns the dead letters of a namespace (e.g.,
+// DEFAULT_NAMESPACE), most recent first.
+func GetDeadLetters(namespace string) ([]DeadLetter, error) {
+	cli, err := utils.GetEtcdClient()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the keys of the default namespace are a prefix of the others
+	resp, err := cli.Get(ctx, DeadLetterKey(namespace, ""), clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	letters := make([]DeadLetter, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		var letter DeadLetter
+		if err := json.Unmarshal(kv.Value, &letter); err != nil {
+			continue
+		}
+		if letter.Namespace == namespace {
+			letters = append(letters, letter)
+		}
+	}
+	sort.Slice(letters, func(i, j int) bool { return letters[i].Failed.After(letters[j].Failed) })
+	return letters, nil
+}
@@ -14,23 +14,50 @@ import (
 // Function describes a serverless function.
 type Function struct {
 	Name            string
-	Runtime         string  // example: python310
-	MemoryMB        int64   // MB
-	CPUDemand       float64 // 1.0 -> 1 core
-	Handler         string  // example: "module.function_name"
-	TarFunctionCode string  // input is .tar
-	CustomImage     string  // used if custom runtime is chosen
+	Namespace       string       `json:",omitempty"` // default namespace if empty
+	Runtime         string       // example: python310
+	MemoryMB        int64        // MB
+	CPUDemand       float64      // 1.0 -> 1 core
+	Handler         string       // example: "module.function_name"
+	TarFunctionCode string       // input is .tar
+	CustomImage     string       // used if custom runtime is chosen
+	MaxConcurrency  int          // max concurrent invocations per container (default: 1)
+	AsyncResultTTL  int64        `json:",omitempty"` // seconds the results of async invocations are kept (0: default)
+	Retry           *RetryPolicy `json:",omitempty"` // retries of failed async invocations (default: none)
+}
+
+// GetMaxConcurrency returns the number of invocations that can be
+// concurrently served by a single container of the function.
+func (f *Function) GetMaxConcurrency() int {
+	if f.MaxConcurrency < 1 {
+		return 1
+	}
+	return f.MaxConcurrency
+}
+
+// GetNamespace returns the namespace of the function.
+func (f *Function) GetNamespace() string {
+	if f.Namespace == "" {
+		return DEFAULT_NAMESPACE
+	}
+	return f.Namespace
+}
+
+// Id returns the identifier of the function, which is unique across
+// namespaces (see QualifiedName).
+func (f *Function) Id() string {
+	return QualifiedName(f.GetNamespace(), f.Name)
 }
 
 func (f *Function) getEtcdKey() string {
-	return getEtcdKey(f.Name)
+	return getEtcdKey(f.Id())
 }
 
-func getEtcdKey(funcName string) string {
-	return fmt.Sprintf("/function/%s", funcName)
+func getEtcdKey(funcId string) string {
+	return fmt.Sprintf("/function/%s", funcId)
 }
 
-// GetFunction retrieves a Function given its name.
+// GetFunction retrieves a Function given its identifier (see QualifiedName).
 func GetFunction(name string) (*Function, bool) {
 
 	val, found := getFromCache(name)
@@ -50,7 +77,7 @@ func GetFunction(name string) (*Function, bool) {
 }
 
 func (f *Function) String() string {
-	return f.Name
+	return f.Id()
 }
 
 func getFromCache(name string) (*Function, bool) {
@@ -103,7 +130,7 @@ func (f *Function) SaveToEtcd() error {
 	}
 
 	// Add the function to the local cache
-	cache.GetCacheInstance().Set(f.Name, f, cache.DefaultExp)
+	cache.GetCacheInstance().Set(f.Id(), f, cache.DefaultExp)
 
 	return nil
 }
@@ -122,11 +149,12 @@ func (f *Function) Delete() error {
 	}
 
 	// Remove the function from the local cache
-	cache.GetCacheInstance().Delete(f.Name)
+	cache.GetCacheInstance().Delete(f.Id())
 
 	return nil
 }
 
+// GetAll returns the identifiers of all the functions, in any namespace.
 func GetAll() ([]string, error) {
 	cli, err := utils.GetEtcdClient()
 	if err != nil {
@@ -146,3 +174,20 @@ func GetAll() ([]string, error) {
 
 	return functions, nil
 }
+
+// GetAllInNamespace returns the names of the functions in a namespace.
+func GetAllInNamespace(namespace string) ([]string, error) {
+	all, err := GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	functions := make([]string, 0)
+	for _, id := range all {
+		ns, name := SplitQualifiedName(id)
+		if ns == namespace {
+			functions = append(functions, name)
+		}
+	}
+	return functions, nil
+}
@@ -0,0 +1,19 @@
+package function
+
+// HTTPRequest is the raw HTTP request that triggered an invocation through
+// the HTTP trigger.
+type HTTPRequest struct {
+	Method  string
+	Path    string // relative to the function endpoint
+	Query   string // raw query string
+	Headers map[string][]string
+	Body    []byte
+}
+
+// HTTPResponse is the raw HTTP response returned by a function invoked
+// through the HTTP trigger, which is relayed verbatim to the client.
+type HTTPResponse struct {
+	StatusCode int
+	Headers    map[string][]string
+	Body       []byte
+}
@@ -0,0 +1,254 @@
+package function
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grussorusso/serverledge/utils"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"golang.org/x/net/context"
+)
+
+// Namespaces scope function names (and the results of their asynchronous
+// invocations). Functions in the default namespace are identified by their
+// name, the others by <namespace>/<name>. The default namespace always
+// exists, the others must be created before use.
+const DEFAULT_NAMESPACE = "default"
+
+// NAMESPACE_HEADER selects the namespace of API requests (default namespace
+// if missing).
+const NAMESPACE_HEADER = "X-Serverledge-Namespace"
+
+var namespaceRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
+var NamespaceNotFoundErr = errors.New("unknown namespace")
+
+// Quota limits the resources used by the functions of a namespace. Zero
+// values mean no limit.
+type Quota struct {
+	MaxFunctions      int     // number of functions
+	MaxContainers     int     // concurrent containers on each node
+	MaxMemoryMB       int64   // total memory of the containers on each node
+	MaxInvocationRate float64 // invocations per second on each node
+}
+
+// Namespace describes a namespace and its quota.
+type Namespace struct {
+	Name  string
+	Quota Quota
+}
+
+const namespacesEtcdPrefix = "/namespace/"
+
+// namespaces is the local copy of the namespaces, kept in sync with etcd
+var namespaces = make(map[string]Namespace)
+var namespacesLock sync.RWMutex
+
+// QualifiedName returns the identifier of the function with the given name
+// in a namespace.
+func QualifiedName(namespace string, name string) string {
+	if namespace == "" || namespace == DEFAULT_NAMESPACE {
+		return name
+	}
+	return namespace + "/" + name
+}
+
+// SplitQualifiedName returns the namespace and the name of the function
+// with the given identifier.
+func SplitQualifiedName(id string) (string, string) {
+	if ns, name, ok := strings.Cut(id, "/"); ok {
+		return ns, name
+	}
+	return DEFAULT_NAMESPACE, id
+}
+
+// ValidateNamespaceName checks that a namespace name is well-formed.
+func ValidateNamespaceName(name string) error {
+	if !namespaceRegex.MatchString(name) {
+		return fmt.Errorf("invalid namespace name: '%s' (lowercase letters, digits and '-' only)", name)
+	}
+	return nil
+}
+
+// AsyncResultKey returns the etcd key of the result of an asynchronous
+// invocation.
+func AsyncResultKey(namespace string, reqId string) string {
+	if namespace == "" || namespace == DEFAULT_NAMESPACE {
+		return fmt.Sprintf("async/%s", reqId)
+	}
+	return fmt.Sprintf("async/%s/%s", namespace, reqId)
+}
+
+func getNamespaceEtcdKey(name string) string {
+	return namespacesEtcdPrefix + name
+}
+
+// GetNamespace retrieves a namespace.
+func GetNamespace(name string) (Namespace, bool) {
+	namespacesLock.RLock()
+	defer namespacesLock.RUnlock()
+	ns, ok := namespaces[name]
+	if !ok && name == DEFAULT_NAMESPACE {
+		return Namespace{Name: DEFAULT_NAMESPACE}, true
+	}
+	return ns, ok
+}
+
+// GetAllNamespaces returns the namespaces, sorted by name.
+func GetAllNamespaces() []Namespace {
+	namespacesLock.RLock()
+	defer namespacesLock.RUnlock()
+	list := make([]Namespace, 0, len(namespaces)+1)
+	if _, ok := namespaces[DEFAULT_NAMESPACE]; !ok {
+		list = append(list, Namespace{Name: DEFAULT_NAMESPACE})
+	}
+	for _, ns := range namespaces {
+		list = append(list, ns)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// SaveToEtcd creates (or updates) the namespace.
+func (ns *Namespace) SaveToEtcd() error {
+	cli, err := utils.GetEtcdClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	payload, err := json.Marshal(*ns)
+	if err != nil {
+		return fmt.Errorf("Could not marshal namespace: %v", err)
+	}
+	_, err = cli.Put(ctx, getNamespaceEtcdKey(ns.Name), string(payload))
+	if err != nil {
+		return fmt.Errorf("Failed Put: %v", err)
+	}
+
+	// update the local copy without waiting for the watcher
+	setNamespace(*ns)
+	return nil
+}
+
+// DeleteNamespace removes a namespace.
+func DeleteNamespace(name string) error {
+	cli, err := utils.GetEtcdClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	dresp, err := cli.Delete(ctx, getNamespaceEtcdKey(name))
+	if err != nil {
+		return fmt.Errorf("Failed Delete: %v", err)
+	}
+	removeNamespace(name)
+	if dresp.Deleted != 1 && name != DEFAULT_NAMESPACE {
+		return NamespaceNotFoundErr
+	}
+	return nil
+}
+
+func setNamespace(ns Namespace) {
+	namespacesLock.Lock()
+	defer namespacesLock.Unlock()
+	namespaces[ns.Name] = ns
+}
+
+func removeNamespace(name string) {
+	namespacesLock.Lock()
+	defer namespacesLock.Unlock()
+	delete(namespaces, name)
+}
+
+// InitNamespaces loads the namespaces from etcd and keeps them in sync in the
+// background.
+func InitNamespaces() error {
+	cli, err := utils.GetEtcdClient()
+	if err != nil {
+		return err
+	}
+
+	rev, err := loadNamespaces(cli)
+	if err != nil {
+		return err
+	}
+
+	go watchNamespaces(cli, rev)
+	return nil
+}
+
+// loadNamespaces replaces the local namespaces with the content of etcd and
+// returns the etcd revision it corresponds to.
+func loadNamespaces(cli *clientv3.Client) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	resp, err := cli.Get(ctx, namespacesEtcdPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return 0, fmt.Errorf("Could not read from etcd: %v", err)
+	}
+
+	loaded := make(map[string]Namespace)
+	for _, kv := range resp.Kvs {
+		var ns Namespace
+		if err := json.Unmarshal(kv.Value, &ns); err != nil {
+			log.Printf("Ignoring malformed namespace %s: %v\n", kv.Key, err)
+			continue
+		}
+		loaded[ns.Name] = ns
+	}
+
+	namespacesLock.Lock()
+	namespaces = loaded
+	namespacesLock.Unlock()
+
+	return resp.Header.Revision, nil
+}
+
+func watchNamespaces(cli *clientv3.Client, rev int64) {
+	for {
+		watchChan := cli.Watch(context.Background(), namespacesEtcdPrefix, clientv3.WithPrefix(),
+			clientv3.WithRev(rev+1))
+		for wresp := range watchChan {
+			if wresp.Err() != nil {
+				log.Printf("Namespaces watch failed: %v\n", wresp.Err())
+				break
+			}
+			for _, ev := range wresp.Events {
+				name := string(ev.Kv.Key)[len(namespacesEtcdPrefix):]
+				if ev.Type == clientv3.EventTypeDelete {
+					removeNamespace(name)
+					continue
+				}
+				var ns Namespace
+				if err := json.Unmarshal(ev.Kv.Value, &ns); err != nil {
+					log.Printf("Ignoring malformed namespace %s: %v\n", name, err)
+					continue
+				}
+				setNamespace(ns)
+			}
+			rev = wresp.Header.Revision
+		}
+
+		if cli.Ctx().Err() != nil {
+			return // the client has been closed
+		}
+		// the watch has been interrupted (e.g., history compacted): reload
+		// all the namespaces and start again
+		time.Sleep(1 * time.Second)
+		if newRev, err := loadNamespaces(cli); err == nil {
+			rev = newRev
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package function
 
 import (
+	"context"
 	"fmt"
+	"math"
 	"time"
 )
 
@@ -11,10 +13,27 @@ type Request struct {
 	Fun     *Function
 	Params  map[string]interface{}
 	Arrival time.Time
+	Ctx     context.Context // cancelled if the client is no longer interested
 	RequestQoS
 	CanDoOffloading bool
 	Async           bool
 	ReturnOutput    bool
+	Retries         int // number of times the request has been scheduled again
+	// HTTPRequest (if not nil) is the raw request received by the HTTP
+	// trigger, which is passed to the function in place of Params
+	HTTPRequest *HTTPRequest
+	// Stream (if not nil) receives the function output and partial results
+	// while the function is running
+	Stream func(event string, data string)
+	// Callback (if not nil) receives the response of async requests
+	Callback *Callback
+	// ResultTTL is how long the result of async requests is kept (0: the
+	// function or configured default)
+	ResultTTL time.Duration
+	// Attempts is the number of failed attempts of async requests (see
+	// RetryPolicy), and LastError the error of the last one
+	Attempts  int
+	LastError string
 }
 
 type RequestQoS struct {
@@ -29,8 +48,35 @@ type ExecutionReport struct {
 	InitTime       float64
 	OffloadLatency float64
 	Duration       float64
+	ReadinessTime  float64
 	SchedAction    string
 	Output         string
+	Usage          *ResourceUsage `json:",omitempty"`
+	HTTPResponse   *HTTPResponse  `json:",omitempty"` // HTTP trigger only
+}
+
+// ResourceUsage reports the resources used to serve an invocation, as far as
+// they are known.
+type ResourceUsage struct {
+	CPUUserTime       float64 // handler process (seconds)
+	CPUSystemTime     float64 // handler process (seconds)
+	MaxRSSKB          int64   // handler process
+	ExitCode          int     // handler process
+	ContainerCPUTime  float64 // CPU time consumed by the container during the invocation (seconds)
+	ContainerMemoryMB float64 // memory used by the container after the invocation
+}
+
+// CPUTime returns the CPU time spent serving the invocation.
+func (u *ResourceUsage) CPUTime() float64 {
+	if u.ContainerCPUTime > 0 {
+		return u.ContainerCPUTime
+	}
+	return u.CPUUserTime + u.CPUSystemTime
+}
+
+// MemoryMB returns the memory used to serve the invocation.
+func (u *ResourceUsage) MemoryMB() float64 {
+	return math.Max(u.ContainerMemoryMB, float64(u.MaxRSSKB)/1024.0)
 }
 
 type Response struct {
@@ -42,8 +88,21 @@ type AsyncResponse struct {
 	ReqId string
 }
 
+// Context returns the request context, which is never nil.
+func (r *Request) Context() context.Context {
+	if r.Ctx == nil {
+		return context.Background()
+	}
+	return r.Ctx
+}
+
+// IsCancelled returns true if the request context has been cancelled.
+func (r *Request) IsCancelled() bool {
+	return r.Ctx != nil && r.Ctx.Err() != nil
+}
+
 func (r *Request) String() string {
-	return fmt.Sprintf("[%s] Rq-%s", r.Fun.Name, r.ReqId)
+	return fmt.Sprintf("[%s] Rq-%s", r.Fun, r.ReqId)
 }
 
 type ServiceClass int64
@@ -0,0 +1,90 @@
+package function
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Classes of errors of asynchronous invocations, which retry policies refer
+// to.
+const (
+	ERROR_CLASS_RESOURCES = "resources" // not enough resources to serve the request
+	ERROR_CLASS_OFFLOAD   = "offload"   // the request could not be offloaded
+	ERROR_CLASS_CONTAINER = "container" // the container failed (e.g., it stopped responding)
+	ERROR_CLASS_FUNCTION  = "function"  // the function failed (e.g., it raised an error)
+)
+
+var errorClasses = []string{ERROR_CLASS_RESOURCES, ERROR_CLASS_OFFLOAD, ERROR_CLASS_CONTAINER, ERROR_CLASS_FUNCTION}
+
+// Defaults of retry policies.
+const (
+	DEFAULT_RETRY_BACKOFF     = 1.0   // seconds
+	DEFAULT_RETRY_MAX_BACKOFF = 300.0 // seconds
+)
+
+// RetryPolicy controls how failed asynchronous invocations of a function are
+// attempted again. Without a policy, invocations are attempted once.
+type RetryPolicy struct {
+	MaxAttempts int      // attempts, including the first one
+	Backoff     float64  `json:",omitempty"` // seconds before the first retry, doubled upon each further retry (default: 1)
+	MaxBackoff  float64  `json:",omitempty"` // max seconds between attempts (default: 300)
+	RetryOn     []string `json:",omitempty"` // error classes that are retried (default: all)
+}
+
+// Validate checks that the policy is well-formed.
+func (p *RetryPolicy) Validate() error {
+	if p.MaxAttempts < 1 {
+		return fmt.Errorf("invalid MaxAttempts: %d (at least 1 expected)", p.MaxAttempts)
+	}
+	if p.Backoff < 0 || p.MaxBackoff < 0 {
+		return fmt.Errorf("invalid backoff: negative")
+	}
+	for _, class := range p.RetryOn {
+		if !isErrorClass(class) {
+			return fmt.Errorf("unknown error class: '%s' (expected one of %v)", class, errorClasses)
+		}
+	}
+	return nil
+}
+
+func isErrorClass(class string) bool {
+	for _, c := range errorClasses {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// ShouldRetry returns true if an invocation which has failed with an error
+// of the given class, after the given number of attempts, can be attempted
+// again.
+func (p *RetryPolicy) ShouldRetry(class string, attempts int) bool {
+	if attempts >= p.MaxAttempts {
+		return false
+	}
+	if len(p.RetryOn) == 0 {
+		return true
+	}
+	for _, c := range p.RetryOn {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// Delay returns the time to wait before attempting an invocation again,
+// after the given number of attempts.
+func (p *RetryPolicy) Delay(attempts int) time.Duration {
+	backoff, maxBackoff := p.Backoff, p.MaxBackoff
+	if backoff == 0 {
+		backoff = DEFAULT_RETRY_BACKOFF
+	}
+	if maxBackoff == 0 {
+		maxBackoff = DEFAULT_RETRY_MAX_BACKOFF
+	}
+	delay := math.Min(backoff*math.Pow(2, float64(attempts-1)), maxBackoff)
+	return time.Duration(delay * float64(time.Second))
+}
@@ -0,0 +1,387 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/api"
+	"github.com/grussorusso/serverledge/internal/client"
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/grussorusso/serverledge/internal/node"
+	"github.com/grussorusso/serverledge/internal/scheduling"
+	"github.com/grussorusso/serverledge/internal/sse"
+	"github.com/grussorusso/serverledge/utils"
+	"github.com/spf13/viper"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestAsyncInvocationAndPoll(t *testing.T) {
+	f := &function.Function{Name: "async-fn", Runtime: "python310", MemoryMB: 128, Handler: "h.handler"}
+	createFunction(t, f)
+
+	resp := postJson(t, testNode.URL+"/invoke/"+f.Name, client.InvocationRequest{
+		Params: map[string]interface{}{"x": "y"},
+		Async:  true})
+	var asyncResp function.AsyncResponse
+	decode(t, resp, &asyncResp)
+	if asyncResp.ReqId == "" {
+		t.Fatalf("no request ID returned")
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		pollResp, err := http.Get(testNode.URL + "/poll/" + asyncResp.ReqId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pollResp.StatusCode == http.StatusOK {
+			var response function.Response
+			decode(t, pollResp, &response)
+			if !response.Success || response.Result != `{"x":"y"}` {
+				t.Errorf("unexpected async response: %+v", response)
+			}
+			return
+		}
+		pollResp.Body.Close()
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("async result not available")
+}
+
+func TestAsyncWaitAndSubscribe(t *testing.T) {
+	etcdClient, err := utils.GetEtcdClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish := func(reqId string, result string) {
+		payload, _ := json.Marshal(function.Response{Success: true, ExecutionReport: function.ExecutionReport{Result: result}})
+		if _, err := etcdClient.Put(context.Background(), function.AsyncResultKey("", reqId), string(payload)); err != nil {
+			t.Error(err)
+		}
+	}
+	t.Cleanup(func() {
+		_, _ = etcdClient.Delete(context.Background(), function.AsyncResultKey("", "wait-"), clientv3.WithPrefix())
+	})
+
+	// long polling returns as soon as the result is published
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		publish("wait-1", "one")
+	}()
+	start := time.Now()
+	pollResp, err := http.Get(testNode.URL + "/poll/wait-1?wait=10s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response function.Response
+	decode(t, pollResp, &response)
+	if pollResp.StatusCode != http.StatusOK || response.Result != "one" || time.Since(start) > 5*time.Second {
+		t.Errorf("unexpected long poll response: %s %+v", pollResp.Status, response)
+	}
+
+	pollResp, err = http.Get(testNode.URL + "/poll/wait-missing?wait=200ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pollResp.Body.Close()
+	if pollResp.StatusCode != http.StatusNotFound {
+		t.Errorf("unexpected response for a missing result: %s", pollResp.Status)
+	}
+	expectV2Error(t, v2Request(t, http.MethodGet, "/invocations/wait-1?wait=soon", nil), http.StatusBadRequest, api.ERR_INVALID_REQUEST)
+
+	// subscribers receive the available results first, then the new ones
+	publish("wait-2", "two")
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		publish("wait-3", "three")
+	}()
+	resp, err := http.Get(testNode.URL + "/subscribe?reqId=wait-2,wait-3&reqId=wait-4&wait=2s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("subscription failed: %s", resp.Status)
+	}
+	var received []string
+	var pending []string
+	err = sse.ReadEvents(resp.Body, func(event string, data string) error {
+		switch event {
+		case client.EVENT_ASYNC_RESULT:
+			var result client.AsyncResultEvent
+			if err := json.Unmarshal([]byte(data), &result); err != nil {
+				return err
+			}
+			var response function.Response
+			if err := json.Unmarshal(result.Result, &response); err != nil {
+				return err
+			}
+			received = append(received, result.ReqId+":"+response.Result)
+		case client.EVENT_ASYNC_TIMEOUT:
+			return json.Unmarshal([]byte(data), &pending)
+		case client.EVENT_ASYNC_ERROR:
+			return fmt.Errorf("%s", data)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(received) != "[wait-2:two wait-3:three]" || fmt.Sprint(pending) != "[wait-4]" {
+		t.Errorf("unexpected events: received %v, pending %v", received, pending)
+	}
+}
+
+// waitForTerminalState waits for an async request to complete, as its state is
+// updated right after the result is published.
+func waitForTerminalState(t *testing.T, reqId string) function.AsyncRequestStatus {
+	var status function.AsyncRequestStatus
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		decode(t, v2Request(t, http.MethodGet, "/invocations/"+reqId+"/status", nil), &status)
+		if status.IsTerminal() {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return status
+}
+
+func TestAsyncLifecycle(t *testing.T) {
+	f := &function.Function{Name: "lifecycle-fn", Runtime: "python310", MemoryMB: 128, Handler: "h.handler"}
+	createFunction(t, f)
+
+	testNode.Factory.ExecLatency = 500 * time.Millisecond
+	defer func() { testNode.Factory.ExecLatency = 0 }()
+
+	resp := postJson(t, testNode.URL+"/invoke/"+f.Name, client.InvocationRequest{Async: true})
+	var asyncResp function.AsyncResponse
+	decode(t, resp, &asyncResp)
+
+	// the request is known while pending
+	pollResp, err := http.Get(testNode.URL + "/poll/" + asyncResp.ReqId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status function.AsyncRequestStatus
+	decode(t, pollResp, &status)
+	if pollResp.StatusCode != http.StatusAccepted || status.IsTerminal() || status.Owner != node.NodeIdentifier {
+		t.Errorf("unexpected status of a pending request: %s %+v", pollResp.Status, status)
+	}
+
+	pollResp, err = http.Get(testNode.URL + "/poll/" + asyncResp.ReqId + "?wait=10s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pollResp.Body.Close()
+	if pollResp.StatusCode != http.StatusOK {
+		t.Fatalf("async result not available: %s", pollResp.Status)
+	}
+	status = waitForTerminalState(t, asyncResp.ReqId)
+	if status.State != function.ASYNC_SUCCEEDED || status.Function != f.Name || status.Owner != node.NodeIdentifier {
+		t.Errorf("unexpected status of a completed request: %+v", status)
+	}
+	expectV2Error(t, v2Request(t, http.MethodGet, "/invocations/unknown-req/status", nil), http.StatusNotFound, api.ERR_INVOCATION_NOT_FOUND)
+}
+
+func TestOrphanedAsyncRequests(t *testing.T) {
+	f := &function.Function{Name: "orphan-fn", Runtime: "python310", MemoryMB: 128, Handler: "h.handler"}
+	createFunction(t, f)
+
+	etcdClient, err := utils.GetEtcdClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	t.Cleanup(func() {
+		_, _ = etcdClient.Delete(ctx, function.AsyncRequestKey("", "recovery-"), clientv3.WithPrefix())
+		_, _ = etcdClient.Delete(ctx, function.AsyncResultKey("", "recovery-"), clientv3.WithPrefix())
+	})
+
+	// the lease of a failed node has expired
+	deadLease, err := etcdClient.Grant(ctx, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = etcdClient.Revoke(ctx, deadLease.ID); err != nil {
+		t.Fatal(err)
+	}
+	liveLease, err := etcdClient.Grant(ctx, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _, _ = etcdClient.Revoke(ctx, liveLease.ID) })
+
+	save := func(reqId string, state string, lease clientv3.LeaseID, area string, accepted time.Time) {
+		record := function.AsyncRequestRecord{
+			AsyncRequestStatus: function.AsyncRequestStatus{ReqId: reqId, Function: f.Name, State: state,
+				Owner: "registry/" + area + "/other", Accepted: accepted, Updated: accepted},
+			Namespace:  function.DEFAULT_NAMESPACE,
+			OwnerLease: int64(lease),
+			Area:       area,
+			Params:     map[string]interface{}{"req": reqId},
+		}
+		payload, _ := json.Marshal(record)
+		if _, err := etcdClient.Put(ctx, function.AsyncRequestKey("", reqId), string(payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	save("recovery-orphan", function.ASYNC_RUNNING, deadLease.ID, AREA, time.Now())
+	save("recovery-old", function.ASYNC_RUNNING, deadLease.ID, AREA, time.Now().Add(-2*time.Hour))
+	save("recovery-alive", function.ASYNC_RUNNING, liveLease.ID, AREA, time.Now())
+	save("recovery-other-area", function.ASYNC_RUNNING, deadLease.ID, "elsewhere", time.Now())
+	// the owner failed before the remote node accepted the request
+	save("recovery-offloaded", function.ASYNC_OFFLOADED, deadLease.ID, AREA, time.Now())
+	save("recovery-offloaded-alive", function.ASYNC_OFFLOADED, liveLease.ID, AREA, time.Now())
+
+	if recovered := scheduling.RecoverOrphanedRequests(); recovered != 3 {
+		t.Errorf("unexpected number of recovered requests: %d", recovered)
+	}
+	if recovered := scheduling.RecoverOrphanedRequests(); recovered != 0 {
+		t.Errorf("requests recovered twice: %d", recovered)
+	}
+
+	// the orphaned request is executed again by this node
+	pollResp, err := http.Get(testNode.URL + "/poll/recovery-orphan?wait=10s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response function.Response
+	decode(t, pollResp, &response)
+	if pollResp.StatusCode != http.StatusOK || !response.Success || response.Result != `{"req":"recovery-orphan"}` {
+		t.Errorf("unexpected response of the recovered request: %s %+v", pollResp.Status, response)
+	}
+
+	expected := map[string]string{
+		"recovery-orphan":          function.ASYNC_SUCCEEDED,
+		"recovery-old":             function.ASYNC_EXPIRED,
+		"recovery-alive":           function.ASYNC_RUNNING,
+		"recovery-other-area":      function.ASYNC_RUNNING,
+		"recovery-offloaded":       function.ASYNC_SUCCEEDED,
+		"recovery-offloaded-alive": function.ASYNC_OFFLOADED,
+	}
+	for reqId, state := range expected {
+		var status function.AsyncRequestStatus
+		takenOver := state != function.ASYNC_RUNNING && state != function.ASYNC_OFFLOADED
+		if !takenOver {
+			decode(t, v2Request(t, http.MethodGet, "/invocations/"+reqId+"/status", nil), &status)
+		} else {
+			status = waitForTerminalState(t, reqId)
+		}
+		if status.State != state {
+			t.Errorf("unexpected state of %s: %+v", reqId, status)
+		}
+		if takenOver != (status.Owner == node.NodeIdentifier && status.Takeovers == 1) {
+			t.Errorf("unexpected owner of %s: %+v", reqId, status)
+		}
+	}
+}
+
+func TestAsyncResultStore(t *testing.T) {
+	f := &function.Function{Name: "store-fn", Runtime: "python310", MemoryMB: 128, Handler: "h.handler", AsyncResultTTL: 120}
+	createFunction(t, f)
+
+	etcdClient, err := utils.GetEtcdClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	resultTTL := func(reqId string) int64 {
+		res, err := etcdClient.Get(ctx, function.AsyncResultKey("", reqId))
+		if err != nil || len(res.Kvs) != 1 {
+			t.Fatalf("result of %s not found: %v", reqId, err)
+		}
+		ttl, err := etcdClient.TimeToLive(ctx, clientv3.LeaseID(res.Kvs[0].Lease))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ttl.GrantedTTL
+	}
+	invokeAndWait := func(req client.InvocationRequest) (string, function.Response) {
+		req.Async = true
+		var asyncResp function.AsyncResponse
+		decode(t, postJson(t, testNode.URL+"/invoke/"+f.Name, req), &asyncResp)
+		pollResp, err := http.Get(testNode.URL + "/poll/" + asyncResp.ReqId + "?wait=10s")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pollResp.StatusCode != http.StatusOK {
+			t.Fatalf("async result not available: %s", pollResp.Status)
+		}
+		var response function.Response
+		decode(t, pollResp, &response)
+		return asyncResp.ReqId, response
+	}
+
+	// results expire as requested, or as configured for the function
+	reqId, _ := invokeAndWait(client.InvocationRequest{ResultTTL: 300})
+	if ttl := resultTTL(reqId); ttl != 300 {
+		t.Errorf("unexpected TTL of the result: %d", ttl)
+	}
+	reqId, _ = invokeAndWait(client.InvocationRequest{})
+	if ttl := resultTTL(reqId); ttl != 120 {
+		t.Errorf("unexpected TTL of the result: %d", ttl)
+	}
+	resp := postJson(t, testNode.URL+"/invoke/"+f.Name, client.InvocationRequest{ResultTTL: 300})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("result TTL accepted for a synchronous request: %s", resp.Status)
+	}
+	resp = postJson(t, testNode.URL+"/create", function.Function{Name: "store-invalid-fn", Runtime: "python310", MemoryMB: 128, Handler: "h.handler", AsyncResultTTL: -1})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("negative result TTL accepted: %s", resp.Status)
+	}
+
+	// large results are spilled to the disk, and served by the node
+	viper.Set(config.ASYNC_SPILL_DIR, t.TempDir())
+	viper.Set(config.ASYNC_RESULT_MAX_SIZE, 512)
+	defer viper.Set(config.ASYNC_RESULT_MAX_SIZE, 1024*1024)
+	params := map[string]interface{}{"data": string(bytes.Repeat([]byte("x"), 1024))}
+	reqId, response := invokeAndWait(client.InvocationRequest{Params: params})
+	expected, _ := json.Marshal(params)
+	if !response.Success || response.Result != string(expected) {
+		t.Errorf("unexpected spilled result: %+v", response)
+	}
+	res, err := etcdClient.Get(ctx, function.AsyncResultKey("", reqId))
+	if err != nil || len(res.Kvs) != 1 || bytes.Contains(res.Kvs[0].Value, []byte("xxxx")) {
+		t.Errorf("large result stored in the registry: %v", err)
+	}
+
+	// results spilled by other nodes are redirected to them
+	if _, err := etcdClient.Put(ctx, function.AsyncResultKey("", "store-remote"), "spilled:http://10.0.0.1:1323"); err != nil {
+		t.Fatal(err)
+	}
+	defer etcdClient.Delete(ctx, function.AsyncResultKey("", "store-remote"))
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	pollResp, err := noRedirect.Get(testNode.URL + "/poll/store-remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pollResp.Body.Close()
+	if pollResp.StatusCode != http.StatusTemporaryRedirect || pollResp.Header.Get("Location") != "http://10.0.0.1:1323/poll/store-remote" {
+		t.Errorf("unexpected response for a remote result: %s %s", pollResp.Status, pollResp.Header.Get("Location"))
+	}
+
+	// results which cannot be stored make the request fail
+	notADir := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(notADir, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	viper.Set(config.ASYNC_SPILL_DIR, notADir)
+	reqId, response = invokeAndWait(client.InvocationRequest{Params: params})
+	if response.Success {
+		t.Errorf("unexpected response for a result which cannot be stored: %+v", response)
+	}
+	if status := waitForTerminalState(t, reqId); status.State != function.ASYNC_FAILED {
+		t.Errorf("unexpected state of a request whose result cannot be stored: %+v", status)
+	}
+}
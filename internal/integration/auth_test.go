@@ -0,0 +1,93 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/grussorusso/serverledge/internal/api"
+	"github.com/grussorusso/serverledge/internal/auth"
+	"github.com/grussorusso/serverledge/internal/client"
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/spf13/viper"
+)
+
+func requestWithToken(t *testing.T, method string, url string, token string, body interface{}) *http.Response {
+	t.Helper()
+	payload, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestAuthentication(t *testing.T) {
+	f := &function.Function{Name: "auth-fn", Runtime: "python310", MemoryMB: 128, Handler: "h.handler"}
+	createFunction(t, f)
+
+	viper.Set(config.AUTH_ENABLED, true)
+	viper.Set(config.AUTH_SERVICE_TOKEN, "service-secret")
+	defer func() {
+		viper.Set(config.AUTH_ENABLED, false)
+		viper.Set(config.AUTH_SERVICE_TOKEN, "")
+	}()
+
+	_, invoker, err := auth.CreateKey(auth.INVOKER, "", "test invoker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, admin, err := auth.CreateKey(auth.ADMIN, "", "test admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectStatus := func(resp *http.Response, status int) {
+		t.Helper()
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("expected status %d, got %s", status, resp.Status)
+		}
+	}
+	invokeURL := testNode.URL + "/invoke/" + f.Name
+	created := &function.Function{Name: "auth-fn-2", Runtime: "python310", Handler: "h.handler"}
+
+	// unauthenticated requests are rejected
+	expectStatus(requestWithToken(t, http.MethodPost, invokeURL, "", client.InvocationRequest{}), http.StatusUnauthorized)
+	expectStatus(requestWithToken(t, http.MethodPost, invokeURL, invoker+"x", client.InvocationRequest{}), http.StatusUnauthorized)
+	expectV2Error(t, requestWithToken(t, http.MethodGet, testNode.URL+"/v2/functions", "", nil), http.StatusUnauthorized, api.ERR_UNAUTHORIZED)
+
+	// roles gate operations
+	expectStatus(requestWithToken(t, http.MethodPost, invokeURL, invoker, client.InvocationRequest{}), http.StatusOK)
+	expectStatus(requestWithToken(t, http.MethodPost, testNode.URL+"/create", invoker, created), http.StatusForbidden)
+	expectV2Error(t, requestWithToken(t, http.MethodPost, testNode.URL+"/v2/keys", invoker, api.KeyRequest{Role: auth.ADMIN}),
+		http.StatusForbidden, api.ERR_FORBIDDEN)
+
+	// the service credential allows offloading, not managing functions
+	expectStatus(requestWithToken(t, http.MethodPost, invokeURL, "service-secret", client.InvocationRequest{}), http.StatusOK)
+	expectStatus(requestWithToken(t, http.MethodPost, testNode.URL+"/create", "service-secret", created), http.StatusForbidden)
+
+	// admins manage keys
+	var newKey api.KeyCreated
+	resp := requestWithToken(t, http.MethodPost, testNode.URL+"/v2/keys", admin, api.KeyRequest{Role: auth.DEVELOPER})
+	decode(t, resp, &newKey)
+	if resp.StatusCode != http.StatusCreated || newKey.Token == "" || newKey.Key.Hash != "" {
+		t.Fatalf("unexpected key creation response: %s %+v", resp.Status, newKey)
+	}
+	expectStatus(requestWithToken(t, http.MethodPost, testNode.URL+"/create", newKey.Token, created), http.StatusOK)
+	expectStatus(requestWithToken(t, http.MethodPost, testNode.URL+"/delete", newKey.Token, created), http.StatusOK)
+	expectStatus(requestWithToken(t, http.MethodDelete, testNode.URL+"/v2/keys/"+newKey.Key.Id, admin, nil), http.StatusNoContent)
+	expectStatus(requestWithToken(t, http.MethodPost, invokeURL, newKey.Token, client.InvocationRequest{}), http.StatusUnauthorized)
+}
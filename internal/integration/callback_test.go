@@ -0,0 +1,191 @@
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/api"
+	"github.com/grussorusso/serverledge/internal/client"
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/grussorusso/serverledge/internal/testutil"
+	"github.com/spf13/viper"
+)
+
+func TestAsyncCallback(t *testing.T) {
+	f := &function.Function{Name: "callback-fn", Runtime: "python310", MemoryMB: 128, Handler: "h.handler"}
+	createFunction(t, f)
+
+	viper.Set(config.CALLBACK_BACKOFF, 0.05)
+	defer viper.Set(config.CALLBACK_BACKOFF, 1.0)
+
+	// the first delivery attempt fails
+	var attempts atomic.Int32
+	deliveries := make(chan *http.Request, 2)
+	bodies := make(chan []byte, 2)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		deliveries <- r
+		bodies <- body
+	}))
+	defer receiver.Close()
+
+	callback := &function.Callback{URL: receiver.URL + "/hook", Secret: "s3cret"}
+	resp := postJson(t, testNode.URL+"/invoke/"+f.Name, client.InvocationRequest{Callback: callback})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("callback accepted for a synchronous request: %s", resp.Status)
+	}
+	expectV2Error(t, v2Request(t, http.MethodPost, "/functions/"+f.Name+"/invocations",
+		client.InvocationRequest{Async: true, Callback: &function.Callback{URL: "ftp://example.com"}}), http.StatusBadRequest, api.ERR_INVALID_REQUEST)
+	expectV2Error(t, v2Request(t, http.MethodPost, "/functions/"+f.Name+"/invocations",
+		client.InvocationRequest{Async: true, Callback: &function.Callback{URL: "http://10.1.2.3/hook"}}), http.StatusBadRequest, api.ERR_INVALID_REQUEST)
+
+	resp = postJson(t, testNode.URL+"/invoke/"+f.Name, client.InvocationRequest{
+		Params:   map[string]interface{}{"x": "y"},
+		Async:    true,
+		Callback: callback})
+	var asyncResp function.AsyncResponse
+	decode(t, resp, &asyncResp)
+
+	var delivery *http.Request
+	var body []byte
+	select {
+	case delivery = <-deliveries:
+		body = <-bodies
+	case <-time.After(10 * time.Second):
+		t.Fatalf("callback not delivered")
+	}
+	if delivery.URL.Path != "/hook" || delivery.Header.Get(function.CALLBACK_REQUEST_ID_HEADER) != asyncResp.ReqId {
+		t.Errorf("unexpected delivery: %s %v", delivery.URL, delivery.Header)
+	}
+	expected := function.SignCallback(callback.Secret, delivery.Header.Get(function.CALLBACK_TIMESTAMP_HEADER), body)
+	if delivery.Header.Get(function.CALLBACK_SIGNATURE_HEADER) != expected {
+		t.Errorf("invalid signature: %s", delivery.Header.Get(function.CALLBACK_SIGNATURE_HEADER))
+	}
+	var response function.Response
+	if err := json.Unmarshal(body, &response); err != nil || !response.Success || response.Result != `{"x":"y"}` {
+		t.Errorf("unexpected delivered response: %s", body)
+	}
+
+	// the delivery status is published once delivered
+	status := waitForCallbackState(t, asyncResp.ReqId, function.CALLBACK_DELIVERED)
+	if status.State != function.CALLBACK_DELIVERED || status.Attempts != 2 || status.StatusCode != http.StatusOK {
+		t.Errorf("unexpected callback status: %+v", status)
+	}
+
+	// internal addresses are rejected by default, including those a host
+	// name resolves to
+	viper.Set(config.CALLBACK_ALLOW, "")
+	defer viper.Set(config.CALLBACK_ALLOW, "127.0.0.1")
+	expectV2Error(t, v2Request(t, http.MethodPost, "/functions/"+f.Name+"/invocations",
+		client.InvocationRequest{Async: true, Callback: callback}), http.StatusBadRequest, api.ERR_INVALID_REQUEST)
+	_, port, _ := net.SplitHostPort(receiver.Listener.Addr().String())
+	resp = postJson(t, testNode.URL+"/invoke/"+f.Name, client.InvocationRequest{
+		Async:    true,
+		Callback: &function.Callback{URL: "http://localhost:" + port + "/hook"}})
+	decode(t, resp, &asyncResp)
+	status = waitForCallbackState(t, asyncResp.ReqId, function.CALLBACK_FAILED)
+	if status.State != function.CALLBACK_FAILED || status.Attempts != 1 || attempts.Load() != 2 {
+		t.Errorf("callback delivered to an internal address: %+v", status)
+	}
+}
+
+// waitForCallbackState polls the delivery status of a callback until it
+// reaches the given state (or a timeout expires).
+func waitForCallbackState(t *testing.T, reqId string, state string) function.CallbackStatus {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	var status function.CallbackStatus
+	for status.State != state && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+		statusResp, err := http.Get(testNode.URL + "/callback/" + reqId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if statusResp.StatusCode != http.StatusOK {
+			statusResp.Body.Close()
+			continue
+		}
+		decode(t, statusResp, &status)
+	}
+	return status
+}
+
+func TestAsyncCallbackOffloaded(t *testing.T) {
+	f := &function.Function{Name: "callback-offload-fn", Runtime: "python310", MemoryMB: 128, Handler: "h.handler"}
+	createFunction(t, f)
+	cloud := startCloudNode(t)
+
+	deliveries := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		deliveries <- r
+		bodies <- body
+	}))
+	defer receiver.Close()
+
+	testNode.Factory.StartErr = fmt.Errorf("scripted failure")
+	defer func() { testNode.Factory.StartErr = nil }()
+
+	callback := &function.Callback{URL: receiver.URL + "/hook", Secret: "s3cret"}
+	resp := postJson(t, testNode.URL+"/invoke/"+f.Name, client.InvocationRequest{
+		Params:          map[string]interface{}{"x": "y"},
+		Async:           true,
+		CanDoOffloading: true,
+		Callback:        callback})
+	var asyncResp function.AsyncResponse
+	decode(t, resp, &asyncResp)
+
+	// the Cloud node delivers the callback, using the same request ID
+	var delivery *http.Request
+	var body []byte
+	select {
+	case delivery = <-deliveries:
+		body = <-bodies
+	case <-time.After(10 * time.Second):
+		t.Fatalf("callback not delivered")
+	}
+	if delivery.Header.Get(function.CALLBACK_REQUEST_ID_HEADER) != asyncResp.ReqId {
+		t.Errorf("unexpected delivery: %v", delivery.Header)
+	}
+	expected := function.SignCallback(callback.Secret, delivery.Header.Get(function.CALLBACK_TIMESTAMP_HEADER), body)
+	if delivery.Header.Get(function.CALLBACK_SIGNATURE_HEADER) != expected {
+		t.Errorf("invalid signature: %s", delivery.Header.Get(function.CALLBACK_SIGNATURE_HEADER))
+	}
+	var delivered function.Response
+	var result testutil.ExecutionResult
+	if err := json.Unmarshal(body, &delivered); err != nil || !delivered.Success {
+		t.Fatalf("unexpected delivered response: %s", body)
+	}
+	if err := json.Unmarshal([]byte(delivered.Result), &result); err != nil || result.Node != cloud.URL || result.Params["x"] != "y" {
+		t.Errorf("unexpected delivered result: %s", delivered.Result)
+	}
+
+	// the result published by the Cloud node is available through the test node
+	status := waitForTerminalState(t, asyncResp.ReqId)
+	if status.State != function.ASYNC_SUCCEEDED {
+		t.Errorf("unexpected state: %+v", status)
+	}
+	pollResp, err := http.Get(testNode.URL + "/poll/" + asyncResp.ReqId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var polled function.Response
+	decode(t, pollResp, &polled)
+	if polled.Result != delivered.Result {
+		t.Errorf("unexpected published result: %+v", polled)
+	}
+}
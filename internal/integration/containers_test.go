@@ -0,0 +1,274 @@
+package integration
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/client"
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/container"
+	"github.com/grussorusso/serverledge/internal/executor"
+	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/grussorusso/serverledge/internal/node"
+	"github.com/grussorusso/serverledge/internal/sse"
+	"github.com/spf13/viper"
+)
+
+func TestContainerReconciliation(t *testing.T) {
+	f := &function.Function{Name: "orphan-fn", Runtime: container.CUSTOM_RUNTIME, CustomImage: "example/orphan", MemoryMB: 64}
+	createFunction(t, f)
+	stale := *f
+	stale.TarFunctionCode = "b2xkIGNvZGU="
+	// containers created with other limits (e.g., before an update)
+	oldLimits := *f
+	oldLimits.MemoryMB = 32
+	oldConcurrency := *f
+	oldConcurrency.MaxConcurrency = 4
+
+	otherNodeLabels := node.ContainerLabels(f, f.CustomImage)
+	otherNodeLabels[node.LABEL_NODE] = "other-node"
+	orphans := []map[string]string{
+		node.ContainerLabels(f, f.CustomImage),                           // adopted
+		node.ContainerLabels(&stale, f.CustomImage),                      // code changed
+		node.ContainerLabels(&oldLimits, f.CustomImage),                  // memory limit changed
+		node.ContainerLabels(&oldConcurrency, f.CustomImage),             // concurrency changed
+		node.ContainerLabels(&function.Function{Name: "deleted-fn"}, ""), // function deleted
+		otherNodeLabels, // not owned by this node
+	}
+	ids := make([]container.ContainerID, len(orphans))
+	for i, labels := range orphans {
+		id, err := container.NewContainer(f.CustomImage, "", &container.ContainerOptions{MemoryMB: f.MemoryMB, Labels: labels})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = id
+	}
+	defer func() { _ = container.Destroy(ids[len(ids)-1]) }()
+
+	node.Resources.RLock()
+	memBefore := node.Resources.AvailableMemMB
+	node.Resources.RUnlock()
+	containersBefore := testNode.Factory.Count()
+
+	node.ReconcileContainers()
+
+	if n := testNode.Factory.Count(); n != containersBefore-4 {
+		t.Errorf("expected 4 destroyed containers, got %d", containersBefore-n)
+	}
+	if warm := node.WarmStatus()[f.Name]; warm != 1 {
+		t.Errorf("expected 1 adopted container, got %d", warm)
+	}
+	node.Resources.RLock()
+	memAfter := node.Resources.AvailableMemMB
+	node.Resources.RUnlock()
+	if memBefore-memAfter != f.MemoryMB {
+		t.Errorf("adopted container memory not accounted: %d -> %d", memBefore, memAfter)
+	}
+
+	_, response := invoke(t, testNode.URL, f.Name, client.InvocationRequest{})
+	if !response.IsWarmStart {
+		t.Errorf("adopted container not used for warm start")
+	}
+}
+
+// newContainerFor invokes a function and returns the container created to
+// serve the invocation.
+func newContainerFor(t *testing.T, f *function.Function) container.ContainerID {
+	t.Helper()
+	before := make(map[container.ContainerID]bool)
+	for _, id := range testNode.Factory.IDs() {
+		before[id] = true
+	}
+	if resp, _ := invoke(t, testNode.URL, f.Name, client.InvocationRequest{}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("invocation failed: %s", resp.Status)
+	}
+	for _, id := range testNode.Factory.IDs() {
+		if !before[id] {
+			return id
+		}
+	}
+	t.Fatalf("no container created")
+	return ""
+}
+
+func TestUnhealthyWarmContainer(t *testing.T) {
+	f := &function.Function{Name: "unhealthy-fn", Runtime: "python310", MemoryMB: 128, Handler: "h.handler"}
+	createFunction(t, f)
+	contID := newContainerFor(t, f)
+
+	node.CheckWarmContainers()
+	if warm := node.WarmStatus()[f.Name]; warm != 1 {
+		t.Fatalf("healthy container removed")
+	}
+
+	if err := testNode.Factory.Crash(contID); err != nil {
+		t.Fatal(err)
+	}
+	node.CheckWarmContainers()
+	if warm := node.WarmStatus()[f.Name]; warm != 0 {
+		t.Errorf("unhealthy container still available")
+	}
+	if testNode.Factory.HasContainer(contID) {
+		t.Errorf("unhealthy container not destroyed")
+	}
+}
+
+func TestBrokenContainer(t *testing.T) {
+	f := &function.Function{Name: "broken-fn", Runtime: "python310", MemoryMB: 128, Handler: "h.handler"}
+	createFunction(t, f)
+
+	// without retries, the invocation fails
+	contID := newContainerFor(t, f)
+	_ = testNode.Factory.Crash(contID)
+	if resp, _ := invoke(t, testNode.URL, f.Name, client.InvocationRequest{}); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected invocation failure, got %s", resp.Status)
+	}
+	waitForDestruction(t, contID)
+
+	// the request is served by a new container
+	viper.Set(config.SCHEDULER_BROKEN_RETRIES, 1)
+	defer viper.Set(config.SCHEDULER_BROKEN_RETRIES, 0)
+	contID = newContainerFor(t, f)
+	_ = testNode.Factory.Crash(contID)
+	resp, response := invoke(t, testNode.URL, f.Name, client.InvocationRequest{})
+	if resp.StatusCode != http.StatusOK || response.IsWarmStart {
+		t.Errorf("expected cold start after retry, got %s (warm: %v)", resp.Status, response.IsWarmStart)
+	}
+	waitForDestruction(t, contID)
+}
+
+func TestFunctionFailure(t *testing.T) {
+	f := &function.Function{Name: "failure-fn", Runtime: "python310", MemoryMB: 128, Handler: "h.handler"}
+	createFunction(t, f)
+	contID := newContainerFor(t, f)
+
+	testNode.Factory.ExecErr = fmt.Errorf("handler not found")
+	defer func() { testNode.Factory.ExecErr = nil }()
+
+	// failures reported by the Executor are not container failures
+	if resp, _ := invoke(t, testNode.URL, f.Name, client.InvocationRequest{}); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected invocation failure, got %s", resp.Status)
+	}
+	resp := postJson(t, testNode.URL+"/invoke/"+f.Name+"/stream", client.InvocationRequest{})
+	var failed bool
+	_ = sse.ReadEvents(resp.Body, func(event string, data string) error {
+		failed = failed || event == executor.EVENT_ERROR
+		return nil
+	})
+	resp.Body.Close()
+	if !failed {
+		t.Errorf("streaming invocation did not fail")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if !testNode.Factory.HasContainer(contID) {
+		t.Fatalf("working container destroyed")
+	}
+	testNode.Factory.ExecErr = nil
+	if resp, response := invoke(t, testNode.URL, f.Name, client.InvocationRequest{}); resp.StatusCode != http.StatusOK || !response.IsWarmStart {
+		t.Errorf("container not reused: %s (warm: %v)", resp.Status, response.IsWarmStart)
+	}
+}
+
+func waitForDestruction(t *testing.T, contID container.ContainerID) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if !testNode.Factory.HasContainer(contID) {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Errorf("container %s not destroyed", contID)
+}
+
+func TestResourceUsage(t *testing.T) {
+	f := &function.Function{Name: "usage-fn", Runtime: "python310", MemoryMB: 128, Handler: "h.handler"}
+	createFunction(t, f)
+
+	testNode.Factory.ExecCPUSeconds = 0.05
+	testNode.Factory.MemoryBytes = 64 * 1048576
+	viper.Set(config.CONTAINER_STATS, true)
+	defer func() {
+		testNode.Factory.ExecCPUSeconds = 0
+		testNode.Factory.MemoryBytes = 0
+		viper.Set(config.CONTAINER_STATS, false)
+	}()
+
+	invoke(t, testNode.URL, f.Name, client.InvocationRequest{})
+	resp, response := invoke(t, testNode.URL, f.Name, client.InvocationRequest{})
+	if resp.StatusCode != http.StatusOK || !response.IsWarmStart {
+		t.Fatalf("warm invocation failed: %s", resp.Status)
+	}
+	usage := response.Usage
+	if usage == nil || math.Abs(usage.ContainerCPUTime-0.05) > 1e-6 || usage.ContainerMemoryMB != 64 {
+		t.Fatalf("unexpected resource usage: %+v", usage)
+	}
+
+	// the observed usage is updated upon completion
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if observed, ok := node.GetObservedUsage(f.Name); ok && observed.Samples == 2 {
+			if observed.MaxMemoryMB != 64 || observed.CPUDemand <= 0 {
+				t.Errorf("unexpected observed usage: %+v", observed)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("observed usage not updated")
+}
+
+func TestObservedCPUDemand(t *testing.T) {
+	// the declared demand would only allow one invocation at a time
+	f := &function.Function{Name: "inflated-fn", Runtime: "python310", MemoryMB: 128, Handler: "h.handler", CPUDemand: 3.0}
+	createFunction(t, f)
+
+	testNode.Factory.ExecCPUSeconds = 0.01
+	testNode.Factory.ExecLatency = 200 * time.Millisecond
+	viper.Set(config.CONTAINER_STATS, true)
+	defer func() {
+		testNode.Factory.ExecCPUSeconds = 0
+		testNode.Factory.ExecLatency = 0
+		viper.Set(config.CONTAINER_STATS, false)
+	}()
+
+	if resp, _ := invoke(t, testNode.URL, f.Name, client.InvocationRequest{}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("invocation failed: %s", resp.Status)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := node.GetObservedUsage(f.Name); ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if demand := node.CPUDemandOf(f); demand >= 1.0 {
+		t.Fatalf("observed CPU demand not accounted: %f", demand)
+	}
+
+	// concurrent invocations are admitted based on the observed demand
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if resp, _ := invoke(t, testNode.URL, f.Name, client.InvocationRequest{}); resp.StatusCode != http.StatusOK {
+				t.Errorf("invocation not admitted: %s", resp.Status)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the reserved CPUs are released upon completion
+	node.Resources.RLock()
+	available := node.Resources.AvailableCPUs
+	node.Resources.RUnlock()
+	if math.Abs(available-4.0) > 1e-6 {
+		t.Errorf("unexpected available CPUs: %f", available)
+	}
+}
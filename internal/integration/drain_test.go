@@ -0,0 +1,92 @@
+package integration
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/client"
+	"github.com/grussorusso/serverledge/internal/executor"
+	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/grussorusso/serverledge/internal/node"
+	"github.com/grussorusso/serverledge/internal/scheduling"
+)
+
+func TestDrainPendingAsyncWork(t *testing.T) {
+	policy := &function.RetryPolicy{MaxAttempts: 3, Backoff: 30, RetryOn: []string{function.ERROR_CLASS_FUNCTION}}
+	f := &function.Function{Name: "drain-fn", Runtime: "python310", MemoryMB: 128, Handler: "h.handler", Retry: policy}
+	createFunction(t, f)
+
+	// "broken" invocations fail until fixed
+	var fixed atomic.Bool
+	testNode.Factory.Handler = func(req *executor.InvocationRequest) *executor.InvocationResult {
+		if req.Params["mode"] == "broken" && !fixed.Load() {
+			return &executor.InvocationResult{Success: false}
+		}
+		return &executor.InvocationResult{Success: true, Result: "{}"}
+	}
+	defer func() { testNode.Factory.Handler = nil }()
+
+	// callbacks are slowly delivered
+	var delivered atomic.Bool
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+		delivered.Store(true)
+	}))
+	defer receiver.Close()
+
+	invokeAsync := func(params map[string]interface{}, cb *function.Callback) string {
+		var asyncResp function.AsyncResponse
+		decode(t, postJson(t, testNode.URL+"/invoke/"+f.Name, client.InvocationRequest{Params: params, Async: true, Callback: cb}), &asyncResp)
+		return asyncResp.ReqId
+	}
+	retrying := invokeAsync(map[string]interface{}{"mode": "broken"}, nil)
+	completed := invokeAsync(nil, &function.Callback{URL: receiver.URL})
+	if status := waitForTerminalState(t, completed); status.State != function.ASYNC_SUCCEEDED {
+		t.Fatalf("unexpected status of the completed request: %+v", status)
+	}
+	var status function.AsyncRequestStatus
+	for deadline := time.Now().Add(5 * time.Second); status.State != function.ASYNC_RETRYING && time.Now().Before(deadline); {
+		time.Sleep(50 * time.Millisecond)
+		decode(t, v2Request(t, http.MethodGet, "/invocations/"+retrying+"/status", nil), &status)
+	}
+	if status.State != function.ASYNC_RETRYING {
+		t.Fatalf("unexpected status of the failed request: %+v", status)
+	}
+
+	// draining waits for the callback, but not for the retry, which is
+	// released to the other nodes
+	start := time.Now()
+	var drained struct {
+		Drained bool
+		Pending int64
+	}
+	decode(t, postJson(t, testNode.URL+"/drain", client.DrainRequest{Timeout: 10}), &drained)
+	if !drained.Drained || drained.Pending != 0 || time.Since(start) > 5*time.Second {
+		t.Errorf("unexpected drain result after %v: %+v", time.Since(start), drained)
+	}
+	if !delivered.Load() {
+		t.Errorf("node drained before delivering the callback")
+	}
+	status = function.AsyncRequestStatus{}
+	decode(t, v2Request(t, http.MethodGet, "/invocations/"+retrying+"/status", nil), &status)
+	if status.State != function.ASYNC_RETRYING || status.Owner != "" || status.Attempts != 1 {
+		t.Errorf("retry not released: %+v", status)
+	}
+
+	resp := postJson(t, testNode.URL+"/resume", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("could not resume the node: %s", resp.Status)
+	}
+
+	// released requests are taken over without waiting for the owner to fail
+	fixed.Store(true)
+	scheduling.RecoverOrphanedRequests()
+	status = waitForTerminalState(t, retrying)
+	if status.State != function.ASYNC_SUCCEEDED || status.Owner != node.NodeIdentifier || status.Takeovers != 1 {
+		t.Errorf("unexpected status of the released request: %+v", status)
+	}
+}
@@ -0,0 +1,213 @@
+// Package integration contains end-to-end tests that boot a complete
+// Serverledge node in-process, backed by an embedded etcd server and a fake
+// container factory.
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/grussorusso/serverledge/internal/client"
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/grussorusso/serverledge/internal/node"
+	"github.com/grussorusso/serverledge/internal/scheduling"
+	"github.com/grussorusso/serverledge/internal/testutil"
+	"github.com/spf13/viper"
+)
+
+const AREA = "test"
+
+var testNode *testutil.Node
+
+// serviceToken authenticates the nodes to each other
+const serviceToken = "node-s3cret"
+
+func TestMain(m *testing.M) {
+	if testutil.IsNodeProcess() {
+		testutil.RunNodeProcess(&scheduling.CloudEdgePolicy{})
+		return
+	}
+	os.Exit(runTests(m))
+}
+
+func runTests(m *testing.M) int {
+	dataDir, err := os.MkdirTemp("", "serverledge-etcd")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	etcd, err := testutil.StartEmbeddedEtcd(dataDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer etcd.Close()
+
+	viper.Set(config.POOL_MEMORY_MB, 1024)
+	viper.Set(config.POOL_CPUS, 4.0)
+	viper.Set(config.AUTH_SERVICE_TOKEN, serviceToken)
+	// callback receivers run on the loopback interface
+	viper.Set(config.CALLBACK_ALLOW, "127.0.0.1")
+
+	testNode, err = testutil.StartNode(AREA, &scheduling.CloudEdgePolicy{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer testNode.Stop()
+
+	return m.Run()
+}
+
+func postJson(t *testing.T, url string, body interface{}) *http.Response {
+	t.Helper()
+	payload, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func decode(t *testing.T, resp *http.Response, v interface{}) {
+	t.Helper()
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+}
+
+func createFunction(t *testing.T, f *function.Function) {
+	t.Helper()
+	resp := postJson(t, testNode.URL+"/create", f)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("creation of %s failed: %s", f.Name, resp.Status)
+	}
+	t.Cleanup(func() {
+		resp := postJson(t, testNode.URL+"/delete", f)
+		resp.Body.Close()
+	})
+}
+
+func invoke(t *testing.T, url string, funcName string, req client.InvocationRequest) (*http.Response, function.Response) {
+	t.Helper()
+	var response function.Response
+	resp := postJson(t, url+"/invoke/"+funcName, req)
+	if resp.StatusCode == http.StatusOK {
+		decode(t, resp, &response)
+	} else {
+		resp.Body.Close()
+	}
+	return resp, response
+}
+
+func TestCreateAndList(t *testing.T) {
+	f := &function.Function{Name: "list-fn", Runtime: "python310", MemoryMB: 128, Handler: "h.handler"}
+	createFunction(t, f)
+
+	// duplicates are rejected
+	resp := postJson(t, testNode.URL+"/create", f)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("expected 409 for duplicate function, got %s", resp.Status)
+	}
+
+	// invalid runtimes are rejected
+	resp = postJson(t, testNode.URL+"/create", &function.Function{Name: "bad-fn", Runtime: "cobol"})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for invalid runtime, got %s", resp.Status)
+	}
+
+	listResp, err := http.Get(testNode.URL + "/function")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var functions []string
+	decode(t, listResp, &functions)
+	found := false
+	for _, name := range functions {
+		found = found || name == f.Name
+	}
+	if !found {
+		t.Errorf("function %s not listed: %v", f.Name, functions)
+	}
+}
+
+func TestInvokeColdAndWarm(t *testing.T) {
+	f := &function.Function{Name: "invoke-fn", Runtime: "python310", MemoryMB: 128, Handler: "h.handler"}
+	createFunction(t, f)
+
+	req := client.InvocationRequest{Params: map[string]interface{}{"a": 1.0}}
+	resp, response := invoke(t, testNode.URL, f.Name, req)
+	if resp.StatusCode != http.StatusOK || !response.Success {
+		t.Fatalf("invocation failed: %s", resp.Status)
+	}
+	if response.Result != `{"a":1}` {
+		t.Errorf("unexpected result: %s", response.Result)
+	}
+	if response.IsWarmStart {
+		t.Errorf("first invocation should be a cold start")
+	}
+
+	_, response = invoke(t, testNode.URL, f.Name, req)
+	if !response.IsWarmStart {
+		t.Errorf("second invocation should be a warm start")
+	}
+}
+
+func TestInvokeUnknownFunction(t *testing.T) {
+	resp, _ := invoke(t, testNode.URL, "missing-fn", client.InvocationRequest{})
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404, got %s", resp.Status)
+	}
+}
+
+func TestPrewarmAndDelete(t *testing.T) {
+	f := &function.Function{Name: "prewarm-fn", Runtime: "python310", MemoryMB: 64, Handler: "h.handler"}
+	resp := postJson(t, testNode.URL+"/create", f)
+	resp.Body.Close()
+
+	resp = postJson(t, testNode.URL+"/prewarm", client.PrewarmingRequest{Function: f.Name, Instances: 2})
+	var prewarmResp struct{ Prewarmed int64 }
+	decode(t, resp, &prewarmResp)
+	if prewarmResp.Prewarmed != 2 {
+		t.Errorf("expected 2 prewarmed instances, got %d", prewarmResp.Prewarmed)
+	}
+	if warm := node.WarmStatus()[f.Name]; warm != 2 {
+		t.Errorf("expected 2 warm containers, got %d", warm)
+	}
+
+	resp = postJson(t, testNode.URL+"/delete", f)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("deletion failed: %s", resp.Status)
+	}
+
+	invResp, _ := invoke(t, testNode.URL, f.Name, client.InvocationRequest{})
+	if invResp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 after deletion, got %s", invResp.Status)
+	}
+}
+
+func TestColdStartFailure(t *testing.T) {
+	f := &function.Function{Name: "failing-fn", Runtime: "python310", MemoryMB: 128, Handler: "h.handler"}
+	createFunction(t, f)
+
+	testNode.Factory.StartErr = fmt.Errorf("scripted failure")
+	defer func() { testNode.Factory.StartErr = nil }()
+
+	resp, _ := invoke(t, testNode.URL, f.Name, client.InvocationRequest{CanDoOffloading: false})
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected request to be dropped, got %s", resp.Status)
+	}
+}
@@ -0,0 +1,114 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/grussorusso/serverledge/internal/client"
+	"github.com/grussorusso/serverledge/internal/executor"
+	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/grussorusso/serverledge/internal/sse"
+)
+
+func TestStreamingInvocation(t *testing.T) {
+	f := &function.Function{Name: "stream-fn", Runtime: "python310", MemoryMB: 128, Handler: "h.handler"}
+	createFunction(t, f)
+
+	testNode.Factory.StreamOutput = []string{"first line\n", "second line\n"}
+	defer func() { testNode.Factory.StreamOutput = nil }()
+
+	req := client.InvocationRequest{Params: map[string]interface{}{"a": 1.0}}
+	resp := postJson(t, testNode.URL+"/invoke/"+f.Name+"/stream", req)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("streaming invocation failed: %s", resp.Status)
+	}
+
+	var output string
+	var response function.Response
+	err := sse.ReadEvents(resp.Body, func(event string, data string) error {
+		switch event {
+		case executor.EVENT_OUTPUT:
+			output += data
+		case executor.EVENT_RESULT:
+			return json.Unmarshal([]byte(data), &response)
+		case executor.EVENT_ERROR:
+			return fmt.Errorf("%s", data)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "first line\nsecond line\n" {
+		t.Errorf("unexpected output: %q", output)
+	}
+	if !response.Success || response.Result != `{"a":1}` {
+		t.Errorf("unexpected response: %+v", response)
+	}
+}
+
+// receivedHeaders returns the names of the headers of a request.
+func receivedHeaders(headers map[string][]string) []string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestHTTPTrigger(t *testing.T) {
+	f := &function.Function{Name: "http-fn", Runtime: "python310", MemoryMB: 128, Handler: "h.handler"}
+	createFunction(t, f)
+
+	testNode.Factory.Handler = func(req *executor.InvocationRequest) *executor.InvocationResult {
+		if req.HTTPRequest == nil {
+			return &executor.InvocationResult{Success: true, Result: `"not an HTTP request"`}
+		}
+		r := req.HTTPRequest
+		headers := map[string][]string{
+			"Content-Type": {"application/octet-stream"},
+			"X-Path":       {r.Method + " " + r.Path + "?" + r.Query},
+			"X-Received":   {strings.Join(receivedHeaders(r.Headers), ",")},
+		}
+		return &executor.InvocationResult{Success: true, HTTPResponse: &executor.HTTPResponse{
+			StatusCode: http.StatusCreated,
+			Headers:    headers,
+			Body:       append([]byte{0xff}, r.Body...),
+		}}
+	}
+	defer func() { testNode.Factory.Handler = nil }()
+
+	body := []byte{0x00, 0x01, 0xfe}
+	req, _ := http.NewRequest(http.MethodPost, testNode.URL+"/http/"+f.Name+"/items/42?verbose=1", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Custom", "1")
+	// credentials are not passed to functions
+	req.Header.Set("Authorization", "Bearer s3cret")
+	req.Header.Set("X-API-Key", "s3cret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("unexpected status: %s", resp.Status)
+	}
+	if resp.Header.Get("X-Path") != "POST /items/42?verbose=1" || resp.Header.Get("Content-Type") != "application/octet-stream" {
+		t.Errorf("unexpected headers: %v", resp.Header)
+	}
+	if received := resp.Header.Get("X-Received"); !strings.Contains(received, "X-Custom") ||
+		strings.Contains(received, "Authorization") || strings.Contains(received, "X-Api-Key") {
+		t.Errorf("unexpected headers passed to the function: %s", received)
+	}
+	if !bytes.Equal(respBody, append([]byte{0xff}, body...)) {
+		t.Errorf("unexpected body: %v", respBody)
+	}
+}
@@ -0,0 +1,139 @@
+package integration
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/api"
+	"github.com/grussorusso/serverledge/internal/auth"
+	"github.com/grussorusso/serverledge/internal/client"
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/grussorusso/serverledge/internal/node"
+	"github.com/spf13/viper"
+)
+
+func TestNamespaces(t *testing.T) {
+	expectStatus := func(resp *http.Response, status int) {
+		t.Helper()
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("expected status %d, got %s", status, resp.Status)
+		}
+	}
+
+	ns := function.Namespace{Name: "team-a", Quota: function.Quota{MaxFunctions: 2, MaxContainers: 1}}
+	expectStatus(v2Request(t, http.MethodPut, "/namespaces/"+ns.Name, ns), http.StatusOK)
+	t.Cleanup(func() {
+		v2Request(t, http.MethodDelete, "/namespaces/"+ns.Name, nil).Body.Close()
+	})
+	expectV2Error(t, v2Request(t, http.MethodPut, "/namespaces/Bad_Name", nil), http.StatusBadRequest, api.ERR_INVALID_REQUEST)
+	expectV2Error(t, v2RequestIn(t, "unknown", http.MethodPost, "/functions", &function.Function{Name: "ns-fn", Runtime: "python310", Handler: "h.handler"}),
+		http.StatusNotFound, api.ERR_NAMESPACE_NOT_FOUND)
+
+	// the same name can be used in different namespaces
+	f := &function.Function{Name: "ns-fn", Runtime: "python310", Handler: "h.handler"}
+	createFunction(t, f)
+	expectStatus(v2RequestIn(t, ns.Name, http.MethodPost, "/functions", f), http.StatusCreated)
+	t.Cleanup(func() {
+		v2RequestIn(t, ns.Name, http.MethodDelete, "/functions/"+f.Name, nil).Body.Close()
+	})
+	var scoped function.Function
+	decode(t, v2RequestIn(t, ns.Name, http.MethodGet, "/functions/"+f.Name, nil), &scoped)
+	if scoped.Namespace != ns.Name {
+		t.Errorf("unexpected function: %+v", scoped)
+	}
+	var list []function.Function
+	decode(t, v2RequestIn(t, ns.Name, http.MethodGet, "/functions", nil), &list)
+	if len(list) != 1 || list[0].Name != f.Name {
+		t.Errorf("unexpected functions in namespace: %+v", list)
+	}
+	expectV2Error(t, v2Request(t, http.MethodDelete, "/namespaces/"+ns.Name, nil), http.StatusConflict, api.ERR_NAMESPACE_NOT_EMPTY)
+
+	// function count quota
+	g := &function.Function{Name: "ns-fn-2", Runtime: "python310", Handler: "h.handler"}
+	expectStatus(v2RequestIn(t, ns.Name, http.MethodPost, "/functions", g), http.StatusCreated)
+	t.Cleanup(func() {
+		v2RequestIn(t, ns.Name, http.MethodDelete, "/functions/"+g.Name, nil).Body.Close()
+	})
+	expectV2Error(t, v2RequestIn(t, ns.Name, http.MethodPost, "/functions", &function.Function{Name: "ns-fn-3", Runtime: "python310", Handler: "h.handler"}),
+		http.StatusForbidden, api.ERR_QUOTA_EXCEEDED)
+
+	// container quota: the warm container of the first function is
+	// dismissed to make room for the cold start of the second one
+	expectStatus(v2RequestIn(t, ns.Name, http.MethodPost, "/functions/"+f.Name+"/invocations", client.InvocationRequest{}), http.StatusOK)
+	expectStatus(v2RequestIn(t, ns.Name, http.MethodPost, "/functions/"+g.Name+"/invocations", client.InvocationRequest{}), http.StatusOK)
+	var status api.NamespaceStatus
+	decode(t, v2Request(t, http.MethodGet, "/namespaces/"+ns.Name, nil), &status)
+	if status.Usage.Containers != 1 || status.Usage.MemoryMB != 128 {
+		t.Errorf("unexpected namespace status: %+v", status)
+	}
+
+	// ... while a busy container prevents it
+	testNode.Factory.ExecLatency = 500 * time.Millisecond
+	busy := make(chan *http.Response)
+	go func() {
+		busy <- v2RequestIn(t, ns.Name, http.MethodPost, "/functions/"+f.Name+"/invocations", client.InvocationRequest{})
+	}()
+	time.Sleep(200 * time.Millisecond)
+	expectV2Error(t, v2RequestIn(t, ns.Name, http.MethodPost, "/functions/"+g.Name+"/invocations", client.InvocationRequest{}),
+		http.StatusTooManyRequests, api.ERR_TOO_MANY_REQUESTS)
+	expectStatus(<-busy, http.StatusOK)
+	testNode.Factory.ExecLatency = 0
+	decode(t, v2Request(t, http.MethodGet, "/namespaces/"+ns.Name, nil), &status)
+	if status.Functions != 2 || status.Usage.Containers != 1 || status.Usage.MemoryMB != 128 {
+		t.Errorf("unexpected namespace status: %+v", status)
+	}
+
+	// ... and so does a node without enough memory for the new container
+	node.Resources.Lock()
+	node.Resources.AvailableMemMB -= 1 << 20
+	node.Resources.Unlock()
+	expectV2Error(t, v2RequestIn(t, ns.Name, http.MethodPost, "/functions/"+g.Name+"/invocations", client.InvocationRequest{}),
+		http.StatusTooManyRequests, api.ERR_TOO_MANY_REQUESTS)
+	node.Resources.Lock()
+	node.Resources.AvailableMemMB += 1 << 20
+	node.Resources.Unlock()
+	decode(t, v2Request(t, http.MethodGet, "/namespaces/"+ns.Name, nil), &status)
+	if status.Usage.Containers != 1 || status.Usage.MemoryMB != 128 {
+		t.Errorf("warm container dismissed in vain: %+v", status)
+	}
+
+	// invocation rate quota (bursts of one invocation)
+	ns.Quota.MaxInvocationRate = 0.01
+	expectStatus(v2Request(t, http.MethodPut, "/namespaces/"+ns.Name, ns), http.StatusOK)
+	expectStatus(v2RequestIn(t, ns.Name, http.MethodPost, "/functions/"+f.Name+"/invocations", client.InvocationRequest{}), http.StatusOK)
+	expectV2Error(t, v2RequestIn(t, ns.Name, http.MethodPost, "/functions/"+f.Name+"/invocations", client.InvocationRequest{}),
+		http.StatusTooManyRequests, api.ERR_QUOTA_EXCEEDED)
+	resp, _ := invoke(t, testNode.URL, f.Name, client.InvocationRequest{})
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("the quota affects other namespaces: %s", resp.Status)
+	}
+
+	// keys bound to a namespace only give access to it
+	viper.Set(config.AUTH_ENABLED, true)
+	defer viper.Set(config.AUTH_ENABLED, false)
+	_, token, err := auth.CreateKey(auth.DEVELOPER, ns.Name, "tenant key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = requestWithToken(t, http.MethodGet, testNode.URL+"/function", token, nil)
+	var names []string
+	decode(t, resp, &names)
+	if len(names) != 2 {
+		t.Errorf("unexpected functions visible to the tenant: %v", names)
+	}
+	req, err := http.NewRequest(http.MethodGet, testNode.URL+"/function", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(function.NAMESPACE_HEADER, function.DEFAULT_NAMESPACE)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(resp, http.StatusForbidden)
+	expectV2Error(t, requestWithToken(t, http.MethodGet, testNode.URL+"/v2/namespaces", token, nil), http.StatusForbidden, api.ERR_FORBIDDEN)
+}
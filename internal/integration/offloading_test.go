@@ -0,0 +1,65 @@
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/grussorusso/serverledge/internal/client"
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/grussorusso/serverledge/internal/scheduling"
+	"github.com/grussorusso/serverledge/internal/testutil"
+	"github.com/spf13/viper"
+)
+
+// startCloudNode runs a Cloud node in another process, which requires
+// clients to authenticate, and configures the test node to offload requests
+// to it.
+func startCloudNode(t *testing.T) *testutil.NodeProcess {
+	t.Helper()
+	cloud, err := testutil.StartNodeProcess("cloud", map[string]interface{}{
+		config.POOL_MEMORY_MB:     1024,
+		config.POOL_CPUS:          4.0,
+		config.AUTH_ENABLED:       true,
+		config.AUTH_SERVICE_TOKEN: serviceToken,
+		config.CALLBACK_ALLOW:     "127.0.0.1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cloud.Stop)
+	viper.Set(config.CLOUD_URL, cloud.URL)
+	t.Cleanup(func() { viper.Set(config.CLOUD_URL, "") })
+	return cloud
+}
+
+func TestOffloading(t *testing.T) {
+	f := &function.Function{Name: "offload-fn", Runtime: "python310", MemoryMB: 128, Handler: "h.handler"}
+	createFunction(t, f)
+	cloud := startCloudNode(t)
+
+	// clients of the Cloud node must authenticate, unlike the test node
+	resp, _ := invoke(t, cloud.URL, f.Name, client.InvocationRequest{})
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("unauthenticated request accepted by the Cloud node: %s", resp.Status)
+	}
+
+	testNode.Factory.StartErr = fmt.Errorf("scripted failure")
+	defer func() { testNode.Factory.StartErr = nil }()
+
+	params := map[string]interface{}{"x": "y"}
+	resp, response := invoke(t, testNode.URL, f.Name, client.InvocationRequest{Params: params, CanDoOffloading: true})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("offloaded invocation failed: %s", resp.Status)
+	}
+	if response.SchedAction != scheduling.SCHED_ACTION_OFFLOAD || !response.Success {
+		t.Errorf("unexpected response: %+v", response)
+	}
+	// the request has been served by the Cloud node
+	var result testutil.ExecutionResult
+	if err := json.Unmarshal([]byte(response.Result), &result); err != nil || result.Node != cloud.URL || result.Params["x"] != "y" {
+		t.Errorf("unexpected result: %s", response.Result)
+	}
+}
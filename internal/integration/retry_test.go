@@ -0,0 +1,164 @@
+package integration
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/api"
+	"github.com/grussorusso/serverledge/internal/client"
+	"github.com/grussorusso/serverledge/internal/executor"
+	"github.com/grussorusso/serverledge/internal/function"
+)
+
+func TestAsyncRetriesAndDeadLetters(t *testing.T) {
+	policy := &function.RetryPolicy{MaxAttempts: 3, Backoff: 0.05, RetryOn: []string{function.ERROR_CLASS_FUNCTION}}
+	f := &function.Function{Name: "retry-fn", Runtime: "python310", MemoryMB: 128, Handler: "h.handler", Retry: policy}
+	createFunction(t, f)
+	g := &function.Function{Name: "no-retry-fn", Runtime: "python310", MemoryMB: 128, Handler: "h.handler"}
+	createFunction(t, g)
+
+	for _, invalid := range []*function.RetryPolicy{{MaxAttempts: 0}, {MaxAttempts: 2, RetryOn: []string{"unknown"}}} {
+		resp := postJson(t, testNode.URL+"/create", function.Function{Name: "retry-invalid-fn", Runtime: "python310", MemoryMB: 128, Handler: "h.handler", Retry: invalid})
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("invalid retry policy %+v accepted: %s", invalid, resp.Status)
+		}
+	}
+
+	// "flaky" invocations fail twice, "broken" ones until fixed
+	var flakyFailures atomic.Int32
+	var fixed atomic.Bool
+	testNode.Factory.Handler = func(req *executor.InvocationRequest) *executor.InvocationResult {
+		switch req.Params["mode"] {
+		case "flaky":
+			if flakyFailures.Add(1) <= 2 {
+				return &executor.InvocationResult{Success: false}
+			}
+		case "broken":
+			if !fixed.Load() {
+				return &executor.InvocationResult{Success: false}
+			}
+		}
+		params, _ := json.Marshal(req.Params)
+		return &executor.InvocationResult{Success: true, Result: string(params)}
+	}
+	defer func() { testNode.Factory.Handler = nil }()
+
+	// deliveries of callbacks, signed by their secret
+	signed := make(chan string, 4)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		expected := function.SignCallback("s3cret", r.Header.Get(function.CALLBACK_TIMESTAMP_HEADER), body)
+		if r.Header.Get(function.CALLBACK_SIGNATURE_HEADER) == expected {
+			signed <- r.Header.Get(function.CALLBACK_REQUEST_ID_HEADER)
+		}
+	}))
+	defer receiver.Close()
+	callback := &function.Callback{URL: receiver.URL, Secret: "s3cret"}
+
+	invokeAndWait := func(funcName string, params map[string]interface{}, cb *function.Callback) (string, function.Response) {
+		var asyncResp function.AsyncResponse
+		decode(t, postJson(t, testNode.URL+"/invoke/"+funcName, client.InvocationRequest{Params: params, Async: true, Callback: cb}), &asyncResp)
+		pollResp, err := http.Get(testNode.URL + "/poll/" + asyncResp.ReqId + "?wait=10s")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pollResp.StatusCode != http.StatusOK {
+			t.Fatalf("async result not available: %s", pollResp.Status)
+		}
+		var response function.Response
+		decode(t, pollResp, &response)
+		return asyncResp.ReqId, response
+	}
+
+	// failed attempts are retried
+	reqId, response := invokeAndWait(f.Name, map[string]interface{}{"mode": "flaky"}, nil)
+	if !response.Success {
+		t.Errorf("flaky request not retried: %+v", response)
+	}
+	if status := waitForTerminalState(t, reqId); status.State != function.ASYNC_SUCCEEDED || status.Attempts != 2 {
+		t.Errorf("unexpected status of a retried request: %+v", status)
+	}
+
+	// requests failing after all the attempts are dead-lettered
+	params := map[string]interface{}{"mode": "broken", "x": "y"}
+	reqId, response = invokeAndWait(f.Name, params, callback)
+	if response.Success {
+		t.Errorf("unexpected response of a failed request: %+v", response)
+	}
+	if status := waitForTerminalState(t, reqId); status.State != function.ASYNC_FAILED || status.Attempts != 3 || status.LastError == "" {
+		t.Errorf("unexpected status of a failed request: %+v", status)
+	}
+	// requests are attempted once without a retry policy
+	noRetryId, _ := invokeAndWait(g.Name, params, nil)
+
+	resp, err := http.Get(testNode.URL + "/deadletter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var letters []function.DeadLetter
+	decode(t, resp, &letters)
+	found := make(map[string]function.DeadLetter)
+	for _, letter := range letters {
+		found[letter.ReqId] = letter
+	}
+	// callback secrets are not disclosed
+	if letter, ok := found[reqId]; !ok || letter.Attempts != 3 || letter.ErrorClass != function.ERROR_CLASS_FUNCTION ||
+		letter.Function != f.Name || letter.Params["x"] != "y" || letter.Callback == nil ||
+		letter.Callback.URL != callback.URL || letter.Callback.Secret != "" {
+		t.Errorf("unexpected dead letter of %s: %+v", reqId, letter)
+	}
+	if letter, ok := found[noRetryId]; !ok || letter.Attempts != 1 {
+		t.Errorf("unexpected dead letter of %s: %+v", noRetryId, letter)
+	}
+	var letter function.DeadLetter
+	decode(t, v2Request(t, http.MethodGet, "/dead-letters/"+reqId, nil), &letter)
+	if letter.ReqId != reqId || letter.State != function.ASYNC_FAILED || letter.Callback == nil || letter.Callback.Secret != "" {
+		t.Errorf("unexpected dead letter: %+v", letter)
+	}
+	resp, err = http.Get(testNode.URL + "/deadletter/" + reqId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	letter = function.DeadLetter{}
+	decode(t, resp, &letter)
+	if letter.ReqId != reqId || letter.Callback == nil || letter.Callback.Secret != "" {
+		t.Errorf("unexpected dead letter: %+v", letter)
+	}
+	expectV2Error(t, v2Request(t, http.MethodGet, "/dead-letters/unknown-req", nil), http.StatusNotFound, api.ERR_DEAD_LETTER_NOT_FOUND)
+
+	// re-driven requests are submitted again, as new requests
+	fixed.Store(true)
+	resp = v2Request(t, http.MethodPost, "/dead-letters/"+reqId+"/redrive", nil)
+	var redriven api.AsyncInvocation
+	decode(t, resp, &redriven)
+	if resp.StatusCode != http.StatusAccepted || redriven.Id == "" || redriven.Id == reqId {
+		t.Fatalf("unexpected response to redrive: %s %+v", resp.Status, redriven)
+	}
+	pollResp, err := http.Get(testNode.URL + "/poll/" + redriven.Id + "?wait=10s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decode(t, pollResp, &response)
+	expected, _ := json.Marshal(params)
+	if !response.Success || response.Result != string(expected) {
+		t.Errorf("unexpected response of the re-driven request: %+v", response)
+	}
+	// ... and deliver callbacks signed by the original secret
+	deadline := time.After(10 * time.Second)
+	for delivered := false; !delivered; {
+		select {
+		case id := <-signed:
+			delivered = id == redriven.Id
+		case <-deadline:
+			t.Fatalf("signed callback of the re-driven request not delivered")
+		}
+	}
+	expectV2Error(t, v2Request(t, http.MethodGet, "/dead-letters/"+reqId, nil), http.StatusNotFound, api.ERR_DEAD_LETTER_NOT_FOUND)
+	expectV2Error(t, v2Request(t, http.MethodPost, "/dead-letters/"+reqId+"/redrive", nil), http.StatusNotFound, api.ERR_DEAD_LETTER_NOT_FOUND)
+}
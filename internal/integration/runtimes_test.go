@@ -0,0 +1,191 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/client"
+	"github.com/grussorusso/serverledge/internal/container"
+	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/grussorusso/serverledge/utils"
+)
+
+func TestRuntimeCatalog(t *testing.T) {
+	rt := container.RuntimeInfo{
+		Name:            "ruby3",
+		Image:           "example/serverledge-ruby3",
+		InvocationCmd:   []string{"ruby", "/entrypoint.rb"},
+		DefaultMemoryMB: 256,
+		HandlerFormat:   `^\w+\.rb$`,
+	}
+	resp := postJson(t, testNode.URL+"/runtime", rt)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("runtime creation failed: %s", resp.Status)
+	}
+
+	resp = postJson(t, testNode.URL+"/create", &function.Function{Name: "ruby-fn", Runtime: rt.Name, Handler: "main.py"})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for unsupported handler, got %s", resp.Status)
+	}
+
+	f := &function.Function{Name: "ruby-fn", Runtime: rt.Name, Handler: "main.rb"}
+	createFunction(t, f)
+	created, _ := function.GetFunction(f.Name)
+	if created.MemoryMB != rt.DefaultMemoryMB {
+		t.Errorf("expected default memory %d, got %d", rt.DefaultMemoryMB, created.MemoryMB)
+	}
+	if resp, _ := invoke(t, testNode.URL, f.Name, client.InvocationRequest{}); resp.StatusCode != http.StatusOK {
+		t.Errorf("invocation failed: %s", resp.Status)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, testNode.URL+"/runtime/"+rt.Name, nil)
+	delResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delResp.Body.Close()
+	if delResp.StatusCode != http.StatusConflict {
+		t.Errorf("expected 409 when deleting a runtime in use, got %s", delResp.Status)
+	}
+}
+
+func TestRuntimeCatalogWatch(t *testing.T) {
+	// runtimes added by other nodes are picked up through etcd
+	cli, err := utils.GetEtcdClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := json.Marshal(container.RuntimeInfo{Name: "go121", Image: "example/serverledge-go121"})
+	if _, err := cli.Put(context.Background(), "/runtime/go121", string(payload)); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := container.GetRuntimeInfo("go121"); ok {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if _, ok := container.GetRuntimeInfo("go121"); !ok {
+		t.Fatalf("new runtime not available")
+	}
+
+	if _, err := cli.Delete(context.Background(), "/runtime/go121"); err != nil {
+		t.Fatal(err)
+	}
+	deadline = time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := container.GetRuntimeInfo("go121"); !ok {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Errorf("deleted runtime still available")
+}
+
+func TestImagePullDeduplication(t *testing.T) {
+	f := &function.Function{Name: "image-fn", Runtime: container.CUSTOM_RUNTIME, CustomImage: "example/image-fn"}
+	createFunction(t, f)
+
+	testNode.Factory.PullLatency = 200 * time.Millisecond
+	defer func() { testNode.Factory.PullLatency = 0 }()
+	pullsBefore := testNode.Factory.Pulls()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			payload, _ := json.Marshal(client.InvocationRequest{})
+			resp, err := http.Post(testNode.URL+"/invoke/"+f.Name, "application/json", bytes.NewReader(payload))
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if pulls := testNode.Factory.Pulls() - pullsBefore; pulls != 1 {
+		t.Errorf("expected a single pull, got %d", pulls)
+	}
+}
+
+func TestImageGarbageCollection(t *testing.T) {
+	f := &function.Function{Name: "gc-fn", Runtime: container.CUSTOM_RUNTIME, CustomImage: "example/gc-used"}
+	createFunction(t, f)
+	if resp, _ := invoke(t, testNode.URL, f.Name, client.InvocationRequest{}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("invocation failed: %s", resp.Status)
+	}
+	if err := container.DownloadImage("example/gc-unused", false); err != nil {
+		t.Fatal(err)
+	}
+
+	testNode.Factory.ImageSizeBytes = 100 * 1048576
+	defer func() { testNode.Factory.ImageSizeBytes = 0 }()
+
+	removed, err := container.CollectUnusedImages(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removedSet := make(map[string]bool)
+	for _, image := range removed {
+		removedSet[image] = true
+	}
+	if !removedSet["example/gc-unused"] {
+		t.Errorf("unused image not removed: %v", removed)
+	}
+	if removedSet["example/gc-used"] || !testNode.Factory.HasImage("example/gc-used") {
+		t.Errorf("image used by a warm container has been removed")
+	}
+
+	// images are removed without blocking the node, and containers created
+	// meanwhile wait for the removal and pull the image again
+	removing := &function.Function{Name: "gc-removing-fn", Runtime: container.CUSTOM_RUNTIME, CustomImage: "example/gc-removing"}
+	createFunction(t, removing)
+	if err := container.DownloadImage(removing.CustomImage, false); err != nil {
+		t.Fatal(err)
+	}
+	testNode.Factory.RemoveLatency = 500 * time.Millisecond
+	defer func() { testNode.Factory.RemoveLatency = 0 }()
+	collected := make(chan []string)
+	go func() {
+		removed, _ := container.CollectUnusedImages(0)
+		collected <- removed
+	}()
+
+	var state string
+	deadline := time.Now().Add(5 * time.Second)
+	for state != container.IMAGE_REMOVING && time.Now().Before(deadline) {
+		for _, status := range container.GetImagesStatus() {
+			if status.Image == removing.CustomImage {
+				state = status.State
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if state != container.IMAGE_REMOVING {
+		t.Fatalf("unexpected image state: %s", state)
+	}
+	pulls := testNode.Factory.Pulls()
+	if resp, _ := invoke(t, testNode.URL, removing.Name, client.InvocationRequest{}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("invocation failed: %s", resp.Status)
+	}
+	removedSet = make(map[string]bool)
+	for _, image := range <-collected {
+		removedSet[image] = true
+	}
+	if !removedSet[removing.CustomImage] {
+		t.Errorf("unused image not removed")
+	}
+	if testNode.Factory.Pulls() != pulls+1 || !testNode.Factory.HasImage(removing.CustomImage) {
+		t.Errorf("image not pulled again after removal")
+	}
+}
@@ -0,0 +1,138 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/api"
+	"github.com/grussorusso/serverledge/internal/client"
+	"github.com/grussorusso/serverledge/internal/function"
+)
+
+func v2Request(t *testing.T, method string, path string, body interface{}) *http.Response {
+	t.Helper()
+	return v2RequestIn(t, "", method, path, body)
+}
+
+// v2RequestIn sends a v2 API request referring to a namespace (the default
+// one if empty).
+func v2RequestIn(t *testing.T, namespace string, method string, path string, body interface{}) *http.Response {
+	t.Helper()
+	var payload io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, testNode.URL+"/v2"+path, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if namespace != "" {
+		req.Header.Set(function.NAMESPACE_HEADER, namespace)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func expectV2Error(t *testing.T, resp *http.Response, status int, code string) {
+	t.Helper()
+	if resp.StatusCode != status {
+		t.Errorf("expected status %d, got %s", status, resp.Status)
+	}
+	var apiErr api.Error
+	decode(t, resp, &apiErr)
+	if apiErr.Code != code {
+		t.Errorf("expected error code %s, got %+v", code, apiErr)
+	}
+}
+
+func TestV2API(t *testing.T) {
+	f := &function.Function{Name: "v2-fn", Runtime: "python310", Handler: "h.handler"}
+	resp := v2Request(t, http.MethodPost, "/functions", f)
+	var created function.Function
+	decode(t, resp, &created)
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("Location") != "/v2/functions/v2-fn" || created.MemoryMB != 128 {
+		t.Fatalf("unexpected creation response: %s %+v", resp.Status, created)
+	}
+	t.Cleanup(func() {
+		v2Request(t, http.MethodDelete, "/functions/"+f.Name, nil).Body.Close()
+	})
+
+	expectV2Error(t, v2Request(t, http.MethodPost, "/functions", f), http.StatusConflict, api.ERR_FUNCTION_EXISTS)
+	expectV2Error(t, v2Request(t, http.MethodPost, "/functions", &function.Function{Name: "v2-bad", Runtime: "nope"}),
+		http.StatusBadRequest, api.ERR_INVALID_RUNTIME)
+	expectV2Error(t, v2Request(t, http.MethodGet, "/functions/unknown", nil), http.StatusNotFound, api.ERR_FUNCTION_NOT_FOUND)
+	expectV2Error(t, v2Request(t, http.MethodGet, "/unknown", nil), http.StatusNotFound, api.ERR_NOT_FOUND)
+
+	// functions created through v2 are visible through v1
+	var list []string
+	listResp, err := http.Get(testNode.URL + "/function")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decode(t, listResp, &list)
+	found := false
+	for _, name := range list {
+		found = found || name == f.Name
+	}
+	if !found {
+		t.Errorf("function not listed by v1: %v", list)
+	}
+
+	// synchronous invocation
+	var response function.Response
+	resp = v2Request(t, http.MethodPost, "/functions/"+f.Name+"/invocations", client.InvocationRequest{Params: map[string]interface{}{"a": 1.0}})
+	decode(t, resp, &response)
+	if resp.StatusCode != http.StatusOK || !response.Success || response.Result != `{"a":1}` {
+		t.Errorf("unexpected invocation response: %s %+v", resp.Status, response)
+	}
+
+	// asynchronous invocation
+	var async api.AsyncInvocation
+	resp = v2Request(t, http.MethodPost, "/functions/"+f.Name+"/invocations", client.InvocationRequest{Async: true})
+	decode(t, resp, &async)
+	if resp.StatusCode != http.StatusAccepted || async.Location != "/v2/invocations/"+async.Id {
+		t.Fatalf("unexpected async response: %s %+v", resp.Status, async)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		resp = v2Request(t, http.MethodGet, "/invocations/"+async.Id, nil)
+		if resp.StatusCode == http.StatusOK {
+			resp.Body.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			expectV2Error(t, resp, http.StatusNotFound, api.ERR_INVOCATION_NOT_FOUND)
+			t.Fatalf("async result not available")
+		}
+		resp.Body.Close()
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// the OpenAPI document describes the routes
+	var doc struct {
+		OpenAPI string
+		Paths   map[string]map[string]interface{}
+	}
+	decode(t, v2Request(t, http.MethodGet, "/openapi.json", nil), &doc)
+	if _, ok := doc.Paths["/v2/functions/{name}/invocations"]["post"]; !ok || doc.OpenAPI == "" {
+		t.Errorf("incomplete OpenAPI document: %+v", doc)
+	}
+
+	resp = v2Request(t, http.MethodDelete, "/functions/"+f.Name, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("unexpected deletion response: %s", resp.Status)
+	}
+	expectV2Error(t, v2Request(t, http.MethodDelete, "/functions/"+f.Name, nil), http.StatusNotFound, api.ERR_FUNCTION_NOT_FOUND)
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/grussorusso/serverledge/internal/config"
 	"github.com/grussorusso/serverledge/internal/registration"
+	"github.com/grussorusso/serverledge/internal/tlsutil"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 )
@@ -31,12 +32,39 @@ func StartReverseProxy(e *echo.Echo, region string) {
 	log.Printf("Initializing with %d targets.\n", len(targets))
 	balancer := newBalancer(targets)
 	currentTargets = targets
-	e.Use(middleware.Proxy(balancer))
+
+	// requests are authenticated by the nodes through the credentials of
+	// the clients, which are forwarded: the certificate of the load
+	// balancer (if any) must not identify it as a node
+	clientConfig, err := tlsutil.ProxyConfig()
+	if err != nil {
+		log.Printf("Invalid TLS configuration: %v\n", err)
+		os.Exit(2)
+	}
+	var transport http.RoundTripper
+	if clientConfig != nil {
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		tr.TLSClientConfig = clientConfig
+		transport = tr
+	}
+	e.Use(middleware.ProxyWithConfig(middleware.ProxyConfig{Balancer: balancer, Transport: transport}))
 
 	go updateTargets(balancer, region)
 
 	portNumber := config.GetInt(config.API_PORT, 1323)
-	if err := e.Start(fmt.Sprintf(":%d", portNumber)); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	serverConfig, err := tlsutil.ServerConfig()
+	if err != nil {
+		log.Printf("Invalid TLS configuration: %v\n", err)
+		os.Exit(2)
+	}
+	if serverConfig != nil {
+		e.TLSServer.Addr = fmt.Sprintf(":%d", portNumber)
+		e.TLSServer.TLSConfig = serverConfig
+		err = e.StartServer(e.TLSServer)
+	} else {
+		err = e.Start(fmt.Sprintf(":%d", portNumber))
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		e.Logger.Fatal("shutting down the server")
 	}
 }
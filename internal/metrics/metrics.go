@@ -6,6 +6,8 @@ import (
 	"net/http"
 
 	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/container"
+	"github.com/grussorusso/serverledge/internal/function"
 	"github.com/grussorusso/serverledge/internal/node"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -45,24 +47,89 @@ var (
 		Name: "sedge_completed_total",
 		Help: "The total number of completed function invocations",
 	}, []string{"node", "function"})
+	DroppedInvocations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sedge_dropped_total",
+		Help: "The total number of dropped function invocations",
+	}, []string{"node", "function"})
+	CancelledInvocations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sedge_cancelled_total",
+		Help: "The total number of function invocations cancelled by clients",
+	}, []string{"node", "function"})
 	ExecutionTimes = promauto.NewHistogramVec(prometheus.HistogramOpts{
 		Name:    "sedge_exectime",
 		Help:    "Function duration",
 		Buckets: durationBuckets,
 	},
 		[]string{"node", "function"})
+	CPUTimes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sedge_cputime",
+		Help:    "CPU time used by function invocations",
+		Buckets: durationBuckets,
+	},
+		[]string{"node", "function"})
+	MemoryUsage = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sedge_memory_mb",
+		Help:    "Memory used by function invocations (MB)",
+		Buckets: memoryBuckets,
+	},
+		[]string{"node", "function"})
 )
 
 var durationBuckets = []float64{0.002, 0.005, 0.010, 0.02, 0.03, 0.05, 0.1, 0.15, 0.3, 0.6, 1.0}
+var memoryBuckets = []float64{8, 16, 32, 64, 128, 256, 512, 1024, 2048, 4096}
 
 func AddCompletedInvocation(funcName string) {
 	CompletedInvocations.With(prometheus.Labels{"function": funcName, "node": nodeIdentifier}).Inc()
 }
+func AddDroppedInvocation(funcName string) {
+	DroppedInvocations.With(prometheus.Labels{"function": funcName, "node": nodeIdentifier}).Inc()
+}
+func AddCancelledInvocation(funcName string) {
+	CancelledInvocations.With(prometheus.Labels{"function": funcName, "node": nodeIdentifier}).Inc()
+}
 func AddFunctionDurationValue(funcName string, duration float64) {
 	ExecutionTimes.With(prometheus.Labels{"function": funcName, "node": nodeIdentifier}).Observe(duration)
 }
 
+func AddResourceUsage(funcName string, usage *function.ResourceUsage) {
+	labels := prometheus.Labels{"function": funcName, "node": nodeIdentifier}
+	CPUTimes.With(labels).Observe(usage.CPUTime())
+	if memory := usage.MemoryMB(); memory > 0 {
+		MemoryUsage.With(labels).Observe(memory)
+	}
+}
+
 func registerGlobalMetrics() {
 	registry.MustRegister(CompletedInvocations)
+	registry.MustRegister(DroppedInvocations)
+	registry.MustRegister(CancelledInvocations)
 	registry.MustRegister(ExecutionTimes)
+	registry.MustRegister(CPUTimes)
+	registry.MustRegister(MemoryUsage)
+	registerImageMetrics()
+}
+
+// registerImageMetrics exposes the counters kept by the image manager.
+func registerImageMetrics() {
+	labels := prometheus.Labels{"node": nodeIdentifier}
+	registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name:        "sedge_image_pulls_total",
+		Help:        "The total number of image pulls",
+		ConstLabels: labels,
+	}, func() float64 { return float64(container.GetImageStats().Pulls) }))
+	registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name:        "sedge_image_pull_failures_total",
+		Help:        "The total number of failed image pulls",
+		ConstLabels: labels,
+	}, func() float64 { return float64(container.GetImageStats().FailedPulls) }))
+	registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        "sedge_image_pulls_in_progress",
+		Help:        "The number of image pulls in progress",
+		ConstLabels: labels,
+	}, func() float64 { return float64(container.GetImageStats().PullsInFlight) }))
+	registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name:        "sedge_image_removed_total",
+		Help:        "The total number of unused images garbage collected",
+		ConstLabels: labels,
+	}, func() float64 { return float64(container.GetImageStats().Removed) }))
 }
@@ -0,0 +1,58 @@
+package node
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+var draining atomic.Bool
+
+// inFlight counts the requests accepted by the node that have not been
+// completed yet (including queued and asynchronous ones, whose pending retries
+// and callback deliveries are counted as well).
+var inFlight atomic.Int64
+
+// StartDraining puts the node in drain mode: new requests will not be
+// served locally.
+func StartDraining() {
+	draining.Store(true)
+}
+
+// StopDraining makes the node accept new requests again.
+func StopDraining() {
+	draining.Store(false)
+}
+
+// IsDraining returns true if the node is in drain (maintenance) mode.
+func IsDraining() bool {
+	return draining.Load()
+}
+
+// BeginRequest must be called when the node takes charge of a request.
+func BeginRequest() {
+	inFlight.Add(1)
+}
+
+// EndRequest must be called when a request has been completely served.
+func EndRequest() {
+	inFlight.Add(-1)
+}
+
+// InFlightRequests returns the number of requests that have not been
+// completed yet.
+func InFlightRequests() int64 {
+	return inFlight.Load()
+}
+
+// WaitForInFlightRequests waits until all the in-flight requests complete
+// or the timeout expires. It returns true if no request is pending.
+func WaitForInFlightRequests(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for InFlightRequests() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package node
+
+import (
+	"log"
+
+	"github.com/grussorusso/serverledge/internal/container"
+)
+
+type containerToCheck struct {
+	contID container.ContainerID
+	pool   *ContainerPool
+}
+
+// CheckWarmContainers probes the Executors of warm containers, and destroys
+// the containers that are not responding. Busy containers are not checked,
+// as failures are detected upon invocation.
+func CheckWarmContainers() {
+	Resources.RLock()
+	toCheck := make([]containerToCheck, 0)
+	for _, pool := range Resources.ContainerPools {
+		for elem := pool.ready.Front(); elem != nil; elem = elem.Next() {
+			toCheck = append(toCheck, containerToCheck{elem.Value.(warmContainer).contID, pool})
+		}
+	}
+	Resources.RUnlock()
+
+	// containers are probed without holding the lock
+	unhealthy := make([]containerToCheck, 0)
+	for _, c := range toCheck {
+		if err := container.CheckHealth(c.contID); err != nil {
+			log.Printf("Container %s is not healthy: %v\n", c.contID, err)
+			unhealthy = append(unhealthy, c)
+		}
+	}
+	if len(unhealthy) == 0 {
+		return
+	}
+
+	Resources.Lock()
+	toDestroy := make([]container.ContainerID, 0, len(unhealthy))
+	for _, c := range unhealthy {
+		// the container might have been acquired or removed meanwhile
+		for elem := c.pool.ready.Front(); elem != nil; elem = elem.Next() {
+			if elem.Value.(warmContainer).contID == c.contID {
+				c.pool.ready.Remove(elem)
+				memory, _ := container.GetMemoryMB(c.contID)
+				releaseResources(0, memory)
+				toDestroy = append(toDestroy, c.contID)
+				break
+			}
+		}
+	}
+	Resources.Unlock()
+
+	for _, contID := range toDestroy {
+		log.Printf("Removing unhealthy container %s\n", contID)
+		if err := container.Destroy(contID); err != nil {
+			log.Printf("Error while destroying container %s: %s\n", contID, err)
+		}
+	}
+}
@@ -32,10 +32,22 @@ func GetJanitorInstance() *janitor {
 
 func (j *janitor) run() {
 	ticker := time.NewTicker(j.Interval)
+
+	// warm containers are periodically probed as well (if enabled)
+	var healthChan <-chan time.Time
+	healthInterval := time.Duration(config.GetInt(config.CONTAINER_HEALTH_INTERVAL, 30)) * time.Second
+	if healthInterval > 0 {
+		healthTicker := time.NewTicker(healthInterval)
+		defer healthTicker.Stop()
+		healthChan = healthTicker.C
+	}
+
 	for {
 		select {
 		case <-ticker.C:
 			DeleteExpiredContainer()
+		case <-healthChan:
+			CheckWarmContainers()
 		case <-j.stop:
 			ticker.Stop()
 			return
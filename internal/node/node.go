@@ -15,6 +15,7 @@ type NodeResources struct {
 	AvailableMemMB int64
 	AvailableCPUs  float64
 	DropCount      int64
+	CancelCount    int64
 	ContainerPools map[string]*ContainerPool
 }
 
@@ -13,8 +13,12 @@ import (
 )
 
 type ContainerPool struct {
-	busy  *list.List // list of ContainerID
+	busy  *list.List // list of *busyContainer
 	ready *list.List // list of warmContainer
+
+	namespace string
+	memoryMB  int64 // memory of each container
+	reserved  int   // containers being created (resources already acquired)
 }
 
 type warmContainer struct {
@@ -22,20 +26,46 @@ type warmContainer struct {
 	contID     container.ContainerID
 }
 
+// busyContainer is a container serving at least one invocation. Containers
+// of functions with MaxConcurrency > 1 may serve several invocations at once.
+type busyContainer struct {
+	contID    container.ContainerID
+	inFlight  int
+	broken    bool    // to be destroyed as soon as it serves no more invocations
+	cpuDemand float64 // CPU reserved for the container (see CPUDemandOf)
+}
+
 var NoWarmFoundErr = errors.New("no warm container is available")
 
 // getFunctionPool retrieves (or creates) the container pool for a function.
 func getFunctionPool(f *function.Function) *ContainerPool {
-	if fp, ok := Resources.ContainerPools[f.Name]; ok {
+	if fp, ok := Resources.ContainerPools[f.Id()]; ok {
 		return fp
 	}
 
 	fp := newFunctionPool(f)
-	Resources.ContainerPools[f.Name] = fp
+	Resources.ContainerPools[f.Id()] = fp
 	return fp
 }
 
-func (fp *ContainerPool) getWarmContainer() (container.ContainerID, bool) {
+// getSharedContainer looks for a busy container that can accept another
+// concurrent invocation.
+func (fp *ContainerPool) getSharedContainer(maxConcurrency int) (*busyContainer, bool) {
+	if maxConcurrency < 2 {
+		return nil, false
+	}
+
+	for elem := fp.busy.Front(); elem != nil; elem = elem.Next() {
+		bc := elem.Value.(*busyContainer)
+		if bc.inFlight < maxConcurrency && !bc.broken {
+			return bc, true
+		}
+	}
+
+	return nil, false
+}
+
+func (fp *ContainerPool) getWarmContainer(cpuDemand float64) (container.ContainerID, bool) {
 	// TODO: picking most-recent / least-recent container might be better?
 	elem := fp.ready.Front()
 	if elem == nil {
@@ -43,13 +73,13 @@ func (fp *ContainerPool) getWarmContainer() (container.ContainerID, bool) {
 	}
 
 	wc := fp.ready.Remove(elem).(warmContainer)
-	fp.putBusyContainer(wc.contID)
+	fp.putBusyContainer(wc.contID, cpuDemand)
 
 	return wc.contID, true
 }
 
-func (fp *ContainerPool) putBusyContainer(contID container.ContainerID) {
-	fp.busy.PushBack(contID)
+func (fp *ContainerPool) putBusyContainer(contID container.ContainerID, cpuDemand float64) {
+	fp.busy.PushBack(&busyContainer{contID: contID, inFlight: 1, cpuDemand: cpuDemand})
 }
 
 func (fp *ContainerPool) putReadyContainer(contID container.ContainerID, expiration int64) {
@@ -59,42 +89,66 @@ func (fp *ContainerPool) putReadyContainer(contID container.ContainerID, expirat
 	})
 }
 
-func newFunctionPool(_ *function.Function) *ContainerPool {
-	fp := &ContainerPool{}
+func newFunctionPool(f *function.Function) *ContainerPool {
+	fp := &ContainerPool{namespace: f.GetNamespace(), memoryMB: f.MemoryMB}
 	fp.busy = list.New()
 	fp.ready = list.New()
 
 	return fp
 }
 
-// AcquireResources reserves the specified amount of cpu and memory if possible.
-func AcquireResources(cpuDemand float64, memDemand int64, destroyContainersIfNeeded bool) bool {
+// AcquireResources reserves the specified amount of cpu and memory for a
+// function if possible (see acquireResources).
+func AcquireResources(fun *function.Function, cpuDemand float64, memDemand int64, destroyContainersIfNeeded bool) error {
 	Resources.Lock()
 	defer Resources.Unlock()
-	return acquireResources(cpuDemand, memDemand, destroyContainersIfNeeded)
+	return acquireResources(fun, cpuDemand, memDemand, destroyContainersIfNeeded)
 }
 
-// acquireResources reserves the specified amount of cpu and memory if possible.
+// acquireResources reserves the specified amount of cpu and memory for a
+// function if possible. Reserving memory means that a new container is going
+// to be added to the function pool, which must be allowed by the quota of the
+// function namespace (QuotaExceededErr otherwise).
 // The function is NOT thread-safe.
-func acquireResources(cpuDemand float64, memDemand int64, destroyContainersIfNeeded bool) bool {
+func acquireResources(fun *function.Function, cpuDemand float64, memDemand int64, destroyContainersIfNeeded bool) error {
+	if memDemand > 0 {
+		if err := checkContainerQuota(fun.GetNamespace(), memDemand); err != nil {
+			if !destroyContainersIfNeeded {
+				return err
+			}
+			// idle containers of the namespace make room for the new one,
+			// provided that the node has enough resources for it
+			toDismiss, ok := namespaceContainersToDismiss(fun, memDemand)
+			if !ok {
+				return err
+			}
+			if Resources.AvailableCPUs < cpuDemand || !enoughMemoryAfterDismissal(toDismiss, memDemand) {
+				return OutOfResourcesErr
+			}
+			dismissContainers(toDismiss)
+		}
+	}
 	if Resources.AvailableCPUs < cpuDemand {
-		return false
+		return OutOfResourcesErr
 	}
 	if Resources.AvailableMemMB < memDemand {
 		if !destroyContainersIfNeeded {
-			return false
+			return OutOfResourcesErr
 		}
 
 		enoughMem, _ := dismissContainer(memDemand)
 		if !enoughMem {
-			return false
+			return OutOfResourcesErr
 		}
 	}
 
 	Resources.AvailableCPUs -= cpuDemand
 	Resources.AvailableMemMB -= memDemand
+	if memDemand > 0 {
+		getFunctionPool(fun).reserved++
+	}
 
-	return true
+	return nil
 }
 
 // releaseResources releases the specified amount of cpu and memory.
@@ -107,6 +161,8 @@ func releaseResources(cpuDemand float64, memDemand int64) {
 // AcquireWarmContainer acquires a warm container for a given function (if any).
 // A warm container is in running/paused state and has already been initialized
 // with the function code.
+// If the function allows concurrent invocations within the same container,
+// a busy container with spare concurrency is preferred.
 // The acquired container is already in the busy pool.
 // The function returns an error if either:
 // (i) the warm container does not exist
@@ -116,22 +172,44 @@ func AcquireWarmContainer(f *function.Function) (container.ContainerID, error) {
 	defer Resources.Unlock()
 
 	fp := getFunctionPool(f)
-	contID, found := fp.getWarmContainer()
-	if !found {
+
+	// CPU has already been reserved for shared containers
+	if bc, found := fp.getSharedContainer(f.GetMaxConcurrency()); found {
+		bc.inFlight++
+		return bc.contID, nil
+	}
+
+	if fp.ready.Len() == 0 {
 		return "", NoWarmFoundErr
 	}
 
-	if !acquireResources(f.CPUDemand, 0, false) {
+	cpuDemand := CPUDemandOf(f)
+	if err := acquireResources(f, cpuDemand, 0, false); err != nil {
 		//log.Printf("Not enough CPU to start a warm container for %s", f)
-		return "", OutOfResourcesErr
+		return "", err
 	}
 
+	contID, _ := fp.getWarmContainer(cpuDemand)
+
 	//log.Printf("Using warm %s for %s. Now: %v", contID, f, Resources)
 	return contID, nil
 }
 
-// ReleaseContainer puts a container in the ready pool for a function.
+// ReleaseContainer releases a container after an invocation. As soon as the
+// container serves no more invocations, it is put in the ready pool for the
+// function.
 func ReleaseContainer(contID container.ContainerID, f *function.Function) {
+	releaseContainer(contID, f, false)
+}
+
+// ReleaseBrokenContainer releases a container that failed to serve an
+// invocation. As soon as the container serves no more invocations, it is
+// destroyed.
+func ReleaseBrokenContainer(contID container.ContainerID, f *function.Function) {
+	releaseContainer(contID, f, true)
+}
+
+func releaseContainer(contID container.ContainerID, f *function.Function, broken bool) {
 	// setup Expiration as time duration from now
 	d := time.Duration(config.GetInt(config.CONTAINER_EXPIRATION_TIME, 600)) * time.Second
 	expTime := time.Now().Add(d).UnixNano()
@@ -142,22 +220,41 @@ func ReleaseContainer(contID container.ContainerID, f *function.Function) {
 	fp := getFunctionPool(f)
 
 	// we must update the busy list by removing this element
-	var removed container.ContainerID
-	elem := fp.busy.Front()
-	for ok := elem != nil; ok; ok = elem != nil {
-		if elem.Value.(container.ContainerID) == contID {
-			removed = fp.busy.Remove(elem).(container.ContainerID)
+	var released *busyContainer
+	for elem := fp.busy.Front(); elem != nil; elem = elem.Next() {
+		bc := elem.Value.(*busyContainer)
+		if bc.contID == contID {
+			bc.inFlight--
+			bc.broken = bc.broken || broken
+			if bc.inFlight > 0 {
+				// still serving other invocations
+				return
+			}
+			released = fp.busy.Remove(elem).(*busyContainer)
 			break
 		}
-		elem = elem.Next()
 	}
-	if removed != contID {
-		panic("Failed to release container")
+	if released == nil {
+		// the container has been destroyed meanwhile (e.g., while draining
+		// the node) and resources have already been released
+		log.Printf("Released container %s is not in the busy pool\n", contID)
+		return
+	}
+
+	if released.broken {
+		log.Printf("Destroying broken container %s\n", contID)
+		releaseResources(released.cpuDemand, f.MemoryMB)
+		go func() {
+			if err := container.Destroy(contID); err != nil {
+				log.Printf("Error while destroying container %s: %s\n", contID, err)
+			}
+		}()
+		return
 	}
 
 	fp.putReadyContainer(contID, expTime)
 
-	releaseResources(f.CPUDemand, 0)
+	releaseResources(released.cpuDemand, 0)
 
 	//log.Printf("Released resources. Now: %v", Resources)
 }
@@ -166,17 +263,18 @@ func ReleaseContainer(contID container.ContainerID, f *function.Function) {
 // The container can be directly used to schedule a request, as it is already
 // in the busy pool.
 func NewContainer(fun *function.Function) (container.ContainerID, error) {
+	cpuDemand := CPUDemandOf(fun)
 	Resources.Lock()
-	if !acquireResources(fun.CPUDemand, fun.MemoryMB, true) {
+	if err := acquireResources(fun, cpuDemand, fun.MemoryMB, true); err != nil {
 		//log.Printf("Not enough resources for the new container.")
 		Resources.Unlock()
-		return "", OutOfResourcesErr
+		return "", err
 	}
 
 	//log.Printf("Acquired resources for new container. Now: %v", Resources)
 	Resources.Unlock()
 
-	return NewContainerWithAcquiredResources(fun)
+	return NewContainerWithAcquiredResources(fun, cpuDemand)
 }
 
 func getImageForFunction(fun *function.Function) (string, error) {
@@ -184,7 +282,7 @@ func getImageForFunction(fun *function.Function) (string, error) {
 	if fun.Runtime == container.CUSTOM_RUNTIME {
 		image = fun.CustomImage
 	} else {
-		runtime, ok := container.RuntimeToInfo[fun.Runtime]
+		runtime, ok := container.GetRuntimeInfo(fun.Runtime)
 		if !ok {
 			log.Printf("Unknown runtime: %s\n", fun.Runtime)
 			return "", fmt.Errorf("Invalid runtime: %s", fun.Runtime)
@@ -195,32 +293,35 @@ func getImageForFunction(fun *function.Function) (string, error) {
 }
 
 // NewContainerWithAcquiredResources spawns a new container for the given
-// function, assuming that the required CPU and memory resources have been
-// already been acquired.
-func NewContainerWithAcquiredResources(fun *function.Function) (container.ContainerID, error) {
+// function, assuming that the required CPU (cpuDemand) and memory resources
+// have been already been acquired.
+func NewContainerWithAcquiredResources(fun *function.Function, cpuDemand float64) (container.ContainerID, error) {
+	var contID container.ContainerID
 	image, err := getImageForFunction(fun)
-	if err != nil {
-		return "", err
+	if err == nil {
+		contID, err = container.NewContainer(image, fun.TarFunctionCode, &container.ContainerOptions{
+			MemoryMB: fun.MemoryMB,
+			CPUQuota: fun.CPUDemand,
+			Labels:   ContainerLabels(fun, image),
+			// the Executor may serve up to MaxConcurrency invocations at once
+			Env: []string{fmt.Sprintf("MAX_CONCURRENCY=%d", fun.GetMaxConcurrency())},
+		})
 	}
 
-	contID, err := container.NewContainer(image, fun.TarFunctionCode, &container.ContainerOptions{
-		MemoryMB: fun.MemoryMB,
-		CPUQuota: fun.CPUDemand,
-	})
-
 	if err != nil {
 		log.Printf("Failed container creation: %v\n", err)
 	}
 
 	Resources.Lock()
 	defer Resources.Unlock()
+	fp := getFunctionPool(fun)
+	fp.reserved--
 	if err != nil {
-		releaseResources(fun.CPUDemand, fun.MemoryMB)
+		releaseResources(cpuDemand, fun.MemoryMB)
 		return "", err
 	}
 
-	fp := getFunctionPool(fun)
-	fp.putBusyContainer(contID) // We immediately mark it as busy
+	fp.putBusyContainer(contID, cpuDemand) // We immediately mark it as busy
 
 	return contID, nil
 }
@@ -319,7 +420,7 @@ func ShutdownWarmContainersFor(f *function.Function) {
 	Resources.Lock()
 	defer Resources.Unlock()
 
-	fp, ok := Resources.ContainerPools[f.Name]
+	fp, ok := Resources.ContainerPools[f.Id()]
 	if !ok {
 		return
 	}
@@ -356,7 +457,7 @@ func ShutdownAllContainers() {
 	Resources.Lock()
 	defer Resources.Unlock()
 
-	for fun, pool := range Resources.ContainerPools {
+	for _, pool := range Resources.ContainerPools {
 		elem := pool.ready.Front()
 		for ok := elem != nil; ok; ok = elem != nil {
 			warmed := elem.Value.(warmContainer)
@@ -373,15 +474,14 @@ func ShutdownAllContainers() {
 			Resources.AvailableMemMB += memory
 		}
 
-		functionDescriptor, _ := function.GetFunction(fun)
-
 		elem = pool.busy.Front()
 		for ok := elem != nil; ok; ok = elem != nil {
-			contID := elem.Value.(container.ContainerID)
+			contID := elem.Value.(*busyContainer).contID
+			cpuDemand := elem.Value.(*busyContainer).cpuDemand
 			temp := elem
 			elem = elem.Next()
 			log.Printf("Removing container with ID %s\n", contID)
-			pool.ready.Remove(temp)
+			pool.busy.Remove(temp)
 
 			memory, _ := container.GetMemoryMB(contID)
 			err := container.Destroy(contID)
@@ -389,7 +489,7 @@ func ShutdownAllContainers() {
 				log.Printf("Error while destroying container %s: %s", contID, err)
 			}
 			Resources.AvailableMemMB += memory
-			Resources.AvailableCPUs += functionDescriptor.CPUDemand
+			Resources.AvailableCPUs += cpuDemand
 		}
 	}
 }
@@ -418,13 +518,47 @@ func PrewarmInstances(f *function.Function, count int64, forcePull bool) (int64,
 
 	var spawned int64 = 0
 	for spawned < count {
-		_, err = NewContainer(f)
+		contID, err := NewContainer(f)
 		if err != nil {
 			log.Printf("Prespawning failed: %v\n", err)
 			return spawned, err
 		}
+		// warm containers are expected to serve requests right away
+		if err = container.WaitForReadiness(contID); err != nil {
+			log.Printf("Prespawned container not ready: %v\n", err)
+			ReleaseBrokenContainer(contID, f)
+			return spawned, err
+		}
+		// new containers are marked as busy: make it available for requests
+		ReleaseContainer(contID, f)
 		spawned += 1
 	}
 
 	return spawned, nil
 }
+
+// PrePullImages pulls the images of all the registered functions, so that
+// their first cold start does not wait for the download.
+func PrePullImages() {
+	functions, err := function.GetAll()
+	if err != nil {
+		log.Printf("Could not retrieve functions for image pre-pulling: %v\n", err)
+		return
+	}
+
+	pulled := make(map[string]bool)
+	for _, name := range functions {
+		f, ok := function.GetFunction(name)
+		if !ok {
+			continue
+		}
+		image, err := getImageForFunction(f)
+		if err != nil || pulled[image] {
+			continue
+		}
+		pulled[image] = true
+		if err := container.DownloadImage(image, false); err != nil {
+			log.Printf("Could not pre-pull image %s: %v\n", image, err)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package node
+
+import (
+	"errors"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/container"
+	"github.com/grussorusso/serverledge/internal/function"
+)
+
+var QuotaExceededErr = errors.New("namespace quota exceeded")
+
+// NamespaceUsage reports the resources used by the functions of a namespace
+// on this node.
+type NamespaceUsage struct {
+	Containers int
+	MemoryMB   int64
+}
+
+// namespaceUsage computes the resources used by a namespace, including
+// containers being created. The function is NOT thread-safe.
+func namespaceUsage(namespace string) NamespaceUsage {
+	var usage NamespaceUsage
+	for _, fp := range Resources.ContainerPools {
+		if fp.namespace != namespace {
+			continue
+		}
+		count := fp.busy.Len() + fp.ready.Len() + fp.reserved
+		usage.Containers += count
+		usage.MemoryMB += int64(count) * fp.memoryMB
+	}
+	return usage
+}
+
+// GetNamespaceUsage returns the resources used by a namespace on this node.
+func GetNamespaceUsage(namespace string) NamespaceUsage {
+	Resources.RLock()
+	defer Resources.RUnlock()
+	return namespaceUsage(namespace)
+}
+
+// checkContainerQuota checks whether a new container with the given amount of
+// memory is allowed by the quota of a namespace. The function is NOT
+// thread-safe.
+func checkContainerQuota(namespace string, memDemand int64) error {
+	ns, ok := function.GetNamespace(namespace)
+	if !ok {
+		return nil
+	}
+	if !quotaAllows(ns.Quota, namespaceUsage(namespace), memDemand) {
+		return QuotaExceededErr
+	}
+	return nil
+}
+
+// quotaAllows returns true if a new container with the given amount of memory
+// fits in the quota, given the current usage.
+func quotaAllows(quota function.Quota, usage NamespaceUsage, memDemand int64) bool {
+	if quota.MaxContainers > 0 && usage.Containers+1 > quota.MaxContainers {
+		return false
+	}
+	if quota.MaxMemoryMB > 0 && usage.MemoryMB+memDemand > quota.MaxMemoryMB {
+		return false
+	}
+	return true
+}
+
+// namespaceContainersToDismiss selects the warm containers of the other
+// functions of the namespace of fun to be dismissed, so that a new container
+// with the given amount of memory is allowed by the quota. It returns false
+// if this is not enough to make room for the new one. The function is NOT
+// thread-safe.
+func namespaceContainersToDismiss(fun *function.Function, memDemand int64) ([]itemToDismiss, bool) {
+	namespace := fun.GetNamespace()
+	ns, ok := function.GetNamespace(namespace)
+	if !ok {
+		return nil, true
+	}
+
+	// warm containers of the same function are not dismissed, as they
+	// would be used instead of a new one (unless prewarming)
+	var toDismiss []itemToDismiss
+	usage := namespaceUsage(namespace)
+	for id, fp := range Resources.ContainerPools {
+		if fp.namespace != namespace || id == fun.Id() {
+			continue
+		}
+		for elem := fp.ready.Front(); elem != nil && !quotaAllows(ns.Quota, usage, memDemand); elem = elem.Next() {
+			contID := elem.Value.(warmContainer).contID
+			memory, _ := container.GetMemoryMB(contID)
+			toDismiss = append(toDismiss, itemToDismiss{contID: contID, pool: fp, elem: elem, memory: memory})
+			usage.Containers--
+			usage.MemoryMB -= fp.memoryMB
+		}
+	}
+	return toDismiss, quotaAllows(ns.Quota, usage, memDemand)
+}
+
+// enoughMemoryAfterDismissal checks whether the node would have the given
+// amount of memory after dismissing some warm containers, possibly along with
+// other ones (see dismissContainer). The function is NOT thread-safe.
+func enoughMemoryAfterDismissal(toDismiss []itemToDismiss, memDemand int64) bool {
+	var dismissedMB int64
+	for _, item := range toDismiss {
+		dismissedMB += item.memory
+	}
+	if Resources.AvailableMemMB+dismissedMB >= memDemand {
+		return true
+	}
+
+	var readyMB int64
+	for _, fp := range Resources.ContainerPools {
+		for elem := fp.ready.Front(); elem != nil; elem = elem.Next() {
+			memory, _ := container.GetMemoryMB(elem.Value.(warmContainer).contID)
+			readyMB += memory
+		}
+	}
+	return readyMB-dismissedMB >= memDemand
+}
+
+// dismissContainers destroys the given warm containers, releasing their
+// memory. The function is NOT thread-safe.
+func dismissContainers(toDismiss []itemToDismiss) {
+	for _, item := range toDismiss {
+		item.pool.ready.Remove(item.elem)
+		if err := container.Destroy(item.contID); err != nil {
+			log.Printf("Failed to destroy container %s: %v\n", item.contID, err)
+		}
+		Resources.AvailableMemMB += item.memory
+	}
+}
+
+// tokenBucket limits the invocation rate of a namespace
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+var buckets = make(map[string]*tokenBucket)
+var bucketsLock sync.Mutex
+
+// AdmitInvocation checks whether an invocation of the function is allowed
+// by the invocation rate quota of its namespace. Bursts of up to one second
+// worth of invocations are allowed.
+func AdmitInvocation(fun *function.Function) error {
+	namespace := fun.GetNamespace()
+	ns, ok := function.GetNamespace(namespace)
+	if !ok || ns.Quota.MaxInvocationRate <= 0 {
+		return nil
+	}
+	rate := ns.Quota.MaxInvocationRate
+	burst := math.Max(1.0, rate)
+
+	bucketsLock.Lock()
+	defer bucketsLock.Unlock()
+
+	now := time.Now()
+	b, ok := buckets[namespace]
+	if !ok {
+		b = &tokenBucket{tokens: burst, last: now}
+		buckets[namespace] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens < 1.0 {
+		return QuotaExceededErr
+	}
+	b.tokens -= 1.0
+	return nil
+}
@@ -0,0 +1,138 @@
+package node
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/container"
+	"github.com/grussorusso/serverledge/internal/function"
+)
+
+// Labels attached to the containers created by the node
+const (
+	LABEL_NODE     = "serverledge.node"
+	LABEL_FUNCTION = "serverledge.function"
+	LABEL_CODE     = "serverledge.code"
+)
+
+// GetNodeID returns a stable identifier of the node, which does not change
+// across restarts (unlike NodeIdentifier).
+func GetNodeID() string {
+	if id := config.GetString(config.NODE_ID, ""); id != "" {
+		return id
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	return fmt.Sprintf("%s-%d", hostname, config.GetInt(config.API_PORT, 1323))
+}
+
+// codeDigest identifies the code run by the containers of a function, along
+// with the resource limits and the concurrency they have been created with.
+func codeDigest(fun *function.Function, image string) string {
+	h := sha256.New()
+	for _, s := range []string{image, fun.Runtime, fun.Handler, fun.TarFunctionCode,
+		fmt.Sprint(fun.MemoryMB), fmt.Sprint(fun.CPUDemand), fmt.Sprint(fun.GetMaxConcurrency())} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ContainerLabels returns the labels attached to the containers of a function.
+func ContainerLabels(fun *function.Function, image string) map[string]string {
+	return map[string]string{
+		LABEL_NODE:     GetNodeID(),
+		LABEL_FUNCTION: fun.Id(),
+		LABEL_CODE:     codeDigest(fun, image),
+	}
+}
+
+// ReconcileContainers looks for containers left behind by a previous run of
+// the node (e.g., after a crash). Running containers of existing functions
+// whose code, resource limits and concurrency have not changed are adopted as
+// warm containers, as long as enough memory is available; the others are
+// destroyed.
+func ReconcileContainers() {
+	containers, err := container.ListContainers(map[string]string{LABEL_NODE: GetNodeID()})
+	if err != nil {
+		log.Printf("Container reconciliation skipped: %v\n", err)
+		return
+	}
+
+	d := time.Duration(config.GetInt(config.CONTAINER_EXPIRATION_TIME, 600)) * time.Second
+	expTime := time.Now().Add(d).UnixNano()
+
+	adopted, destroyed := 0, 0
+	for _, c := range containers {
+		if isKnownContainer(c.ID) {
+			continue
+		}
+
+		if fun, image, ok := canAdopt(c); ok {
+			Resources.Lock()
+			if acquireResources(fun, 0, fun.MemoryMB, false) == nil {
+				fp := getFunctionPool(fun)
+				fp.reserved-- // adopted right away
+				fp.putReadyContainer(c.ID, expTime)
+				Resources.Unlock()
+				container.AdoptContainer(c.ID, image)
+				adopted++
+				continue
+			}
+			Resources.Unlock()
+			log.Printf("Not enough memory to adopt container %s\n", c.ID)
+		}
+
+		if err := container.Destroy(c.ID); err != nil {
+			log.Printf("Could not destroy orphan container %s: %v\n", c.ID, err)
+			continue
+		}
+		destroyed++
+	}
+
+	if adopted+destroyed > 0 {
+		log.Printf("Container reconciliation: %d adopted, %d destroyed. Now: %v\n", adopted, destroyed, &Resources)
+	}
+}
+
+// canAdopt checks whether a container can be reused to serve its function.
+func canAdopt(c container.ContainerInfo) (*function.Function, string, bool) {
+	if !c.Running {
+		return nil, "", false
+	}
+	fun, ok := function.GetFunction(c.Labels[LABEL_FUNCTION])
+	if !ok {
+		return nil, "", false
+	}
+	image, err := getImageForFunction(fun)
+	if err != nil || codeDigest(fun, image) != c.Labels[LABEL_CODE] {
+		return nil, "", false
+	}
+	return fun, image, true
+}
+
+func isKnownContainer(contID container.ContainerID) bool {
+	Resources.RLock()
+	defer Resources.RUnlock()
+
+	for _, fp := range Resources.ContainerPools {
+		for elem := fp.ready.Front(); elem != nil; elem = elem.Next() {
+			if elem.Value.(warmContainer).contID == contID {
+				return true
+			}
+		}
+		for elem := fp.busy.Front(); elem != nil; elem = elem.Next() {
+			if elem.Value.(*busyContainer).contID == contID {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package node
+
+import (
+	"math"
+	"sync"
+
+	"github.com/grussorusso/serverledge/internal/function"
+)
+
+// weight of new samples in the moving average of the CPU demand
+const usageSmoothing = 0.2
+
+// ObservedUsage summarizes the resources actually used by the invocations of
+// a function, to be compared with the declared CPUDemand and MemoryMB.
+type ObservedUsage struct {
+	CPUDemand   float64 // moving average of the used cores (1.0 -> 1 core)
+	MaxMemoryMB float64 // peak memory usage
+	Samples     int64
+}
+
+var observedUsage = make(map[string]*ObservedUsage)
+var usageLock sync.RWMutex
+
+// RecordUsage updates the observed usage of a function with the resources
+// used by an invocation.
+func RecordUsage(fun *function.Function, report *function.ExecutionReport) {
+	if report.Usage == nil || report.Duration <= 0 {
+		return
+	}
+	cpuDemand := report.Usage.CPUTime() / report.Duration
+
+	usageLock.Lock()
+	defer usageLock.Unlock()
+
+	u, ok := observedUsage[fun.Id()]
+	if !ok {
+		u = &ObservedUsage{CPUDemand: cpuDemand}
+		observedUsage[fun.Id()] = u
+	} else {
+		u.CPUDemand = usageSmoothing*cpuDemand + (1-usageSmoothing)*u.CPUDemand
+	}
+	u.MaxMemoryMB = math.Max(u.MaxMemoryMB, report.Usage.MemoryMB())
+	u.Samples++
+}
+
+// GetObservedUsage returns the observed usage of a function, if any.
+func GetObservedUsage(funName string) (ObservedUsage, bool) {
+	usageLock.RLock()
+	defer usageLock.RUnlock()
+
+	u, ok := observedUsage[funName]
+	if !ok {
+		return ObservedUsage{}, false
+	}
+	return *u, true
+}
+
+// CPUDemandOf returns the CPU demand accounted for the containers of a
+// function serving invocations: the observed one, once available, up to the
+// declared CPUDemand (e.g., functions declaring a full core but mostly
+// waiting for I/O only account for the cores they actually use).
+func CPUDemandOf(fun *function.Function) float64 {
+	observed, ok := GetObservedUsage(fun.Id())
+	if !ok || observed.Samples == 0 {
+		return fun.CPUDemand
+	}
+	return math.Min(fun.CPUDemand, observed.CPUDemand)
+}
+
+// GetAllObservedUsage returns the observed usage of all the functions.
+func GetAllObservedUsage() map[string]ObservedUsage {
+	usageLock.RLock()
+	defer usageLock.RUnlock()
+
+	usage := make(map[string]ObservedUsage, len(observedUsage))
+	for name, u := range observedUsage {
+		usage[name] = *u
+	}
+	return usage
+}
+
+// ForgetUsage discards the observed usage of a (deleted) function.
+func ForgetUsage(funName string) {
+	usageLock.Lock()
+	defer usageLock.Unlock()
+	delete(observedUsage, funName)
+}
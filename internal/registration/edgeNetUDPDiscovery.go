@@ -10,6 +10,7 @@ import (
 
 	"github.com/grussorusso/serverledge/internal/config"
 	"github.com/grussorusso/serverledge/internal/node"
+	"github.com/grussorusso/serverledge/internal/tlsutil"
 	"github.com/grussorusso/serverledge/utils"
 )
 
@@ -68,13 +69,14 @@ func handleUDPConnection(conn *net.UDPConn) {
 
 func getCurrentStatusInformation() (status []byte, err error) {
 	portNumber := config.GetInt("api.port", 1323)
-	url := fmt.Sprintf("http://%s:%d", utils.GetIpAddress().String(), portNumber)
+	url := fmt.Sprintf("%s://%s:%d", tlsutil.Scheme(), utils.GetIpAddress().String(), portNumber)
 	response := StatusInformation{
 		Url:                     url,
 		AvailableWarmContainers: node.WarmStatus(),
 		AvailableMemMB:          node.Resources.AvailableMemMB,
 		AvailableCPUs:           node.Resources.AvailableCPUs,
 		DropCount:               node.Resources.DropCount,
+		CancelCount:             node.Resources.CancelCount,
 		Coordinates:             *Reg.Client.GetCoordinate(),
 	}
 
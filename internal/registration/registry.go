@@ -25,37 +25,81 @@ func (r *Registry) getEtcdKey(id string) (key string) {
 
 // RegisterToEtcd make a registration to the local Area; etcd put operation is performed
 func (r *Registry) RegisterToEtcd(hostport string) (string, error) {
+	//generate unique identifier
+	id := shortuuid.New() + strconv.FormatInt(time.Now().UnixNano(), 10)
+	r.Key = r.getEtcdKey(id)
+	r.hostport = hostport
+
+	if err := r.register(); err != nil {
+		log.Fatal(err)
+		return "", err
+	}
+
+	return r.Key, nil
+}
+
+// Reregister restores a registration previously removed through Deregister,
+// using the same key (and lease, if still alive).
+func (r *Registry) Reregister() error {
+	if r.Key == "" {
+		return fmt.Errorf("the node has never been registered")
+	}
+	if r.leaseID == clientv3.NoLease {
+		return r.register()
+	}
+
 	etcdClient, err := utils.GetEtcdClient()
 	if err != nil {
-		log.Fatal(UnavailableClientErr)
-		return "", UnavailableClientErr
+		return UnavailableClientErr
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	if _, err = etcdClient.Put(ctx, r.Key, r.hostport, clientv3.WithLease(r.leaseID)); err != nil {
+		// the lease may have expired in the meantime
+		r.leaseID = clientv3.NoLease
+		return r.register()
+	}
+	return nil
+}
+
+// URL returns the URL of the API of the node.
+func (r *Registry) URL() string {
+	return r.hostport
+}
+
+// LeaseID returns the lease of the registration, which is kept alive as long
+// as the node is running (even if deregistered).
+func (r *Registry) LeaseID() clientv3.LeaseID {
+	return r.leaseID
+}
+
+func (r *Registry) register() error {
+	etcdClient, err := utils.GetEtcdClient()
+	if err != nil {
+		return UnavailableClientErr
 	}
 
 	ctx, _ := context.WithTimeout(context.Background(), 1*time.Second)
-	//generate unique identifier
-	id := shortuuid.New() + strconv.FormatInt(time.Now().UnixNano(), 10)
-	r.Key = r.getEtcdKey(id)
 	resp, err := etcdClient.Grant(ctx, int64(TTL))
 	if err != nil {
-		log.Fatal(err)
-		return "", err
+		return err
 	}
 
 	log.Printf("Registration key: %s\n", r.Key)
 	// save couple (id, hostport) to the correct Area-dir on etcd
-	_, err = etcdClient.Put(ctx, r.Key, hostport, clientv3.WithLease(resp.ID))
+	_, err = etcdClient.Put(ctx, r.Key, r.hostport, clientv3.WithLease(resp.ID))
 	if err != nil {
-		log.Fatal(IdRegistrationErr)
-		return "", IdRegistrationErr
+		return IdRegistrationErr
 	}
+	r.leaseID = resp.ID
 
 	cancelCtx, _ := context.WithCancel(etcdClient.Ctx())
 
-	// the key id will be kept alive until a fault will occur
+	// the key id will be kept alive until a fault will occur (or the lease
+	// is revoked)
 	keepAliveCh, err := etcdClient.KeepAlive(cancelCtx, resp.ID)
 	if err != nil || keepAliveCh == nil {
-		log.Fatal(KeepAliveErr)
-		return "", KeepAliveErr
+		return KeepAliveErr
 	}
 
 	go func() {
@@ -65,7 +109,7 @@ func (r *Registry) RegisterToEtcd(hostport string) (string, error) {
 		}
 	}()
 
-	return r.Key, nil
+	return nil
 }
 
 // GetAll is used to obtain the list of  other server's addresses under a specific local Area
@@ -148,7 +192,9 @@ func GetCloudNodesInRegion(region string) (map[string]string, error) {
 	return servers, nil
 }
 
-// Deregister deletes from etcd the key, value pair previously inserted
+// Deregister deletes from etcd the key, value pair previously inserted. The
+// lease is kept alive, so that other nodes do not take over the async
+// requests of the node while it is draining (see Close).
 func (r *Registry) Deregister() (e error) {
 	etcdClient, err := utils.GetEtcdClient()
 	if err != nil {
@@ -165,3 +211,26 @@ func (r *Registry) Deregister() (e error) {
 	log.Println("Deregister : " + r.Key)
 	return nil
 }
+
+// Close deregisters the node and revokes its lease, when the node is
+// shutting down.
+func (r *Registry) Close() error {
+	if err := r.Deregister(); err != nil {
+		return err
+	}
+	etcdClient, err := utils.GetEtcdClient()
+	if err != nil {
+		return UnavailableClientErr
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	// revoking the lease stops the keep-alive loop
+	if r.leaseID != clientv3.NoLease {
+		if _, err = etcdClient.Revoke(ctx, r.leaseID); err != nil {
+			log.Printf("Could not revoke registration lease: %v\n", err)
+		}
+		r.leaseID = clientv3.NoLease
+	}
+	return nil
+}
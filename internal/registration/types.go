@@ -3,8 +3,12 @@ package registration
 import (
 	"errors"
 
+	"github.com/grussorusso/serverledge/internal/container"
+	"github.com/grussorusso/serverledge/internal/node"
+
 	"github.com/LK4D4/trylock"
 	"github.com/hexablock/vivaldi"
+	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
 var UnavailableClientErr = errors.New("etcd client unavailable")
@@ -19,6 +23,8 @@ type Registry struct {
 	NearbyServersMap map[string]*StatusInformation
 	serversMap       map[string]*StatusInformation
 	etcdCh           chan bool
+	hostport         string
+	leaseID          clientv3.LeaseID
 }
 
 type StatusInformation struct {
@@ -27,5 +33,8 @@ type StatusInformation struct {
 	AvailableMemMB          int64
 	AvailableCPUs           float64
 	DropCount               int64
+	CancelCount             int64
 	Coordinates             vivaldi.Coordinate
+	Images                  []container.ImageStatus       `json:",omitempty"` // only reported through the API
+	ObservedUsage           map[string]node.ObservedUsage `json:",omitempty"` // only reported through the API
 }
@@ -1,41 +1,78 @@
 package scheduling
 
 import (
-	"context"
 	"encoding/json"
-	"fmt"
 	"log"
+	"time"
 
+	"github.com/grussorusso/serverledge/internal/asyncstore"
 	"github.com/grussorusso/serverledge/internal/function"
-	"github.com/grussorusso/serverledge/utils"
-	clientv3 "go.etcd.io/etcd/client/v3"
+	"github.com/grussorusso/serverledge/internal/node"
 )
 
-func publishAsyncResponse(reqId string, response function.Response) {
-	etcdClient, err := utils.GetEtcdClient()
-	if err != nil {
-		log.Fatal("Client not available")
-		return
+// publishAsyncResponse publishes the response of an async request, which
+// succeeded or failed depending on the response.
+func publishAsyncResponse(r *function.Request, response function.Response) {
+	state := function.ASYNC_SUCCEEDED
+	if !response.Success {
+		state = function.ASYNC_FAILED
 	}
+	publishAsyncResult(r, response, state)
+}
 
-	ctx := context.Background()
-
-	resp, err := etcdClient.Grant(ctx, 1800)
+// publishAsyncResult stores the response of an async request, and moves the
+// request to the given (terminal) state. If the response cannot be stored, a
+// failed response is stored in its place.
+func publishAsyncResult(r *function.Request, response function.Response, state string) {
+	ttl := resultTTL(r)
+	payload, err := json.Marshal(response)
+	if err == nil {
+		err = storeAsyncResult(r, payload, ttl)
+	}
+	if err != nil && response.Success {
+		log.Printf("%v Could not store the result: %v\n", r, err)
+		state = function.ASYNC_FAILED
+		payload, _ = json.Marshal(function.Response{Success: false})
+		err = storeAsyncResult(r, payload, ttl)
+	}
 	if err != nil {
-		log.Fatal(err)
-		return
+		log.Printf("%v Could not store the response: %v\n", r, err)
 	}
 
-	key := fmt.Sprintf("async/%s", reqId)
-	payload, err := json.Marshal(response)
+	err = updateAsyncRecord(r, ttl, func(record *function.AsyncRequestRecord) {
+		record.State = state
+		record.Attempts = r.Attempts
+		record.LastError = r.LastError
+	})
 	if err != nil {
-		log.Printf("Could not marshal response: %v\n", err)
-		return
+		log.Printf("%v Could not update the state of the request: %v\n", r, err)
 	}
 
-	_, err = etcdClient.Put(ctx, key, string(payload), clientv3.WithLease(resp.ID))
+	if r.Callback != nil {
+		node.BeginRequest()
+		go func() {
+			defer node.EndRequest()
+			deliverCallback(r, payload)
+		}()
+	}
+}
+
+func storeAsyncResult(r *function.Request, payload []byte, ttl time.Duration) error {
+	store, err := asyncstore.Get()
 	if err != nil {
-		log.Fatal(err)
-		return
+		return err
+	}
+	return store.Put(r.Fun.GetNamespace(), r.ReqId, payload, ttl)
+}
+
+// resultTTL returns how long the result of an async request is kept: as
+// requested, or as configured for the function, or the default.
+func resultTTL(r *function.Request) time.Duration {
+	if r.ResultTTL > 0 {
+		return r.ResultTTL
+	}
+	if r.Fun != nil && r.Fun.AsyncResultTTL > 0 {
+		return time.Duration(r.Fun.AsyncResultTTL) * time.Second
 	}
+	return asyncstore.DefaultTTL()
 }
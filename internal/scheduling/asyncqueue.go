@@ -0,0 +1,325 @@
+package scheduling
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/asyncstore"
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/grussorusso/serverledge/internal/node"
+	"github.com/grussorusso/serverledge/internal/registration"
+	"github.com/grussorusso/serverledge/utils"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// AcceptAsyncRequest persists the record of a new async request, owned by
+// this node, before it is submitted. The request must not be submitted if
+// an error is returned, as it could be lost.
+func AcceptAsyncRequest(r *function.Request) error {
+	// the request is in flight as soon as it is accepted, so that draining
+	// waits for it (see SubmitAsyncRequest)
+	node.BeginRequest()
+	if err := persistAsyncRequest(r); err != nil {
+		node.EndRequest()
+		return err
+	}
+	return nil
+}
+
+func persistAsyncRequest(r *function.Request) error {
+	if !asyncRecordsEnabled() {
+		return nil
+	}
+	etcdClient, err := utils.GetEtcdClient()
+	if err != nil {
+		return err
+	}
+
+	record := newAsyncRecord(r, function.ASYNC_QUEUED)
+	setAsyncOwner(&record)
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// requests offloaded by other nodes replace their records
+	_, err = etcdClient.Put(ctx, function.AsyncRequestKey(record.Namespace, r.ReqId), string(payload))
+	return err
+}
+
+// newAsyncRecord returns the record of an async request in the given state.
+func newAsyncRecord(r *function.Request, state string) function.AsyncRequestRecord {
+	return function.AsyncRequestRecord{
+		AsyncRequestStatus: function.AsyncRequestStatus{
+			ReqId:     r.ReqId,
+			Function:  r.Fun.Name,
+			State:     state,
+			Accepted:  r.Arrival,
+			Updated:   time.Now(),
+			Attempts:  r.Attempts,
+			LastError: r.LastError,
+		},
+		Namespace:       r.Fun.GetNamespace(),
+		Params:          r.Params,
+		QoSClass:        int64(r.Class),
+		QoSMaxRespT:     r.MaxRespT,
+		CanDoOffloading: r.CanDoOffloading,
+		ReturnOutput:    r.ReturnOutput,
+		Callback:        r.Callback,
+		ResultTTL:       int64(r.ResultTTL / time.Second),
+	}
+}
+
+// setAsyncOwner records this node as the owner of a request.
+func setAsyncOwner(record *function.AsyncRequestRecord) {
+	record.Owner = node.NodeIdentifier
+	record.OwnerLease = int64(clientv3.NoLease)
+	record.Area = ""
+	if registration.Reg != nil {
+		record.OwnerLease = int64(registration.Reg.LeaseID())
+		record.Area = registration.Reg.Area
+	}
+}
+
+// setAsyncState updates the state of an async request owned by this node.
+func setAsyncState(r *function.Request, state string, offloadedTo string) {
+	err := updateAsyncRecord(r, 0, func(record *function.AsyncRequestRecord) {
+		record.State = state
+		record.OffloadedTo = offloadedTo
+	})
+	if err != nil {
+		log.Printf("%v Could not update the state of the request: %v\n", r, err)
+	}
+}
+
+// updateAsyncRecord modifies the record of an async request, unless it is no
+// longer owned by this node (e.g., it has been taken over by another node, or
+// offloaded to a node which has replaced the record). The record expires
+// after ttl, if positive.
+func updateAsyncRecord(r *function.Request, ttl time.Duration, update func(*function.AsyncRequestRecord)) error {
+	if !asyncRecordsEnabled() {
+		return nil
+	}
+	etcdClient, err := utils.GetEtcdClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	lease := clientv3.NoLease
+	if ttl > 0 {
+		resp, err := etcdClient.Grant(ctx, int64(math.Max(1, math.Ceil(ttl.Seconds()))))
+		if err != nil {
+			return err
+		}
+		lease = resp.ID
+	}
+
+	key := function.AsyncRequestKey(r.Fun.GetNamespace(), r.ReqId)
+	for {
+		resp, err := etcdClient.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		var record function.AsyncRequestRecord
+		if len(resp.Kvs) != 1 || json.Unmarshal(resp.Kvs[0].Value, &record) != nil || record.Owner != node.NodeIdentifier {
+			return nil
+		}
+
+		update(&record)
+		record.Updated = time.Now()
+		payload, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		txn, err := etcdClient.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(key), "=", resp.Kvs[0].ModRevision)).
+			Then(clientv3.OpPut(key, string(payload), clientv3.WithLease(lease))).
+			Commit()
+		if err != nil {
+			return err
+		}
+		if txn.Succeeded {
+			return nil
+		}
+		// modified concurrently: try again
+	}
+}
+
+// releaseAsyncRequest gives up the ownership of a request to be attempted
+// again, so that another node of the area takes it over (see
+// RecoverOrphanedRequests). It returns false if the request cannot be handed
+// over.
+func releaseAsyncRequest(r *function.Request) bool {
+	if registration.Reg == nil || !asyncRecordsEnabled() {
+		return false
+	}
+	err := updateAsyncRecord(r, 0, func(record *function.AsyncRequestRecord) {
+		record.State = function.ASYNC_RETRYING
+		record.OffloadedTo = ""
+		record.Owner = ""
+		record.Attempts = r.Attempts
+		record.LastError = r.LastError
+	})
+	if err != nil {
+		log.Printf("%v Could not release the request: %v\n", r, err)
+		return false
+	}
+	log.Printf("%v Released to the other nodes of the area\n", r)
+	return true
+}
+
+// asyncRecordsEnabled returns true if async requests are persisted in the
+// Global Registry, i.e., their results are too: otherwise, requests could not
+// be taken over by other nodes.
+func asyncRecordsEnabled() bool {
+	store, err := asyncstore.Get()
+	return err == nil && store.Shared()
+}
+
+// RunAsyncRecovery periodically takes over the orphaned async requests of the
+// area (see RecoverOrphanedRequests).
+func RunAsyncRecovery() {
+	interval := time.Duration(config.GetInt(config.ASYNC_RECOVERY_INTERVAL, 10)) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if recovered := RecoverOrphanedRequests(); recovered > 0 {
+			log.Printf("Took over %d orphaned async requests\n", recovered)
+		}
+	}
+}
+
+// RecoverOrphanedRequests takes over the pending async requests of the area
+// whose owner has failed, i.e., its registry lease has expired, or which have
+// been released by a draining node. Requests are submitted again, unless they
+// were accepted too long ago: in this case, they expire. The number of
+// requests taken over is returned.
+func RecoverOrphanedRequests() int {
+	if registration.Reg == nil || node.IsDraining() || !asyncRecordsEnabled() {
+		return 0
+	}
+	etcdClient, err := utils.GetEtcdClient()
+	if err != nil {
+		log.Printf("Could not look for orphaned async requests: %v\n", err)
+		return 0
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resp, err := etcdClient.Get(ctx, function.AsyncRequestPrefix, clientv3.WithPrefix())
+	if err != nil {
+		log.Printf("Could not look for orphaned async requests: %v\n", err)
+		return 0
+	}
+
+	recovered := 0
+	aliveLeases := make(map[int64]bool)
+	for _, kv := range resp.Kvs {
+		var record function.AsyncRequestRecord
+		if err := json.Unmarshal(kv.Value, &record); err != nil {
+			continue
+		}
+		// offloaded requests are in charge of the remote node once accepted,
+		// i.e., the record is replaced: until then, the owner is checked
+		// as for any other pending request
+		if record.IsTerminal() ||
+			record.Area != registration.Reg.Area || record.Owner == node.NodeIdentifier {
+			continue
+		}
+
+		// requests released by draining nodes have no owner
+		if record.Owner != "" {
+			if record.OwnerLease == int64(clientv3.NoLease) {
+				continue
+			}
+			alive, ok := aliveLeases[record.OwnerLease]
+			if !ok {
+				ttl, err := etcdClient.TimeToLive(ctx, clientv3.LeaseID(record.OwnerLease))
+				if err != nil {
+					log.Printf("Could not check the lease of %s: %v\n", record.Owner, err)
+					continue
+				}
+				alive = ttl.TTL > 0
+				aliveLeases[record.OwnerLease] = alive
+			}
+			if alive {
+				continue
+			}
+		}
+
+		if takeOverAsyncRequest(ctx, etcdClient, string(kv.Key), kv.ModRevision, &record) {
+			recovered++
+		}
+	}
+	return recovered
+}
+
+// takeOverAsyncRequest becomes the owner of an orphaned request, unless
+// another node does it first, and submits it again (or lets it expire).
+func takeOverAsyncRequest(ctx context.Context, etcdClient *clientv3.Client, key string, revision int64, record *function.AsyncRequestRecord) bool {
+	expiration := time.Duration(config.GetInt(config.ASYNC_EXPIRATION, 3600)) * time.Second
+	expired := time.Since(record.Accepted) > expiration
+
+	previousOwner := record.Owner
+	setAsyncOwner(record)
+	record.Takeovers++
+	record.Updated = time.Now()
+	record.OffloadedTo = ""
+	record.State = function.ASYNC_QUEUED
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return false
+	}
+	txn, err := etcdClient.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", revision)).
+		Then(clientv3.OpPut(key, string(payload))).
+		Commit()
+	if err != nil {
+		log.Printf("Could not take over async request %s: %v\n", record.ReqId, err)
+		return false
+	} else if !txn.Succeeded {
+		// taken over by another node (or completed) in the meantime
+		return false
+	}
+	log.Printf("Took over async request %s from %s\n", record.ReqId, previousOwner)
+
+	r := &function.Request{
+		ReqId:           record.ReqId,
+		Params:          record.Params,
+		Arrival:         time.Now(),
+		Ctx:             context.Background(),
+		RequestQoS:      function.RequestQoS{Class: function.ServiceClass(record.QoSClass), MaxRespT: record.QoSMaxRespT},
+		CanDoOffloading: record.CanDoOffloading,
+		Async:           true,
+		ReturnOutput:    record.ReturnOutput,
+		Callback:        record.Callback,
+		ResultTTL:       time.Duration(record.ResultTTL) * time.Second,
+		Attempts:        record.Attempts,
+		LastError:       record.LastError,
+	}
+	fun, ok := function.GetFunction(function.QualifiedName(record.Namespace, record.Function))
+	if !ok {
+		log.Printf("Async request %s failed: function %s no longer exists\n", record.ReqId, record.Function)
+		r.Fun = &function.Function{Name: record.Function, Namespace: record.Namespace}
+		publishAsyncResponse(r, function.Response{Success: false})
+		return true
+	}
+	r.Fun = fun
+	if expired {
+		log.Printf("Async request %s expired\n", record.ReqId)
+		publishAsyncResult(r, function.Response{Success: false}, function.ASYNC_EXPIRED)
+		return true
+	}
+
+	node.BeginRequest()
+	go SubmitAsyncRequest(r)
+	return true
+}
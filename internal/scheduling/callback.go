@@ -0,0 +1,153 @@
+package scheduling
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/grussorusso/serverledge/utils"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// maxCallbackBackoff bounds the delay between callback delivery attempts
+const maxCallbackBackoff = 5 * time.Minute
+
+var callbackClient = &http.Client{Transport: newCallbackTransport()}
+
+// newCallbackTransport returns a transport which checks the addresses
+// callbacks are delivered to when connecting, so that neither host names
+// resolving to internal addresses nor redirects can be used to reach them.
+// Proxies are not used, as the target would not be checked.
+func newCallbackTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		dialer := &net.Dialer{
+			Timeout: 30 * time.Second,
+			Control: func(_, address string, _ syscall.RawConn) error {
+				ip, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				return function.CheckCallbackTarget(host, net.ParseIP(ip))
+			},
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+	return transport
+}
+
+// deliverCallback POSTs the response of an async request to its callback URL,
+// retrying with exponential backoff upon network errors, 5xx, 408 and 429
+// responses. The delivery status is published in etcd after each attempt.
+func deliverCallback(r *function.Request, payload []byte) {
+	maxAttempts := config.GetInt(config.CALLBACK_MAX_ATTEMPTS, 5)
+	backoff := time.Duration(config.GetFloat(config.CALLBACK_BACKOFF, 1.0) * float64(time.Second))
+	timeout := time.Duration(config.GetInt(config.CALLBACK_TIMEOUT, 10)) * time.Second
+
+	status := function.CallbackStatus{URL: r.Callback.URL, State: function.CALLBACK_PENDING}
+	for {
+		status.Attempts++
+		status.LastAttempt = time.Now()
+		status.StatusCode, status.LastError = 0, ""
+
+		code, err := postCallback(r.Callback, r.ReqId, payload, timeout)
+		status.StatusCode = code
+		if err == nil {
+			status.State = function.CALLBACK_DELIVERED
+			saveCallbackStatus(r, &status)
+			return
+		}
+
+		status.LastError = err.Error()
+		if !isRetryableCallbackStatus(code) || errors.Is(err, function.CallbackTargetDeniedErr) ||
+			status.Attempts >= maxAttempts {
+			log.Printf("%v Callback delivery failed after %d attempts: %v\n", r, status.Attempts, err)
+			status.State = function.CALLBACK_FAILED
+			saveCallbackStatus(r, &status)
+			return
+		}
+		saveCallbackStatus(r, &status)
+
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxCallbackBackoff {
+			backoff = maxCallbackBackoff
+		}
+	}
+}
+
+// postCallback performs a delivery attempt, returning the response status
+// code (0 if no response has been received).
+func postCallback(cb *function.Callback, reqId string, payload []byte, timeout time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cb.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(function.CALLBACK_REQUEST_ID_HEADER, reqId)
+	req.Header.Set(function.CALLBACK_TIMESTAMP_HEADER, timestamp)
+	if cb.Secret != "" {
+		req.Header.Set(function.CALLBACK_SIGNATURE_HEADER, function.SignCallback(cb.Secret, timestamp, payload))
+	}
+
+	resp, err := callbackClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("callback returned: %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func isRetryableCallbackStatus(code int) bool {
+	return code == 0 || code >= 500 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+}
+
+func saveCallbackStatus(r *function.Request, status *function.CallbackStatus) {
+	etcdClient, err := utils.GetEtcdClient()
+	if err != nil {
+		log.Printf("Could not save callback status: %v\n", err)
+		return
+	}
+	payload, err := json.Marshal(status)
+	if err != nil {
+		log.Printf("Could not marshal callback status: %v\n", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	lease, err := etcdClient.Grant(ctx, int64(math.Max(1, math.Ceil(resultTTL(r).Seconds()))))
+	if err != nil {
+		log.Printf("Could not save callback status: %v\n", err)
+		return
+	}
+	key := function.CallbackStatusKey(r.Fun.GetNamespace(), r.ReqId)
+	if _, err = etcdClient.Put(ctx, key, string(payload), clientv3.WithLease(lease.ID)); err != nil {
+		log.Printf("Could not save callback status: %v\n", err)
+	}
+}
@@ -0,0 +1,92 @@
+package scheduling
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/node"
+	"github.com/grussorusso/serverledge/internal/registration"
+)
+
+var drainLock sync.Mutex
+
+// DefaultDrainTimeout returns the configured max time to wait for pending
+// requests while draining the node.
+func DefaultDrainTimeout() time.Duration {
+	return time.Duration(config.GetInt(config.DRAIN_TIMEOUT, 60)) * time.Second
+}
+
+// Drain puts the node in maintenance mode. The node is removed from the
+// registry and stops serving new requests locally (they are offloaded, if
+// possible, or rejected). Async requests waiting to be retried are released
+// to the other nodes of the area. Queued and running requests are given up to
+// timeout to complete (including the publication of async results and the
+// delivery of callbacks); then, all the containers are destroyed.
+// The function returns false if some request was still pending at the
+// deadline.
+func Drain(timeout time.Duration) bool {
+	drainLock.Lock()
+	defer drainLock.Unlock()
+
+	log.Printf("Draining the node (timeout: %v)\n", timeout)
+	node.StartDraining()
+
+	// deregister from etcd; other nodes should stop offloading to us
+	if registration.Reg != nil {
+		if err := registration.Reg.Deregister(); err != nil {
+			log.Printf("Could not deregister the node: %v\n", err)
+		}
+	}
+
+	// pending retries are taken over by other nodes, if possible
+	releasePendingRetries()
+
+	completed := node.WaitForInFlightRequests(timeout)
+	if !completed {
+		log.Printf("Drain timeout expired with %d pending requests\n", node.InFlightRequests())
+	}
+
+	node.ShutdownAllContainers()
+	log.Println("Node drained.")
+
+	return completed
+}
+
+// Resume brings a drained node back into service.
+func Resume() error {
+	drainLock.Lock()
+	defer drainLock.Unlock()
+
+	if !node.IsDraining() {
+		return nil
+	}
+
+	if registration.Reg != nil {
+		if err := registration.Reg.Reregister(); err != nil {
+			return err
+		}
+	}
+
+	node.StopDraining()
+	log.Println("Node resumed.")
+	return nil
+}
+
+// handleArrivalWhileDraining offloads a new request, if possible, or drops
+// it, as the node is not accepting new local work.
+func handleArrivalWhileDraining(r *scheduledRequest) {
+	if r.CanDoOffloading {
+		if url := pickEdgeNodeForOffloading(r); url != "" {
+			handleOffload(r, url)
+			return
+		}
+		if config.GetString(config.CLOUD_URL, "") != "" {
+			handleCloudOffload(r)
+			return
+		}
+	}
+
+	dropRequest(r)
+}
@@ -1,16 +1,23 @@
 package scheduling
 
 import (
+	"errors"
 	"fmt"
-	"github.com/grussorusso/serverledge/internal/function"
+	"log"
 	"time"
 
+	"github.com/grussorusso/serverledge/internal/config"
 	"github.com/grussorusso/serverledge/internal/container"
 	"github.com/grussorusso/serverledge/internal/executor"
+	"github.com/grussorusso/serverledge/internal/function"
 )
 
 const HANDLER_DIR = "/app"
 
+var CancelledErr = errors.New("the request has been cancelled")
+var BrokenContainerErr = errors.New("the container is not responding")
+var FunctionFailedErr = errors.New("function execution failed")
+
 // Execute serves a request on the specified container.
 func Execute(contID container.ContainerID, r *scheduledRequest, isWarm bool) (function.ExecutionReport, error) {
 	//log.Printf("[%s] Executing on container: %v", r.Fun, contID)
@@ -18,12 +25,20 @@ func Execute(contID container.ContainerID, r *scheduledRequest, isWarm bool) (fu
 	var req executor.InvocationRequest
 	if r.Fun.Runtime == container.CUSTOM_RUNTIME {
 		req = executor.InvocationRequest{
+			Id:           r.ReqId,
 			Params:       r.Params,
 			ReturnOutput: r.ReturnOutput,
 		}
 	} else {
-		cmd := container.RuntimeToInfo[r.Fun.Runtime].InvocationCmd
+		runtime, ok := container.GetRuntimeInfo(r.Fun.Runtime)
+		if !ok {
+			// the runtime has been removed from the catalog meanwhile
+			completions <- &completionNotification{fun: r.Fun, contID: contID, executionReport: nil}
+			return function.ExecutionReport{}, fmt.Errorf("[%s] Unknown runtime: %s", r, r.Fun.Runtime)
+		}
+		cmd := runtime.InvocationCmd
 		req = executor.InvocationRequest{
+			Id:           r.ReqId,
 			Command:      cmd,
 			Params:       r.Params,
 			Handler:      r.Fun.Handler,
@@ -32,34 +47,101 @@ func Execute(contID container.ContainerID, r *scheduledRequest, isWarm bool) (fu
 		}
 	}
 
+	if r.HTTPRequest != nil {
+		req.HTTPRequest = (*executor.HTTPRequest)(r.HTTPRequest)
+	}
+
+	// container-level resource usage is sampled around the invocation
+	sampleStats := config.GetBool(config.CONTAINER_STATS, false)
+	var statsBefore *container.ContainerStats
+	if sampleStats {
+		statsBefore, _ = container.GetStats(contID)
+	}
+
 	t0 := time.Now()
 	initTime := t0.Sub(r.Arrival).Seconds()
 
-	response, invocationWait, err := container.Execute(contID, &req)
+	response, readinessTime, err := container.Execute(r.Context(), contID, &req, isWarm, r.Stream)
 	if err != nil {
-		// notify scheduler
-		completions <- &completionNotification{fun: r.Fun, contID: contID, executionReport: nil}
-		return function.ExecutionReport{}, fmt.Errorf("[%s] Execution failed: %v", r, err)
+		if r.IsCancelled() {
+			// notify scheduler
+			completions <- &completionNotification{fun: r.Fun, contID: contID, executionReport: nil}
+			countCancellation(r.Fun)
+			return function.ExecutionReport{}, CancelledErr
+		}
+		if errors.Is(err, container.InvocationFailedErr) {
+			// the Executor works, but could not run the function
+			completions <- &completionNotification{fun: r.Fun, contID: contID, executionReport: nil}
+			return function.ExecutionReport{}, fmt.Errorf("[%s] %w: %v", r, FunctionFailedErr, err)
+		}
+		// the container cannot be used anymore: notify scheduler
+		completions <- &completionNotification{fun: r.Fun, contID: contID, executionReport: nil, broken: true}
+		return function.ExecutionReport{}, fmt.Errorf("[%s] %w: %v", r, BrokenContainerErr, err)
 	}
 
 	if !response.Success {
 		// notify scheduler
 		completions <- &completionNotification{fun: r.Fun, contID: contID, executionReport: nil}
-		return function.ExecutionReport{}, fmt.Errorf("Function execution failed")
+		return function.ExecutionReport{}, FunctionFailedErr
 	}
 
+	elapsed := time.Now().Sub(t0).Seconds() - readinessTime.Seconds()
 	report := function.ExecutionReport{Result: response.Result,
-		Output:       response.Output,
-		IsWarmStart:  isWarm,
-		Duration:     time.Now().Sub(t0).Seconds() - invocationWait.Seconds(),
-		ResponseTime: time.Now().Sub(t0).Seconds() - invocationWait.Seconds()}
+		Output:        response.Output,
+		IsWarmStart:   isWarm,
+		Duration:      elapsed,
+		ResponseTime:  elapsed,
+		ReadinessTime: readinessTime.Seconds()}
+	// prefer the handler execution time measured by the Executor, if any
+	if response.Duration > 0 {
+		report.Duration = response.Duration
+	}
 
-	// initializing containers may require invocation retries, adding
-	// latency
-	report.InitTime = initTime + invocationWait.Seconds()
+	if response.HTTPResponse != nil {
+		report.HTTPResponse = (*function.HTTPResponse)(response.HTTPResponse)
+	}
+	report.Usage = resourceUsage(contID, response.Usage, sampleStats, statsBefore)
+
+	// waiting for new Executors to be ready adds latency
+	report.InitTime = initTime + readinessTime.Seconds()
 
 	// notify scheduler
 	completions <- &completionNotification{fun: r.Fun, contID: contID, executionReport: &report}
 
 	return report, nil
 }
+
+// resourceUsage merges the resource usage reported by the Executor with the
+// one sampled for the container (if enabled). For containers serving
+// concurrent invocations, container-level usage accounts for all of them.
+func resourceUsage(contID container.ContainerID, execUsage *executor.ResourceUsage, sampleStats bool, statsBefore *container.ContainerStats) *function.ResourceUsage {
+	var usage *function.ResourceUsage
+	if execUsage != nil {
+		usage = &function.ResourceUsage{
+			CPUUserTime:   execUsage.UserTime,
+			CPUSystemTime: execUsage.SystemTime,
+			MaxRSSKB:      execUsage.MaxRSSKB,
+			ExitCode:      execUsage.ExitCode,
+		}
+	}
+	if !sampleStats {
+		return usage
+	}
+
+	stats, err := container.GetStats(contID)
+	if err != nil {
+		log.Printf("Could not sample resource usage of %s: %v\n", contID, err)
+		return usage
+	}
+	if usage == nil {
+		usage = &function.ResourceUsage{}
+	}
+	usage.ContainerMemoryMB = float64(stats.MemoryBytes) / 1048576.0
+	if statsBefore != nil {
+		usage.ContainerCPUTime = stats.CPUSeconds - statsBefore.CPUSeconds
+	} else {
+		// new container: all the consumed CPU time is accounted
+		usage.ContainerCPUTime = stats.CPUSeconds
+	}
+	return usage
+}
@@ -24,7 +24,7 @@ func pickEdgeNodeForOffloading(r *scheduledRequest) (url string) {
 	}
 	//first, search for warm container
 	for _, v := range nearbyServersMap {
-		if v.AvailableWarmContainers[r.Fun.Name] != 0 && v.AvailableCPUs >= r.Request.Fun.CPUDemand {
+		if v.AvailableWarmContainers[r.Fun.Id()] != 0 && v.AvailableCPUs >= r.Request.Fun.CPUDemand {
 			return v.Url
 		}
 	}
@@ -39,17 +39,28 @@ func pickEdgeNodeForOffloading(r *scheduledRequest) (url string) {
 
 func Offload(r *function.Request, serverUrl string) (function.ExecutionReport, error) {
 	// Prepare request
-	request := client.InvocationRequest{Params: r.Params, QoSClass: int64(r.Class), QoSMaxRespT: r.MaxRespT}
+	request := client.InvocationRequest{Params: r.Params, QoSClass: int64(r.Class), QoSMaxRespT: r.MaxRespT,
+		HTTPRequest: r.HTTPRequest}
 	invocationBody, err := json.Marshal(request)
 	if err != nil {
 		log.Print(err)
 		return function.ExecutionReport{}, err
 	}
 	sendingTime := time.Now() // used to compute latency later on
-	resp, err := offloadingClient.Post(serverUrl+"/invoke/"+r.Fun.Name, "application/json",
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, serverUrl+"/invoke/"+r.Fun.Name,
 		bytes.NewBuffer(invocationBody))
+	if err != nil {
+		return function.ExecutionReport{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(function.NAMESPACE_HEADER, r.Fun.GetNamespace())
+	resp, err := offloadingClient.Do(req)
 
 	if err != nil {
+		if r.IsCancelled() {
+			countCancellation(r.Fun)
+			return function.ExecutionReport{}, CancelledErr
+		}
 		log.Print(err)
 		return function.ExecutionReport{}, err
 	}
@@ -86,17 +97,28 @@ func Offload(r *function.Request, serverUrl string) (function.ExecutionReport, e
 
 func OffloadAsync(r *function.Request, serverUrl string) error {
 	// Prepare request
+	// the remote node publishes the response (and delivers the callback)
+	// using the same request ID
 	request := client.InvocationRequest{Params: r.Params,
 		QoSClass:    int64(r.Class),
 		QoSMaxRespT: r.MaxRespT,
-		Async:       true}
+		Async:       true,
+		Callback:    r.Callback,
+		ReqId:       r.ReqId,
+		ResultTTL:   int64(r.ResultTTL / time.Second),
+		Attempts:    r.Attempts}
 	invocationBody, err := json.Marshal(request)
 	if err != nil {
 		log.Print(err)
 		return err
 	}
-	resp, err := offloadingClient.Post(serverUrl+"/invoke/"+r.Fun.Name, "application/json",
-		bytes.NewBuffer(invocationBody))
+	req, err := http.NewRequest(http.MethodPost, serverUrl+"/invoke/"+r.Fun.Name, bytes.NewBuffer(invocationBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(function.NAMESPACE_HEADER, r.Fun.GetNamespace())
+	resp, err := offloadingClient.Do(req)
 
 	if err != nil {
 		log.Print(err)
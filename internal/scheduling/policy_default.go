@@ -30,6 +30,7 @@ func (p *DefaultLocalPolicy) OnCompletion(_ *function.Function, _ *function.Exec
 
 	p.queue.Lock()
 	defer p.queue.Unlock()
+	purgeCancelled(p.queue)
 	if p.queue.Len() == 0 {
 		return
 	}
@@ -45,7 +46,16 @@ func (p *DefaultLocalPolicy) OnCompletion(_ *function.Function, _ *function.Exec
 	}
 
 	if errors.Is(err, node.NoWarmFoundErr) {
-		if node.AcquireResources(req.Fun.CPUDemand, req.Fun.MemoryMB, true) {
+		cpuDemand := node.CPUDemandOf(req.Fun)
+		err = node.AcquireResources(req.Fun, cpuDemand, req.Fun.MemoryMB, true)
+		if errors.Is(err, node.QuotaExceededErr) {
+			// waiting for other requests of the namespace to complete
+			// would block the queue
+			p.queue.Dequeue()
+			dropRequest(req)
+			return
+		}
+		if err == nil {
 			log.Printf("[%s] Cold start from the queue\n", req)
 			p.queue.Dequeue()
 
@@ -53,7 +63,7 @@ func (p *DefaultLocalPolicy) OnCompletion(_ *function.Function, _ *function.Exec
 			// start, but also allows us to check for resource
 			// availability before dequeueing
 			go func() {
-				newContainer, err := node.NewContainerWithAcquiredResources(req.Fun)
+				newContainer, err := node.NewContainerWithAcquiredResources(req.Fun, cpuDemand)
 				if err != nil {
 					dropRequest(req)
 				} else {
@@ -93,6 +103,7 @@ func (p *DefaultLocalPolicy) OnArrival(r *scheduledRequest) {
 	if p.queue != nil {
 		p.queue.Lock()
 		defer p.queue.Unlock()
+		purgeCancelled(p.queue)
 		if p.queue.Enqueue(r) {
 			log.Printf("[%s] Added to queue (length=%d)\n", r, p.queue.Len())
 			return
@@ -11,6 +11,20 @@ type queue interface {
 	Unlock()
 }
 
+// purgeCancelled removes cancelled requests from the queue, preserving the
+// order of the other ones. The queue must be locked by the caller.
+func purgeCancelled(q queue) {
+	n := q.Len()
+	for i := 0; i < n; i++ {
+		r := q.Dequeue()
+		if r.IsCancelled() {
+			cancelRequest(r)
+		} else {
+			q.Enqueue(r)
+		}
+	}
+}
+
 // FIFOQueue defines a circular queue
 type FIFOQueue struct {
 	sync.Mutex
@@ -1,6 +1,7 @@
 package scheduling
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
@@ -19,3 +20,26 @@ func TestQueuet(t *testing.T) {
 	q.Enqueue(r1)
 	fmt.Printf("Size = %d\n", q.Len())
 }
+
+func TestPurgeCancelled(t *testing.T) {
+	f := function.Function{Name: "Function1"}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	active := &scheduledRequest{Request: &function.Request{Fun: &f, ReqId: "active"},
+		decisionChannel: make(chan schedDecision, 1)}
+	cancelled := &scheduledRequest{Request: &function.Request{Fun: &f, ReqId: "cancelled", Ctx: ctx},
+		decisionChannel: make(chan schedDecision, 1)}
+
+	q := NewFIFOQueue(3)
+	q.Enqueue(cancelled)
+	q.Enqueue(active)
+	purgeCancelled(q)
+
+	if q.Len() != 1 || q.Front() != active {
+		t.Fatalf("expected only the active request in the queue, got %d requests", q.Len())
+	}
+	if d := <-cancelled.decisionChannel; d.action != DROP {
+		t.Errorf("expected the cancelled request to be dropped")
+	}
+}
@@ -0,0 +1,114 @@
+package scheduling
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/grussorusso/serverledge/internal/node"
+)
+
+// failAsyncRequest handles a failed attempt of an async request, which is
+// attempted again after a while if allowed by the retry policy of the
+// function. Otherwise, the request fails and is moved to the dead-letter
+// store.
+func failAsyncRequest(r *function.Request, class string, cause error) {
+	r.Attempts++
+	r.LastError = cause.Error()
+
+	if policy := r.Fun.Retry; policy != nil && policy.ShouldRetry(class, r.Attempts) {
+		delay := policy.Delay(r.Attempts)
+		log.Printf("%v Attempt %d failed (%s): %v. Retrying in %v\n", r, r.Attempts, class, cause, delay)
+		err := updateAsyncRecord(r, 0, func(record *function.AsyncRequestRecord) {
+			record.State = function.ASYNC_RETRYING
+			record.OffloadedTo = ""
+			record.Attempts = r.Attempts
+			record.LastError = r.LastError
+		})
+		if err != nil {
+			log.Printf("%v Could not update the state of the request: %v\n", r, err)
+		}
+		retryAsyncRequest(r, delay)
+		return
+	}
+
+	log.Printf("%v Failed after %d attempts (%s): %v\n", r, r.Attempts, class, cause)
+	saveDeadLetter(r, class)
+	publishAsyncResponse(r, function.Response{Success: false})
+}
+
+// pendingRetries are the failed async requests waiting to be attempted again
+// by this node, along with their timers.
+var pendingRetries = struct {
+	sync.Mutex
+	retries map[*function.Request]*pendingRetry
+}{retries: make(map[*function.Request]*pendingRetry)}
+
+type pendingRetry struct {
+	timer *time.Timer
+	due   time.Time
+}
+
+// retryAsyncRequest submits a failed request again after delay. The pending
+// retry is counted as in flight; if the node is draining, the request is
+// handed over to the other nodes instead.
+func retryAsyncRequest(r *function.Request, delay time.Duration) {
+	if node.IsDraining() && releaseAsyncRequest(r) {
+		return
+	}
+
+	node.BeginRequest()
+	pendingRetries.Lock()
+	defer pendingRetries.Unlock()
+	pendingRetries.retries[r] = &pendingRetry{
+		due: time.Now().Add(delay),
+		timer: time.AfterFunc(delay, func() {
+			pendingRetries.Lock()
+			delete(pendingRetries.retries, r)
+			pendingRetries.Unlock()
+			SubmitAsyncRequest(r)
+		}),
+	}
+}
+
+// releasePendingRetries hands over the pending retries to the other nodes of
+// the area, when the node is drained. Retries which cannot be handed over
+// are still attempted by this node.
+func releasePendingRetries() {
+	pendingRetries.Lock()
+	stopped := make(map[*function.Request]*pendingRetry)
+	for r, retry := range pendingRetries.retries {
+		if retry.timer.Stop() {
+			stopped[r] = retry
+		}
+	}
+	pendingRetries.Unlock()
+
+	for r, retry := range stopped {
+		if releaseAsyncRequest(r) {
+			pendingRetries.Lock()
+			delete(pendingRetries.retries, r)
+			pendingRetries.Unlock()
+			node.EndRequest()
+		} else {
+			retry.timer.Reset(time.Until(retry.due))
+		}
+	}
+}
+
+// saveDeadLetter stores an async request which has failed for good, so that
+// it can be inspected and re-driven.
+func saveDeadLetter(r *function.Request, class string) {
+	letter := function.DeadLetter{
+		AsyncRequestRecord: newAsyncRecord(r, function.ASYNC_FAILED),
+		ErrorClass:         class,
+		Failed:             time.Now(),
+	}
+	letter.Owner = node.NodeIdentifier
+	ttl := time.Duration(config.GetInt(config.ASYNC_DEADLETTER_TTL, 7*24*3600)) * time.Second
+	if err := letter.SaveToEtcd(ttl); err != nil {
+		log.Printf("%v Could not save the dead letter: %v\n", r, err)
+	}
+}
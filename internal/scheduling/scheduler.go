@@ -10,7 +10,9 @@ import (
 	"time"
 
 	"github.com/grussorusso/serverledge/internal/node"
+	"github.com/grussorusso/serverledge/internal/tlsutil"
 
+	"github.com/grussorusso/serverledge/internal/auth"
 	"github.com/grussorusso/serverledge/internal/config"
 
 	"github.com/grussorusso/serverledge/internal/container"
@@ -35,7 +37,10 @@ func Run(p Policy) {
 	node.Resources.ContainerPools = make(map[string]*node.ContainerPool)
 	log.Printf("Current resources: %v\n", &node.Resources)
 
-	container.InitDockerContainerFactory()
+	// reclaim containers left behind by a previous run of the node
+	if config.GetBool(config.CONTAINER_RECONCILE, true) {
+		node.ReconcileContainers()
+	}
 
 	//janitor periodically remove expired warm container
 	node.GetJanitorInstance()
@@ -46,7 +51,14 @@ func Run(p Policy) {
 		MaxConnsPerHost:     0,
 		IdleConnTimeout:     30 * time.Minute,
 	}
-	offloadingClient = &http.Client{Transport: tr}
+	// mutual TLS with the other nodes, if configured
+	tlsConfig, err := tlsutil.ClientConfig()
+	if err != nil {
+		log.Printf("Invalid TLS configuration: %v\n", err)
+	}
+	tr.TLSClientConfig = tlsConfig
+	// other nodes may require offloaded requests to be authenticated
+	offloadingClient = &http.Client{Transport: &auth.Transport{Base: tr, Token: auth.ServiceToken()}}
 
 	// initialize scheduling policy
 	p.Init()
@@ -60,15 +72,34 @@ func Run(p Policy) {
 	for {
 		select {
 		case r = <-requests:
+			if r.IsCancelled() {
+				cancelRequest(r)
+				continue
+			}
+			if node.IsDraining() {
+				go handleArrivalWhileDraining(r)
+				continue
+			}
 			go p.OnArrival(r)
 		case c = <-completions:
-			node.ReleaseContainer(c.contID, c.fun)
+			if c.broken {
+				node.ReleaseBrokenContainer(c.contID, c.fun)
+			} else {
+				node.ReleaseContainer(c.contID, c.fun)
+			}
 			p.OnCompletion(c.fun, c.executionReport)
 
+			if c.executionReport != nil && c.executionReport.SchedAction != SCHED_ACTION_OFFLOAD {
+				node.RecordUsage(c.fun, c.executionReport)
+			}
+
 			if metrics.Enabled && c.executionReport != nil {
-				metrics.AddCompletedInvocation(c.fun.Name)
+				metrics.AddCompletedInvocation(c.fun.Id())
 				if c.executionReport.SchedAction != SCHED_ACTION_OFFLOAD {
-					metrics.AddFunctionDurationValue(c.fun.Name, c.executionReport.Duration)
+					metrics.AddFunctionDurationValue(c.fun.Id(), c.executionReport.Duration)
+					if c.executionReport.Usage != nil {
+						metrics.AddResourceUsage(c.fun.Id(), c.executionReport.Usage)
+					}
 				}
 			}
 		}
@@ -78,13 +109,23 @@ func Run(p Policy) {
 
 // SubmitRequest submits a newly arrived request for scheduling and execution
 func SubmitRequest(r *function.Request) (function.ExecutionReport, error) {
+	node.BeginRequest()
+	defer node.EndRequest()
+
 	schedRequest := scheduledRequest{
 		Request:         r,
 		decisionChannel: make(chan schedDecision, 1)}
 	requests <- &schedRequest
 
 	// wait on channel for scheduling action
-	schedDecision, ok := <-schedRequest.decisionChannel
+	var schedDecision schedDecision
+	var ok bool
+	select {
+	case schedDecision, ok = <-schedRequest.decisionChannel:
+	case <-r.Context().Done():
+		go discardDecision(r.Fun, schedRequest.decisionChannel)
+		return function.ExecutionReport{}, CancelledErr
+	}
 	if !ok {
 		return function.ExecutionReport{}, fmt.Errorf("could not schedule the request")
 	}
@@ -92,17 +133,39 @@ func SubmitRequest(r *function.Request) (function.ExecutionReport, error) {
 
 	if schedDecision.action == DROP {
 		//log.Printf("[%s] Dropping request", r)
+		if r.IsCancelled() {
+			return function.ExecutionReport{}, CancelledErr
+		}
 		return function.ExecutionReport{}, node.OutOfResourcesErr
 	} else if schedDecision.action == EXEC_REMOTE {
 		//log.Printf("Offloading request")
 		return Offload(r, schedDecision.remoteHost)
 	} else {
-		return Execute(schedDecision.contID, &schedRequest, schedDecision.useWarm)
+		report, err := Execute(schedDecision.contID, &schedRequest, schedDecision.useWarm)
+		if errors.Is(err, BrokenContainerErr) && shouldRetry(r) {
+			return SubmitRequest(r)
+		}
+		return report, err
+	}
+}
+
+// shouldRetry checks whether a request that failed because of a broken
+// container can be scheduled again (possibly on a new container).
+func shouldRetry(r *function.Request) bool {
+	if r.IsCancelled() || r.Retries >= config.GetInt(config.SCHEDULER_BROKEN_RETRIES, 0) {
+		return false
 	}
+	r.Retries++
+	log.Printf("[%s] Retrying after container failure (attempt %d)\n", r, r.Retries)
+	return true
 }
 
-// SubmitAsyncRequest submits a newly arrived async request for scheduling and execution
+// SubmitAsyncRequest submits a newly arrived async request for scheduling and execution.
+// The request must have been counted as in flight (see AcceptAsyncRequest):
+// it is no longer, once it has been served or scheduled for a retry.
 func SubmitAsyncRequest(r *function.Request) {
+	defer node.EndRequest()
+
 	schedRequest := scheduledRequest{
 		Request:         r,
 		decisionChannel: make(chan schedDecision, 1)}
@@ -111,31 +174,42 @@ func SubmitAsyncRequest(r *function.Request) {
 	// wait on channel for scheduling action
 	schedDecision, ok := <-schedRequest.decisionChannel
 	if !ok {
-		publishAsyncResponse(r.ReqId, function.Response{Success: false})
+		failAsyncRequest(r, function.ERROR_CLASS_RESOURCES, fmt.Errorf("could not schedule the request"))
 		return
 	}
 
-	var err error
 	if schedDecision.action == DROP {
-		publishAsyncResponse(r.ReqId, function.Response{Success: false})
+		failAsyncRequest(r, function.ERROR_CLASS_RESOURCES, node.OutOfResourcesErr)
 	} else if schedDecision.action == EXEC_REMOTE {
 		//log.Printf("Offloading request")
-		err = OffloadAsync(r, schedDecision.remoteHost)
-		if err != nil {
-			publishAsyncResponse(r.ReqId, function.Response{Success: false})
+		// the state is updated first, as the remote node replaces the
+		// record upon acceptance
+		setAsyncState(r, function.ASYNC_OFFLOADED, schedDecision.remoteHost)
+		if err := OffloadAsync(r, schedDecision.remoteHost); err != nil {
+			failAsyncRequest(r, function.ERROR_CLASS_OFFLOAD, err)
 		}
 	} else {
+		setAsyncState(r, function.ASYNC_RUNNING, "")
 		report, err := Execute(schedDecision.contID, &schedRequest, schedDecision.useWarm)
-		if err != nil {
-			publishAsyncResponse(r.ReqId, function.Response{Success: false})
+		if errors.Is(err, BrokenContainerErr) && shouldRetry(r) {
+			node.BeginRequest()
+			SubmitAsyncRequest(r)
+			return
 		}
-		publishAsyncResponse(r.ReqId, function.Response{Success: true, ExecutionReport: report})
+		if errors.Is(err, FunctionFailedErr) {
+			failAsyncRequest(r, function.ERROR_CLASS_FUNCTION, err)
+			return
+		} else if err != nil {
+			failAsyncRequest(r, function.ERROR_CLASS_CONTAINER, err)
+			return
+		}
+		publishAsyncResponse(r, function.Response{Success: true, ExecutionReport: report})
 	}
 }
 
 func handleColdStart(r *scheduledRequest) (isSuccess bool) {
 	newContainer, err := node.NewContainer(r.Fun)
-	if errors.Is(err, node.OutOfResourcesErr) {
+	if errors.Is(err, node.OutOfResourcesErr) || errors.Is(err, node.QuotaExceededErr) {
 		return false
 	} else if err != nil {
 		log.Printf("Cold start failed: %v\n", err)
@@ -146,10 +220,55 @@ func handleColdStart(r *scheduledRequest) (isSuccess bool) {
 	}
 }
 
+// discardDecision waits for the scheduling decision about a request that has
+// been cancelled meanwhile, and releases the allocated container (if any).
+func discardDecision(fun *function.Function, decisionChannel chan schedDecision) {
+	decision, ok := <-decisionChannel
+	if !ok {
+		return
+	}
+	if decision.action == DROP {
+		// already accounted for
+		return
+	}
+
+	countCancellation(fun)
+	if decision.action == EXEC_LOCAL {
+		completions <- &completionNotification{fun: fun, contID: decision.contID, executionReport: nil}
+	}
+}
+
 func dropRequest(r *scheduledRequest) {
+	if r.IsCancelled() {
+		cancelRequest(r)
+		return
+	}
+
+	node.Resources.Lock()
+	node.Resources.DropCount++
+	node.Resources.Unlock()
+	if metrics.Enabled {
+		metrics.AddDroppedInvocation(r.Fun.Id())
+	}
+
 	r.decisionChannel <- schedDecision{action: DROP}
 }
 
+// cancelRequest gives up scheduling a request that has been cancelled.
+func cancelRequest(r *scheduledRequest) {
+	countCancellation(r.Fun)
+	r.decisionChannel <- schedDecision{action: DROP}
+}
+
+func countCancellation(fun *function.Function) {
+	node.Resources.Lock()
+	node.Resources.CancelCount++
+	node.Resources.Unlock()
+	if metrics.Enabled {
+		metrics.AddCancelledInvocation(fun.Id())
+	}
+}
+
 func execLocally(r *scheduledRequest, c container.ContainerID, warmStart bool) {
 	decision := schedDecision{action: EXEC_LOCAL, contID: c, useWarm: warmStart}
 	r.decisionChannel <- decision
@@ -15,6 +15,7 @@ type completionNotification struct {
 	fun             *function.Function
 	contID          container.ContainerID
 	executionReport *function.ExecutionReport
+	broken          bool // the container failed and must be destroyed
 }
 
 // schedDecision wraps a action made by the scheduler.
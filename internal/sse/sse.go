@@ -0,0 +1,77 @@
+// Package sse implements Server-Sent Events, used to stream function output.
+package sse
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const ContentType = "text/event-stream"
+
+// WriteEvent writes an event to a Server-Sent Events stream, flushing it
+// right away if possible.
+func WriteEvent(w io.Writer, event string, data string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "event: %s\n", event)
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return err
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// ReadEvents parses a Server-Sent Events stream, calling handler for each
+// event. Reading stops as soon as handler returns an error, which is
+// returned.
+func ReadEvents(r io.Reader, handler func(event string, data string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	event := ""
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if len(data) > 0 || event != "" {
+				if event == "" {
+					event = "message"
+				}
+				if err := handler(event, strings.Join(data, "\n")); err != nil {
+					return err
+				}
+			}
+			event = ""
+			data = nil
+		case strings.HasPrefix(line, ":"):
+			// comment
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimPrefix(strings.TrimPrefix(line, "event:"), " ")
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	return scanner.Err()
+}
+
+// WriteComment writes a comment to a Server-Sent Events stream, which is
+// ignored by clients but keeps the connection alive.
+func WriteComment(w io.Writer, comment string) error {
+	if _, err := fmt.Fprintf(w, ": %s\n\n", comment); err != nil {
+		return err
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+// Package testutil provides helpers to run Serverledge components within
+// tests, without external dependencies (i.e., Docker and etcd).
+package testutil
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/spf13/viper"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+// FreePort returns a TCP port that is currently available on the loopback
+// interface.
+func FreePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer func(l net.Listener) {
+		_ = l.Close()
+	}(l)
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// StartEmbeddedEtcd starts an etcd server within the current process, storing
+// data in dataDir, and configures the node to use it as Global Registry.
+// The server must be stopped through Close.
+func StartEmbeddedEtcd(dataDir string) (*embed.Etcd, error) {
+	clientPort, err := FreePort()
+	if err != nil {
+		return nil, err
+	}
+	peerPort, err := FreePort()
+	if err != nil {
+		return nil, err
+	}
+	clientUrl, _ := url.Parse(fmt.Sprintf("http://127.0.0.1:%d", clientPort))
+	peerUrl, _ := url.Parse(fmt.Sprintf("http://127.0.0.1:%d", peerPort))
+
+	cfg := embed.NewConfig()
+	cfg.Dir = dataDir
+	cfg.LogLevel = "error"
+	cfg.LCUrls = []url.URL{*clientUrl}
+	cfg.ACUrls = []url.URL{*clientUrl}
+	cfg.LPUrls = []url.URL{*peerUrl}
+	cfg.APUrls = []url.URL{*peerUrl}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		e.Close()
+		return nil, fmt.Errorf("embedded etcd did not start in time")
+	}
+
+	viper.Set(config.ETCD_ADDRESS, clientUrl.Host)
+	return e, nil
+}
@@ -0,0 +1,101 @@
+package testutil
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/api"
+	"github.com/grussorusso/serverledge/internal/auth"
+	"github.com/grussorusso/serverledge/internal/cache"
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/container"
+	"github.com/grussorusso/serverledge/internal/function"
+	"github.com/grussorusso/serverledge/internal/node"
+	"github.com/grussorusso/serverledge/internal/registration"
+	"github.com/grussorusso/serverledge/internal/scheduling"
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+)
+
+// Node is a Serverledge node running within the current process.
+type Node struct {
+	URL      string
+	Factory  *container.FakeFactory
+	Registry *registration.Registry
+	echo     *echo.Echo
+}
+
+// StartNode boots a node within the current process, using a FakeFactory
+// to run functions. The Global Registry (e.g., an embedded etcd) must be
+// already configured. As the node state is process-wide, StartNode can be
+// called only once per process: further nodes are run in child processes
+// (see StartNodeProcess).
+func StartNode(area string, policy scheduling.Policy) (*Node, error) {
+	port, err := FreePort()
+	if err != nil {
+		return nil, err
+	}
+	viper.Set(config.API_PORT, port)
+	url := fmt.Sprintf("http://127.0.0.1:%d", port)
+
+	cache.GetCacheInstance()
+
+	registry := &registration.Registry{Area: area}
+	key, err := registry.RegisterToEtcd(url)
+	if err != nil {
+		return nil, err
+	}
+	node.NodeIdentifier = key
+	registration.Reg = registry
+
+	factory := container.InitFakeFactory()
+	if err := container.InitRuntimeCatalog(); err != nil {
+		return nil, err
+	}
+	if err := function.InitNamespaces(); err != nil {
+		return nil, err
+	}
+	if err := auth.InitKeyStore(); err != nil {
+		return nil, err
+	}
+	go scheduling.Run(policy)
+
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+	api.RegisterRoutes(e)
+	go func() {
+		if err := e.Start(fmt.Sprintf("127.0.0.1:%d", port)); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			e.Logger.Error(err)
+		}
+	}()
+
+	if err := WaitForServer(url, 10*time.Second); err != nil {
+		return nil, err
+	}
+
+	return &Node{URL: url, Factory: factory, Registry: registry, echo: e}, nil
+}
+
+// WaitForServer waits until the API server at url answers requests.
+func WaitForServer(url string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		resp, err := http.Get(url + "/function")
+		if err == nil {
+			_ = resp.Body.Close()
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return fmt.Errorf("server at %s not available", url)
+}
+
+// Stop shuts down the API server and destroys all the containers.
+func (n *Node) Stop() {
+	_ = n.echo.Close()
+	node.ShutdownAllContainers()
+	_ = n.Registry.Close()
+}
@@ -0,0 +1,147 @@
+package testutil
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/executor"
+	"github.com/grussorusso/serverledge/internal/scheduling"
+	"github.com/spf13/viper"
+)
+
+// NODE_PROCESS_ENV is set (to the configuration of the node, JSON-encoded)
+// when the test binary is spawned to run a node by StartNodeProcess.
+const NODE_PROCESS_ENV = "SERVERLEDGE_TEST_NODE"
+
+const nodeURLPrefix = "SERVERLEDGE_TEST_NODE_URL="
+
+// nodeProcessConfig is the configuration of a node run in a child process.
+type nodeProcessConfig struct {
+	Area     string
+	Settings map[string]interface{}
+}
+
+// NodeProcess is a Serverledge node running in a child process.
+type NodeProcess struct {
+	URL   string
+	cmd   *exec.Cmd
+	stdin io.Closer
+}
+
+// ExecutionResult is the result of the invocations served by a node run in a
+// child process, which identifies the node.
+type ExecutionResult struct {
+	Node   string
+	Params map[string]interface{}
+}
+
+// StartNodeProcess runs another node in a child process, as StartNode can be
+// called only once per process. The child is the test binary itself, whose
+// TestMain must hand over to RunNodeProcess if IsNodeProcess returns true.
+// The node uses the configuration of the current process for the Global
+// Registry, and the given settings (configuration keys and values).
+// The node must be stopped through Stop.
+func StartNodeProcess(area string, settings map[string]interface{}) (*NodeProcess, error) {
+	cfg := nodeProcessConfig{Area: area, Settings: map[string]interface{}{}}
+	for _, key := range []string{config.ETCD_ADDRESS} {
+		if viper.IsSet(key) {
+			cfg.Settings[key] = viper.Get(key)
+		}
+	}
+	for key, value := range settings {
+		cfg.Settings[key] = value
+	}
+	encoded, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(os.Environ(), NODE_PROCESS_ENV+"="+string(encoded))
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	// the child terminates as soon as its standard input is closed, i.e.,
+	// even if the current process crashes
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	p := &NodeProcess{cmd: cmd, stdin: stdin}
+
+	urls := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			if url, ok := strings.CutPrefix(scanner.Text(), nodeURLPrefix); ok {
+				urls <- url
+			}
+		}
+		close(urls)
+	}()
+	select {
+	case url, ok := <-urls:
+		if !ok {
+			p.Stop()
+			return nil, fmt.Errorf("node process exited")
+		}
+		p.URL = url
+	case <-time.After(30 * time.Second):
+		p.Stop()
+		return nil, fmt.Errorf("node process did not start in time")
+	}
+	return p, nil
+}
+
+// Stop terminates the node process.
+func (p *NodeProcess) Stop() {
+	_ = p.stdin.Close()
+	_ = p.cmd.Process.Kill()
+	_ = p.cmd.Wait()
+}
+
+// IsNodeProcess returns true if the test binary has been spawned by
+// StartNodeProcess.
+func IsNodeProcess() bool {
+	_, ok := os.LookupEnv(NODE_PROCESS_ENV)
+	return ok
+}
+
+// RunNodeProcess runs the node of a process spawned by StartNodeProcess, using
+// the given policy, until the standard input is closed. Invocations return an
+// ExecutionResult, so that tests can tell which node served them.
+func RunNodeProcess(policy scheduling.Policy) {
+	var cfg nodeProcessConfig
+	if err := json.Unmarshal([]byte(os.Getenv(NODE_PROCESS_ENV)), &cfg); err != nil {
+		log.Fatalf("Invalid node configuration: %v", err)
+	}
+	for key, value := range cfg.Settings {
+		viper.Set(key, value)
+	}
+
+	n, err := StartNode(cfg.Area, policy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer n.Stop()
+	n.Factory.Handler = func(req *executor.InvocationRequest) *executor.InvocationResult {
+		result, _ := json.Marshal(&ExecutionResult{Node: n.URL, Params: req.Params})
+		return &executor.InvocationResult{Success: true, Result: string(result)}
+	}
+
+	fmt.Printf("%s%s\n", nodeURLPrefix, n.URL)
+	_, _ = io.Copy(io.Discard, os.Stdin)
+}
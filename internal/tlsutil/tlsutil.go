@@ -0,0 +1,325 @@
+// Package tlsutil provides the TLS configurations used by the API server, the
+// load balancer, node-to-node communication and the etcd client. Certificates
+// are reloaded from disk when they change, without restarting the node.
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/grussorusso/serverledge/internal/config"
+)
+
+var NoCertificateErr = errors.New("no certificate available")
+
+// Loader keeps a certificate (with its key) and a CA bundle loaded from PEM
+// files. All of them are optional.
+type Loader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	sync.RWMutex
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+	modTime time.Time // latest modification time of the loaded files
+}
+
+// NewLoader loads the certificate in certFile (with the key in keyFile) and
+// the CA certificates in caFile. Empty file names are ignored.
+func NewLoader(certFile string, keyFile string, caFile string) (*Loader, error) {
+	l := &Loader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if _, err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Reload loads the files again if any of them has been modified since the
+// last (successful) load, returning whether they have been reloaded. The
+// previous certificates are kept upon failure.
+func (l *Loader) Reload() (bool, error) {
+	var latest time.Time
+	for _, file := range []string{l.certFile, l.keyFile, l.caFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return false, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	l.RLock()
+	unchanged := !l.modTime.IsZero() && !latest.After(l.modTime)
+	l.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	var cert *tls.Certificate
+	if l.certFile != "" {
+		c, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+		if err != nil {
+			return false, fmt.Errorf("could not load certificate: %v", err)
+		}
+		if c.Leaf == nil {
+			if c.Leaf, err = x509.ParseCertificate(c.Certificate[0]); err != nil {
+				return false, fmt.Errorf("could not load certificate: %v", err)
+			}
+		}
+		cert = &c
+	}
+	var pool *x509.CertPool
+	if l.caFile != "" {
+		pem, err := os.ReadFile(l.caFile)
+		if err != nil {
+			return false, fmt.Errorf("could not load CA: %v", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return false, fmt.Errorf("no valid certificate found in %s", l.caFile)
+		}
+	}
+
+	l.Lock()
+	l.cert = cert
+	l.pool = pool
+	l.modTime = latest
+	l.Unlock()
+	return true, nil
+}
+
+// Watch periodically reloads the files (see Reload), until stop is closed.
+func (l *Loader) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if reloaded, err := l.Reload(); err != nil {
+				log.Printf("Could not reload TLS certificates: %v\n", err)
+			} else if reloaded {
+				log.Println("Reloaded TLS certificates")
+			}
+		}
+	}
+}
+
+func (l *Loader) certificate() (*tls.Certificate, error) {
+	l.RLock()
+	defer l.RUnlock()
+	if l.cert == nil {
+		return nil, NoCertificateErr
+	}
+	return l.cert, nil
+}
+
+func (l *Loader) caPool() *x509.CertPool {
+	l.RLock()
+	defer l.RUnlock()
+	return l.pool
+}
+
+// ServerConfig returns a server configuration presenting the current
+// certificate. If a CA is configured, client certificates are verified
+// against it: they are required if requireClientCert is true, optional
+// otherwise.
+func (l *Loader) ServerConfig(requireClientCert bool) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// a new configuration is used for each connection, so that the
+		// latest certificates are always used
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, err := l.certificate()
+			if err != nil {
+				return nil, err
+			}
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				NextProtos:   []string{"h2", "http/1.1"},
+			}
+			if pool := l.caPool(); pool != nil {
+				cfg.ClientCAs = pool
+				cfg.ClientAuth = tls.VerifyClientCertIfGiven
+				if requireClientCert {
+					cfg.ClientAuth = tls.RequireAndVerifyClientCert
+				}
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// ClientConfig returns a client configuration that presents the current
+// certificate (if any) and verifies servers against the current CA (the
+// system roots if no CA is configured).
+func (l *Loader) ClientConfig() *tls.Config {
+	return l.clientConfig(true)
+}
+
+// ProxyConfig is like ClientConfig, but node certificates (see
+// IsNodeCertificate) are never presented: requests forwarded on behalf of
+// clients (e.g., by the load balancer) must be authenticated through the
+// credentials of the clients themselves.
+func (l *Loader) ProxyConfig() *tls.Config {
+	return l.clientConfig(false)
+}
+
+func (l *Loader) clientConfig(presentNodeCert bool) *tls.Config {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if cert, err := l.certificate(); err == nil && (presentNodeCert || !IsNodeCertificate(cert.Leaf)) {
+				return cert, nil
+			}
+			// no certificate is sent
+			return &tls.Certificate{}, nil
+		},
+	}
+	if l.caFile == "" {
+		return cfg
+	}
+
+	// RootCAs cannot be replaced after the configuration is in use:
+	// verification is performed here against the current CA instead
+	cfg.InsecureSkipVerify = true
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("no server certificate")
+		}
+		opts := x509.VerifyOptions{
+			DNSName:       cs.ServerName,
+			Roots:         l.caPool(),
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, cert := range cs.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		_, err := cs.PeerCertificates[0].Verify(opts)
+		return err
+	}
+	return cfg
+}
+
+var nodeLoader *Loader
+var nodeLoaderErr error
+var nodeLoaderOnce sync.Once
+
+// node returns the loader of the TLS material configured for the node (nil
+// if none is configured). Files are checked for changes periodically.
+func node() (*Loader, error) {
+	nodeLoaderOnce.Do(func() {
+		certFile := config.GetString(config.TLS_CERT, "")
+		keyFile := config.GetString(config.TLS_KEY, "")
+		caFile := config.GetString(config.TLS_CA, "")
+		if certFile == "" && caFile == "" {
+			return
+		}
+		if (certFile == "") != (keyFile == "") {
+			nodeLoaderErr = fmt.Errorf("both %s and %s must be set", config.TLS_CERT, config.TLS_KEY)
+			return
+		}
+		nodeLoader, nodeLoaderErr = NewLoader(certFile, keyFile, caFile)
+		if nodeLoaderErr == nil {
+			interval := time.Duration(config.GetInt(config.TLS_RELOAD_INTERVAL, 60)) * time.Second
+			go nodeLoader.Watch(interval, nil)
+		}
+	})
+	return nodeLoader, nodeLoaderErr
+}
+
+// Init loads the TLS material configured for the node, if any.
+func Init() error {
+	_, err := node()
+	return err
+}
+
+// Enabled returns true if the API must be served over HTTPS, i.e., a
+// certificate is configured for the node.
+func Enabled() bool {
+	return config.GetString(config.TLS_CERT, "") != ""
+}
+
+// Scheme returns the URL scheme of the API of the node.
+func Scheme() string {
+	if Enabled() {
+		return "https"
+	}
+	return "http"
+}
+
+// ServerConfig returns the configuration of the API server (nil if TLS is not
+// enabled).
+func ServerConfig() (*tls.Config, error) {
+	l, err := node()
+	if err != nil || !Enabled() {
+		return nil, err
+	}
+	return l.ServerConfig(config.GetBool(config.TLS_CLIENT_REQUIRE, false)), nil
+}
+
+// ClientConfig returns the configuration used to connect to other nodes and
+// to etcd, which presents the certificate of the node (mutual TLS) and
+// verifies servers against the cluster CA. The result is nil if no TLS
+// material is configured.
+func ClientConfig() (*tls.Config, error) {
+	l, err := node()
+	if err != nil || l == nil {
+		return nil, err
+	}
+	return l.ClientConfig(), nil
+}
+
+// ProxyConfig returns the configuration used to forward client requests to
+// the nodes (see Loader.ProxyConfig). The result is nil if no TLS material is
+// configured.
+func ProxyConfig() (*tls.Config, error) {
+	l, err := node()
+	if err != nil || l == nil {
+		return nil, err
+	}
+	return l.ProxyConfig(), nil
+}
+
+// IsNodeCertificate returns true if a certificate identifies a node, i.e.,
+// its subject has the organizational unit of nodes (see NodeUnit).
+func IsNodeCertificate(cert *x509.Certificate) bool {
+	if cert == nil {
+		return false
+	}
+	unit := NodeUnit()
+	for _, ou := range cert.Subject.OrganizationalUnit {
+		if ou == unit {
+			return true
+		}
+	}
+	return false
+}
+
+// NodeUnit returns the organizational unit of the certificates of nodes.
+func NodeUnit() string {
+	return config.GetString(config.TLS_NODE_OU, "serverledge-node")
+}
+
+// IsClusterPeer returns true if the client of a request presented a node
+// certificate signed by the cluster CA, i.e., it is another node. Other
+// certificates signed by the CA (e.g., of the load balancer or of operators)
+// do not identify nodes.
+func IsClusterPeer(r *http.Request) bool {
+	// client certificates are only verified against the cluster CA
+	return r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && IsNodeCertificate(r.TLS.VerifiedChains[0][0])
+}
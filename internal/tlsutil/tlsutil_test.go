@@ -0,0 +1,248 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, dir string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", der)
+	return &testCA{cert: cert, key: key}
+}
+
+// issue writes a certificate signed by the CA (and its key) to <name>.pem
+// and <name>.key, with the given organizational units.
+func (ca *testCA) issue(t *testing.T, dir string, name string, units ...string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name, OrganizationalUnit: units},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(dir, name+".pem"), "CERTIFICATE", der)
+	writePEM(t, filepath.Join(dir, name+".key"), "EC PRIVATE KEY", keyDer)
+}
+
+func writePEM(t *testing.T, file string, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// touch moves the modification time of the files in dir forward, so that
+// changes are detected regardless of the file system time resolution.
+func touch(t *testing.T, dir string, at time.Time) {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if err := os.Chtimes(file, at, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMutualTLSAndReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	ca.issue(t, dir, "server")
+	ca.issue(t, dir, "client", NodeUnit())
+	touch(t, dir, time.Now())
+
+	server, err := NewLoader(filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewLoader(filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key"), filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	anonymous, err := NewLoader("", "", filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !IsClusterPeer(r) {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	})}
+	go srv.Serve(tls.NewListener(listener, server.ServerConfig(false)))
+	defer srv.Close()
+	url := "https://" + listener.Addr().String()
+
+	get := func(l *Loader) (int, error) {
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: l.ClientConfig(), DisableKeepAlives: true}}
+		resp, err := httpClient.Get(url)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	if status, err := get(client); err != nil || status != http.StatusOK {
+		t.Fatalf("mutual TLS failed: %d %v", status, err)
+	}
+	// client certificates are optional, but identify cluster peers
+	if status, err := get(anonymous); err != nil || status != http.StatusForbidden {
+		t.Fatalf("unexpected response to anonymous client: %d %v", status, err)
+	}
+
+	// rotate all the certificates: clients trusting the old CA are rejected
+	// as soon as the server reloads its certificates
+	ca = newTestCA(t, dir)
+	ca.issue(t, dir, "server")
+	ca.issue(t, dir, "client", NodeUnit())
+	touch(t, dir, time.Now().Add(time.Minute))
+	if reloaded, err := server.Reload(); err != nil || !reloaded {
+		t.Fatalf("server certificates not reloaded: %v", err)
+	}
+	if _, err := get(client); err == nil {
+		t.Fatalf("the client still trusts the old CA")
+	}
+	if reloaded, err := client.Reload(); err != nil || !reloaded {
+		t.Fatalf("client certificates not reloaded: %v", err)
+	}
+	if status, err := get(client); err != nil || status != http.StatusOK {
+		t.Fatalf("mutual TLS failed after reload: %d %v", status, err)
+	}
+	if reloaded, _ := client.Reload(); reloaded {
+		t.Errorf("unchanged certificates reloaded")
+	}
+}
+
+func TestNodeIdentity(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	ca.issue(t, dir, "server", NodeUnit())
+	ca.issue(t, dir, "node", NodeUnit())
+	ca.issue(t, dir, "operator", "operators")
+
+	load := func(name string) *Loader {
+		l, err := NewLoader(filepath.Join(dir, name+".pem"), filepath.Join(dir, name+".key"), filepath.Join(dir, "ca.pem"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+	server, node, operator := load("server"), load("node"), load("operator")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// as the API server, clients must be nodes or present an API key
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !IsClusterPeer(r) && r.Header.Get("Authorization") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	})}
+	go srv.Serve(tls.NewListener(listener, server.ServerConfig(false)))
+	defer srv.Close()
+	target, _ := url.Parse("https://" + listener.Addr().String())
+
+	get := func(url string, cfg *tls.Config, token string) int {
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg, DisableKeepAlives: true}}
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := get(target.String(), node.ClientConfig(), ""); status != http.StatusOK {
+		t.Errorf("node not authenticated: %d", status)
+	}
+	// certificates signed by the CA do not identify nodes by themselves
+	if status := get(target.String(), operator.ClientConfig(), ""); status != http.StatusUnauthorized {
+		t.Errorf("operator authenticated as a node: %d", status)
+	}
+
+	// requests proxied on behalf of clients (as by the load balancer) are
+	// not authenticated as coming from a node, even if the proxy has a node
+	// certificate: the credentials of the clients are needed
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = &http.Transport{TLSClientConfig: node.ProxyConfig(), DisableKeepAlives: true}
+	proxyServer := httptest.NewServer(proxy)
+	defer proxyServer.Close()
+	if status := get(proxyServer.URL, nil, ""); status != http.StatusUnauthorized {
+		t.Errorf("proxied request without credentials accepted: %d", status)
+	}
+	if status := get(proxyServer.URL, nil, "key"); status != http.StatusOK {
+		t.Errorf("credentials of the client not forwarded: %d", status)
+	}
+}
@@ -1,11 +1,13 @@
 package utils
 
 import (
+	"crypto/tls"
 	"fmt"
 	"sync"
 	"time"
 
 	"github.com/grussorusso/serverledge/internal/config"
+	"github.com/grussorusso/serverledge/internal/tlsutil"
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
@@ -22,10 +24,21 @@ func GetEtcdClient() (*clientv3.Client, error) {
 	}
 
 	etcdHost := config.GetString(config.ETCD_ADDRESS, "localhost:2379")
-	cli, err := clientv3.New(clientv3.Config{
+	etcdConfig := clientv3.Config{
 		Endpoints:   []string{etcdHost},
 		DialTimeout: 1 * time.Second,
-	})
+	}
+	if config.GetBool(config.ETCD_TLS, false) {
+		tlsConfig, err := tlsutil.ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("Invalid TLS configuration: %v", err)
+		}
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		etcdConfig.TLS = tlsConfig
+	}
+	cli, err := clientv3.New(etcdConfig)
 	if err != nil {
 		return nil, fmt.Errorf("Could not connect to etcd: %v", err)
 	}
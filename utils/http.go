@@ -20,6 +20,22 @@ func PostJson(url string, body []byte) (*http.Response, error) {
 	return resp, nil
 }
 
+// Delete sends a DELETE request to the given URL.
+func Delete(url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return resp, fmt.Errorf("Server response: %v", resp.Status)
+	}
+	return resp, nil
+}
+
 func PrintJsonResponse(resp io.ReadCloser) {
 	defer func(resp io.ReadCloser) {
 		err := resp.Close()
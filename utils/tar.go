@@ -7,6 +7,9 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
+
+	"github.com/containerd/continuity/fs"
 )
 
 func Tar(src string, of *os.File) error {
@@ -86,3 +89,63 @@ func Tar(src string, of *os.File) error {
 		return nil
 	})
 }
+
+// Untar extracts a TAR archive into the dest directory.
+func Untar(src io.Reader, dest string) error {
+	return UntarInRoot(src, dest, "/")
+}
+
+// UntarInRoot extracts a TAR archive into the dest directory of the file
+// system rooted at root (e.g., the root file system of a container). Paths
+// are resolved as if root were the file system root, so that symbolic links
+// (e.g., in container images) never lead outside of it, and are not followed
+// when creating files.
+func UntarInRoot(src io.Reader, root string, dest string) error {
+	tr := tar.NewReader(src)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("Could not read tar archive: %v", err)
+		}
+
+		// reject entries escaping the destination directory
+		name := filepath.Clean(header.Name)
+		if name == "." {
+			// the destination itself (e.g., "./"), which exists already
+			continue
+		}
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("Invalid path in tar archive: %s", header.Name)
+		}
+		target, err := fs.RootPath(root, filepath.Join(dest, name))
+		if err != nil {
+			return fmt.Errorf("Could not resolve %s: %v", header.Name, err)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|syscall.O_NOFOLLOW, os.FileMode(header.Mode))
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				_ = f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+		default:
+			// other entries (e.g., links) are not produced by Tar
+		}
+	}
+}
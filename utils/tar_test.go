@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tarArchive(t *testing.T, entries ...*tar.Header) *bytes.Buffer {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, header := range entries {
+		if header.Typeflag == tar.TypeReg {
+			header.Size = int64(len(header.Name))
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			_, _ = tw.Write([]byte(header.Name))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestUntar(t *testing.T) {
+	dest := t.TempDir()
+	archive := tarArchive(t,
+		&tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0755},
+		&tar.Header{Name: "./dir/", Typeflag: tar.TypeDir, Mode: 0755},
+		&tar.Header{Name: "./dir/handler.py", Typeflag: tar.TypeReg, Mode: 0644})
+	if err := Untar(archive, dest); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(filepath.Join(dest, "dir", "handler.py")); err != nil || string(content) != "./dir/handler.py" {
+		t.Errorf("unexpected file content: %q %v", content, err)
+	}
+
+	// entries escaping the destination are rejected
+	outside := filepath.Join(filepath.Dir(dest), "x")
+	archive = tarArchive(t, &tar.Header{Name: "../x", Typeflag: tar.TypeReg, Mode: 0644})
+	if err := Untar(archive, dest); err == nil {
+		t.Error("entry outside of the destination accepted")
+	}
+	if _, err := os.Stat(outside); !os.IsNotExist(err) {
+		t.Errorf("file written outside of the destination: %v", err)
+	}
+}
+
+func TestUntarInRootWithSymlinks(t *testing.T) {
+	// the root file system of an image, whose /app directory links to the
+	// root of the host
+	root := t.TempDir()
+	host := t.TempDir()
+	if err := os.Symlink(host, filepath.Join(root, "app")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/", filepath.Join(root, "lib")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(host, "victim"), filepath.Join(root, "handler.py")); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := tarArchive(t, &tar.Header{Name: "handler.py", Typeflag: tar.TypeReg, Mode: 0644})
+	if err := UntarInRoot(archive, root, "/app/"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(host, "handler.py")); !os.IsNotExist(err) {
+		t.Errorf("file written outside of the root: %v", err)
+	}
+	// links are resolved within the root
+	if content, err := os.ReadFile(filepath.Join(root, host, "handler.py")); err != nil || string(content) != "handler.py" {
+		t.Errorf("unexpected file content: %q %v", content, err)
+	}
+
+	archive = tarArchive(t, &tar.Header{Name: "handler.py", Typeflag: tar.TypeReg, Mode: 0644})
+	if err := UntarInRoot(archive, root, "/lib"); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(filepath.Join(root, host, "victim")); err != nil || string(content) != "handler.py" {
+		t.Errorf("unexpected file content: %q %v", content, err)
+	}
+	if _, err := os.Stat(filepath.Join(host, "victim")); !os.IsNotExist(err) {
+		t.Errorf("file written outside of the root through a link: %v", err)
+	}
+}